@@ -37,8 +37,7 @@ type Canal struct {
 	rsLock     sync.Mutex
 	rsHandlers []RowsEventHandler
 
-	connLock sync.Mutex
-	conn     *client.Conn
+	pool *client.Pool
 
 	wg sync.WaitGroup
 
@@ -61,6 +60,12 @@ func NewCanal(cfg *Config) (*Canal, error) {
 	c.rsHandlers = make([]RowsEventHandler, 0, 4)
 	c.tables = make(map[string]*schema.Table)
 
+	c.pool = client.NewPool(client.PoolConfig{Addr: c.cfg.Addr, User: c.cfg.User, Password: c.cfg.Password,
+		Options: []func(conn *client.Conn){func(conn *client.Conn) {
+			conn.SetInterpolateParams(c.cfg.InterpolateParams)
+		}},
+	})
+
 	var err error
 	if c.master, err = loadMasterInfo(c.masterInfoPath()); err != nil {
 		return nil, errors.Trace(err)
@@ -91,7 +96,8 @@ func (c *Canal) prepareDumper() error {
 	var err error
 	if c.dumper, err = dump.NewDumper(c.cfg.Dump.ExecutionPath,
 		c.cfg.Addr, c.cfg.User, c.cfg.Password); err != nil {
-		if err != exec.ErrNotFound {
+		// exec.LookPath returns an *exec.Error for a missing mysqldump
+		if e, ok := errors.Cause(err).(*exec.Error); !ok || e.Err != exec.ErrNotFound {
 			return errors.Trace(err)
 		}
 		//no mysqldump, use binlog only
@@ -169,10 +175,7 @@ func (c *Canal) Close() {
 
 	close(c.quit)
 
-	c.connLock.Lock()
-	c.conn.Close()
-	c.conn = nil
-	c.connLock.Unlock()
+	c.pool.Close()
 
 	if c.syncer != nil {
 		c.syncer.Close()
@@ -264,31 +267,8 @@ func (c *Canal) masterInfoPath() string {
 }
 
 // Execute a SQL
-func (c *Canal) Execute(cmd string, args ...interface{}) (rr *mysql.Result, err error) {
-	c.connLock.Lock()
-	defer c.connLock.Unlock()
-
-	retryNum := 3
-	for i := 0; i < retryNum; i++ {
-		if c.conn == nil {
-			c.conn, err = client.Connect(c.cfg.Addr, c.cfg.User, c.cfg.Password, "")
-			if err != nil {
-				return nil, errors.Trace(err)
-			}
-		}
-
-		rr, err = c.conn.Execute(cmd, args...)
-		if err != nil && err != mysql.ErrBadConn {
-			return
-		} else if err == mysql.ErrBadConn {
-			c.conn.Close()
-			c.conn = nil
-			continue
-		} else {
-			return
-		}
-	}
-	return
+func (c *Canal) Execute(cmd string, args ...interface{}) (*mysql.Result, error) {
+	return c.pool.Execute(cmd, args...)
 }
 
 func (c *Canal) SyncedPosition() mysql.Position {
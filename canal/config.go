@@ -35,6 +35,9 @@ type Config struct {
 	Flavor   string `toml:"flavor"`
 	DataDir  string `toml:"data_dir"`
 
+	// interpolate the args of Execute on the client, see client.Conn.SetInterpolateParams
+	InterpolateParams bool `toml:"interpolate_params"`
+
 	Dump DumpConfig `toml:"dump"`
 }
 
@@ -0,0 +1,139 @@
+package canal
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/gdey/go-mysql/mysql"
+	"github.com/gdey/go-mysql/mysqltest"
+	"github.com/gdey/go-mysql/replication"
+	. "gopkg.in/check.v1"
+)
+
+// fakeCanalTestSuite tests canal with a fake MySQL, so it runs without MySQL.
+type fakeCanalTestSuite struct {
+	s   *mysqltest.Server
+	dir string
+}
+
+var _ = Suite(&fakeCanalTestSuite{})
+
+func (s *fakeCanalTestSuite) SetUpTest(c *C) {
+	var err error
+	s.s, err = mysqltest.NewServer()
+	c.Assert(err, IsNil)
+
+	s.dir, err = ioutil.TempDir("", "canal")
+	c.Assert(err, IsNil)
+
+	s.s.AddTable(&mysqltest.Table{
+		Schema: "test",
+		Name:   "canal_test",
+		Columns: []mysqltest.Column{
+			{Name: "id", Type: "int(11)", Key: "PRI", Extra: "auto_increment"},
+			{Name: "name", Type: "varchar(100)", Nullable: true},
+		},
+		Indexes: []mysqltest.Index{{Name: "PRIMARY", Columns: []string{"id"}}},
+	})
+}
+
+func (s *fakeCanalTestSuite) TearDownTest(c *C) {
+	s.s.Close()
+	os.RemoveAll(s.dir)
+}
+
+// writeBinlog writes a binlog inserting the rows into test.canal_test.
+func (s *fakeCanalTestSuite) writeBinlog(c *C, rows [][]interface{}) []byte {
+	var buf bytes.Buffer
+	w := replication.NewBinlogWriter(&buf, 1)
+	c.Assert(w.WriteFileHeader(), IsNil)
+
+	err := w.WriteEvent(replication.FORMAT_DESCRIPTION_EVENT,
+		replication.NewFormatDescriptionEvent(replication.DefaultBinlogServerVersion, replication.BINLOG_CHECKSUM_ALG_CRC32))
+	c.Assert(err, IsNil)
+
+	table := &replication.TableMapEvent{
+		TableID:     100,
+		Schema:      []byte("test"),
+		Table:       []byte("canal_test"),
+		ColumnCount: 2,
+		ColumnType:  []byte{mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_VARCHAR},
+		ColumnMeta:  []uint16{0, 100},
+		NullBitmap:  []byte{0x02},
+	}
+
+	for _, e := range []struct {
+		t replication.EventType
+		e replication.Event
+	}{
+		{replication.QUERY_EVENT, &replication.QueryEvent{Schema: []byte("test"), Query: []byte("BEGIN")}},
+		{replication.TABLE_MAP_EVENT, table},
+		{replication.WRITE_ROWS_EVENTv2, &replication.RowsEvent{TableID: 100, Table: table, Rows: rows}},
+		{replication.XID_EVENT, &replication.XIDEvent{XID: 1}},
+	} {
+		c.Assert(w.WriteEvent(e.t, e.e), IsNil)
+	}
+
+	return buf.Bytes()
+}
+
+type fakeRowsEventHandler struct {
+	events chan *RowsEvent
+}
+
+func (h *fakeRowsEventHandler) Do(e *RowsEvent) error {
+	h.events <- e
+	return nil
+}
+
+func (h *fakeRowsEventHandler) String() string {
+	return "fakeRowsEventHandler"
+}
+
+func (s *fakeCanalTestSuite) TestCanal(c *C) {
+	err := s.s.AddBinlogFile("mysql-bin.000001", s.writeBinlog(c, [][]interface{}{{int32(1), "a"}, {int32(2), nil}}))
+	c.Assert(err, IsNil)
+
+	cfg := NewDefaultConfig()
+	cfg.Addr = s.s.Addr()
+	cfg.DataDir = s.dir
+	// no mysqldump, sync from the first binlog
+	cfg.Dump.ExecutionPath = "go-mysql-no-mysqldump"
+
+	cc, err := NewCanal(cfg)
+	c.Assert(err, IsNil)
+	defer cc.Close()
+
+	h := &fakeRowsEventHandler{events: make(chan *RowsEvent, 1)}
+	cc.RegRowsEventHandler(h)
+
+	c.Assert(cc.Start(), IsNil)
+
+	var e *RowsEvent
+	select {
+	case e = <-h.events:
+	case <-time.After(5 * time.Second):
+		c.Fatal("no rows event received from the fake binlog")
+	}
+	c.Assert(e.Action, Equals, InsertAction)
+	c.Assert(e.Table.Name, Equals, "canal_test")
+	c.Assert(e.Table.PKColumns, DeepEquals, []int{0})
+	c.Assert(e.Rows, DeepEquals, [][]interface{}{{int32(1), "a"}, {int32(2), nil}})
+
+	err = cc.CatchMasterPos(10)
+	c.Assert(err, IsNil)
+}
+
+func (s *fakeCanalTestSuite) TestRowFormat(c *C) {
+	s.s.SetVariable("binlog_format", "STATEMENT")
+
+	cfg := NewDefaultConfig()
+	cfg.Addr = s.s.Addr()
+	cfg.DataDir = s.dir
+	cfg.Dump.ExecutionPath = "go-mysql-no-mysqldump"
+
+	_, err := NewCanal(cfg)
+	c.Assert(err, ErrorMatches, ".*binlog must ROW format.*")
+}
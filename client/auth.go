@@ -2,12 +2,30 @@ package client
 
 import (
 	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
 	"encoding/binary"
+	"encoding/pem"
 
 	"github.com/gdey/go-mysql/mysql"
 	"github.com/juju/errors"
 )
 
+const (
+	// caching_sha2_password auth more data
+	cachingSha2RequestPublicKey = 2
+	cachingSha2FastAuthSuccess  = 3
+	cachingSha2PerformFullAuth  = 4
+
+	// sha256_password requests the public key with this byte
+	sha256RequestPublicKey = 1
+
+	// the length of the salt the server sends for the auth plugins
+	authSaltLen = 20
+)
+
 func (c *Conn) readInitialHandshake() error {
 	data, err := c.ReadPacket()
 	if err != nil {
@@ -15,7 +33,8 @@ func (c *Conn) readInitialHandshake() error {
 	}
 
 	if data[0] == mysql.ERR_HEADER {
-		return errors.New("read initial handshake error")
+		// the server refuses us, like too many connections
+		return c.handleErrorPacket(data)
 	}
 
 	if data[0] < mysql.MinProtocolVersion {
@@ -26,6 +45,11 @@ func (c *Conn) readInitialHandshake() error {
 	//mysql version end with 0x00
 	pos := 1 + bytes.IndexByte(data[1:], 0x00) + 1
 
+	// connection id, salt, filter and capability lower 2 bytes
+	if len(data) < pos+4+8+1+2 {
+		return errors.Trace(mysql.ErrMalformPacket)
+	}
+
 	//connection id length is 4
 	c.connectionID = uint32(binary.LittleEndian.Uint32(data[pos : pos+4]))
 	pos += 4
@@ -41,7 +65,15 @@ func (c *Conn) readInitialHandshake() error {
 
 	pos += 2
 
+	//the server before 4.1 only supports mysql_native_password like auth
+	c.authPlugin = mysql.AUTH_NATIVE_PASSWORD
+
 	if len(data) > pos {
+		// charset, status, capability upper 2 bytes, auth data len, reserved and salt
+		if len(data) < pos+1+2+2+11+12 {
+			return errors.Trace(mysql.ErrMalformPacket)
+		}
+
 		//skip server charset
 		//c.charset = data[pos]
 		pos += 1
@@ -62,6 +94,18 @@ func (c *Conn) readInitialHandshake() error {
 		// mysql-proxy also use 12
 		// which is not documented but seems to work.
 		c.salt = append(c.salt, data[pos:pos+12]...)
+
+		//skip salt and its [00]
+		pos += 13
+
+		if c.capability&mysql.CLIENT_PLUGIN_AUTH > 0 && len(data) > pos {
+			//auth plugin name, MySQL 5.5.7 - 5.5.9 doesn't end it with [00]
+			name := data[pos:]
+			if end := bytes.IndexByte(name, 0x00); end != -1 {
+				name = name[:end]
+			}
+			c.authPlugin = string(name)
+		}
 	}
 
 	return nil
@@ -70,34 +114,67 @@ func (c *Conn) readInitialHandshake() error {
 func (c *Conn) writeAuthHandshake() error {
 	// Adjust client capability flags based on server support
 	capability := mysql.CLIENT_PROTOCOL_41 | mysql.CLIENT_SECURE_CONNECTION |
-		mysql.CLIENT_LONG_PASSWORD | mysql.CLIENT_TRANSACTIONS | mysql.CLIENT_LONG_FLAG
+		mysql.CLIENT_LONG_PASSWORD | mysql.CLIENT_TRANSACTIONS | mysql.CLIENT_LONG_FLAG |
+		mysql.CLIENT_PLUGIN_AUTH | mysql.CLIENT_PLUGIN_AUTH_LENENC_CLIENT_DATA |
+		mysql.CLIENT_MULTI_RESULTS | mysql.CLIENT_PS_MULTI_RESULTS
 
 	capability &= c.capability
 
-	//packet length
-	//capbility 4
-	//max-packet size 4
-	//charset 1
-	//reserved all[0] 23
-	length := 4 + 4 + 1 + 23
-
-	//username
-	length += len(c.user) + 1
-
-	//we only support secure connection
-	auth := mysql.CalcPassword(c.salt, []byte(c.password))
+	switch c.compression {
+	case mysql.MYSQL_COMPRESS_ZLIB:
+		capability |= c.capability & mysql.CLIENT_COMPRESS
+	case mysql.MYSQL_COMPRESS_ZSTD:
+		capability |= c.capability & mysql.CLIENT_ZSTD_COMPRESSION_ALGORITHM
+	}
 
-	length += 1 + len(auth)
+	useTLS, err := c.useTLS()
+	if err != nil {
+		return errors.Trace(err)
+	} else if useTLS {
+		capability |= mysql.CLIENT_SSL
+	}
 
 	if len(c.db) > 0 {
 		capability |= mysql.CLIENT_CONNECT_WITH_DB
+	}
+
+	if c.localInfileEnabled() {
+		capability |= mysql.CLIENT_LOCAL_FILES
+	}
 
-		length += len(c.db) + 1
+	if c.multiStatements {
+		capability |= c.capability & mysql.CLIENT_MULTI_STATEMENTS
 	}
 
 	c.capability = capability
 
-	data := make([]byte, length+4)
+	if useTLS {
+		// the handshake response is sent after the TLS handshake
+		if err = c.writeSSLRequest(capability); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	if capability&mysql.CLIENT_PLUGIN_AUTH == 0 {
+		// the server doesn't tell us the plugin
+		c.authPlugin = mysql.AUTH_NATIVE_PASSWORD
+	}
+
+	auth, err := c.genAuthResponse(c.authPlugin, c.salt)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	if len(auth) > 250 && capability&mysql.CLIENT_PLUGIN_AUTH_LENENC_CLIENT_DATA == 0 {
+		return errors.Errorf("auth response of %s is too long for the server", c.authPlugin)
+	}
+
+	//packet header 4
+	//capbility 4
+	//max-packet size 4
+	//charset 1
+	//reserved all[0] 23
+	data := make([]byte, 4+4+4+1+23, 128)
 
 	//capability [32 bit]
 	data[4] = byte(capability)
@@ -116,24 +193,239 @@ func (c *Conn) writeAuthHandshake() error {
 	data[12] = byte(mysql.DEFAULT_COLLATION_ID)
 
 	//Filler [23 bytes] (all 0x00)
-	pos := 13 + 23
 
 	//User [null terminated string]
-	if len(c.user) > 0 {
-		pos += copy(data[pos:], c.user)
-	}
-	//data[pos] = 0x00
-	pos++
+	data = append(data, c.user...)
+	data = append(data, 0x00)
 
 	// auth [length encoded integer]
-	data[pos] = byte(len(auth))
-	pos += 1 + copy(data[pos+1:], auth)
+	data = append(data, mysql.PutLengthEncodedInt(uint64(len(auth)))...)
+	data = append(data, auth...)
 
 	// db [null terminated string]
 	if len(c.db) > 0 {
-		pos += copy(data[pos:], c.db)
-		//data[pos] = 0x00
+		data = append(data, c.db...)
+		data = append(data, 0x00)
+	}
+
+	// auth plugin name [null terminated string]
+	if capability&mysql.CLIENT_PLUGIN_AUTH > 0 {
+		data = append(data, c.authPlugin...)
+		data = append(data, 0x00)
+	}
+
+	// zstd compression level [1 byte]
+	if capability&mysql.CLIENT_ZSTD_COMPRESSION_ALGORITHM > 0 {
+		data = append(data, byte(c.zstdLevel))
+	}
+
+	return c.WritePacket(data)
+}
+
+// genAuthResponse returns the auth data of plugin for the handshake response or the auth switch response.
+func (c *Conn) genAuthResponse(plugin string, salt []byte) ([]byte, error) {
+	switch plugin {
+	case mysql.AUTH_NATIVE_PASSWORD:
+		if len(salt) < authSaltLen {
+			return nil, errors.Trace(mysql.ErrMalformPacket)
+		}
+		return mysql.CalcPassword(salt[:authSaltLen], []byte(c.password)), nil
+	case mysql.AUTH_CACHING_SHA2_PASSWORD:
+		return mysql.CalcCachingSha2Password(salt, []byte(c.password)), nil
+	case mysql.AUTH_SHA256_PASSWORD:
+		if len(c.password) == 0 {
+			return []byte{0x00}, nil
+		}
+
+		if c.isSecure() {
+			return c.clearPassword(), nil
+		}
+
+		if c.serverPubKey != nil {
+			return c.encryptPassword(salt, c.serverPubKey)
+		}
+
+		// request the public key from the server
+		if err := c.checkPublicKeyRetrieval(); err != nil {
+			return nil, errors.Trace(err)
+		}
+		return []byte{sha256RequestPublicKey}, nil
+	case mysql.AUTH_CLEAR_PASSWORD:
+		if !c.isSecure() && !c.allowCleartextPassword {
+			return nil, errors.Errorf("%s needs a TLS or unix socket connection", plugin)
+		}
+
+		return c.clearPassword(), nil
+	default:
+		return nil, errors.Errorf("unsupported auth plugin %s", plugin)
+	}
+}
+
+// readAuthResult handles the auth switch and more data until the server returns OK or error.
+func (c *Conn) readAuthResult() error {
+	for {
+		data, err := c.ReadPacket()
+		if err != nil {
+			return errors.Trace(err)
+		}
+
+		switch data[0] {
+		case mysql.OK_HEADER:
+			_, err = c.handleOKPacket(data)
+			return errors.Trace(err)
+		case mysql.ERR_HEADER:
+			return c.handleErrorPacket(data)
+		case mysql.EOF_HEADER:
+			if err = c.handleAuthSwitch(data); err != nil {
+				return errors.Trace(err)
+			}
+		case mysql.MORE_DATA_HEADER:
+			if err = c.handleAuthMoreData(data[1:]); err != nil {
+				return errors.Trace(err)
+			}
+		default:
+			return errors.Errorf("invalid auth result packet %x", data[0])
+		}
+	}
+}
+
+// handleAuthSwitch handles the AuthSwitchRequest, the server asks us to auth with another plugin.
+func (c *Conn) handleAuthSwitch(data []byte) error {
+	if len(data) == 1 {
+		// the old auth switch request for mysql_old_password
+		return errors.Errorf("unsupported auth plugin mysql_old_password")
+	}
+
+	data = data[1:]
+
+	end := bytes.IndexByte(data, 0x00)
+	if end == -1 {
+		return errors.Errorf("invalid auth switch request")
+	}
+
+	c.authPlugin = string(data[:end])
+
+	// the salt ends with [00]
+	salt := data[end+1:]
+	if len(salt) > 0 && salt[len(salt)-1] == 0x00 {
+		salt = salt[:len(salt)-1]
+	}
+	c.salt = append([]byte{}, salt...)
+
+	auth, err := c.genAuthResponse(c.authPlugin, c.salt)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	return errors.Trace(c.writeAuthData(auth))
+}
+
+// handleAuthMoreData handles the extra auth data of caching_sha2_password and sha256_password.
+func (c *Conn) handleAuthMoreData(data []byte) error {
+	if bytes.HasPrefix(data, []byte("-----BEGIN")) {
+		// the public key we requested
+		if err := c.checkPublicKeyRetrieval(); err != nil {
+			return errors.Trace(err)
+		}
+
+		key, err := ParsePublicKey(data)
+		if err != nil {
+			return errors.Trace(err)
+		}
+
+		auth, err := c.encryptPassword(c.salt, key)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		return errors.Trace(c.writeAuthData(auth))
+	}
+
+	if c.authPlugin != mysql.AUTH_CACHING_SHA2_PASSWORD || len(data) == 0 {
+		return errors.Errorf("invalid auth more data for %s", c.authPlugin)
 	}
 
+	switch data[0] {
+	case cachingSha2FastAuthSuccess:
+		// the server has the password in cache, it sends OK next
+		return nil
+	case cachingSha2PerformFullAuth:
+		if c.isSecure() {
+			return errors.Trace(c.writeAuthData(c.clearPassword()))
+		}
+
+		if c.serverPubKey != nil {
+			auth, err := c.encryptPassword(c.salt, c.serverPubKey)
+			if err != nil {
+				return errors.Trace(err)
+			}
+			return errors.Trace(c.writeAuthData(auth))
+		}
+
+		if err := c.checkPublicKeyRetrieval(); err != nil {
+			return errors.Trace(err)
+		}
+		return errors.Trace(c.writeAuthData([]byte{cachingSha2RequestPublicKey}))
+	default:
+		return errors.Errorf("invalid caching_sha2_password auth state %d", data[0])
+	}
+}
+
+func (c *Conn) writeAuthData(auth []byte) error {
+	data := make([]byte, 4, 4+len(auth))
+	data = append(data, auth...)
 	return c.WritePacket(data)
 }
+
+// checkPublicKeyRetrieval returns an error if we can't request the server public key, see SetAllowPublicKeyRetrieval.
+func (c *Conn) checkPublicKeyRetrieval() error {
+	if !c.allowPublicKeyRetrieval {
+		return errors.Errorf("%s needs the server public key without TLS or unix socket, set it by SetServerPubKey, "+
+			"or allow to request it by SetAllowPublicKeyRetrieval", c.authPlugin)
+	}
+	return nil
+}
+
+// isSecure returns whether we can send the password in clear text.
+func (c *Conn) isSecure() bool {
+	return c.IsTLS() || getNetProto(c.addr) == "unix"
+}
+
+func (c *Conn) clearPassword() []byte {
+	return append([]byte(c.password), 0x00)
+}
+
+// encryptPassword encrypts the password with [00] XOR the salt by the server public key.
+func (c *Conn) encryptPassword(salt []byte, key *rsa.PublicKey) ([]byte, error) {
+	if len(salt) == 0 {
+		return nil, errors.Trace(mysql.ErrMalformPacket)
+	}
+
+	plain := c.clearPassword()
+	for i := range plain {
+		plain[i] ^= salt[i%len(salt)]
+	}
+
+	data, err := rsa.EncryptOAEP(sha1.New(), rand.Reader, key, plain, nil)
+	return data, errors.Trace(err)
+}
+
+// ParsePublicKey parses the RSA public key in PEM format, like the server public_key.pem,
+// for SetServerPubKey.
+func ParsePublicKey(data []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.Errorf("invalid public key in PEM format")
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.Errorf("public key is not RSA")
+	}
+
+	return rsaKey, nil
+}
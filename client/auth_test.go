@@ -0,0 +1,380 @@
+package client
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"net"
+
+	"github.com/gdey/go-mysql/mysql"
+	"github.com/gdey/go-mysql/packet"
+	. "gopkg.in/check.v1"
+)
+
+const testAuthPassword = "secret"
+
+// authTestSuite tests the auth plugins with a fake server which plays the server side of the handshake.
+type authTestSuite struct {
+	key *rsa.PrivateKey
+}
+
+var _ = Suite(&authTestSuite{})
+
+func (s *authTestSuite) SetUpSuite(c *C) {
+	var err error
+	s.key, err = rsa.GenerateKey(rand.Reader, 2048)
+	c.Assert(err, IsNil)
+}
+
+type authHandshake struct {
+	*packet.Conn
+
+	salt   []byte
+	auth   []byte
+	plugin string
+}
+
+// serveAuth accepts a connection, sends the initial handshake with plugin, reads the handshake response,
+// then lets fn play the rest of the auth.
+func (s *authTestSuite) serveAuth(c *C, plugin string, fn func(h *authHandshake) error) (string, chan error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+
+	done := make(chan error, 1)
+
+	go func() {
+		defer l.Close()
+
+		conn, err := l.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+
+		h := &authHandshake{Conn: packet.NewConn(conn), plugin: plugin}
+		defer h.Close()
+
+		if err = h.handshake(); err != nil {
+			done <- err
+			return
+		}
+
+		done <- fn(h)
+	}()
+
+	return l.Addr().String(), done
+}
+
+func (h *authHandshake) handshake() error {
+	capability := mysql.CLIENT_LONG_PASSWORD | mysql.CLIENT_LONG_FLAG | mysql.CLIENT_CONNECT_WITH_DB |
+		mysql.CLIENT_PROTOCOL_41 | mysql.CLIENT_TRANSACTIONS | mysql.CLIENT_SECURE_CONNECTION |
+		mysql.CLIENT_PLUGIN_AUTH | mysql.CLIENT_PLUGIN_AUTH_LENENC_CLIENT_DATA
+
+	h.salt, _ = mysql.RandomBuf(20)
+
+	data := make([]byte, 4, 128)
+	data = append(data, 10)
+	data = append(data, "8.0.0"...)
+	data = append(data, 0)
+	data = append(data, 1, 0, 0, 0)
+	data = append(data, h.salt[0:8]...)
+	data = append(data, 0)
+	data = append(data, byte(capability), byte(capability>>8))
+	data = append(data, mysql.DEFAULT_COLLATION_ID)
+	data = append(data, byte(mysql.SERVER_STATUS_AUTOCOMMIT), 0)
+	data = append(data, byte(capability>>16), byte(capability>>24))
+	data = append(data, 21)
+	data = append(data, make([]byte, 10)...)
+	data = append(data, h.salt[8:]...)
+	data = append(data, 0)
+	data = append(data, h.plugin...)
+	data = append(data, 0)
+
+	if err := h.WritePacket(data); err != nil {
+		return err
+	}
+
+	data, err := h.ReadPacket()
+	if err != nil {
+		return err
+	}
+
+	capability = binary.LittleEndian.Uint32(data)
+	if capability&mysql.CLIENT_PLUGIN_AUTH == 0 {
+		return mysql.NewError(mysql.ER_HANDSHAKE_ERROR, "no CLIENT_PLUGIN_AUTH")
+	}
+
+	pos := 4 + 4 + 1 + 23
+	pos += bytes.IndexByte(data[pos:], 0) + 1
+
+	n, _, m := mysql.LengthEncodedInt(data[pos:])
+	pos += m
+	h.auth = data[pos : pos+int(n)]
+	pos += int(n)
+
+	if capability&mysql.CLIENT_CONNECT_WITH_DB > 0 {
+		pos += bytes.IndexByte(data[pos:], 0) + 1
+	}
+
+	if plugin := string(data[pos : pos+bytes.IndexByte(data[pos:], 0)]); plugin != h.plugin {
+		return mysql.NewError(mysql.ER_HANDSHAKE_ERROR, "invalid plugin "+plugin)
+	}
+
+	return nil
+}
+
+func (h *authHandshake) writeData(data ...byte) error {
+	return h.WritePacket(append(make([]byte, 4), data...))
+}
+
+func (h *authHandshake) writeOK() error {
+	return h.writeData(mysql.OK_HEADER, 0, 0, byte(mysql.SERVER_STATUS_AUTOCOMMIT), 0, 0, 0)
+}
+
+func (h *authHandshake) writeAccessDenied() error {
+	data := []byte{mysql.ERR_HEADER, 0, 0, '#'}
+	binary.LittleEndian.PutUint16(data[1:], mysql.ER_ACCESS_DENIED_ERROR)
+	data = append(data, "28000"...)
+	data = append(data, "Access denied"...)
+	return h.writeData(data...)
+}
+
+func (h *authHandshake) readData() ([]byte, error) {
+	return h.ReadPacket()
+}
+
+func (h *authHandshake) decryptPassword(key *rsa.PrivateKey, data []byte) (string, error) {
+	plain, err := rsa.DecryptOAEP(sha1.New(), rand.Reader, key, data, nil)
+	if err != nil {
+		return "", err
+	}
+
+	for i := range plain {
+		plain[i] ^= h.salt[i%len(h.salt)]
+	}
+
+	if len(plain) == 0 || plain[len(plain)-1] != 0 {
+		return "", mysql.NewError(mysql.ER_HANDSHAKE_ERROR, "password not end with [00]")
+	}
+	return string(plain[:len(plain)-1]), nil
+}
+
+func (s *authTestSuite) pubKeyPEM(c *C) []byte {
+	der, err := x509.MarshalPKIXPublicKey(&s.key.PublicKey)
+	c.Assert(err, IsNil)
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+func (s *authTestSuite) connect(addr string, password string, options ...func(c *Conn)) error {
+	conn, err := Connect(addr, "root", password, "", options...)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func (s *authTestSuite) TestCachingSha2FastAuth(c *C) {
+	addr, done := s.serveAuth(c, mysql.AUTH_CACHING_SHA2_PASSWORD, func(h *authHandshake) error {
+		if !bytes.Equal(h.auth, mysql.CalcCachingSha2Password(h.salt, []byte(testAuthPassword))) {
+			return h.writeAccessDenied()
+		}
+
+		if err := h.writeData(mysql.MORE_DATA_HEADER, cachingSha2FastAuthSuccess); err != nil {
+			return err
+		}
+		return h.writeOK()
+	})
+
+	c.Assert(s.connect(addr, testAuthPassword), IsNil)
+	c.Assert(<-done, IsNil)
+
+	addr, done = s.serveAuth(c, mysql.AUTH_CACHING_SHA2_PASSWORD, func(h *authHandshake) error {
+		return h.writeAccessDenied()
+	})
+
+	err := s.connect(addr, "wrong")
+	c.Assert(err, NotNil)
+	c.Assert(<-done, IsNil)
+}
+
+func (s *authTestSuite) testFullAuth(c *C, plugin string, serverSendsKey bool, options ...func(c *Conn)) {
+	addr, done := s.serveAuth(c, plugin, func(h *authHandshake) error {
+		auth := h.auth
+
+		if plugin == mysql.AUTH_CACHING_SHA2_PASSWORD {
+			if err := h.writeData(mysql.MORE_DATA_HEADER, cachingSha2PerformFullAuth); err != nil {
+				return err
+			}
+
+			var err error
+			if auth, err = h.readData(); err != nil {
+				return err
+			}
+		}
+
+		if serverSendsKey {
+			request := byte(sha256RequestPublicKey)
+			if plugin == mysql.AUTH_CACHING_SHA2_PASSWORD {
+				request = cachingSha2RequestPublicKey
+			}
+
+			if len(auth) != 1 || auth[0] != request {
+				return mysql.NewError(mysql.ER_HANDSHAKE_ERROR, "public key is not requested")
+			}
+
+			if err := h.writeData(append([]byte{mysql.MORE_DATA_HEADER}, s.pubKeyPEM(c)...)...); err != nil {
+				return err
+			}
+
+			var err error
+			if auth, err = h.readData(); err != nil {
+				return err
+			}
+		}
+
+		password, err := h.decryptPassword(s.key, auth)
+		if err != nil {
+			return err
+		} else if password != testAuthPassword {
+			return h.writeAccessDenied()
+		}
+
+		return h.writeOK()
+	})
+
+	c.Assert(s.connect(addr, testAuthPassword, options...), IsNil)
+	c.Assert(<-done, IsNil)
+}
+
+func (s *authTestSuite) TestCachingSha2FullAuth(c *C) {
+	// request the public key from the server
+	s.testFullAuth(c, mysql.AUTH_CACHING_SHA2_PASSWORD, true, func(conn *Conn) {
+		conn.SetAllowPublicKeyRetrieval(true)
+	})
+
+	s.testFullAuth(c, mysql.AUTH_CACHING_SHA2_PASSWORD, false, func(conn *Conn) {
+		conn.SetServerPubKey(&s.key.PublicKey)
+	})
+}
+
+func (s *authTestSuite) TestSha256Password(c *C) {
+	s.testFullAuth(c, mysql.AUTH_SHA256_PASSWORD, true, func(conn *Conn) {
+		conn.SetAllowPublicKeyRetrieval(true)
+	})
+
+	s.testFullAuth(c, mysql.AUTH_SHA256_PASSWORD, false, func(conn *Conn) {
+		conn.SetServerPubKey(&s.key.PublicKey)
+	})
+}
+
+func (s *authTestSuite) TestPublicKeyRetrieval(c *C) {
+	// the key is not requested by default
+	for _, plugin := range []string{mysql.AUTH_CACHING_SHA2_PASSWORD, mysql.AUTH_SHA256_PASSWORD} {
+		addr, done := s.serveAuth(c, plugin, func(h *authHandshake) error {
+			if err := h.writeData(mysql.MORE_DATA_HEADER, cachingSha2PerformFullAuth); err != nil {
+				return err
+			}
+			_, err := h.readData()
+			return err
+		})
+
+		err := s.connect(addr, testAuthPassword)
+		c.Assert(err, ErrorMatches, ".*SetAllowPublicKeyRetrieval.*", Commentf("plugin %s", plugin))
+		<-done
+	}
+
+	// nor accepted if the server sends it
+	addr, done := s.serveAuth(c, mysql.AUTH_CACHING_SHA2_PASSWORD, func(h *authHandshake) error {
+		if err := h.writeData(append([]byte{mysql.MORE_DATA_HEADER}, s.pubKeyPEM(c)...)...); err != nil {
+			return err
+		}
+		_, err := h.readData()
+		return err
+	})
+
+	err := s.connect(addr, testAuthPassword)
+	c.Assert(err, ErrorMatches, ".*SetAllowPublicKeyRetrieval.*")
+	<-done
+}
+
+func (s *authTestSuite) TestAuthSwitch(c *C) {
+	addr, done := s.serveAuth(c, mysql.AUTH_CACHING_SHA2_PASSWORD, func(h *authHandshake) error {
+		h.salt, _ = mysql.RandomBuf(20)
+
+		data := []byte{mysql.EOF_HEADER}
+		data = append(data, mysql.AUTH_NATIVE_PASSWORD...)
+		data = append(data, 0)
+		data = append(data, h.salt...)
+		data = append(data, 0)
+		if err := h.writeData(data...); err != nil {
+			return err
+		}
+
+		auth, err := h.readData()
+		if err != nil {
+			return err
+		}
+
+		if !bytes.Equal(auth, mysql.CalcPassword(h.salt, []byte(testAuthPassword))) {
+			return h.writeAccessDenied()
+		}
+		return h.writeOK()
+	})
+
+	c.Assert(s.connect(addr, testAuthPassword), IsNil)
+	c.Assert(<-done, IsNil)
+}
+
+func (s *authTestSuite) TestAuthSwitchShortSalt(c *C) {
+	for _, plugin := range []string{mysql.AUTH_NATIVE_PASSWORD, mysql.AUTH_SHA256_PASSWORD} {
+		addr, done := s.serveAuth(c, mysql.AUTH_CACHING_SHA2_PASSWORD, func(h *authHandshake) error {
+			// the salt is too short, and empty for sha256_password
+			data := []byte{mysql.EOF_HEADER}
+			data = append(data, plugin...)
+			data = append(data, 0)
+			if plugin == mysql.AUTH_NATIVE_PASSWORD {
+				data = append(data, "12345678"...)
+			}
+			data = append(data, 0)
+			if err := h.writeData(data...); err != nil {
+				return err
+			}
+
+			// the client closes the connection
+			if _, err := h.readData(); err == nil {
+				return mysql.NewError(mysql.ER_HANDSHAKE_ERROR, "auth data is sent with the malformed salt")
+			}
+			return nil
+		})
+
+		err := s.connect(addr, testAuthPassword, func(conn *Conn) {
+			conn.SetServerPubKey(&s.key.PublicKey)
+		})
+		c.Assert(err, ErrorMatches, ".*"+mysql.ErrMalformPacket.Error(), Commentf("plugin %s", plugin))
+		c.Assert(<-done, IsNil)
+	}
+}
+
+func (s *authTestSuite) TestClearPasswordNeedsTLS(c *C) {
+	addr, done := s.serveAuth(c, mysql.AUTH_CACHING_SHA2_PASSWORD, func(h *authHandshake) error {
+		data := []byte{mysql.EOF_HEADER}
+		data = append(data, mysql.AUTH_CLEAR_PASSWORD...)
+		data = append(data, 0)
+		if err := h.writeData(data...); err != nil {
+			return err
+		}
+
+		// the client closes the connection without sending the password
+		if _, err := h.readData(); err == nil {
+			return mysql.NewError(mysql.ER_HANDSHAKE_ERROR, "password is sent without TLS")
+		}
+		return nil
+	})
+
+	c.Assert(s.connect(addr, testAuthPassword), NotNil)
+	c.Assert(<-done, IsNil)
+}
@@ -3,6 +3,7 @@ package client
 import (
 	"flag"
 	"fmt"
+	"strings"
 	"testing"
 
 	. "gopkg.in/check.v1"
@@ -126,6 +127,41 @@ func (s *clientTestSuite) TestConn_Escape(c *C) {
 	c.Assert(ss, Equals, e)
 }
 
+func (s *clientTestSuite) TestConn_Compression(c *C) {
+	for _, algorithm := range []int{mysql.MYSQL_COMPRESS_ZLIB, mysql.MYSQL_COMPRESS_ZSTD} {
+		conn, err := Connect(*testAddr, *testUser, *testPassword, *testDB, func(conn *Conn) {
+			conn.SetCompression(algorithm)
+			conn.SetZstdLevel(7)
+		})
+		c.Assert(err, IsNil)
+
+		if conn.Compression() != algorithm {
+			// zstd needs MySQL 8.0.18+
+			conn.Close()
+			continue
+		}
+
+		// the rows in many compressed packets, and the packets buffered in one
+		r, err := conn.Execute("SELECT REPEAT('a', 100000), 1 UNION ALL SELECT REPEAT('b', 20000000), 2")
+		c.Assert(err, IsNil)
+		c.Assert(r.RowNumber(), Equals, 2)
+		v, _ := r.GetString(1, 0)
+		c.Assert(v, Equals, strings.Repeat("b", 20000000))
+
+		// the statement closed is sent with the next command
+		stmt, err := conn.Prepare("SELECT ?")
+		c.Assert(err, IsNil)
+		r, err = stmt.Execute(strings.Repeat("c", 1000))
+		c.Assert(err, IsNil)
+		v, _ = r.GetString(0, 0)
+		c.Assert(v, Equals, strings.Repeat("c", 1000))
+		c.Assert(stmt.Close(), IsNil)
+
+		c.Assert(conn.Ping(), IsNil)
+		conn.Close()
+	}
+}
+
 func (s *clientTestSuite) TestConn_SetCharset(c *C) {
 	err := s.c.SetCharset("gb2312")
 	c.Assert(err, IsNil)
@@ -1,7 +1,10 @@
 package client
 
 import (
+	"crypto/rsa"
+	"crypto/tls"
 	"fmt"
+	"io"
 	"net"
 	"strings"
 	"time"
@@ -27,6 +30,53 @@ type Conn struct {
 	salt []byte
 
 	connectionID uint32
+
+	addr      string
+	tlsMode   TLSMode
+	tlsConfig *tls.Config
+
+	// the auth plugin the server asks us to use
+	authPlugin string
+	// the RSA public key to encrypt the password for sha256_password and caching_sha2_password,
+	// we request it from the server if not set and allowPublicKeyRetrieval.
+	serverPubKey            *rsa.PublicKey
+	allowPublicKeyRetrieval bool
+
+	// the compression algorithm we want to use, see SetCompression
+	compression int
+	// the level of zstd, see SetZstdLevel
+	zstdLevel int
+
+	// mysql_clear_password is allowed without TLS, see SetAllowCleartextPassword
+	allowCleartextPassword bool
+
+	// see SetTimeout
+	dialTimeout  time.Duration
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+
+	// interpolate the args on the client, see SetInterpolateParams
+	interpolateParams bool
+
+	// CLIENT_MULTI_STATEMENTS is requested, see SetMultiStatements
+	multiStatements bool
+
+	// the prepared statements used by Execute and PrepareCached, see SetStmtCacheSize
+	stmtCacheSize int
+	stmtCache     *stmtCache
+
+	// the readers and the files allowed for LOAD DATA LOCAL INFILE
+	localInfileReaders map[string]func() (io.Reader, error)
+	localInfilePaths   []string
+
+	closed bool
+
+	// the streaming rows not read yet
+	rows *Rows
+
+	// used by Pool
+	createdAt time.Time
+	lastUsed  time.Time
 }
 
 func getNetProto(addr string) string {
@@ -38,24 +88,38 @@ func getNetProto(addr string) string {
 }
 
 // Connect to a MySQL server, addr can be ip:port, or a unix socket domain like /var/sock.
-func Connect(addr string, user string, password string, dbName string) (*Conn, error) {
+// The options are applied before handshake, e.g, to set TLS with SetTLS.
+func Connect(addr string, user string, password string, dbName string, options ...func(c *Conn)) (*Conn, error) {
 	proto := getNetProto(addr)
 
 	c := new(Conn)
 
-	var err error
-	conn, err := net.DialTimeout(proto, addr, 10*time.Second)
-	if err != nil {
-		return nil, errors.Trace(err)
-	}
-
-	c.Conn = packet.NewConn(conn)
+	c.addr = addr
 	c.user = user
 	c.password = password
 	c.db = dbName
 
 	//use default charset here, utf-8
 	c.charset = mysql.DEFAULT_CHARSET
+	c.zstdLevel = packet.DefaultZstdLevel
+
+	c.stmtCacheSize = DefaultStmtCacheSize
+	c.dialTimeout = DefaultDialTimeout
+
+	for _, option := range options {
+		option(c)
+	}
+
+	var err error
+	conn, err := net.DialTimeout(proto, addr, c.dialTimeout)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if c.readTimeout > 0 || c.writeTimeout > 0 {
+		conn = &deadlineConn{Conn: conn, readTimeout: c.readTimeout, writeTimeout: c.writeTimeout}
+	}
+	c.Conn = packet.NewConn(conn)
 
 	if err = c.handshake(); err != nil {
 		return nil, errors.Trace(err)
@@ -77,7 +141,19 @@ func (c *Conn) handshake() error {
 		return errors.Trace(err)
 	}
 
-	if _, err := c.readOK(); err != nil {
+	if err := c.readAuthResult(); err != nil {
+		c.Close()
+		return errors.Trace(err)
+	}
+
+	// the compressed protocol starts after the handshake
+	if c.capability&mysql.CLIENT_ZSTD_COMPRESSION_ALGORITHM > 0 {
+		err = c.EnableCompression(mysql.MYSQL_COMPRESS_ZSTD, c.zstdLevel)
+	} else if c.capability&mysql.CLIENT_COMPRESS > 0 {
+		err = c.EnableCompression(mysql.MYSQL_COMPRESS_ZLIB, 0)
+	}
+
+	if err != nil {
 		c.Close()
 		return errors.Trace(err)
 	}
@@ -85,7 +161,36 @@ func (c *Conn) handshake() error {
 	return nil
 }
 
+// SetCompression sets the compression algorithm, MYSQL_COMPRESS_ZLIB or MYSQL_COMPRESS_ZSTD (MySQL 8.0.18+),
+// it must be called with the Connect options before handshake.
+// The connection is not compressed if the server doesn't support the algorithm, see Compression.
+func (c *Conn) SetCompression(algorithm int) {
+	c.compression = algorithm
+}
+
+// SetZstdLevel sets the level of zstd from 1 to 22 for MYSQL_COMPRESS_ZSTD, DefaultZstdLevel by default,
+// it must be called with the Connect options before handshake. The server uses it too.
+func (c *Conn) SetZstdLevel(level int) {
+	c.zstdLevel = level
+}
+
+// SetServerPubKey sets the server RSA public key for sha256_password and caching_sha2_password,
+// it must be called with the Connect options before handshake.
+// Without the key, the password is sent in clear text with TLS or unix socket,
+// otherwise the key is requested from the server if SetAllowPublicKeyRetrieval.
+func (c *Conn) SetServerPubKey(key *rsa.PublicKey) {
+	c.serverPubKey = key
+}
+
+// SetAllowPublicKeyRetrieval allows to request the server RSA public key for sha256_password and caching_sha2_password
+// without TLS or unix socket, it must be called with the Connect options. The key is not verified,
+// so a man in the middle can send its own key to get the password, set the key with SetServerPubKey if possible.
+func (c *Conn) SetAllowPublicKeyRetrieval(on bool) {
+	c.allowPublicKeyRetrieval = on
+}
+
 func (c *Conn) Close() error {
+	c.closed = true
 	return c.Conn.Close()
 }
 
@@ -101,6 +206,25 @@ func (c *Conn) Ping() error {
 	return nil
 }
 
+// ResetConnection resets the session state with COM_RESET_CONNECTION, like the user variables, temporary tables,
+// prepared statements and transaction, without authenticating again. The database is not changed.
+func (c *Conn) ResetConnection() error {
+	// the cached statements are closed by the server
+	c.ClearStmtCache()
+
+	if err := c.writeCommand(mysql.COM_RESET_CONNECTION); err != nil {
+		return errors.Trace(err)
+	}
+
+	if _, err := c.readOK(); err != nil {
+		return errors.Trace(err)
+	}
+
+	// the charset of the handshake
+	c.charset = mysql.DEFAULT_CHARSET
+	return nil
+}
+
 func (c *Conn) UseDB(dbName string) error {
 	if c.db == dbName {
 		return nil
@@ -125,16 +249,53 @@ func (c *Conn) GetDB() string {
 func (c *Conn) Execute(command string, args ...interface{}) (*mysql.Result, error) {
 	if len(args) == 0 {
 		return c.exec(command)
-	} else {
-		if s, err := c.Prepare(command); err != nil {
+	} else if c.canInterpolate() {
+		query, err := c.interpolate(command, args)
+		if err != nil {
 			return nil, errors.Trace(err)
-		} else {
-			var r *mysql.Result
+		}
+		return c.exec(query)
+	} else {
+		var r *mysql.Result
+		err := c.withCachedStmt(command, func(s *Stmt) (err error) {
 			r, err = s.Execute(args...)
-			s.Close()
-			return r, err
+			return err
+		})
+		return r, err
+	}
+}
+
+// SetMultiStatements allows the multi statements like "a; b; c" in a query, it must be called with the Connect options.
+// It's off by default, so an injected "; DROP ..." is a syntax error, the stored procedures return many results anyway.
+func (c *Conn) SetMultiStatements(on bool) {
+	c.multiStatements = on
+}
+
+// ExecuteMultiple executes a multi statement like "a; b; c", or a stored procedure which returns many results,
+// and returns all the results in order. Execute only returns the first one. The multi statements need SetMultiStatements.
+func (c *Conn) ExecuteMultiple(command string, args ...interface{}) ([]*mysql.Result, error) {
+	if len(args) > 0 && c.canInterpolate() {
+		var err error
+		if command, err = c.interpolate(command, args); err != nil {
+			return nil, errors.Trace(err)
 		}
+		args = nil
 	}
+
+	if len(args) == 0 {
+		if err := c.writeCommandStr(mysql.COM_QUERY, command); err != nil {
+			return nil, errors.Trace(err)
+		}
+
+		return c.readResults(false)
+	}
+
+	var results []*mysql.Result
+	err := c.withCachedStmt(command, func(s *Stmt) (err error) {
+		results, err = s.ExecuteMultiple(args...)
+		return err
+	})
+	return results, err
 }
 
 func (c *Conn) Begin() error {
@@ -242,5 +403,5 @@ func (c *Conn) exec(query string) (*mysql.Result, error) {
 		return nil, errors.Trace(err)
 	}
 
-	return c.readResult(false)
+	return c.readFirstResult(false)
 }
@@ -0,0 +1,129 @@
+package client
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gdey/go-mysql/mysql"
+	"github.com/juju/errors"
+)
+
+// the prefix of the file name in LOAD DATA LOCAL INFILE to use a registered reader
+const localInfileReaderPrefix = "Reader::"
+
+// the max size of the packets sending the local file
+const localInfilePacketSize = 64 * 1024
+
+// RegisterLocalInfileReader registers a reader for LOAD DATA LOCAL INFILE 'Reader::name',
+// fn is called for every request, and the reader is closed after read if it's an io.Closer.
+// It must be called with the Connect options before handshake.
+func (c *Conn) RegisterLocalInfileReader(name string, fn func() (io.Reader, error)) {
+	if c.localInfileReaders == nil {
+		c.localInfileReaders = make(map[string]func() (io.Reader, error))
+	}
+	c.localInfileReaders[name] = fn
+}
+
+// AllowLocalInfile allows LOAD DATA LOCAL INFILE to send the files in paths,
+// a path may be a file or a directory, in which all the files are allowed.
+// It must be called with the Connect options before handshake.
+func (c *Conn) AllowLocalInfile(paths ...string) {
+	c.localInfilePaths = append(c.localInfilePaths, paths...)
+}
+
+func (c *Conn) localInfileEnabled() bool {
+	return len(c.localInfileReaders) > 0 || len(c.localInfilePaths) > 0
+}
+
+// handleLocalInfile sends the file the server requests with data after the LocalInFile_HEADER,
+// then reads the result. The file content is ended with an empty packet, even if we can't send the file.
+func (c *Conn) handleLocalInfile(data []byte) (*mysql.Result, error) {
+	name := string(data[1:])
+
+	r, err := c.openLocalInfile(name)
+	if err == nil {
+		err = c.writeLocalInfile(r)
+
+		if closer, ok := r.(io.Closer); ok {
+			closer.Close()
+		}
+
+		if errors.Cause(err) == mysql.ErrBadConn {
+			return nil, errors.Trace(err)
+		}
+	}
+
+	if werr := c.WritePacket(make([]byte, 4)); werr != nil {
+		return nil, errors.Trace(werr)
+	}
+
+	result, rerr := c.readOK()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return result, errors.Trace(rerr)
+}
+
+func (c *Conn) openLocalInfile(name string) (io.Reader, error) {
+	if strings.HasPrefix(name, localInfileReaderPrefix) {
+		fn, ok := c.localInfileReaders[name[len(localInfileReaderPrefix):]]
+		if !ok {
+			return nil, errors.Errorf("local infile reader %s is not registered", name)
+		}
+
+		r, err := fn()
+		return r, errors.Trace(err)
+	}
+
+	if !c.localInfileAllowed(name) {
+		return nil, errors.Errorf("local infile %s is not allowed", name)
+	}
+
+	f, err := os.Open(name)
+	return f, errors.Trace(err)
+}
+
+// localInfileAllowed returns whether the file is in the allowed paths, the symlinks are resolved.
+func (c *Conn) localInfileAllowed(name string) bool {
+	name, err := filepath.EvalSymlinks(name)
+	if err != nil {
+		return false
+	} else if name, err = filepath.Abs(name); err != nil {
+		return false
+	}
+
+	for _, path := range c.localInfilePaths {
+		path, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			continue
+		} else if path, err = filepath.Abs(path); err != nil {
+			continue
+		}
+
+		if name == path || strings.HasPrefix(name, path+string(filepath.Separator)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *Conn) writeLocalInfile(r io.Reader) error {
+	buf := make([]byte, 4+localInfilePacketSize)
+	for {
+		n, err := r.Read(buf[4:])
+		if n > 0 {
+			if werr := c.WritePacket(buf[:4+n]); werr != nil {
+				return errors.Trace(werr)
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.Trace(err)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+package client
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gdey/go-mysql/mysql"
+	"github.com/juju/errors"
+)
+
+// the charsets in which a multibyte character may contain a backslash or a quote,
+// so the escaped string may be broken, we don't interpolate for them.
+var unsafeEscapeCharsets = map[string]bool{
+	"big5":    true,
+	"cp932":   true,
+	"gb18030": true,
+	"gbk":     true,
+	"sjis":    true,
+}
+
+// SetInterpolateParams lets Execute, ExecuteMultiple and Query with args interpolate the args into the query
+// on the client, instead of preparing the statement on the server, which needs three round trips.
+// The args are escaped with the NO_BACKSLASH_ESCAPES mode of the server,
+// but the statement is still prepared if the connection charset is not safe to escape, like gbk.
+// It can be called with the Connect options, or at any time.
+func (c *Conn) SetInterpolateParams(on bool) {
+	c.interpolateParams = on
+}
+
+// canInterpolate returns whether the args can be interpolated into the query on the client.
+func (c *Conn) canInterpolate() bool {
+	return c.interpolateParams && !unsafeEscapeCharsets[strings.ToLower(c.charset)]
+}
+
+// interpolate replaces every placeholder '?' in query with the escaped literal of the arg,
+// the '?' in the strings, quoted identifiers and comments is not a placeholder.
+func (c *Conn) interpolate(query string, args []interface{}) (string, error) {
+	noBackslashEscapes := c.status&mysql.SERVER_STATUS_NO_BACKSLASH_ESCAPED > 0
+
+	buf := make([]byte, 0, len(query)+16*len(args))
+	n := 0
+
+	for i := 0; i < len(query); i++ {
+		switch ch := query[i]; ch {
+		case '?':
+			if n >= len(args) {
+				return "", errors.Errorf("argument mismatch, need more than %d", len(args))
+			}
+
+			var err error
+			if buf, err = appendLiteral(buf, args[n], noBackslashEscapes); err != nil {
+				return "", errors.Trace(err)
+			}
+			n++
+		case '\'', '"', '`':
+			j := skipQuoted(query, i, noBackslashEscapes || ch == '`')
+			buf = append(buf, query[i:j]...)
+			i = j - 1
+		case '#':
+			j := skipLine(query, i)
+			buf = append(buf, query[i:j]...)
+			i = j - 1
+		case '-':
+			// "-- " starts a comment
+			if i+2 < len(query) && query[i+1] == '-' && (query[i+2] == ' ' || query[i+2] == '\t' || query[i+2] == '\n') {
+				j := skipLine(query, i)
+				buf = append(buf, query[i:j]...)
+				i = j - 1
+			} else {
+				buf = append(buf, ch)
+			}
+		case '/':
+			if i+1 < len(query) && query[i+1] == '*' {
+				j := len(query)
+				if k := strings.Index(query[i+2:], "*/"); k >= 0 {
+					j = i + 2 + k + 2
+				}
+				buf = append(buf, query[i:j]...)
+				i = j - 1
+			} else {
+				buf = append(buf, ch)
+			}
+		default:
+			buf = append(buf, ch)
+		}
+	}
+
+	if n != len(args) {
+		return "", errors.Errorf("argument mismatch, need %d but got %d", n, len(args))
+	}
+
+	return string(buf), nil
+}
+
+// skipQuoted returns the position after the quoted string or identifier starting at i.
+func skipQuoted(query string, i int, noBackslashEscapes bool) int {
+	quote := query[i]
+	for j := i + 1; j < len(query); j++ {
+		switch query[j] {
+		case '\\':
+			if !noBackslashEscapes {
+				j++
+			}
+		case quote:
+			// the doubled quote is the quote itself
+			if j+1 < len(query) && query[j+1] == quote {
+				j++
+			} else {
+				return j + 1
+			}
+		}
+	}
+	return len(query)
+}
+
+func skipLine(query string, i int) int {
+	if k := strings.IndexByte(query[i:], '\n'); k >= 0 {
+		return i + k + 1
+	}
+	return len(query)
+}
+
+func appendLiteral(buf []byte, arg interface{}, noBackslashEscapes bool) ([]byte, error) {
+	switch v := arg.(type) {
+	case nil:
+		return append(buf, "NULL"...), nil
+	case int8:
+		return strconv.AppendInt(buf, int64(v), 10), nil
+	case int16:
+		return strconv.AppendInt(buf, int64(v), 10), nil
+	case int32:
+		return strconv.AppendInt(buf, int64(v), 10), nil
+	case int:
+		return strconv.AppendInt(buf, int64(v), 10), nil
+	case int64:
+		return strconv.AppendInt(buf, v, 10), nil
+	case uint8:
+		return strconv.AppendUint(buf, uint64(v), 10), nil
+	case uint16:
+		return strconv.AppendUint(buf, uint64(v), 10), nil
+	case uint32:
+		return strconv.AppendUint(buf, uint64(v), 10), nil
+	case uint:
+		return strconv.AppendUint(buf, uint64(v), 10), nil
+	case uint64:
+		return strconv.AppendUint(buf, v, 10), nil
+	case bool:
+		if v {
+			return append(buf, '1'), nil
+		}
+		return append(buf, '0'), nil
+	case float32:
+		return appendFloat(buf, float64(v), 32)
+	case float64:
+		return appendFloat(buf, v, 64)
+	case string:
+		return appendString(buf, v, noBackslashEscapes), nil
+	case json.RawMessage:
+		if v == nil {
+			return append(buf, "NULL"...), nil
+		}
+		return appendString(buf, string(v), noBackslashEscapes), nil
+	case []byte:
+		if v == nil {
+			return append(buf, "NULL"...), nil
+		}
+		// the hex literal needs no escaping, and is not converted by the charset
+		buf = append(buf, "_binary x'"...)
+		buf = append(buf, hex.EncodeToString(v)...)
+		return append(buf, '\''), nil
+	case time.Time:
+		return appendTime(buf, v), nil
+	case *time.Time:
+		if v == nil {
+			return append(buf, "NULL"...), nil
+		}
+		return appendTime(buf, *v), nil
+	}
+
+	// the named types, like type ID int64, and the pointers
+	rv := reflect.ValueOf(arg)
+	switch rv.Kind() {
+	case reflect.Ptr:
+		if rv.IsNil() {
+			return append(buf, "NULL"...), nil
+		}
+		return appendLiteral(buf, rv.Elem().Interface(), noBackslashEscapes)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.AppendInt(buf, rv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.AppendUint(buf, rv.Uint(), 10), nil
+	case reflect.Bool:
+		return appendLiteral(buf, rv.Bool(), noBackslashEscapes)
+	case reflect.Float32:
+		return appendFloat(buf, rv.Float(), 32)
+	case reflect.Float64:
+		return appendFloat(buf, rv.Float(), 64)
+	case reflect.String:
+		return appendString(buf, rv.String(), noBackslashEscapes), nil
+	case reflect.Slice:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			return appendLiteral(buf, rv.Bytes(), noBackslashEscapes)
+		}
+	}
+
+	return nil, errors.Errorf("invalid argument type %T", arg)
+}
+
+func appendFloat(buf []byte, v float64, bitSize int) ([]byte, error) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil, errors.Errorf("invalid float argument %v", v)
+	}
+	return strconv.AppendFloat(buf, v, 'g', -1, bitSize), nil
+}
+
+func appendTime(buf []byte, t time.Time) []byte {
+	if t.IsZero() {
+		return append(buf, "'0000-00-00'"...)
+	}
+
+	buf = append(buf, '\'')
+	if t.Nanosecond() == 0 {
+		buf = t.AppendFormat(buf, "2006-01-02 15:04:05")
+	} else {
+		buf = t.AppendFormat(buf, "2006-01-02 15:04:05.999999")
+	}
+	return append(buf, '\'')
+}
+
+// appendString quotes s, if NO_BACKSLASH_ESCAPES is on, only the quote is escaped by doubling it.
+func appendString(buf []byte, s string, noBackslashEscapes bool) []byte {
+	buf = append(buf, '\'')
+
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if noBackslashEscapes {
+			if ch == '\'' {
+				buf = append(buf, '\'')
+			}
+			buf = append(buf, ch)
+		} else if to := mysql.EncodeMap[ch]; to == mysql.DONTESCAPE {
+			buf = append(buf, ch)
+		} else {
+			buf = append(buf, '\\', to)
+		}
+	}
+
+	return append(buf, '\'')
+}
@@ -0,0 +1,103 @@
+package client
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+
+	"github.com/gdey/go-mysql/mysql"
+	. "gopkg.in/check.v1"
+)
+
+type interpolateTestSuite struct{}
+
+var _ = Suite(&interpolateTestSuite{})
+
+type testID int64
+
+type testName string
+
+func (s *interpolateTestSuite) TestInterpolate(c *C) {
+	conn := &Conn{charset: mysql.DEFAULT_CHARSET}
+
+	t := time.Date(2016, 1, 2, 3, 4, 5, 600000000, time.UTC)
+	name := testName("abc")
+
+	tbl := []struct {
+		query  string
+		args   []interface{}
+		expect string
+	}{
+		{"SELECT ?, ?, ?", []interface{}{int8(-1), uint64(math.MaxUint64), 1.5}, "SELECT -1, 18446744073709551615, 1.5"},
+		{"SELECT ?, ?", []interface{}{nil, true}, "SELECT NULL, 1"},
+		{"SELECT ?", []interface{}{"a'b\"c\\d\n\x00"}, `SELECT 'a\'b\"c\\d\n\0'`},
+		{"SELECT ?, ?", []interface{}{[]byte("a'b"), []byte(nil)}, "SELECT _binary x'612762', NULL"},
+		{"SELECT ?", []interface{}{json.RawMessage(`{"a":"b"}`)}, `SELECT '{\"a\":\"b\"}'`},
+		{"SELECT ?, ?", []interface{}{t, time.Time{}}, "SELECT '2016-01-02 03:04:05.6', '0000-00-00'"},
+		{"SELECT ?, ?, ?", []interface{}{testID(10), &name, (*int)(nil)}, "SELECT 10, 'abc', NULL"},
+		// not placeholders
+		{"SELECT '?', \"?\", `?`, 'it\\'s ?', ? # ?", []interface{}{1}, "SELECT '?', \"?\", `?`, 'it\\'s ?', 1 # ?"},
+		{"SELECT ? /* ? */, ? -- ?\n", []interface{}{1, 2}, "SELECT 1 /* ? */, 2 -- ?\n"},
+	}
+
+	for _, t := range tbl {
+		query, err := conn.interpolate(t.query, t.args)
+		c.Assert(err, IsNil)
+		c.Assert(query, Equals, t.expect)
+	}
+
+	_, err := conn.interpolate("SELECT ?, ?", []interface{}{1})
+	c.Assert(err, NotNil)
+
+	_, err = conn.interpolate("SELECT ?", []interface{}{1, 2})
+	c.Assert(err, NotNil)
+
+	_, err = conn.interpolate("SELECT ?", []interface{}{math.NaN()})
+	c.Assert(err, NotNil)
+
+	_, err = conn.interpolate("SELECT ?", []interface{}{struct{}{}})
+	c.Assert(err, NotNil)
+}
+
+func (s *interpolateTestSuite) TestNoBackslashEscapes(c *C) {
+	conn := &Conn{charset: mysql.DEFAULT_CHARSET, status: mysql.SERVER_STATUS_NO_BACKSLASH_ESCAPED}
+
+	query, err := conn.interpolate("SELECT 'a\\', ?", []interface{}{"a'b\\c"})
+	c.Assert(err, IsNil)
+	c.Assert(query, Equals, "SELECT 'a\\', 'a''b\\c'")
+}
+
+func (s *interpolateTestSuite) TestUnsafeCharset(c *C) {
+	conn := &Conn{charset: mysql.DEFAULT_CHARSET}
+	c.Assert(conn.canInterpolate(), Equals, false)
+
+	conn.SetInterpolateParams(true)
+	c.Assert(conn.canInterpolate(), Equals, true)
+
+	conn.charset = "gbk"
+	c.Assert(conn.canInterpolate(), Equals, false)
+}
+
+func (s *rowsTestSuite) TestInterpolateParams(c *C) {
+	s.conn.SetInterpolateParams(true)
+	defer s.conn.SetInterpolateParams(false)
+
+	// the fake server can't prepare, so the args must be interpolated
+	r, err := s.conn.Execute("SELECT ?", 3)
+	c.Assert(err, IsNil)
+	c.Assert(r.RowNumber(), Equals, 3)
+
+	results, err := s.conn.ExecuteMultiple("SELECT ?; SELECT ?", 1, 2)
+	c.Assert(err, IsNil)
+	c.Assert(results, HasLen, 2)
+	c.Assert(results[1].RowNumber(), Equals, 2)
+
+	rows, err := s.conn.Query("SELECT ?", uint8(4))
+	c.Assert(err, IsNil)
+	n := 0
+	for rows.Next() {
+		n++
+	}
+	c.Assert(rows.Close(), IsNil)
+	c.Assert(n, Equals, 4)
+}
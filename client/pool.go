@@ -0,0 +1,345 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/gdey/go-mysql/mysql"
+	"github.com/juju/errors"
+)
+
+var ErrPoolClosed = errors.New("pool was closed")
+
+const (
+	defaultPoolMaxIdle = 2
+
+	// the pool closes the expired idle connections and opens the min idle ones in this interval
+	poolMaintainInterval = time.Second
+
+	// Execute retries with another connection if the connection is bad
+	poolBadConnRetries = 3
+)
+
+type PoolConfig struct {
+	Addr     string
+	User     string
+	Password string
+	DB       string
+
+	// Options are applied to every new connection, see Connect.
+	Options []func(c *Conn)
+
+	// MinIdle is the number of idle connections the pool keeps ready.
+	MinIdle int
+	// MaxIdle is the max number of idle connections, the others are closed on Put,
+	// 0 means max(MinIdle, 2).
+	MaxIdle int
+	// MaxOpen is the max number of open connections, Get waits if it's reached, 0 means no limit.
+	MaxOpen int
+	// MaxLifetime is how long a connection can be reused, 0 means forever.
+	MaxLifetime time.Duration
+	// PingInterval is how long a connection can be idle before Get checks it with Ping,
+	// 0 means always ping.
+	PingInterval time.Duration
+}
+
+// Pool is a pool of connections to a MySQL server, it's safe for concurrent use.
+// Use Get and Put to hold a connection for more than one query, like a transaction,
+// or Execute for a single query.
+type Pool struct {
+	cfg PoolConfig
+
+	m      sync.Mutex
+	idle   []*Conn
+	open   int
+	closed bool
+
+	// notified when a connection is put back or closed, so a waiting Get can try again
+	wakeup chan struct{}
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewPool(cfg PoolConfig) *Pool {
+	if cfg.MaxIdle <= 0 {
+		cfg.MaxIdle = defaultPoolMaxIdle
+		if cfg.MinIdle > cfg.MaxIdle {
+			cfg.MaxIdle = cfg.MinIdle
+		}
+	}
+
+	p := new(Pool)
+	p.cfg = cfg
+	p.idle = make([]*Conn, 0, cfg.MaxIdle)
+	p.wakeup = make(chan struct{}, 1)
+	p.quit = make(chan struct{})
+
+	if cfg.MinIdle > 0 || cfg.MaxLifetime > 0 {
+		p.wg.Add(1)
+		go p.run()
+	}
+
+	return p
+}
+
+// Get returns an idle connection or opens a new one,
+// it waits for a connection to be put back if MaxOpen is reached, until ctx is done.
+func (p *Pool) Get(ctx context.Context) (*Conn, error) {
+	for {
+		p.m.Lock()
+		if p.closed {
+			p.m.Unlock()
+			// wake up the other waiting Get
+			p.notify()
+			return nil, ErrPoolClosed
+		}
+
+		if n := len(p.idle); n > 0 {
+			c := p.idle[n-1]
+			p.idle = p.idle[:n-1]
+			if n > 1 {
+				// let another waiting Get take the rest
+				p.notify()
+			}
+			p.m.Unlock()
+
+			if p.expired(c) || !p.alive(c) {
+				p.closeConn(c)
+				continue
+			}
+
+			return c, nil
+		}
+
+		if p.cfg.MaxOpen <= 0 || p.open < p.cfg.MaxOpen {
+			p.open++
+			p.m.Unlock()
+
+			c, err := p.newConn()
+			if err != nil {
+				p.m.Lock()
+				p.open--
+				p.m.Unlock()
+				p.notify()
+				return nil, errors.Trace(err)
+			}
+
+			return c, nil
+		}
+		p.m.Unlock()
+
+		select {
+		case <-ctx.Done():
+			// we may have taken the wakeup for another waiting Get
+			p.notify()
+			return nil, ctx.Err()
+		case <-p.wakeup:
+		}
+	}
+}
+
+// Put returns the connection to the pool, the session state is reset by COM_RESET_CONNECTION,
+// and it's changed to the default database if any. A closed connection, or one can't be reset,
+// like the server doesn't support COM_RESET_CONNECTION, is discarded.
+func (p *Pool) Put(c *Conn) {
+	if err := p.reset(c); err != nil {
+		p.closeConn(c)
+		return
+	}
+
+	p.m.Lock()
+	if p.closed || len(p.idle) >= p.cfg.MaxIdle || p.expired(c) {
+		p.m.Unlock()
+		p.closeConn(c)
+		return
+	}
+
+	c.lastUsed = time.Now()
+	p.idle = append(p.idle, c)
+	p.m.Unlock()
+
+	p.notify()
+}
+
+// Execute runs the command with a connection of the pool,
+// it retries with another connection if the connection is bad.
+func (p *Pool) Execute(command string, args ...interface{}) (*mysql.Result, error) {
+	var err error
+	for i := 0; i < poolBadConnRetries; i++ {
+		var c *Conn
+		if c, err = p.Get(context.Background()); err != nil {
+			return nil, errors.Trace(err)
+		}
+
+		var r *mysql.Result
+		r, err = c.Execute(command, args...)
+		if errors.Cause(err) == mysql.ErrBadConn {
+			c.Close()
+			p.Put(c)
+			continue
+		}
+
+		p.Put(c)
+		return r, err
+	}
+	return nil, err
+}
+
+// Stats returns the number of open connections, and how many of them are idle.
+func (p *Pool) Stats() (open int, idle int) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	return p.open, len(p.idle)
+}
+
+// Close closes the idle connections, the ones in use are closed when they are put back.
+func (p *Pool) Close() {
+	p.m.Lock()
+	if p.closed {
+		p.m.Unlock()
+		return
+	}
+
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	p.m.Unlock()
+
+	close(p.quit)
+	p.wg.Wait()
+
+	p.notify()
+
+	for _, c := range idle {
+		p.closeConn(c)
+	}
+}
+
+func (p *Pool) notify() {
+	select {
+	case p.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+func (p *Pool) newConn() (*Conn, error) {
+	c, err := Connect(p.cfg.Addr, p.cfg.User, p.cfg.Password, p.cfg.DB, p.cfg.Options...)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	c.createdAt = time.Now()
+	c.lastUsed = c.createdAt
+	return c, nil
+}
+
+func (p *Pool) closeConn(c *Conn) {
+	if !c.closed {
+		c.Close()
+	}
+
+	p.m.Lock()
+	p.open--
+	p.m.Unlock()
+
+	p.notify()
+}
+
+func (p *Pool) expired(c *Conn) bool {
+	return p.cfg.MaxLifetime > 0 && time.Since(c.createdAt) > p.cfg.MaxLifetime
+}
+
+// alive checks the idle connection with Ping if it has been idle for PingInterval.
+func (p *Pool) alive(c *Conn) bool {
+	if p.cfg.PingInterval > 0 && time.Since(c.lastUsed) < p.cfg.PingInterval {
+		return true
+	}
+
+	return c.Ping() == nil
+}
+
+// reset resets the session state of the connection to the one just connected, the database used
+// is kept if there is no default database, as it can't be unset.
+func (p *Pool) reset(c *Conn) error {
+	if c.closed {
+		return mysql.ErrBadConn
+	}
+
+	if c.rows != nil {
+		if err := c.rows.Close(); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	if err := c.ResetConnection(); err != nil {
+		return errors.Trace(err)
+	}
+
+	if len(p.cfg.DB) > 0 {
+		if err := c.UseDB(p.cfg.DB); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	return nil
+}
+
+// run closes the expired idle connections, and keeps MinIdle connections ready.
+func (p *Pool) run() {
+	defer p.wg.Done()
+
+	t := time.NewTicker(poolMaintainInterval)
+	defer t.Stop()
+
+	for {
+		p.maintain()
+
+		select {
+		case <-p.quit:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (p *Pool) maintain() {
+	p.m.Lock()
+	var expired []*Conn
+	idle := p.idle[:0]
+	for _, c := range p.idle {
+		if p.expired(c) {
+			expired = append(expired, c)
+		} else {
+			idle = append(idle, c)
+		}
+	}
+	p.idle = idle
+	p.m.Unlock()
+
+	for _, c := range expired {
+		p.closeConn(c)
+	}
+
+	for {
+		p.m.Lock()
+		if p.closed || len(p.idle) >= p.cfg.MinIdle || (p.cfg.MaxOpen > 0 && p.open >= p.cfg.MaxOpen) {
+			p.m.Unlock()
+			return
+		}
+		p.open++
+		p.m.Unlock()
+
+		c, err := p.newConn()
+		if err != nil {
+			p.m.Lock()
+			p.open--
+			p.m.Unlock()
+			return
+		}
+
+		p.Put(c)
+	}
+}
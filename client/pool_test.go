@@ -0,0 +1,309 @@
+package client
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gdey/go-mysql/mysql"
+	"github.com/gdey/go-mysql/packet"
+	. "gopkg.in/check.v1"
+)
+
+// poolTestSuite tests the pool with a fake server which accepts any password,
+// and replies OK to every command, but COM_RESET_CONNECTION if noReset.
+type poolTestSuite struct {
+	l net.Listener
+
+	m        sync.Mutex
+	conns    []net.Conn
+	commands []string
+	noReset  bool
+}
+
+var _ = Suite(&poolTestSuite{})
+
+func (s *poolTestSuite) SetUpTest(c *C) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+
+	s.m.Lock()
+	s.l = l
+	s.conns = nil
+	s.commands = nil
+	s.noReset = false
+	s.m.Unlock()
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go s.serve(conn)
+		}
+	}()
+}
+
+func (s *poolTestSuite) TearDownTest(c *C) {
+	s.l.Close()
+	s.closeServerConns()
+}
+
+func (s *poolTestSuite) serve(conn net.Conn) {
+	h := &authHandshake{Conn: packet.NewConn(conn), plugin: mysql.AUTH_NATIVE_PASSWORD}
+	defer h.Close()
+
+	s.m.Lock()
+	s.conns = append(s.conns, conn)
+	s.m.Unlock()
+
+	if err := h.handshake(); err != nil {
+		return
+	}
+
+	err := h.writeOK()
+	for err == nil {
+		h.ResetSequence()
+
+		data, err := h.readData()
+		if err != nil || data[0] == mysql.COM_QUIT {
+			return
+		}
+
+		s.m.Lock()
+		switch data[0] {
+		case mysql.COM_PING:
+			s.commands = append(s.commands, "PING")
+		case mysql.COM_INIT_DB:
+			s.commands = append(s.commands, "USE "+string(data[1:]))
+		case mysql.COM_RESET_CONNECTION:
+			s.commands = append(s.commands, "RESET")
+		default:
+			s.commands = append(s.commands, string(data[1:]))
+		}
+		noReset := s.noReset
+		s.m.Unlock()
+
+		if data[0] == mysql.COM_RESET_CONNECTION && noReset {
+			code := uint16(mysql.ER_UNKNOWN_COM_ERROR)
+			err = h.writeData(append([]byte{mysql.ERR_HEADER, byte(code), byte(code >> 8), '#'}, "08S01Unknown command"...)...)
+		} else {
+			err = h.writeOK()
+		}
+	}
+}
+
+func (s *poolTestSuite) takeCommands() []string {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	commands := s.commands
+	s.commands = nil
+	return commands
+}
+
+// closeServerConns closes the server side of all the connections.
+func (s *poolTestSuite) closeServerConns() {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *poolTestSuite) newPool(cfg PoolConfig) *Pool {
+	cfg.Addr = s.l.Addr().String()
+	cfg.User = "root"
+	return NewPool(cfg)
+}
+
+func (s *poolTestSuite) TestGetPut(c *C) {
+	p := s.newPool(PoolConfig{DB: "test", MaxIdle: 1, PingInterval: time.Hour})
+	defer p.Close()
+
+	c1, err := p.Get(context.Background())
+	c.Assert(err, IsNil)
+	c2, err := p.Get(context.Background())
+	c.Assert(err, IsNil)
+	c.Assert(c1, Not(Equals), c2)
+
+	open, idle := p.Stats()
+	c.Assert(open, Equals, 2)
+	c.Assert(idle, Equals, 0)
+
+	p.Put(c1)
+	// the idle connection is full
+	p.Put(c2)
+	c.Assert(c2.closed, Equals, true)
+
+	open, idle = p.Stats()
+	c.Assert(open, Equals, 1)
+	c.Assert(idle, Equals, 1)
+
+	c3, err := p.Get(context.Background())
+	c.Assert(err, IsNil)
+	c.Assert(c3, Equals, c1)
+	p.Put(c3)
+
+	c.Assert(s.takeCommands(), DeepEquals, []string{"RESET", "RESET", "RESET"})
+}
+
+func (s *poolTestSuite) TestReset(c *C) {
+	p := s.newPool(PoolConfig{DB: "test", PingInterval: time.Hour})
+	defer p.Close()
+
+	conn, err := p.Get(context.Background())
+	c.Assert(err, IsNil)
+
+	c.Assert(conn.UseDB("test2"), IsNil)
+	c.Assert(conn.SetCharset("latin1"), IsNil)
+	// the fake server doesn't track the status, the OK packet of the reset has autocommit
+	conn.status = mysql.SERVER_STATUS_IN_TRANS
+
+	p.Put(conn)
+	c.Assert(conn.closed, Equals, false)
+	c.Assert(conn.GetDB(), Equals, "test")
+	c.Assert(conn.IsInTransaction(), Equals, false)
+	c.Assert(conn.charset, Equals, mysql.DEFAULT_CHARSET)
+
+	c.Assert(s.takeCommands(), DeepEquals, []string{"USE test2", "SET NAMES latin1", "RESET", "USE test"})
+
+	// the database used is kept without the default one
+	p2 := s.newPool(PoolConfig{PingInterval: time.Hour})
+	defer p2.Close()
+
+	conn, err = p2.Get(context.Background())
+	c.Assert(err, IsNil)
+	c.Assert(conn.UseDB("test2"), IsNil)
+
+	p2.Put(conn)
+	c.Assert(conn.closed, Equals, false)
+	c.Assert(conn.GetDB(), Equals, "test2")
+	c.Assert(s.takeCommands(), DeepEquals, []string{"USE test2", "RESET"})
+
+	// the connection is closed if the server doesn't support COM_RESET_CONNECTION
+	s.m.Lock()
+	s.noReset = true
+	s.m.Unlock()
+
+	conn, err = p.Get(context.Background())
+	c.Assert(err, IsNil)
+
+	p.Put(conn)
+	c.Assert(conn.closed, Equals, true)
+	c.Assert(s.takeCommands(), DeepEquals, []string{"RESET"})
+}
+
+func (s *poolTestSuite) TestMaxOpen(c *C) {
+	p := s.newPool(PoolConfig{MaxOpen: 1, PingInterval: time.Hour})
+	defer p.Close()
+
+	conn, err := p.Get(context.Background())
+	c.Assert(err, IsNil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	_, err = p.Get(ctx)
+	cancel()
+	c.Assert(err, Equals, context.DeadlineExceeded)
+
+	done := make(chan *Conn)
+	go func() {
+		conn, err := p.Get(context.Background())
+		c.Check(err, IsNil)
+		done <- conn
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	p.Put(conn)
+
+	select {
+	case conn2 := <-done:
+		c.Assert(conn2, Equals, conn)
+		p.Put(conn2)
+	case <-time.After(5 * time.Second):
+		c.Fatal("Get is not woken up by Put")
+	}
+}
+
+func (s *poolTestSuite) TestHealthCheck(c *C) {
+	p := s.newPool(PoolConfig{})
+	defer p.Close()
+
+	conn, err := p.Get(context.Background())
+	c.Assert(err, IsNil)
+	p.Put(conn)
+
+	s.closeServerConns()
+
+	// the broken idle connection is discarded after ping
+	conn2, err := p.Get(context.Background())
+	c.Assert(err, IsNil)
+	c.Assert(conn2, Not(Equals), conn)
+	c.Assert(conn.closed, Equals, true)
+	p.Put(conn2)
+
+	s.closeServerConns()
+	s.takeCommands()
+
+	// Execute retries with a new connection, which is reset when it's put back
+	_, err = p.Execute("SELECT 1")
+	c.Assert(err, IsNil)
+	c.Assert(s.takeCommands(), DeepEquals, []string{"SELECT 1", "RESET"})
+
+	open, idle := p.Stats()
+	c.Assert(open, Equals, 1)
+	c.Assert(idle, Equals, 1)
+}
+
+func (s *poolTestSuite) TestMaxLifetime(c *C) {
+	p := s.newPool(PoolConfig{MaxLifetime: 100 * time.Millisecond, PingInterval: time.Hour})
+	defer p.Close()
+
+	conn, err := p.Get(context.Background())
+	c.Assert(err, IsNil)
+	p.Put(conn)
+
+	time.Sleep(200 * time.Millisecond)
+
+	conn2, err := p.Get(context.Background())
+	c.Assert(err, IsNil)
+	c.Assert(conn2, Not(Equals), conn)
+	c.Assert(conn.closed, Equals, true)
+	p.Put(conn2)
+}
+
+func (s *poolTestSuite) TestMinIdle(c *C) {
+	p := s.newPool(PoolConfig{MinIdle: 2})
+
+	for i := 0; i < 50; i++ {
+		if _, idle := p.Stats(); idle == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	open, idle := p.Stats()
+	c.Assert(open, Equals, 2)
+	c.Assert(idle, Equals, 2)
+
+	conn, err := p.Get(context.Background())
+	c.Assert(err, IsNil)
+
+	p.Close()
+
+	_, err = p.Get(context.Background())
+	c.Assert(err, Equals, ErrPoolClosed)
+
+	// the connection in use is closed when it's put back
+	p.Put(conn)
+	c.Assert(conn.closed, Equals, true)
+
+	open, idle = p.Stats()
+	c.Assert(open, Equals, 0)
+	c.Assert(idle, Equals, 0)
+}
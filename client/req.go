@@ -1,7 +1,24 @@
 package client
 
-func (c *Conn) writeCommand(command byte) error {
+import "github.com/juju/errors"
+
+// ErrUnreadRows is returned if a command is sent before the streaming rows are read or closed.
+var ErrUnreadRows = errors.New("unread rows on connection")
+
+// startCommand resets the sequence for a new command, the rows must be read before.
+func (c *Conn) startCommand() error {
+	if c.rows != nil {
+		return ErrUnreadRows
+	}
+
 	c.ResetSequence()
+	return nil
+}
+
+func (c *Conn) writeCommand(command byte) error {
+	if err := c.startCommand(); err != nil {
+		return err
+	}
 
 	return c.WritePacket([]byte{
 		0x01, //1 bytes long
@@ -13,7 +30,9 @@ func (c *Conn) writeCommand(command byte) error {
 }
 
 func (c *Conn) writeCommandBuf(command byte, arg []byte) error {
-	c.ResetSequence()
+	if err := c.startCommand(); err != nil {
+		return err
+	}
 
 	length := len(arg) + 1
 
@@ -27,7 +46,9 @@ func (c *Conn) writeCommandBuf(command byte, arg []byte) error {
 }
 
 func (c *Conn) writeCommandStr(command byte, arg string) error {
-	c.ResetSequence()
+	if err := c.startCommand(); err != nil {
+		return err
+	}
 
 	length := len(arg) + 1
 
@@ -41,7 +62,9 @@ func (c *Conn) writeCommandStr(command byte, arg string) error {
 }
 
 func (c *Conn) writeCommandUint32(command byte, arg uint32) error {
-	c.ResetSequence()
+	if err := c.startCommand(); err != nil {
+		return err
+	}
 
 	return c.WritePacket([]byte{
 		0x05, //5 bytes long
@@ -59,7 +82,9 @@ func (c *Conn) writeCommandUint32(command byte, arg uint32) error {
 }
 
 func (c *Conn) writeCommandStrStr(command byte, arg1 string, arg2 string) error {
-	c.ResetSequence()
+	if err := c.startCommand(); err != nil {
+		return err
+	}
 
 	data := make([]byte, 4, 6+len(arg1)+len(arg2))
 
@@ -107,13 +107,55 @@ func (c *Conn) readResult(binary bool) (*mysql.Result, error) {
 	} else if data[0] == mysql.ERR_HEADER {
 		return nil, c.handleErrorPacket(data)
 	} else if data[0] == mysql.LocalInFile_HEADER {
-		return nil, mysql.ErrMalformPacket
+		return c.handleLocalInfile(data)
 	}
 
 	return c.readResultset(data, binary)
 }
 
+// readResults reads the results of a multi statement or a stored procedure, one for each statement,
+// until SERVER_MORE_RESULTS_EXISTS is not set. The results before an error are returned with the error.
+func (c *Conn) readResults(binary bool) ([]*mysql.Result, error) {
+	var results []*mysql.Result
+	for {
+		r, err := c.readResult(binary)
+		if err != nil {
+			return results, errors.Trace(err)
+		}
+
+		results = append(results, r)
+
+		if c.status&mysql.SERVER_MORE_RESULTS_EXISTS == 0 {
+			return results, nil
+		}
+	}
+}
+
+// readFirstResult reads all the results, but only returns the first one.
+func (c *Conn) readFirstResult(binary bool) (*mysql.Result, error) {
+	results, err := c.readResults(binary)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return results[0], nil
+}
+
 func (c *Conn) readResultset(data []byte, binary bool) (*mysql.Result, error) {
+	result, err := c.readResultsetHeader(data)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if err := c.readResultRows(result, binary); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return result, nil
+}
+
+// readResultsetHeader reads the column count in data and the columns, the rows are not read.
+func (c *Conn) readResultsetHeader(data []byte) (*mysql.Result, error) {
 	result := &mysql.Result{
 		Status:       0,
 		InsertId:     0,
@@ -136,10 +178,6 @@ func (c *Conn) readResultset(data []byte, binary bool) (*mysql.Result, error) {
 		return nil, errors.Trace(err)
 	}
 
-	if err := c.readResultRows(result, binary); err != nil {
-		return nil, errors.Trace(err)
-	}
-
 	return result, nil
 }
 
@@ -200,6 +238,9 @@ func (c *Conn) readResultRows(result *mysql.Result, isBinary bool) (err error) {
 			}
 
 			break
+		} else if data[0] == mysql.ERR_HEADER {
+			// the query may fail while sending the rows
+			return c.handleErrorPacket(data)
 		}
 
 		result.RowDatas = append(result.RowDatas, data)
@@ -0,0 +1,339 @@
+package client
+
+import (
+	"encoding/binary"
+
+	"github.com/gdey/go-mysql/mysql"
+	"github.com/juju/errors"
+)
+
+// Rows is a result set streamed from the connection, Next reads the rows one by one,
+// so the whole result set is never buffered.
+// The connection can't be used for another command until all the rows are read or Rows is closed.
+type Rows struct {
+	c      *Conn
+	binary bool
+
+	// the statement prepared by Query, closed with rows
+	stmt *Stmt
+
+	result *mysql.Result
+	data   mysql.RowData
+	values []interface{}
+
+	// the rows of the current result set are all read
+	done bool
+	// there is another result set after the current one
+	more bool
+	// all the result sets are read
+	finished bool
+
+	err error
+}
+
+// Query executes the command and returns the rows without reading them,
+// the command is prepared and executed with args if it has any, or interpolated, see SetInterpolateParams.
+func (c *Conn) Query(command string, args ...interface{}) (*Rows, error) {
+	if len(args) > 0 && c.canInterpolate() {
+		var err error
+		if command, err = c.interpolate(command, args); err != nil {
+			return nil, errors.Trace(err)
+		}
+		args = nil
+	}
+
+	if len(args) == 0 {
+		if err := c.writeCommandStr(mysql.COM_QUERY, command); err != nil {
+			return nil, errors.Trace(err)
+		}
+
+		return c.readRows(false)
+	}
+
+	s, err := c.PrepareCached(command)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	rows, err := s.Query(args...)
+	if err != nil {
+		s.Close()
+		return nil, errors.Trace(err)
+	}
+
+	if rows.finished {
+		s.Close()
+	} else {
+		rows.stmt = s
+	}
+	return rows, nil
+}
+
+// ExecuteStreaming is like Execute, but onRow is called for every row as it's read instead of buffering the rows,
+// the returned result has no rows. onFields, if not nil, is called with the fields before the rows.
+// If a callback returns an error, the rest rows are drained, and the error is returned.
+func (c *Conn) ExecuteStreaming(command string, onFields func(fields []*mysql.Field) error,
+	onRow func(row []interface{}) error, args ...interface{}) (*mysql.Result, error) {
+	rows, err := c.Query(command, args...)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer rows.Close()
+
+	if onFields != nil && rows.Fields() != nil {
+		if err = onFields(rows.Fields()); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+
+	for rows.Next() {
+		if err = onRow(rows.Values()); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return rows.Result(), nil
+}
+
+// Query executes the statement and returns the rows without reading them.
+func (s *Stmt) Query(args ...interface{}) (*Rows, error) {
+	if err := s.write(args...); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	rows, err := s.conn.readRows(true)
+	if err != nil {
+		s.checkStale(err)
+		return nil, errors.Trace(err)
+	}
+	return rows, nil
+}
+
+func (c *Conn) readRows(binary bool) (*Rows, error) {
+	rows := &Rows{c: c, binary: binary}
+	if err := rows.readResultsetHeader(); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if !rows.finished {
+		c.rows = rows
+	}
+	return rows, nil
+}
+
+// readResultsetHeader reads the OK packet, or the fields of the next result set.
+func (r *Rows) readResultsetHeader() error {
+	data, err := r.c.ReadPacket()
+	if err != nil {
+		r.finish()
+		return errors.Trace(err)
+	}
+
+	r.done = false
+	r.more = false
+
+	switch data[0] {
+	case mysql.OK_HEADER:
+		// no result set
+		if r.result, err = r.c.handleOKPacket(data); err != nil {
+			r.finish()
+			return errors.Trace(err)
+		}
+		r.endResultset()
+		return nil
+	case mysql.ERR_HEADER:
+		r.finish()
+		return r.c.handleErrorPacket(data)
+	case mysql.LocalInFile_HEADER:
+		if r.result, err = r.c.handleLocalInfile(data); err != nil {
+			r.finish()
+			return errors.Trace(err)
+		}
+		r.endResultset()
+		return nil
+	}
+
+	if r.result, err = r.c.readResultsetHeader(data); err != nil {
+		r.finish()
+		return errors.Trace(err)
+	}
+
+	return nil
+}
+
+// Fields returns the fields of the result set, or nil if the command has no result set.
+func (r *Rows) Fields() []*mysql.Field {
+	if r.result.Resultset == nil {
+		return nil
+	}
+	return r.result.Fields
+}
+
+// Result returns the result without rows, the status is updated after all the rows are read.
+func (r *Rows) Result() *mysql.Result {
+	return r.result
+}
+
+// Next reads the next row of the current result set,
+// it returns false if there are no more rows or an error occurs, see Err.
+func (r *Rows) Next() bool {
+	if r.done || r.err != nil {
+		return false
+	}
+
+	data, err := r.c.ReadPacket()
+	if err != nil {
+		r.err = errors.Trace(err)
+		r.finish()
+		return false
+	}
+
+	if r.c.isEOFPacket(data) {
+		r.readEOF(data)
+		r.endResultset()
+		return false
+	} else if data[0] == mysql.ERR_HEADER {
+		// the query may fail while sending the rows
+		r.err = r.c.handleErrorPacket(data)
+		r.finish()
+		return false
+	}
+
+	r.data = data
+	if r.values, err = r.data.Parse(r.result.Fields, r.binary); err != nil {
+		// the rest rows are drained in Close
+		r.err = errors.Trace(err)
+		return false
+	}
+
+	return true
+}
+
+// HasNextResultSet returns whether there is another result set, after the current one is read.
+func (r *Rows) HasNextResultSet() bool {
+	return r.more
+}
+
+// NextResultSet drains the current result set, and moves to the next one of a multi statement or stored procedure,
+// it returns false if there is none or an error occurs, see Err.
+func (r *Rows) NextResultSet() bool {
+	if r.err != nil {
+		return false
+	}
+
+	if err := r.drain(); err != nil {
+		r.err = errors.Trace(err)
+		return false
+	}
+
+	if !r.more {
+		return false
+	}
+
+	if err := r.readResultsetHeader(); err != nil {
+		r.err = errors.Trace(err)
+		return false
+	}
+
+	return true
+}
+
+// Values returns the current row.
+func (r *Rows) Values() []interface{} {
+	return r.values
+}
+
+// RowData returns the current row not parsed, in the binary protocol if the rows are of a statement,
+// so it can be sent as is, like in a proxy.
+func (r *Rows) RowData() mysql.RowData {
+	return r.data
+}
+
+// Binary returns whether the rows are in the binary protocol.
+func (r *Rows) Binary() bool {
+	return r.binary
+}
+
+// Err returns the error while reading the rows.
+func (r *Rows) Err() error {
+	return r.err
+}
+
+// Close drains the rows and the result sets not read, so the connection can be used again.
+func (r *Rows) Close() error {
+	for !r.finished {
+		if err := r.drain(); err != nil {
+			return errors.Trace(err)
+		}
+
+		if r.more {
+			if err := r.readResultsetHeader(); err != nil {
+				return errors.Trace(err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// drain reads the rest rows of the current result set.
+func (r *Rows) drain() error {
+	for !r.done {
+		data, err := r.c.ReadPacket()
+		if err != nil {
+			r.finish()
+			return errors.Trace(err)
+		}
+
+		if r.c.isEOFPacket(data) {
+			r.readEOF(data)
+			r.endResultset()
+		} else if data[0] == mysql.ERR_HEADER {
+			r.finish()
+			return r.c.handleErrorPacket(data)
+		}
+	}
+
+	return nil
+}
+
+func (r *Rows) readEOF(data []byte) {
+	if r.c.capability&mysql.CLIENT_PROTOCOL_41 > 0 {
+		r.result.Status = binary.LittleEndian.Uint16(data[3:])
+		r.c.status = r.result.Status
+	}
+}
+
+// endResultset is called after the current result set is read.
+func (r *Rows) endResultset() {
+	r.done = true
+	r.data = nil
+	r.values = nil
+
+	r.more = r.c.status&mysql.SERVER_MORE_RESULTS_EXISTS > 0
+	if !r.more {
+		r.finish()
+	}
+}
+
+// finish is called after all the result sets are read, or the connection is broken.
+func (r *Rows) finish() {
+	r.done = true
+	r.more = false
+	r.finished = true
+	r.data = nil
+	r.values = nil
+
+	if r.c.rows == r {
+		r.c.rows = nil
+	}
+
+	if r.stmt != nil {
+		r.stmt.Close()
+		r.stmt = nil
+	}
+}
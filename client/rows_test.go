@@ -0,0 +1,277 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/gdey/go-mysql/mysql"
+	"github.com/gdey/go-mysql/packet"
+	"github.com/juju/errors"
+	. "gopkg.in/check.v1"
+)
+
+// rowsTestSuite tests the streaming and multiple result sets with a fake server,
+// "SELECT n" returns n rows, "FAIL n" fails after n rows, and the other statements return OK.
+type rowsTestSuite struct {
+	l    net.Listener
+	conn *Conn
+}
+
+var _ = Suite(&rowsTestSuite{})
+
+func (s *rowsTestSuite) SetUpTest(c *C) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	s.l = l
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		s.serve(conn)
+	}()
+
+	s.conn, err = Connect(l.Addr().String(), "root", "", "", func(conn *Conn) {
+		conn.SetMultiStatements(true)
+	})
+	c.Assert(err, IsNil)
+}
+
+func (s *rowsTestSuite) TearDownTest(c *C) {
+	s.conn.Close()
+	s.l.Close()
+}
+
+func (s *rowsTestSuite) serve(conn net.Conn) {
+	h := &authHandshake{Conn: packet.NewConn(conn), plugin: mysql.AUTH_NATIVE_PASSWORD}
+	defer h.Close()
+
+	if err := h.handshake(); err != nil {
+		return
+	}
+
+	if err := h.writeOK(); err != nil {
+		return
+	}
+
+	for {
+		h.ResetSequence()
+
+		data, err := h.readData()
+		if err != nil || data[0] != mysql.COM_QUERY {
+			return
+		}
+
+		// every statement of a multi statement has a result
+		stmts := strings.Split(string(data[1:]), ";")
+		for i, stmt := range stmts {
+			status := mysql.SERVER_STATUS_AUTOCOMMIT
+			if i < len(stmts)-1 {
+				status |= mysql.SERVER_MORE_RESULTS_EXISTS
+			}
+
+			seps := append(strings.Fields(stmt), "")
+			if len(seps) != 3 {
+				err = h.writeData(mysql.OK_HEADER, 0, 0, byte(status), byte(status>>8), 0, 0)
+			} else {
+				n, _ := strconv.Atoi(seps[1])
+				err = s.writeRows(h, n, status, seps[0] == "FAIL")
+			}
+
+			if err != nil {
+				return
+			} else if seps[0] == "FAIL" {
+				// the statements after the error are not executed
+				break
+			}
+		}
+	}
+}
+
+func (s *rowsTestSuite) writeRows(h *authHandshake, n int, status uint16, fail bool) error {
+	values := make([][]interface{}, n)
+	for i := range values {
+		values[i] = []interface{}{int64(i), fmt.Sprintf("row %d", i)}
+	}
+
+	r, err := mysql.BuildSimpleTextResultset([]string{"id", "name"}, values)
+	if err != nil {
+		return err
+	}
+
+	if err = h.writeData(mysql.PutLengthEncodedInt(uint64(len(r.Fields)))...); err != nil {
+		return err
+	}
+
+	for _, f := range r.Fields {
+		if err = h.writeData(f.Dump()...); err != nil {
+			return err
+		}
+	}
+
+	eof := []byte{mysql.EOF_HEADER, 0, 0, byte(status), byte(status >> 8)}
+	if err = h.writeData(eof...); err != nil {
+		return err
+	}
+
+	for _, row := range r.RowDatas {
+		if err = h.writeData(row...); err != nil {
+			return err
+		}
+	}
+
+	if fail {
+		return h.writeAccessDenied()
+	}
+	return h.writeData(eof...)
+}
+
+func (s *rowsTestSuite) TestQuery(c *C) {
+	rows, err := s.conn.Query("SELECT 100")
+	c.Assert(err, IsNil)
+	c.Assert(rows.Fields(), HasLen, 2)
+	c.Assert(string(rows.Fields()[1].Name), Equals, "name")
+
+	n := 0
+	for rows.Next() {
+		c.Assert(rows.Values()[0], Equals, int64(n))
+		c.Assert(rows.Values()[1], DeepEquals, []byte(fmt.Sprintf("row %d", n)))
+		n++
+	}
+	c.Assert(rows.Err(), IsNil)
+	c.Assert(n, Equals, 100)
+	c.Assert(rows.Close(), IsNil)
+	c.Assert(rows.Result().Status&mysql.SERVER_STATUS_AUTOCOMMIT, Not(Equals), uint16(0))
+
+	// no result set
+	rows, err = s.conn.Query("BEGIN")
+	c.Assert(err, IsNil)
+	c.Assert(rows.Fields(), IsNil)
+	c.Assert(rows.Next(), Equals, false)
+	c.Assert(rows.Close(), IsNil)
+}
+
+func (s *rowsTestSuite) TestAbort(c *C) {
+	rows, err := s.conn.Query("SELECT 100")
+	c.Assert(err, IsNil)
+	c.Assert(rows.Next(), Equals, true)
+
+	// no command before the rows are read
+	_, err = s.conn.Execute("SELECT 2")
+	c.Assert(errors.Cause(err), Equals, ErrUnreadRows)
+	_, err = s.conn.Prepare("SELECT 2")
+	c.Assert(errors.Cause(err), Equals, ErrUnreadRows)
+	c.Assert(errors.Cause(s.conn.Ping()), Equals, ErrUnreadRows)
+
+	// the rest rows are drained
+	c.Assert(rows.Close(), IsNil)
+
+	r, err := s.conn.Execute("SELECT 2")
+	c.Assert(err, IsNil)
+	c.Assert(r.RowNumber(), Equals, 2)
+
+	errStop := errors.New("stop")
+	n := 0
+	_, err = s.conn.ExecuteStreaming("SELECT 100", nil, func(row []interface{}) error {
+		if n++; n == 10 {
+			return errStop
+		}
+		return nil
+	})
+	c.Assert(errors.Cause(err), Equals, errStop)
+	c.Assert(n, Equals, 10)
+
+	r, err = s.conn.Execute("SELECT 3")
+	c.Assert(err, IsNil)
+	c.Assert(r.RowNumber(), Equals, 3)
+}
+
+func (s *rowsTestSuite) TestExecuteStreaming(c *C) {
+	var fields []*mysql.Field
+	n := 0
+	r, err := s.conn.ExecuteStreaming("SELECT 10", func(fs []*mysql.Field) error {
+		fields = fs
+		return nil
+	}, func(row []interface{}) error {
+		c.Assert(fields, NotNil)
+		n++
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 10)
+	c.Assert(r.RowNumber(), Equals, 0)
+	c.Assert(r.FieldNames["id"], Equals, 0)
+
+	n = 0
+	_, err = s.conn.ExecuteStreaming("FAIL 5", nil, func(row []interface{}) error {
+		n++
+		return nil
+	})
+	c.Assert(errors.Cause(err).(*mysql.MyError).Code, Equals, uint16(mysql.ER_ACCESS_DENIED_ERROR))
+	c.Assert(n, Equals, 5)
+
+	// the failed query is drained
+	c.Assert(s.conn.rows, IsNil)
+	r, err = s.conn.Execute("SELECT 1")
+	c.Assert(err, IsNil)
+	c.Assert(r.RowNumber(), Equals, 1)
+}
+
+func (s *rowsTestSuite) TestExecuteMultiple(c *C) {
+	results, err := s.conn.ExecuteMultiple("SELECT 1; BEGIN; SELECT 2")
+	c.Assert(err, IsNil)
+	c.Assert(results, HasLen, 3)
+	c.Assert(results[0].RowNumber(), Equals, 1)
+	c.Assert(results[1].Resultset, IsNil)
+	c.Assert(results[2].RowNumber(), Equals, 2)
+
+	// Execute returns the first result, and reads the others
+	r, err := s.conn.Execute("SELECT 3; SELECT 4")
+	c.Assert(err, IsNil)
+	c.Assert(r.RowNumber(), Equals, 3)
+
+	results, err = s.conn.ExecuteMultiple("SELECT 1; FAIL 1; SELECT 2")
+	c.Assert(err, NotNil)
+	c.Assert(results, HasLen, 1)
+
+	r, err = s.conn.Execute("SELECT 5")
+	c.Assert(err, IsNil)
+	c.Assert(r.RowNumber(), Equals, 5)
+}
+
+func (s *rowsTestSuite) TestNextResultSet(c *C) {
+	rows, err := s.conn.Query("SELECT 1; BEGIN; SELECT 2")
+	c.Assert(err, IsNil)
+
+	n := 0
+	for rows.Next() {
+		n++
+	}
+	c.Assert(n, Equals, 1)
+	c.Assert(rows.HasNextResultSet(), Equals, true)
+
+	c.Assert(rows.NextResultSet(), Equals, true)
+	c.Assert(rows.Fields(), IsNil)
+	c.Assert(rows.Next(), Equals, false)
+
+	// the last one is not read but drained
+	c.Assert(rows.NextResultSet(), Equals, true)
+	c.Assert(rows.Fields(), HasLen, 2)
+	c.Assert(rows.NextResultSet(), Equals, false)
+	c.Assert(rows.Err(), IsNil)
+	c.Assert(rows.Close(), IsNil)
+
+	// the rest result sets are drained
+	rows, err = s.conn.Query("SELECT 10; SELECT 10")
+	c.Assert(err, IsNil)
+	c.Assert(rows.Next(), Equals, true)
+	c.Assert(rows.Close(), IsNil)
+
+	r, err := s.conn.Execute("SELECT 5")
+	c.Assert(err, IsNil)
+	c.Assert(r.RowNumber(), Equals, 5)
+}
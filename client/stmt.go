@@ -16,6 +16,13 @@ type Stmt struct {
 
 	params  int
 	columns int
+
+	// the cache the statement is in, nil if it's not cached or evicted
+	cache *stmtCache
+	// the users of the cached statement, it's closed after evicted and not used
+	cached bool
+	refs   int
+	closed bool
 }
 
 func (s *Stmt) ParamNum() int {
@@ -31,10 +38,49 @@ func (s *Stmt) Execute(args ...interface{}) (*mysql.Result, error) {
 		return nil, errors.Trace(err)
 	}
 
-	return s.conn.readResult(true)
+	r, err := s.conn.readFirstResult(true)
+	if err != nil {
+		s.checkStale(err)
+		return nil, errors.Trace(err)
+	}
+	return r, nil
+}
+
+// ExecuteMultiple is like Execute, but returns all the results of a stored procedure.
+func (s *Stmt) ExecuteMultiple(args ...interface{}) ([]*mysql.Result, error) {
+	if err := s.write(args...); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	results, err := s.conn.readResults(true)
+	if err != nil {
+		s.checkStale(err)
+		return results, errors.Trace(err)
+	}
+	return results, nil
 }
 
+// Close closes the statement, or releases it if it's cached, see PrepareCached.
 func (s *Stmt) Close() error {
+	if s.cached {
+		if s.refs > 0 {
+			s.refs--
+		}
+
+		if s.refs > 0 || s.cache != nil {
+			return nil
+		}
+	}
+
+	return s.close()
+}
+
+func (s *Stmt) close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
 	if err := s.conn.writeCommandUint32(mysql.COM_STMT_CLOSE, s.id); err != nil {
 		return errors.Trace(err)
 	}
@@ -159,7 +205,9 @@ func (s *Stmt) write(args ...interface{}) error {
 		}
 	}
 
-	s.conn.ResetSequence()
+	if err := s.conn.startCommand(); err != nil {
+		return err
+	}
 
 	return s.conn.WritePacket(data)
 }
@@ -182,6 +230,7 @@ func (c *Conn) Prepare(query string) (*Stmt, error) {
 
 	s := new(Stmt)
 	s.conn = c
+	s.query = query
 
 	pos := 1
 
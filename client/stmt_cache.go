@@ -0,0 +1,150 @@
+package client
+
+import (
+	"container/list"
+
+	"github.com/gdey/go-mysql/mysql"
+	"github.com/juju/errors"
+)
+
+// DefaultStmtCacheSize is the default number of the prepared statements cached in a connection.
+const DefaultStmtCacheSize = 64
+
+// stmtCache is a LRU cache of the prepared statements keyed by the query.
+// A statement may be evicted while it's still used, it's closed after the last user closes it.
+type stmtCache struct {
+	size int
+
+	// the front is the most recently used
+	l     *list.List
+	stmts map[string]*list.Element
+}
+
+func newStmtCache(size int) *stmtCache {
+	return &stmtCache{size: size, l: list.New(), stmts: make(map[string]*list.Element)}
+}
+
+func (c *stmtCache) get(query string) *Stmt {
+	e, ok := c.stmts[query]
+	if !ok {
+		return nil
+	}
+
+	c.l.MoveToFront(e)
+	return e.Value.(*Stmt)
+}
+
+func (c *stmtCache) add(s *Stmt) {
+	c.stmts[s.query] = c.l.PushFront(s)
+
+	for c.l.Len() > c.size {
+		c.remove(c.l.Back().Value.(*Stmt))
+	}
+}
+
+// remove evicts the statement, and closes it if nobody uses it.
+func (c *stmtCache) remove(s *Stmt) {
+	e, ok := c.stmts[s.query]
+	if !ok || e.Value.(*Stmt) != s {
+		return
+	}
+
+	c.l.Remove(e)
+	delete(c.stmts, s.query)
+
+	s.cache = nil
+	if s.refs == 0 {
+		s.close()
+	}
+}
+
+// SetStmtCacheSize sets the max number of the prepared statements cached in the connection,
+// the least recently used one is closed if exceeded, and 0 disables the cache.
+// It can be called with the Connect options, or at any time.
+func (c *Conn) SetStmtCacheSize(size int) {
+	if size <= 0 {
+		c.stmtCacheSize = 0
+		c.ClearStmtCache()
+		return
+	}
+
+	c.stmtCacheSize = size
+	if c.stmtCache != nil {
+		c.stmtCache.size = size
+		for c.stmtCache.l.Len() > size {
+			c.stmtCache.remove(c.stmtCache.l.Back().Value.(*Stmt))
+		}
+	}
+}
+
+// ClearStmtCache closes all the cached statements not used.
+func (c *Conn) ClearStmtCache() {
+	if c.stmtCache == nil {
+		return
+	}
+
+	for c.stmtCache.l.Len() > 0 {
+		c.stmtCache.remove(c.stmtCache.l.Back().Value.(*Stmt))
+	}
+}
+
+// PrepareCached is like Prepare, but returns the cached statement for the same query,
+// Close releases the statement to the cache instead of closing it.
+// It's the same as Prepare if the cache is disabled, see SetStmtCacheSize.
+func (c *Conn) PrepareCached(query string) (*Stmt, error) {
+	if c.stmtCacheSize == 0 {
+		return c.Prepare(query)
+	}
+
+	if c.stmtCache == nil {
+		c.stmtCache = newStmtCache(c.stmtCacheSize)
+	}
+
+	if s := c.stmtCache.get(query); s != nil {
+		s.refs++
+		return s, nil
+	}
+
+	s, err := c.Prepare(query)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	s.cache = c.stmtCache
+	s.cached = true
+	s.refs = 1
+	c.stmtCache.add(s)
+	return s, nil
+}
+
+// withCachedStmt calls fn with the cached statement of the query,
+// and prepares the statement again once if it's stale, like after the table is altered.
+func (c *Conn) withCachedStmt(query string, fn func(s *Stmt) error) error {
+	for i := 0; ; i++ {
+		s, err := c.PrepareCached(query)
+		if err != nil {
+			return errors.Trace(err)
+		}
+
+		err = fn(s)
+		s.Close()
+
+		if err != nil && i == 0 && s.cached && isStaleStmtError(err) {
+			continue
+		}
+		return err
+	}
+}
+
+// isStaleStmtError returns whether the statement can't be used any more, and must be prepared again.
+func isStaleStmtError(err error) bool {
+	e, ok := errors.Cause(err).(*mysql.MyError)
+	return ok && (e.Code == mysql.ER_NEED_REPREPARE || e.Code == mysql.ER_UNKNOWN_STMT_HANDLER)
+}
+
+// checkStale evicts the statement from the cache if err says it's stale.
+func (s *Stmt) checkStale(err error) {
+	if s.cache != nil && isStaleStmtError(err) {
+		s.cache.remove(s)
+	}
+}
@@ -0,0 +1,220 @@
+package client
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+
+	"github.com/gdey/go-mysql/mysql"
+	"github.com/gdey/go-mysql/packet"
+	. "gopkg.in/check.v1"
+)
+
+// stmtCacheTestSuite tests the statement cache with a fake server, every statement has one param,
+// and the execution returns the statement id as the affected rows.
+// "STALE" makes all the prepared statements return ER_NEED_REPREPARE.
+type stmtCacheTestSuite struct {
+	l    net.Listener
+	conn *Conn
+
+	m      sync.Mutex
+	id     uint32
+	stmts  map[uint32]string
+	stale  map[uint32]bool
+	closed []uint32
+}
+
+var _ = Suite(&stmtCacheTestSuite{})
+
+func (s *stmtCacheTestSuite) SetUpTest(c *C) {
+	s.id = 0
+	s.stmts = make(map[uint32]string)
+	s.stale = make(map[uint32]bool)
+	s.closed = nil
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	s.l = l
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		s.serve(conn)
+	}()
+
+	s.conn, err = Connect(l.Addr().String(), "root", "", "")
+	c.Assert(err, IsNil)
+}
+
+func (s *stmtCacheTestSuite) TearDownTest(c *C) {
+	s.conn.Close()
+	s.l.Close()
+}
+
+func (s *stmtCacheTestSuite) serve(conn net.Conn) {
+	h := &authHandshake{Conn: packet.NewConn(conn), plugin: mysql.AUTH_NATIVE_PASSWORD}
+	defer h.Close()
+
+	if err := h.handshake(); err != nil {
+		return
+	}
+
+	if err := h.writeOK(); err != nil {
+		return
+	}
+
+	for {
+		h.ResetSequence()
+
+		data, err := h.readData()
+		if err != nil {
+			return
+		}
+
+		if err = s.handle(h, data); err != nil {
+			return
+		}
+	}
+}
+
+func (s *stmtCacheTestSuite) handle(h *authHandshake, data []byte) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	switch data[0] {
+	case mysql.COM_PING:
+		return h.writeOK()
+	case mysql.COM_QUERY:
+		// STALE
+		for id := range s.stmts {
+			s.stale[id] = true
+		}
+		return h.writeOK()
+	case mysql.COM_STMT_PREPARE:
+		s.id++
+		s.stmts[s.id] = string(data[1:])
+
+		// id, 0 column, 1 param
+		ok := []byte{mysql.OK_HEADER, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0}
+		binary.LittleEndian.PutUint32(ok[1:], s.id)
+		if err := h.writeData(ok...); err != nil {
+			return err
+		}
+
+		if err := h.writeData((&mysql.Field{Name: []byte("?")}).Dump()...); err != nil {
+			return err
+		}
+		return h.writeData(mysql.EOF_HEADER, 0, 0, 0, 0)
+	case mysql.COM_STMT_EXECUTE:
+		id := binary.LittleEndian.Uint32(data[1:])
+
+		code := uint16(0)
+		if _, ok := s.stmts[id]; !ok {
+			code = mysql.ER_UNKNOWN_STMT_HANDLER
+		} else if s.stale[id] {
+			code = mysql.ER_NEED_REPREPARE
+		}
+
+		if code != 0 {
+			e := []byte{mysql.ERR_HEADER, 0, 0, '#', 'H', 'Y', '0', '0', '0'}
+			binary.LittleEndian.PutUint16(e[1:], code)
+			return h.writeData(e...)
+		}
+
+		return h.writeData(mysql.OK_HEADER, byte(id), 0, byte(mysql.SERVER_STATUS_AUTOCOMMIT), 0, 0, 0)
+	case mysql.COM_STMT_CLOSE:
+		id := binary.LittleEndian.Uint32(data[1:])
+		delete(s.stmts, id)
+		s.closed = append(s.closed, id)
+		return nil
+	}
+
+	return mysql.ErrMalformPacket
+}
+
+func (s *stmtCacheTestSuite) execute(c *C, query string) uint64 {
+	r, err := s.conn.Execute(query, 1)
+	c.Assert(err, IsNil)
+	return r.AffectedRows
+}
+
+// closedStmts returns the closed statements, after the server has handled all the commands.
+func (s *stmtCacheTestSuite) closedStmts(c *C) []uint32 {
+	c.Assert(s.conn.Ping(), IsNil)
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return append([]uint32(nil), s.closed...)
+}
+
+func (s *stmtCacheTestSuite) TestCache(c *C) {
+	c.Assert(s.execute(c, "SELECT ?"), Equals, uint64(1))
+	c.Assert(s.execute(c, "SELECT ?"), Equals, uint64(1))
+	c.Assert(s.execute(c, "SELECT 2, ?"), Equals, uint64(2))
+	c.Assert(s.execute(c, "SELECT ?"), Equals, uint64(1))
+	c.Assert(s.closedStmts(c), HasLen, 0)
+
+	// 1 is used recently, so 2 is evicted
+	s.conn.SetStmtCacheSize(2)
+	c.Assert(s.execute(c, "SELECT 3, ?"), Equals, uint64(3))
+	c.Assert(s.execute(c, "SELECT ?"), Equals, uint64(1))
+	c.Assert(s.closedStmts(c), DeepEquals, []uint32{2})
+
+	s.conn.SetStmtCacheSize(0)
+	c.Assert(s.execute(c, "SELECT ?"), Equals, uint64(4))
+	c.Assert(s.execute(c, "SELECT ?"), Equals, uint64(5))
+	c.Assert(s.closedStmts(c), DeepEquals, []uint32{2, 3, 1, 4, 5})
+}
+
+func (s *stmtCacheTestSuite) TestStale(c *C) {
+	c.Assert(s.execute(c, "SELECT ?"), Equals, uint64(1))
+
+	_, err := s.conn.Execute("STALE")
+	c.Assert(err, IsNil)
+
+	// prepared again
+	c.Assert(s.execute(c, "SELECT ?"), Equals, uint64(2))
+	c.Assert(s.execute(c, "SELECT ?"), Equals, uint64(2))
+	c.Assert(s.closedStmts(c), DeepEquals, []uint32{1})
+
+	// the stale statement is evicted, but the error is returned without the retry
+	st, err := s.conn.PrepareCached("SELECT ?")
+	c.Assert(err, IsNil)
+
+	_, err = s.conn.Execute("STALE")
+	c.Assert(err, IsNil)
+
+	_, err = st.Execute(1)
+	c.Assert(err, NotNil)
+	c.Assert(st.Close(), IsNil)
+	c.Assert(s.execute(c, "SELECT ?"), Equals, uint64(3))
+	c.Assert(s.closedStmts(c), DeepEquals, []uint32{1, 2})
+}
+
+func (s *stmtCacheTestSuite) TestEvictInUse(c *C) {
+	s.conn.SetStmtCacheSize(1)
+
+	st, err := s.conn.PrepareCached("SELECT ?")
+	c.Assert(err, IsNil)
+
+	st2, err := s.conn.PrepareCached("SELECT ?")
+	c.Assert(err, IsNil)
+	c.Assert(st2, Equals, st)
+
+	// st is evicted, but closed after the last Close
+	c.Assert(s.execute(c, "SELECT 2, ?"), Equals, uint64(2))
+	c.Assert(st.Close(), IsNil)
+
+	r, err := st2.Execute(1)
+	c.Assert(err, IsNil)
+	c.Assert(r.AffectedRows, Equals, uint64(1))
+	c.Assert(s.closedStmts(c), HasLen, 0)
+
+	c.Assert(st2.Close(), IsNil)
+	c.Assert(st2.Close(), IsNil)
+	c.Assert(s.closedStmts(c), DeepEquals, []uint32{1})
+}
@@ -0,0 +1,54 @@
+package client
+
+import (
+	"net"
+	"time"
+)
+
+// DefaultDialTimeout is the timeout to connect the server if not set by SetTimeout.
+const DefaultDialTimeout = 10 * time.Second
+
+// SetTimeout sets the timeouts to connect the server, and to read or write a packet,
+// it must be called with the Connect options. 0 means no timeout, but dial uses DefaultDialTimeout.
+func (c *Conn) SetTimeout(dial, read, write time.Duration) {
+	if dial <= 0 {
+		dial = DefaultDialTimeout
+	}
+
+	c.dialTimeout = dial
+	c.readTimeout = read
+	c.writeTimeout = write
+}
+
+// SetAllowCleartextPassword allows mysql_clear_password without TLS or unix socket,
+// it must be called with the Connect options. The password is sent in clear text,
+// so it's only for the trusted network, like the PAM authentication in a private network.
+func (c *Conn) SetAllowCleartextPassword(on bool) {
+	c.allowCleartextPassword = on
+}
+
+// deadlineConn sets the deadline before every read or write.
+type deadlineConn struct {
+	net.Conn
+
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+}
+
+func (c *deadlineConn) Read(b []byte) (int, error) {
+	if c.readTimeout > 0 {
+		if err := c.Conn.SetReadDeadline(time.Now().Add(c.readTimeout)); err != nil {
+			return 0, err
+		}
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *deadlineConn) Write(b []byte) (int, error) {
+	if c.writeTimeout > 0 {
+		if err := c.Conn.SetWriteDeadline(time.Now().Add(c.writeTimeout)); err != nil {
+			return 0, err
+		}
+	}
+	return c.Conn.Write(b)
+}
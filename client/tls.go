@@ -0,0 +1,155 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"strings"
+
+	"github.com/gdey/go-mysql/mysql"
+	"github.com/juju/errors"
+)
+
+// TLSMode is like the --ssl-mode option of the MySQL client.
+type TLSMode int
+
+const (
+	// TLSDisabled never uses TLS.
+	TLSDisabled TLSMode = iota
+	// TLSPreferred uses TLS if the server supports it, the server certificate is not verified.
+	TLSPreferred
+	// TLSRequired must use TLS, the server certificate is not verified.
+	TLSRequired
+	// TLSVerifyCA must use TLS, and verifies the server certificate with the CA in the tls.Config RootCAs.
+	TLSVerifyCA
+	// TLSVerifyIdentity is like TLSVerifyCA, and also verifies the server host name with the certificate.
+	TLSVerifyIdentity
+)
+
+var tlsModeNames = map[TLSMode]string{
+	TLSDisabled:       "DISABLED",
+	TLSPreferred:      "PREFERRED",
+	TLSRequired:       "REQUIRED",
+	TLSVerifyCA:       "VERIFY_CA",
+	TLSVerifyIdentity: "VERIFY_IDENTITY",
+}
+
+func (m TLSMode) String() string {
+	if s, ok := tlsModeNames[m]; ok {
+		return s
+	}
+	return "UNKNOWN"
+}
+
+// ParseTLSMode parses the mode name like the --ssl-mode option, e.g, preferred or verify_identity.
+func ParseTLSMode(s string) (TLSMode, error) {
+	s = strings.Replace(strings.ToUpper(s), "-", "_", -1)
+	for m, name := range tlsModeNames {
+		if name == s {
+			return m, nil
+		}
+	}
+	return TLSDisabled, errors.Errorf("invalid TLS mode %s", s)
+}
+
+// SetTLS sets the TLS mode and config, it must be called with the Connect options before handshake.
+// The config can be nil, the ServerName is the connecting host if not set.
+func (c *Conn) SetTLS(mode TLSMode, config *tls.Config) {
+	c.tlsMode = mode
+	c.tlsConfig = config
+}
+
+// IsTLS returns whether the connection is encrypted with TLS.
+func (c *Conn) IsTLS() bool {
+	_, ok := c.Conn.Conn.(*tls.Conn)
+	return ok
+}
+
+// useTLS returns whether we should upgrade to TLS with the server capability.
+func (c *Conn) useTLS() (bool, error) {
+	if c.tlsMode == TLSDisabled {
+		return false, nil
+	}
+
+	if c.capability&mysql.CLIENT_SSL == 0 {
+		if c.tlsMode == TLSPreferred {
+			return false, nil
+		}
+		return false, errors.Errorf("TLS mode %s is required, but the server does not support TLS", c.tlsMode)
+	}
+
+	return true, nil
+}
+
+func (c *Conn) newTLSConfig() *tls.Config {
+	var config *tls.Config
+	if c.tlsConfig != nil {
+		config = c.tlsConfig.Clone()
+	} else {
+		config = new(tls.Config)
+	}
+
+	if len(config.ServerName) == 0 {
+		if host, _, err := net.SplitHostPort(c.addr); err == nil {
+			config.ServerName = host
+		}
+	}
+
+	switch c.tlsMode {
+	case TLSPreferred, TLSRequired:
+		config.InsecureSkipVerify = true
+	case TLSVerifyCA:
+		// verify the certificate chain but not the host name
+		config.InsecureSkipVerify = true
+		config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return verifyCertificateChain(rawCerts, config.RootCAs)
+		}
+	}
+
+	return config
+}
+
+func verifyCertificateChain(rawCerts [][]byte, roots *x509.CertPool) error {
+	if len(rawCerts) == 0 {
+		return errors.New("no server certificate")
+	}
+
+	certs := make([]*x509.Certificate, 0, len(rawCerts))
+	for _, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		certs = append(certs, cert)
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+
+	_, err := certs[0].Verify(opts)
+	return errors.Trace(err)
+}
+
+// writeSSLRequest sends the SSL request packet, then upgrades the connection to TLS.
+func (c *Conn) writeSSLRequest(capability uint32) error {
+	//capability 4, max-packet size 4, charset 1, reserved all[0] 23
+	data := make([]byte, 4+4+4+1+23)
+
+	data[4] = byte(capability)
+	data[5] = byte(capability >> 8)
+	data[6] = byte(capability >> 16)
+	data[7] = byte(capability >> 24)
+
+	data[12] = byte(mysql.DEFAULT_COLLATION_ID)
+
+	if err := c.WritePacket(data); err != nil {
+		return errors.Trace(err)
+	}
+
+	return errors.Trace(c.UpgradeTLS(tls.Client, c.newTLSConfig()))
+}
@@ -0,0 +1,79 @@
+// go-mysqlbinlog-relay: pulls the binlog from a MySQL master once and serves it to many slaves.
+// The slaves can use CHANGE MASTER TO the relay address and dump binlog by position or GTID.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/gdey/go-mysql/relay"
+	"github.com/juju/errors"
+)
+
+var configFile = flag.String("config", "", "relay config file, the other flags are ignored if set")
+
+var masterAddr = flag.String("master_addr", "127.0.0.1:3306", "MySQL master address")
+var masterUser = flag.String("master_user", "root", "MySQL master user, must have replication privilege")
+var masterPassword = flag.String("master_password", "", "MySQL master password")
+
+var serverID = flag.Int("server_id", 0, "Server ID to register to the master, random if 0")
+var flavor = flag.String("flavor", "mysql", "Flavor: mysql or mariadb")
+var startFile = flag.String("start_file", "", "Binlog filename to start with if no binlog in data dir")
+
+var dataDir = flag.String("data_dir", "./var", "Data dir to store binlog files")
+
+var addr = flag.String("addr", "127.0.0.1:3307", "Relay listen address")
+var user = flag.String("user", "root", "Relay user for slaves")
+var password = flag.String("password", "", "Relay password for slaves")
+
+func main() {
+	flag.Parse()
+
+	var cfg *relay.Config
+	if len(*configFile) > 0 {
+		var err error
+		if cfg, err = relay.NewConfigWithFile(*configFile); err != nil {
+			fmt.Printf("Load config error: %v\n", errors.ErrorStack(err))
+			return
+		}
+	} else {
+		cfg = relay.NewDefaultConfig()
+
+		cfg.MasterAddr = *masterAddr
+		cfg.MasterUser = *masterUser
+		cfg.MasterPassword = *masterPassword
+
+		if *serverID > 0 {
+			cfg.ServerID = uint32(*serverID)
+		}
+		cfg.Flavor = *flavor
+		cfg.StartFile = *startFile
+
+		cfg.DataDir = *dataDir
+
+		cfg.Addr = *addr
+		cfg.User = *user
+		cfg.Password = *password
+	}
+
+	r, err := relay.NewRelay(cfg)
+	if err != nil {
+		fmt.Printf("Create relay error: %v\n", errors.ErrorStack(err))
+		return
+	}
+
+	sc := make(chan os.Signal, 1)
+	signal.Notify(sc, os.Kill, os.Interrupt, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTERM)
+
+	if err = r.Start(); err != nil {
+		fmt.Printf("Start relay error: %v\n", errors.ErrorStack(err))
+		return
+	}
+
+	<-sc
+
+	r.Close()
+}
@@ -4,10 +4,15 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"time"
 
+	"github.com/gdey/go-mysql/client"
 	"github.com/gdey/go-mysql/mysql"
 	"github.com/gdey/go-mysql/replication"
 	"github.com/juju/errors"
@@ -25,14 +30,59 @@ var pos = flag.Int("pos", 4, "Binlog position")
 
 var semiSync = flag.Bool("semisync", false, "Support semi sync")
 var backupPath = flag.String("backup_path", "", "backup path to store binlog files")
+var syncInterval = flag.Duration("sync_interval", time.Second, "sync backup binlog file to disk interval, 0 means only at rotate")
+var verifyChecksum = flag.Bool("verify_checksum", true, "verify backup binlog event checksum")
+var purgeDays = flag.Int("purge_days", 0, "purge backup binlog files older than days, 0 means never")
+var purgeCount = flag.Int("purge_count", 0, "keep at most count backup binlog files, 0 means no limit")
+var compress = flag.Bool("compress", false, "compress closed backup binlog files with gzip")
 
 var rawMode = flag.Bool("raw", false, "Use raw mode")
 
+var sslMode = flag.String("ssl_mode", "disabled", "SSL mode: disabled, preferred, required, verify_ca or verify_identity")
+var sslCA = flag.String("ssl_ca", "", "CA file in PEM format to verify the server certificate")
+var protocolCompression = flag.String("protocol_compression", "none", "Protocol compression: none, zlib or zstd")
+
 func main() {
 	flag.Parse()
 
 	b := replication.NewBinlogSyncer(101, *flavor)
 
+	mode, err := client.ParseTLSMode(*sslMode)
+	if err != nil {
+		fmt.Printf("Parse SSL mode error: %v\n", errors.ErrorStack(err))
+		return
+	}
+
+	if mode != client.TLSDisabled {
+		config := new(tls.Config)
+		if len(*sslCA) > 0 {
+			pem, err := ioutil.ReadFile(*sslCA)
+			if err != nil {
+				fmt.Printf("Read SSL CA error: %v\n", err)
+				return
+			}
+
+			config.RootCAs = x509.NewCertPool()
+			if !config.RootCAs.AppendCertsFromPEM(pem) {
+				fmt.Printf("No certificate in SSL CA %s\n", *sslCA)
+				return
+			}
+		}
+
+		b.SetTLSConfig(mode, config)
+	}
+
+	switch *protocolCompression {
+	case "none":
+	case "zlib":
+		b.SetCompression(mysql.MYSQL_COMPRESS_ZLIB)
+	case "zstd":
+		b.SetCompression(mysql.MYSQL_COMPRESS_ZSTD)
+	default:
+		fmt.Printf("Invalid protocol compression %s\n", *protocolCompression)
+		return
+	}
+
 	if err := b.RegisterSlave(*host, uint16(*port), *user, *password); err != nil {
 		fmt.Printf("Register slave error: %v \n", errors.ErrorStack(err))
 		return
@@ -52,7 +102,16 @@ func main() {
 		// must raw mode
 		b.SetRawMode(true)
 
-		err := b.StartBackup(*backupPath, pos, 0)
+		cfg := &replication.BackupConfig{
+			Dir:            *backupPath,
+			SyncInterval:   *syncInterval,
+			VerifyChecksum: *verifyChecksum,
+			PurgeAge:       time.Duration(*purgeDays) * 24 * time.Hour,
+			PurgeCount:     *purgeCount,
+			Compress:       *compress,
+		}
+
+		err := b.StartBackupWithConfig(pos, cfg)
 		if err != nil {
 			fmt.Printf("Start backup error: %v\n", errors.ErrorStack(err))
 			return
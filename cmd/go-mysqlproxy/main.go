@@ -0,0 +1,77 @@
+// go-mysqlproxy: accepts the MySQL clients, and forwards their commands to a MySQL server and its replicas,
+// or to several servers routed by the schema with a config file.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/gdey/go-mysql/proxy"
+	"github.com/juju/errors"
+)
+
+var configFile = flag.String("config", "", "proxy config file, the other flags are ignored if set")
+
+var addr = flag.String("addr", "127.0.0.1:3307", "Proxy listen address")
+var user = flag.String("user", "root", "Proxy user for clients")
+var password = flag.String("password", "", "Proxy password for clients")
+
+var backendAddr = flag.String("backend_addr", "127.0.0.1:3306", "MySQL backend address")
+var backendUser = flag.String("backend_user", "root", "MySQL backend user")
+var backendPassword = flag.String("backend_password", "", "MySQL backend password")
+var replicas = flag.String("replicas", "", "MySQL replica addresses of the backend for the reads, separated by comma")
+var maxReplicaLag = flag.Int("max_replica_lag", 10, "Max seconds a replica can be behind the backend to serve the reads")
+
+var maxConns = flag.Int("max_conns", 0, "Max number of client connections, 0 means no limit")
+var maxOpen = flag.Int("max_open", 0, "Max number of backend connections for every schema, 0 means no limit")
+
+func main() {
+	flag.Parse()
+
+	var cfg *proxy.Config
+	if len(*configFile) > 0 {
+		var err error
+		if cfg, err = proxy.NewConfigWithFile(*configFile); err != nil {
+			fmt.Printf("Load config error: %v\n", errors.ErrorStack(err))
+			return
+		}
+	} else {
+		cfg = proxy.NewDefaultConfig()
+
+		cfg.Addr = *addr
+		cfg.User = *user
+		cfg.Password = *password
+
+		cfg.MaxConns = *maxConns
+		cfg.MaxOpen = *maxOpen
+		cfg.MaxReplicaLag = *maxReplicaLag
+
+		backend := proxy.BackendConfig{Addr: *backendAddr, User: *backendUser, Password: *backendPassword}
+		if len(*replicas) > 0 {
+			backend.Replicas = strings.Split(*replicas, ",")
+		}
+		cfg.Backends = []proxy.BackendConfig{backend}
+	}
+
+	p, err := proxy.NewProxy(cfg)
+	if err != nil {
+		fmt.Printf("Create proxy error: %v\n", errors.ErrorStack(err))
+		return
+	}
+
+	sc := make(chan os.Signal, 1)
+	signal.Notify(sc, os.Kill, os.Interrupt, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGTERM)
+
+	if err = p.Start(); err != nil {
+		fmt.Printf("Start proxy error: %v\n", errors.ErrorStack(err))
+		return
+	}
+
+	<-sc
+
+	p.Close()
+}
@@ -23,7 +23,7 @@ type testDriverSuite struct {
 var _ = Suite(&testDriverSuite{})
 
 func (s *testDriverSuite) SetUpSuite(c *C) {
-	dsn := fmt.Sprintf("root@%s:3306?test", *testHost)
+	dsn := fmt.Sprintf("root@tcp(%s:3306)/test?multiStatements=true", *testHost)
 
 	var err error
 	s.db, err = sqlx.Open("mysql", dsn)
@@ -71,3 +71,28 @@ func (s *testDriverSuite) TestTransaction(c *C) {
 	err = tx.Commit()
 	c.Assert(err, IsNil)
 }
+
+func (s *testDriverSuite) TestMultiResultSet(c *C) {
+	rows, err := s.db.Query("SELECT 1; SELECT 2, 3")
+	c.Assert(err, IsNil)
+	defer rows.Close()
+
+	var n int
+	c.Assert(rows.Next(), Equals, true)
+	c.Assert(rows.Scan(&n), IsNil)
+	c.Assert(n, Equals, 1)
+	c.Assert(rows.Next(), Equals, false)
+
+	c.Assert(rows.NextResultSet(), Equals, true)
+	columns, err := rows.Columns()
+	c.Assert(err, IsNil)
+	c.Assert(columns, HasLen, 2)
+
+	var m int
+	c.Assert(rows.Next(), Equals, true)
+	c.Assert(rows.Scan(&n, &m), IsNil)
+	c.Assert(n, Equals, 2)
+	c.Assert(m, Equals, 3)
+
+	c.Assert(rows.NextResultSet(), Equals, false)
+}
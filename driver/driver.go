@@ -3,11 +3,13 @@
 package driver
 
 import (
+	"context"
 	"database/sql"
 	sqldriver "database/sql/driver"
 	"fmt"
 	"io"
 	"strings"
+	"time"
 
 	"github.com/gdey/go-mysql/client"
 	"github.com/gdey/go-mysql/mysql"
@@ -18,53 +20,55 @@ import (
 type driver struct {
 }
 
-// DSN user:password@addr[?db]
+// Open connects with the DSN, see Config for the format and the params.
 func (d driver) Open(dsn string) (sqldriver.Conn, error) {
-	seps := strings.Split(dsn, "@")
-	if len(seps) != 2 {
-		return nil, errors.Errorf("invalid dsn, must user:password@addr[?db]")
-	}
-
-	var user string
-	var password string
-	var addr string
-	var db string
-
-	if ss := strings.Split(seps[0], ":"); len(ss) == 2 {
-		user, password = ss[0], ss[1]
-	} else if len(ss) == 1 {
-		user = ss[0]
-	} else {
-		return nil, errors.Errorf("invalid dsn, must user:password@addr[?db]")
+	cfg, err := ParseDSN(dsn)
+	if err != nil {
+		return nil, errors.Trace(err)
 	}
 
-	if ss := strings.Split(seps[1], "?"); len(ss) == 2 {
-		addr, db = ss[0], ss[1]
-	} else if len(ss) == 1 {
-		addr = ss[0]
-	} else {
-		return nil, errors.Errorf("invalid dsn, must user:password@addr[?db]")
-	}
+	return open(cfg)
+}
 
-	c, err := client.Connect(addr, user, password, db)
+func open(cfg *Config) (*conn, error) {
+	c, err := cfg.connect()
 	if err != nil {
 		return nil, err
 	}
 
-	return &conn{c}, nil
+	return &conn{c, cfg}, nil
+}
+
+type connector struct {
+	cfg *Config
+}
+
+// NewConnector returns the connector with the config for sql.OpenDB, so it needn't be formatted as a DSN.
+func NewConnector(cfg *Config) sqldriver.Connector {
+	return &connector{cfg}
+}
+
+func (c *connector) Connect(ctx context.Context) (sqldriver.Conn, error) {
+	return open(c.cfg)
+}
+
+func (c *connector) Driver() sqldriver.Driver {
+	return driver{}
 }
 
 type conn struct {
 	*client.Conn
+
+	cfg *Config
 }
 
 func (c *conn) Prepare(query string) (sqldriver.Stmt, error) {
-	st, err := c.Conn.Prepare(query)
+	st, err := c.Conn.PrepareCached(query)
 	if err != nil {
 		return nil, errors.Trace(err)
 	}
 
-	return &stmt{st}, nil
+	return &stmt{st, c.cfg}, nil
 }
 
 func (c *conn) Close() error {
@@ -80,16 +84,44 @@ func (c *conn) Begin() (sqldriver.Tx, error) {
 	return &tx{c.Conn}, nil
 }
 
-func buildArgs(args []sqldriver.Value) []interface{} {
+func buildArgs(args []sqldriver.Value, loc *time.Location) []interface{} {
 	a := make([]interface{}, len(args))
 
 	for i, arg := range args {
-		a[i] = arg
+		if t, ok := arg.(time.Time); ok {
+			// the statement can't send time.Time, and we want it in the location
+			a[i] = formatTime(t, loc)
+		} else {
+			a[i] = arg
+		}
 	}
 
 	return a
 }
 
+func formatTime(t time.Time, loc *time.Location) string {
+	if t.IsZero() {
+		return "0000-00-00"
+	}
+	return t.In(loc).Format(mysql.TimeFormat + ".999999")
+}
+
+// parseTime parses the value of DATE, DATETIME and TIMESTAMP, the zero date is the zero time.
+func parseTime(v []byte, loc *time.Location) (time.Time, error) {
+	s := hack.String(v)
+	if strings.Trim(s, "0-:. ") == "" {
+		return time.Time{}, nil
+	}
+
+	layout := mysql.TimeFormat
+	if len(s) == len("2006-01-02") {
+		layout = "2006-01-02"
+	}
+
+	t, err := time.ParseInLocation(layout, s, loc)
+	return t, errors.Trace(err)
+}
+
 func replyError(err error) error {
 	if err == mysql.ErrBadConn {
 		return sqldriver.ErrBadConn
@@ -99,7 +131,7 @@ func replyError(err error) error {
 }
 
 func (c *conn) Exec(query string, args []sqldriver.Value) (sqldriver.Result, error) {
-	a := buildArgs(args)
+	a := buildArgs(args, c.cfg.Loc)
 	r, err := c.Conn.Execute(query, a...)
 	if err != nil {
 		return nil, replyError(err)
@@ -108,16 +140,18 @@ func (c *conn) Exec(query string, args []sqldriver.Value) (sqldriver.Result, err
 }
 
 func (c *conn) Query(query string, args []sqldriver.Value) (sqldriver.Rows, error) {
-	a := buildArgs(args)
-	r, err := c.Conn.Execute(query, a...)
+	a := buildArgs(args, c.cfg.Loc)
+	r, err := c.Conn.ExecuteMultiple(query, a...)
 	if err != nil {
 		return nil, replyError(err)
 	}
-	return newRows(r.Resultset)
+	return newRows(r, c.cfg)
 }
 
 type stmt struct {
 	*client.Stmt
+
+	cfg *Config
 }
 
 func (s *stmt) Close() error {
@@ -129,7 +163,7 @@ func (s *stmt) NumInput() int {
 }
 
 func (s *stmt) Exec(args []sqldriver.Value) (sqldriver.Result, error) {
-	a := buildArgs(args)
+	a := buildArgs(args, s.cfg.Loc)
 	r, err := s.Stmt.Execute(a...)
 	if err != nil {
 		return nil, replyError(err)
@@ -138,12 +172,12 @@ func (s *stmt) Exec(args []sqldriver.Value) (sqldriver.Result, error) {
 }
 
 func (s *stmt) Query(args []sqldriver.Value) (sqldriver.Rows, error) {
-	a := buildArgs(args)
-	r, err := s.Stmt.Execute(a...)
+	a := buildArgs(args, s.cfg.Loc)
+	r, err := s.Stmt.ExecuteMultiple(a...)
 	if err != nil {
 		return nil, replyError(err)
 	}
-	return newRows(r.Resultset)
+	return newRows(r, s.cfg)
 }
 
 type tx struct {
@@ -175,24 +209,42 @@ type rows struct {
 
 	columns []string
 	step    int
+
+	cfg *Config
+
+	// the result sets after the current one, the results without a result set are skipped
+	next []*mysql.Resultset
 }
 
-func newRows(r *mysql.Resultset) (*rows, error) {
-	if r == nil {
+func newRows(results []*mysql.Result, cfg *Config) (*rows, error) {
+	var rss []*mysql.Resultset
+	for _, r := range results {
+		if r.Resultset != nil {
+			rss = append(rss, r.Resultset)
+		}
+	}
+
+	if len(rss) == 0 {
 		return nil, fmt.Errorf("invalid mysql query, no correct result")
 	}
 
 	rs := new(rows)
-	rs.Resultset = r
+	rs.cfg = cfg
+	rs.next = rss[1:]
+	rs.setResultset(rss[0])
 
-	rs.columns = make([]string, len(r.Fields))
+	return rs, nil
+}
 
-	for i, f := range r.Fields {
-		rs.columns[i] = hack.String(f.Name)
-	}
-	rs.step = 0
+func (r *rows) setResultset(rs *mysql.Resultset) {
+	r.Resultset = rs
 
-	return rs, nil
+	r.columns = make([]string, len(rs.Fields))
+
+	for i, f := range rs.Fields {
+		r.columns[i] = hack.String(f.Name)
+	}
+	r.step = 0
 }
 
 func (r *rows) Columns() []string {
@@ -201,6 +253,7 @@ func (r *rows) Columns() []string {
 
 func (r *rows) Close() error {
 	r.step = -1
+	r.next = nil
 	return nil
 }
 
@@ -217,6 +270,12 @@ func (r *rows) Next(dest []sqldriver.Value) error {
 			return err
 		}
 
+		if b, ok := value.([]byte); ok && r.cfg.ParseTime && isTimeField(r.Resultset.Fields[i]) {
+			if value, err = parseTime(b, r.cfg.Loc); err != nil {
+				return err
+			}
+		}
+
 		dest[i] = sqldriver.Value(value)
 	}
 
@@ -225,6 +284,29 @@ func (r *rows) Next(dest []sqldriver.Value) error {
 	return nil
 }
 
+func isTimeField(f *mysql.Field) bool {
+	switch f.Type {
+	case mysql.MYSQL_TYPE_DATE, mysql.MYSQL_TYPE_NEWDATE, mysql.MYSQL_TYPE_DATETIME, mysql.MYSQL_TYPE_TIMESTAMP:
+		return true
+	default:
+		return false
+	}
+}
+
+func (r *rows) HasNextResultSet() bool {
+	return len(r.next) > 0
+}
+
+func (r *rows) NextResultSet() error {
+	if len(r.next) == 0 {
+		return io.EOF
+	}
+
+	r.setResultset(r.next[0])
+	r.next = r.next[1:]
+	return nil
+}
+
 func init() {
 	sql.Register("mysql", driver{})
 }
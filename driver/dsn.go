@@ -0,0 +1,486 @@
+package driver
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gdey/go-mysql/client"
+	"github.com/juju/errors"
+)
+
+const (
+	defaultTCPAddr  = "127.0.0.1:3306"
+	defaultUnixAddr = "/tmp/mysql.sock"
+)
+
+// the names of the session variables, charsets and collations
+var identifierRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_.]*$`)
+
+// Config is the config of the connections, parsed from the DSN
+//
+//	[user[:password]@][net(addr)|addr][/dbname][?param=value&...]
+//
+// e.g, root:pa:ss@word@tcp(127.0.0.1:3306)/test?parseTime=true&loc=Local&sql_mode='ANSI'.
+// The password is everything between the first : and the last @, so it needn't be escaped,
+// the dbname and the param values are escaped like in the URL.
+// The net is tcp or unix, the addr without net is tcp, like 127.0.0.1:3306.
+// The old DSN user:password@addr?dbname still works.
+type Config struct {
+	User     string
+	Password string
+	// tcp or unix
+	Net    string
+	Addr   string
+	DBName string
+
+	// the timeouts to connect, and to read or write a packet, params timeout, readTimeout and writeTimeout,
+	// like 5s or 1m
+	Timeout      time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	// params charset and collation, the charset is from the collation if not set
+	Charset   string
+	Collation string
+
+	// param tls, the client.TLSMode name like preferred or verify_identity
+	TLSMode client.TLSMode
+	// params tlsCA, tlsCert and tlsKey, the paths of the PEM files to verify the server and the client certificate
+	TLSCA   string
+	TLSCert string
+	TLSKey  string
+	// param tlsServerName, to verify the server host name, the host of addr if not set
+	TLSServerName string
+
+	// param loc, the location of the time args and the times parsed, UTC if not set, Local is the local time zone,
+	// it's loaded by name, so a fixed zone can't be in the DSN
+	Loc *time.Location
+	// param parseTime, scans DATE, DATETIME and TIMESTAMP to time.Time
+	ParseTime bool
+
+	// param serverPubKey, the path of the server RSA public key for sha256_password and caching_sha2_password
+	ServerPubKey string
+	// param allowPublicKeyRetrieval, requests the server public key if not set, it's not verified
+	AllowPublicKeyRetrieval bool
+	// param allowCleartextPasswords, allows mysql_clear_password without TLS or unix socket
+	AllowCleartextPasswords bool
+
+	// param interpolateParams, interpolates the args in the query on the client
+	InterpolateParams bool
+	// param multiStatements, allows the multi statements like "a; b; c" in a query
+	MultiStatements bool
+
+	// the other params are the session variables set on connect, the values are SQL expressions,
+	// so the strings must be quoted, like sql_mode='ANSI'
+	Params map[string]string
+}
+
+// NewConfig returns the config with the default values.
+func NewConfig() *Config {
+	return &Config{
+		Net: "tcp",
+		Loc: time.UTC,
+	}
+}
+
+// ParseDSN parses the DSN into the config, see Config.
+func ParseDSN(dsn string) (*Config, error) {
+	cfg := NewConfig()
+
+	// the query is after the last @, the password may have ?
+	rest, query := dsn, ""
+	if n := strings.LastIndex(dsn, "?"); n > strings.LastIndex(dsn, "@") {
+		rest, query = dsn[:n], dsn[n+1:]
+	}
+
+	if n := strings.LastIndex(rest, "@"); n >= 0 {
+		userInfo := rest[:n]
+		rest = rest[n+1:]
+
+		if m := strings.IndexByte(userInfo, ':'); m >= 0 {
+			cfg.User, cfg.Password = userInfo[:m], userInfo[m+1:]
+		} else {
+			cfg.User = userInfo
+		}
+	}
+
+	if len(query) > 0 && !strings.ContainsAny(query, "=&") && !strings.ContainsAny(rest, "()") {
+		// the old DSN addr?dbname
+		cfg.Addr, cfg.DBName = rest, query
+		if strings.Contains(rest, "/") {
+			cfg.Net = "unix"
+		}
+		if err := cfg.normalize(); err != nil {
+			return nil, errors.Annotatef(err, "invalid dsn %s", dsn)
+		}
+		return cfg, nil
+	}
+
+	if n := strings.IndexByte(rest, '('); n >= 0 {
+		m := strings.LastIndex(rest, ")")
+		if m < n {
+			return nil, errors.Errorf("invalid dsn %s, missing ) after the addr", dsn)
+		}
+		cfg.Net, cfg.Addr = rest[:n], rest[n+1:m]
+		rest = rest[m+1:]
+		if len(rest) > 0 && rest[0] != '/' {
+			return nil, errors.Errorf("invalid dsn %s, must be / after the addr", dsn)
+		}
+	} else if n := strings.IndexByte(rest, '/'); n >= 0 {
+		cfg.Addr, rest = rest[:n], rest[n:]
+	} else {
+		cfg.Addr, rest = rest, ""
+	}
+
+	if len(rest) > 0 {
+		db, err := url.PathUnescape(rest[1:])
+		if err != nil {
+			return nil, errors.Annotatef(err, "invalid dbname in dsn %s", dsn)
+		}
+		cfg.DBName = db
+	}
+
+	if err := cfg.parseParams(query); err != nil {
+		return nil, errors.Annotatef(err, "invalid dsn %s", dsn)
+	}
+
+	if err := cfg.normalize(); err != nil {
+		return nil, errors.Annotatef(err, "invalid dsn %s", dsn)
+	}
+
+	return cfg, nil
+}
+
+func (cfg *Config) parseParams(query string) error {
+	if len(query) == 0 {
+		return nil
+	}
+
+	for _, param := range strings.Split(query, "&") {
+		if len(param) == 0 {
+			continue
+		}
+
+		n := strings.IndexByte(param, '=')
+		if n < 0 {
+			return errors.Errorf("invalid param %s, must be name=value", param)
+		}
+
+		name := param[:n]
+		value, err := url.QueryUnescape(param[n+1:])
+		if err != nil {
+			return errors.Annotatef(err, "invalid value of param %s", name)
+		}
+
+		if err = cfg.setParam(name, value); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	return nil
+}
+
+func (cfg *Config) setParam(name string, value string) error {
+	var err error
+	switch name {
+	case "timeout":
+		cfg.Timeout, err = time.ParseDuration(value)
+	case "readTimeout":
+		cfg.ReadTimeout, err = time.ParseDuration(value)
+	case "writeTimeout":
+		cfg.WriteTimeout, err = time.ParseDuration(value)
+	case "charset":
+		cfg.Charset = value
+	case "collation":
+		cfg.Collation = value
+	case "tls":
+		cfg.TLSMode, err = client.ParseTLSMode(value)
+	case "tlsCA":
+		cfg.TLSCA = value
+	case "tlsCert":
+		cfg.TLSCert = value
+	case "tlsKey":
+		cfg.TLSKey = value
+	case "tlsServerName":
+		cfg.TLSServerName = value
+	case "loc":
+		cfg.Loc, err = time.LoadLocation(value)
+	case "parseTime":
+		cfg.ParseTime, err = strconv.ParseBool(value)
+	case "serverPubKey":
+		cfg.ServerPubKey = value
+	case "allowPublicKeyRetrieval":
+		cfg.AllowPublicKeyRetrieval, err = strconv.ParseBool(value)
+	case "allowCleartextPasswords":
+		cfg.AllowCleartextPasswords, err = strconv.ParseBool(value)
+	case "interpolateParams":
+		cfg.InterpolateParams, err = strconv.ParseBool(value)
+	case "multiStatements":
+		cfg.MultiStatements, err = strconv.ParseBool(value)
+	default:
+		if cfg.Params == nil {
+			cfg.Params = make(map[string]string)
+		}
+		cfg.Params[name] = value
+	}
+
+	return errors.Annotatef(err, "invalid value %s of param %s", value, name)
+}
+
+// normalize checks the config and sets the default values.
+func (cfg *Config) normalize() error {
+	if len(cfg.Net) == 0 {
+		cfg.Net = "tcp"
+	}
+
+	switch cfg.Net {
+	case "tcp":
+		if len(cfg.Addr) == 0 {
+			cfg.Addr = defaultTCPAddr
+		} else if _, _, err := net.SplitHostPort(cfg.Addr); err != nil {
+			cfg.Addr = net.JoinHostPort(cfg.Addr, "3306")
+		}
+	case "unix":
+		if len(cfg.Addr) == 0 {
+			cfg.Addr = defaultUnixAddr
+		}
+	default:
+		return errors.Errorf("invalid net %s, must be tcp or unix", cfg.Net)
+	}
+
+	if cfg.Loc == nil {
+		cfg.Loc = time.UTC
+	}
+
+	if len(cfg.Charset) == 0 && len(cfg.Collation) > 0 {
+		cfg.Charset = strings.SplitN(cfg.Collation, "_", 2)[0]
+	}
+
+	for _, name := range []string{cfg.Charset, cfg.Collation} {
+		if len(name) > 0 && !identifierRegexp.MatchString(name) {
+			return errors.Errorf("invalid charset or collation %s", name)
+		}
+	}
+
+	for name := range cfg.Params {
+		if !identifierRegexp.MatchString(name) {
+			return errors.Errorf("invalid session variable %s", name)
+		}
+	}
+
+	if (len(cfg.TLSCert) > 0) != (len(cfg.TLSKey) > 0) {
+		return errors.Errorf("tlsCert and tlsKey must be set together")
+	}
+
+	return nil
+}
+
+// FormatDSN returns the DSN of the config, ParseDSN parses it to the same config.
+func (cfg *Config) FormatDSN() string {
+	var buf bytes.Buffer
+
+	if len(cfg.User) > 0 || len(cfg.Password) > 0 {
+		buf.WriteString(cfg.User)
+		if len(cfg.Password) > 0 {
+			buf.WriteByte(':')
+			buf.WriteString(cfg.Password)
+		}
+		buf.WriteByte('@')
+	}
+
+	netName := cfg.Net
+	if len(netName) == 0 {
+		netName = "tcp"
+	}
+	buf.WriteString(netName)
+	buf.WriteByte('(')
+	buf.WriteString(cfg.Addr)
+	buf.WriteByte(')')
+
+	buf.WriteByte('/')
+	// @ is not escaped in the path, but we split the user info by it
+	buf.WriteString(strings.Replace(url.PathEscape(cfg.DBName), "@", "%40", -1))
+
+	params := make(map[string]string, len(cfg.Params))
+	for name, value := range cfg.Params {
+		params[name] = value
+	}
+
+	setDuration := func(name string, d time.Duration) {
+		if d > 0 {
+			params[name] = d.String()
+		}
+	}
+	setString := func(name string, s string) {
+		if len(s) > 0 {
+			params[name] = s
+		}
+	}
+	setBool := func(name string, b bool) {
+		if b {
+			params[name] = "true"
+		}
+	}
+
+	setDuration("timeout", cfg.Timeout)
+	setDuration("readTimeout", cfg.ReadTimeout)
+	setDuration("writeTimeout", cfg.WriteTimeout)
+	setString("charset", cfg.Charset)
+	setString("collation", cfg.Collation)
+	if cfg.TLSMode != client.TLSDisabled {
+		params["tls"] = strings.ToLower(cfg.TLSMode.String())
+	}
+	setString("tlsCA", cfg.TLSCA)
+	setString("tlsCert", cfg.TLSCert)
+	setString("tlsKey", cfg.TLSKey)
+	setString("tlsServerName", cfg.TLSServerName)
+	if cfg.Loc != nil && cfg.Loc != time.UTC {
+		params["loc"] = cfg.Loc.String()
+	}
+	setBool("parseTime", cfg.ParseTime)
+	setString("serverPubKey", cfg.ServerPubKey)
+	setBool("allowPublicKeyRetrieval", cfg.AllowPublicKeyRetrieval)
+	setBool("allowCleartextPasswords", cfg.AllowCleartextPasswords)
+	setBool("interpolateParams", cfg.InterpolateParams)
+	setBool("multiStatements", cfg.MultiStatements)
+
+	names := make([]string, 0, len(params))
+	for name := range params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for i, name := range names {
+		if i == 0 {
+			buf.WriteByte('?')
+		} else {
+			buf.WriteByte('&')
+		}
+		buf.WriteString(name)
+		buf.WriteByte('=')
+		buf.WriteString(url.QueryEscape(params[name]))
+	}
+
+	return buf.String()
+}
+
+// connect connects the server with the config, and sets the charset and session variables.
+func (cfg *Config) connect() (*client.Conn, error) {
+	if err := cfg.normalize(); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	var tlsConfig *tls.Config
+	if cfg.TLSMode != client.TLSDisabled {
+		var err error
+		if tlsConfig, err = cfg.newTLSConfig(); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+
+	var pubKey *rsa.PublicKey
+	if len(cfg.ServerPubKey) > 0 {
+		data, err := ioutil.ReadFile(cfg.ServerPubKey)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if pubKey, err = client.ParsePublicKey(data); err != nil {
+			return nil, errors.Annotatef(err, "invalid server public key %s", cfg.ServerPubKey)
+		}
+	}
+
+	addr := cfg.Addr
+	if cfg.Net == "unix" && !strings.Contains(addr, "/") {
+		// the client takes the addr with / as the unix socket
+		addr = "./" + addr
+	}
+
+	c, err := client.Connect(addr, cfg.User, cfg.Password, cfg.DBName, func(c *client.Conn) {
+		c.SetTimeout(cfg.Timeout, cfg.ReadTimeout, cfg.WriteTimeout)
+		c.SetTLS(cfg.TLSMode, tlsConfig)
+		if pubKey != nil {
+			c.SetServerPubKey(pubKey)
+		}
+		c.SetAllowPublicKeyRetrieval(cfg.AllowPublicKeyRetrieval)
+		c.SetAllowCleartextPassword(cfg.AllowCleartextPasswords)
+		c.SetInterpolateParams(cfg.InterpolateParams)
+		c.SetMultiStatements(cfg.MultiStatements)
+	})
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if err = cfg.initSession(c); err != nil {
+		c.Close()
+		return nil, errors.Trace(err)
+	}
+
+	return c, nil
+}
+
+// initSession sets the charset, collation and the session variables.
+func (cfg *Config) initSession(c *client.Conn) error {
+	if len(cfg.Charset) > 0 {
+		if err := c.SetCharset(cfg.Charset); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	var vars []string
+	if len(cfg.Collation) > 0 {
+		vars = append(vars, "collation_connection = '"+cfg.Collation+"'")
+	}
+
+	names := make([]string, 0, len(cfg.Params))
+	for name := range cfg.Params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		vars = append(vars, name+" = "+cfg.Params[name])
+	}
+
+	if len(vars) == 0 {
+		return nil
+	}
+
+	_, err := c.Execute("SET " + strings.Join(vars, ", "))
+	return errors.Trace(err)
+}
+
+func (cfg *Config) newTLSConfig() (*tls.Config, error) {
+	config := &tls.Config{ServerName: cfg.TLSServerName}
+
+	if len(cfg.TLSCA) > 0 {
+		data, err := ioutil.ReadFile(cfg.TLSCA)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(data) {
+			return nil, errors.Errorf("no certificate in tlsCA %s", cfg.TLSCA)
+		}
+	}
+
+	if len(cfg.TLSCert) > 0 {
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
@@ -0,0 +1,107 @@
+package driver
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/gdey/go-mysql/client"
+	"github.com/gdey/go-mysql/mysqltest"
+	. "gopkg.in/check.v1"
+)
+
+type dsnTestSuite struct{}
+
+var _ = Suite(&dsnTestSuite{})
+
+func (s *dsnTestSuite) TestParseDSN(c *C) {
+	tbl := []struct {
+		dsn string
+		cfg *Config
+	}{
+		// the old DSN
+		{"root@127.0.0.1:3306?test", &Config{User: "root", Net: "tcp", Addr: "127.0.0.1:3306", DBName: "test", Loc: time.UTC}},
+		{"root:pw@/tmp/mysql.sock?test", &Config{User: "root", Password: "pw", Net: "unix", Addr: "/tmp/mysql.sock", DBName: "test", Loc: time.UTC}},
+		{"root@127.0.0.1", &Config{User: "root", Net: "tcp", Addr: "127.0.0.1:3306", Loc: time.UTC}},
+		// the password has : @ / and ?
+		{"root:p:a@s/s?w@tcp(127.0.0.1:3307)/test", &Config{User: "root", Password: "p:a@s/s?w", Net: "tcp", Addr: "127.0.0.1:3307", DBName: "test", Loc: time.UTC}},
+		{"unix(/tmp/mysql.sock)/", &Config{Net: "unix", Addr: "/tmp/mysql.sock", Loc: time.UTC}},
+		{"/test%2Fdb?charset=utf8mb4", &Config{Net: "tcp", Addr: "127.0.0.1:3306", DBName: "test/db", Charset: "utf8mb4", Loc: time.UTC}},
+		{"u@tcp(localhost)/test?timeout=5s&readTimeout=1m&writeTimeout=30s&collation=utf8mb4_bin&tls=verify-ca&tlsCA=%2Fca.pem" +
+			"&loc=Local&parseTime=true&serverPubKey=key.pem&allowPublicKeyRetrieval=true&allowCleartextPasswords=1&interpolateParams=true&multiStatements=true" +
+			"&sql_mode=%27ANSI%27&autocommit=0",
+			&Config{User: "u", Net: "tcp", Addr: "localhost:3306", DBName: "test",
+				Timeout: 5 * time.Second, ReadTimeout: time.Minute, WriteTimeout: 30 * time.Second,
+				Charset: "utf8mb4", Collation: "utf8mb4_bin", TLSMode: client.TLSVerifyCA, TLSCA: "/ca.pem",
+				Loc: time.Local, ParseTime: true, ServerPubKey: "key.pem", AllowPublicKeyRetrieval: true, AllowCleartextPasswords: true, InterpolateParams: true, MultiStatements: true,
+				Params: map[string]string{"sql_mode": "'ANSI'", "autocommit": "0"}}},
+	}
+
+	for _, t := range tbl {
+		cfg, err := ParseDSN(t.dsn)
+		c.Assert(err, IsNil, Commentf("dsn %s", t.dsn))
+		c.Assert(cfg, DeepEquals, t.cfg, Commentf("dsn %s", t.dsn))
+
+		// round trip
+		cfg, err = ParseDSN(cfg.FormatDSN())
+		c.Assert(err, IsNil, Commentf("dsn %s", t.dsn))
+		c.Assert(cfg, DeepEquals, t.cfg, Commentf("dsn %s", t.dsn))
+	}
+
+	for _, dsn := range []string{
+		"root@tcp(127.0.0.1:3306",
+		"root@tcp(127.0.0.1:3306)test",
+		"root@udp(127.0.0.1:3306)/test",
+		"root@tcp(127.0.0.1:3306)/test?timeout=5",
+		"root@tcp(127.0.0.1:3306)/test?tls=maybe",
+		"root@tcp(127.0.0.1:3306)/test?parseTime",
+		"root@tcp(127.0.0.1:3306)/test?a;b=1",
+		"root@tcp(127.0.0.1:3306)/test?tlsCert=cert.pem",
+	} {
+		_, err := ParseDSN(dsn)
+		c.Assert(err, NotNil, Commentf("dsn %s", dsn))
+	}
+}
+
+func (s *dsnTestSuite) TestOpen(c *C) {
+	srv, err := mysqltest.NewServer()
+	c.Assert(err, IsNil)
+	defer srv.Close()
+
+	loc := time.FixedZone("UTC+8", 8*3600)
+	t := time.Date(2026, 10, 19, 8, 30, 0, 0, loc)
+	err = srv.AddResultset("SELECT t FROM test", []string{"t"}, [][]interface{}{{t}})
+	c.Assert(err, IsNil)
+
+	cfg := NewConfig()
+	cfg.User = "root"
+	cfg.Addr = srv.Addr()
+	cfg.Loc = loc
+	cfg.ParseTime = true
+	cfg.Collation = "utf8mb4_bin"
+	cfg.Params = map[string]string{"sql_mode": "'ANSI'"}
+
+	db := sql.OpenDB(NewConnector(cfg))
+	defer db.Close()
+
+	var v time.Time
+	err = db.QueryRow("SELECT t FROM test").Scan(&v)
+	c.Assert(err, IsNil)
+	c.Assert(v.Equal(t), Equals, true)
+	c.Assert(v.Location(), Equals, loc)
+
+	c.Assert(srv.Queries()[:2], DeepEquals, []string{
+		"SET NAMES utf8mb4",
+		"SET collation_connection = 'utf8mb4_bin', sql_mode = 'ANSI'",
+	})
+
+	// the same with the DSN, the fixed zone has no name to load
+	cfg.Loc = time.UTC
+	db, err = sql.Open("mysql", cfg.FormatDSN())
+	c.Assert(err, IsNil)
+	defer db.Close()
+
+	// the server sends the time without zone, it's read in UTC now
+	err = db.QueryRow("SELECT t FROM test").Scan(&v)
+	c.Assert(err, IsNil)
+	c.Assert(v, DeepEquals, time.Date(2026, 10, 19, 8, 30, 0, 0, time.UTC))
+}
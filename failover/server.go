@@ -2,6 +2,7 @@ package failover
 
 import (
 	"fmt"
+	"sync"
 
 	"github.com/gdey/go-mysql/client"
 	"github.com/gdey/go-mysql/mysql"
@@ -18,7 +19,9 @@ type Server struct {
 	User     User
 	ReplUser User
 
-	conn *client.Conn
+	// the pool is created by the first command if the server is not created by NewServer
+	poolLock sync.Mutex
+	pool     *client.Pool
 }
 
 func NewServer(addr string, user User, replUser User) *Server {
@@ -29,36 +32,33 @@ func NewServer(addr string, user User, replUser User) *Server {
 	s.User = user
 	s.ReplUser = replUser
 
+	s.pool = client.NewPool(client.PoolConfig{Addr: addr, User: user.Name, Password: user.Password})
+
 	return s
 }
 
-func (s *Server) Close() {
-	if s.conn != nil {
-		s.conn.Close()
+func (s *Server) getPool() *client.Pool {
+	s.poolLock.Lock()
+	defer s.poolLock.Unlock()
+
+	if s.pool == nil {
+		s.pool = client.NewPool(client.PoolConfig{Addr: s.Addr, User: s.User.Name, Password: s.User.Password})
 	}
+	return s.pool
 }
 
-func (s *Server) Execute(cmd string, args ...interface{}) (r *mysql.Result, err error) {
-	retryNum := 3
-	for i := 0; i < retryNum; i++ {
-		if s.conn == nil {
-			s.conn, err = client.Connect(s.Addr, s.User.Name, s.User.Password, "")
-			if err != nil {
-				return nil, err
-			}
-		}
-
-		r, err = s.conn.Execute(cmd, args...)
-		if err != nil && err != mysql.ErrBadConn {
-			return
-		} else if err == mysql.ErrBadConn {
-			s.conn = nil
-			continue
-		} else {
-			return
-		}
+func (s *Server) Close() {
+	s.poolLock.Lock()
+	defer s.poolLock.Unlock()
+
+	if s.pool != nil {
+		s.pool.Close()
+		s.pool = nil
 	}
-	return
+}
+
+func (s *Server) Execute(cmd string, args ...interface{}) (*mysql.Result, error) {
+	return s.getPool().Execute(cmd, args...)
 }
 
 func (s *Server) StartSlave() error {
@@ -0,0 +1,25 @@
+package failover
+
+import (
+	"github.com/gdey/go-mysql/mysqltest"
+	. "gopkg.in/check.v1"
+)
+
+type serverTestSuite struct{}
+
+var _ = Suite(&serverTestSuite{})
+
+func (s *serverTestSuite) TestServerWithoutNew(c *C) {
+	srv, err := mysqltest.NewServer()
+	c.Assert(err, IsNil)
+	defer srv.Close()
+
+	// the pool is created by the first command
+	server := &Server{Addr: srv.Addr(), User: User{Name: "root"}}
+	mode, err := server.MysqlGTIDMode()
+	c.Assert(err, IsNil)
+	c.Assert(mode, Equals, GTIDModeOff)
+
+	server.Close()
+	server.Close()
+}
@@ -0,0 +1,40 @@
+package mysql
+
+import (
+	"strings"
+)
+
+// CollationNames has the common collations, the id is sent in the handshake.
+var CollationNames = map[uint8]string{
+	1:   "big5_chinese_ci",
+	8:   "latin1_swedish_ci",
+	11:  "ascii_general_ci",
+	13:  "sjis_japanese_ci",
+	24:  "gb2312_chinese_ci",
+	28:  "gbk_chinese_ci",
+	33:  "utf8_general_ci",
+	45:  "utf8mb4_general_ci",
+	46:  "utf8mb4_bin",
+	47:  "latin1_bin",
+	48:  "latin1_general_ci",
+	63:  "binary",
+	65:  "ascii_bin",
+	83:  "utf8_bin",
+	87:  "gbk_bin",
+	95:  "cp932_japanese_ci",
+	192: "utf8_unicode_ci",
+	224: "utf8mb4_unicode_ci",
+	248: "gb18030_chinese_ci",
+	255: "utf8mb4_0900_ai_ci",
+}
+
+// CollationCharset returns the charset of the collation, or empty if it's unknown.
+func CollationCharset(id uint8) string {
+	name, ok := CollationNames[id]
+	if !ok {
+		return ""
+	} else if i := strings.IndexByte(name, '_'); i != -1 {
+		return name[:i]
+	}
+	return name
+}
@@ -16,6 +16,7 @@ const (
 	ERR_HEADER         byte = 0xff
 	EOF_HEADER         byte = 0xfe
 	LocalInFile_HEADER byte = 0xfb
+	MORE_DATA_HEADER   byte = 0x01
 )
 
 const (
@@ -31,6 +32,18 @@ const (
 	SERVER_STATUS_METADATA_CHANGED     uint16 = 0x0400
 	SERVER_QUERY_WAS_SLOW              uint16 = 0x0800
 	SERVER_PS_OUT_PARAMS               uint16 = 0x1000
+	SERVER_STATUS_IN_TRANS_READONLY    uint16 = 0x2000
+	SERVER_SESSION_STATE_CHANGED       uint16 = 0x4000
+)
+
+// the types of the session state changes in the OK packet, for CLIENT_SESSION_TRACK
+const (
+	SESSION_TRACK_SYSTEM_VARIABLES byte = iota
+	SESSION_TRACK_SCHEMA
+	SESSION_TRACK_STATE_CHANGE
+	SESSION_TRACK_GTIDS
+	SESSION_TRACK_TRANSACTION_CHARACTERISTICS
+	SESSION_TRACK_TRANSACTION_STATE
 )
 
 const (
@@ -68,6 +81,20 @@ const (
 	COM_RESET_CONNECTION
 )
 
+// the cursor flags of COM_STMT_EXECUTE
+const (
+	CURSOR_TYPE_NO_CURSOR  byte = 0x00
+	CURSOR_TYPE_READ_ONLY  byte = 0x01
+	CURSOR_TYPE_FOR_UPDATE byte = 0x02
+	CURSOR_TYPE_SCROLLABLE byte = 0x04
+)
+
+// the options of COM_SET_OPTION
+const (
+	MYSQL_OPTION_MULTI_STATEMENTS_ON uint16 = iota
+	MYSQL_OPTION_MULTI_STATEMENTS_OFF
+)
+
 const (
 	CLIENT_LONG_PASSWORD uint32 = 1 << iota
 	CLIENT_FOUND_ROWS
@@ -91,6 +118,18 @@ const (
 	CLIENT_PLUGIN_AUTH
 	CLIENT_CONNECT_ATTRS
 	CLIENT_PLUGIN_AUTH_LENENC_CLIENT_DATA
+	CLIENT_CAN_HANDLE_EXPIRED_PASSWORDS
+	CLIENT_SESSION_TRACK
+	CLIENT_DEPRECATE_EOF
+	CLIENT_OPTIONAL_RESULTSET_METADATA
+	CLIENT_ZSTD_COMPRESSION_ALGORITHM
+)
+
+// the compression algorithms of the compressed protocol
+const (
+	MYSQL_COMPRESS_NONE int = iota
+	MYSQL_COMPRESS_ZLIB
+	MYSQL_COMPRESS_ZSTD
 )
 
 const (
@@ -149,6 +188,14 @@ const (
 	UNIQUE_FLAG         = 65536
 )
 
+// auth plugins
+const (
+	AUTH_NATIVE_PASSWORD       = "mysql_native_password"
+	AUTH_CACHING_SHA2_PASSWORD = "caching_sha2_password"
+	AUTH_SHA256_PASSWORD       = "sha256_password"
+	AUTH_CLEAR_PASSWORD        = "mysql_clear_password"
+)
+
 const (
 	AUTH_NAME                     = "mysql_native_password"
 	DEFAULT_CHARSET               = "utf8"
@@ -2,6 +2,7 @@ package mysql
 
 import (
 	"testing"
+	"time"
 
 	"gopkg.in/check.v1"
 )
@@ -151,3 +152,34 @@ func (t *mysqlTestSuite) TestMysqlParseBinaryUint64(c *check.C) {
 	u64 := ParseBinaryUint64([]byte{1, 2, 3, 4, 5, 6, 7, 128})
 	c.Assert(u64, check.Equals, 128*uint64(72057594037927936) + 7*uint64(281474976710656) + 6*uint64(1099511627776) + 5*uint64(4294967296) + 4*16777216 + 3*65536 + 2*256 + 1)
 }
+
+func (t *mysqlTestSuite) TestBinaryTimeRoundTrip(c *check.C) {
+	fields := []*Field{{Type: MYSQL_TYPE_TIME}}
+	tbl := []struct {
+		value interface{}
+		text  string
+	}{
+		{"00:00:00", "00:00:00"},
+		{"12:34:56", "12:34:56"},
+		{[]byte("-838:59:59"), "-838:59:59"},
+		{"01:02:03.5", "01:02:03.500000"},
+		{"-25:00:02.000001", "-25:00:02.000001"},
+		{time.Duration(0), "00:00:00"},
+		{49*time.Hour + 30*time.Minute + 1500*time.Millisecond, "49:30:01.500000"},
+		{-(time.Hour + time.Second), "-01:00:01"},
+	}
+
+	for _, tt := range tbl {
+		row, err := BuildRowData(fields, []interface{}{tt.value}, true)
+		c.Assert(err, check.IsNil)
+
+		values, err := row.ParseBinary(fields)
+		c.Assert(err, check.IsNil)
+		c.Assert(string(values[0].([]byte)), check.Equals, tt.text, check.Commentf("%v", tt.value))
+	}
+
+	for _, v := range []string{"12:34", "12:60:00", "1:2:3.1234567", "a:00:00"} {
+		_, err := BuildRowData(fields, []interface{}{v}, true)
+		c.Assert(err, check.NotNil, check.Commentf(v))
+	}
+}
@@ -3,6 +3,8 @@ package mysql
 import (
 	"math"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/juju/errors"
 	"github.com/siddontang/go/hack"
@@ -38,45 +40,16 @@ func formatTextValue(value interface{}) ([]byte, error) {
 		return v, nil
 	case string:
 		return hack.Slice(v), nil
+	case time.Time:
+		if v.IsZero() {
+			return []byte("0000-00-00 00:00:00"), nil
+		}
+		return []byte(v.Format(TimeFormat + ".999999")), nil
 	default:
 		return nil, errors.Errorf("invalid type %T", value)
 	}
 }
 
-func formatBinaryValue(value interface{}) ([]byte, error) {
-	switch v := value.(type) {
-	case int8:
-		return Uint64ToBytes(uint64(v)), nil
-	case int16:
-		return Uint64ToBytes(uint64(v)), nil
-	case int32:
-		return Uint64ToBytes(uint64(v)), nil
-	case int64:
-		return Uint64ToBytes(uint64(v)), nil
-	case int:
-		return Uint64ToBytes(uint64(v)), nil
-	case uint8:
-		return Uint64ToBytes(uint64(v)), nil
-	case uint16:
-		return Uint64ToBytes(uint64(v)), nil
-	case uint32:
-		return Uint64ToBytes(uint64(v)), nil
-	case uint64:
-		return Uint64ToBytes(uint64(v)), nil
-	case uint:
-		return Uint64ToBytes(uint64(v)), nil
-	case float32:
-		return Uint64ToBytes(math.Float64bits(float64(v))), nil
-	case float64:
-		return Uint64ToBytes(math.Float64bits(v)), nil
-	case []byte:
-		return v, nil
-	case string:
-		return hack.Slice(v), nil
-	default:
-		return nil, errors.Errorf("invalid type %T", value)
-	}
-}
 func formatField(field *Field, value interface{}) error {
 	switch value.(type) {
 	case int8, int16, int32, int64, int:
@@ -94,112 +67,356 @@ func formatField(field *Field, value interface{}) error {
 	case string, []byte:
 		field.Charset = 33
 		field.Type = MYSQL_TYPE_VAR_STRING
+	case time.Time:
+		field.Charset = 63
+		field.Type = MYSQL_TYPE_DATETIME
+		field.Flag = BINARY_FLAG
 	default:
 		return errors.Errorf("unsupport type %T for resultset", value)
 	}
 	return nil
 }
 
+// we can't know the field types if no row, use string for all
+func buildEmptyFields(names []string) []*Field {
+	fields := make([]*Field, len(names))
+	for i, name := range names {
+		fields[i] = &Field{Name: hack.Slice(name), Charset: 33, Type: MYSQL_TYPE_VAR_STRING}
+	}
+	return fields
+}
+
 func BuildSimpleTextResultset(names []string, values [][]interface{}) (*Resultset, error) {
+	return buildSimpleResultset(names, values, false)
+}
+
+func BuildSimpleBinaryResultset(names []string, values [][]interface{}) (*Resultset, error) {
+	return buildSimpleResultset(names, values, true)
+}
+
+// buildSimpleResultset uses the types of the first row values as the field types, and keeps the values in the Resultset.
+func buildSimpleResultset(names []string, values [][]interface{}, binary bool) (*Resultset, error) {
 	r := new(Resultset)
 
+	if len(values) == 0 {
+		r.Fields = buildEmptyFields(names)
+		return r, nil
+	}
+
 	r.Fields = make([]*Field, len(names))
 
-	var b []byte
-	var err error
+	for j, value := range values[0] {
+		if j >= len(names) {
+			break
+		}
+
+		field := &Field{}
+		r.Fields[j] = field
+		field.Name = hack.Slice(names[j])
+		if err := formatField(field, value); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
 
 	for i, vs := range values {
 		if len(vs) != len(r.Fields) {
 			return nil, errors.Errorf("row %d has %d column not equal %d", i, len(vs), len(r.Fields))
 		}
 
+		row, err := BuildRowData(r.Fields, vs, binary)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		r.RowDatas = append(r.RowDatas, row)
+	}
+
+	r.Values = values
+
+	return r, nil
+}
+
+func BuildSimpleResultset(names []string, values [][]interface{}, binary bool) (*Resultset, error) {
+	if binary {
+		return BuildSimpleBinaryResultset(names, values)
+	} else {
+		return BuildSimpleTextResultset(names, values)
+	}
+}
+
+// BuildRowData encodes the values of a row for the fields, with the text or binary protocol, nil is NULL.
+// The value is formatted like BuildSimpleResultset, but the binary value is encoded by the field type.
+func BuildRowData(fields []*Field, values []interface{}, binary bool) (RowData, error) {
+	if len(values) != len(fields) {
+		return nil, errors.Errorf("row has %d column not equal %d", len(values), len(fields))
+	}
+
+	if !binary {
 		var row []byte
-		for j, value := range vs {
-			if i == 0 {
-				field := &Field{}
-				r.Fields[j] = field
-				field.Name = hack.Slice(names[j])
-
-				if err = formatField(field, value); err != nil {
-					return nil, errors.Trace(err)
-				}
+		for _, value := range values {
+			if value == nil {
+				row = append(row, 0xfb)
+				continue
 			}
-			b, err = formatTextValue(value)
 
+			b, err := formatTextValue(value)
 			if err != nil {
 				return nil, errors.Trace(err)
 			}
-
 			row = append(row, PutLengthEncodedString(b)...)
 		}
+		return row, nil
+	}
 
-		r.RowDatas = append(r.RowDatas, row)
+	// header, null bitmap with 2 bits offset
+	bitmapLen := (len(fields) + 7 + 2) >> 3
+	row := make([]byte, 1+bitmapLen)
+
+	for i, value := range values {
+		if value == nil {
+			row[1+(i+2)/8] |= 1 << (uint(i+2) % 8)
+			continue
+		}
+
+		b, err := formatBinaryFieldValue(fields[i], value)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		row = append(row, b...)
 	}
 
-	return r, nil
+	return row, nil
 }
 
-func BuildSimpleBinaryResultset(names []string, values [][]interface{}) (*Resultset, error) {
-	r := new(Resultset)
+func formatBinaryFieldValue(field *Field, value interface{}) ([]byte, error) {
+	var size int
+	switch field.Type {
+	case MYSQL_TYPE_TINY:
+		size = 1
+	case MYSQL_TYPE_SHORT, MYSQL_TYPE_YEAR:
+		size = 2
+	case MYSQL_TYPE_INT24, MYSQL_TYPE_LONG:
+		size = 4
+	case MYSQL_TYPE_LONGLONG:
+		size = 8
+	case MYSQL_TYPE_FLOAT:
+		f, ok := toFloat64(value)
+		if !ok {
+			return nil, errors.Errorf("invalid type %T for float", value)
+		}
+		return Uint64ToBytes(uint64(math.Float32bits(float32(f))))[:4], nil
+	case MYSQL_TYPE_DOUBLE:
+		f, ok := toFloat64(value)
+		if !ok {
+			return nil, errors.Errorf("invalid type %T for double", value)
+		}
+		return Uint64ToBytes(math.Float64bits(f)), nil
+	case MYSQL_TYPE_DATE, MYSQL_TYPE_NEWDATE, MYSQL_TYPE_DATETIME, MYSQL_TYPE_TIMESTAMP:
+		t, err := toTime(value)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return formatBinaryTime(field.Type, t), nil
+	case MYSQL_TYPE_TIME:
+		d, err := toDuration(value)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return formatBinaryDuration(d), nil
+	default:
+		// the string, decimal, blob, etc... are sent as the text
+		b, err := formatTextValue(value)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return PutLengthEncodedString(b), nil
+	}
 
-	r.Fields = make([]*Field, len(names))
+	var n uint64
+	switch v := value.(type) {
+	case int8:
+		n = uint64(v)
+	case int16:
+		n = uint64(v)
+	case int32:
+		n = uint64(v)
+	case int64:
+		n = uint64(v)
+	case int:
+		n = uint64(v)
+	case uint8:
+		n = uint64(v)
+	case uint16:
+		n = uint64(v)
+	case uint32:
+		n = uint64(v)
+	case uint64:
+		n = v
+	case uint:
+		n = uint64(v)
+	case []byte, string:
+		// the value parsed from the text protocol
+		var err error
+		if field.Flag&UNSIGNED_FLAG != 0 {
+			n, err = strconv.ParseUint(toString(v), 10, 64)
+		} else {
+			var i int64
+			i, err = strconv.ParseInt(toString(v), 10, 64)
+			n = uint64(i)
+		}
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+	default:
+		return nil, errors.Errorf("invalid type %T for integer", value)
+	}
 
-	var b []byte
-	var err error
+	return Uint64ToBytes(n)[:size], nil
+}
 
-	bitmapLen := ((len(names) + 7 + 2) >> 3)
+func toFloat64(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	case []byte, string:
+		f, err := strconv.ParseFloat(toString(v), 64)
+		return f, err == nil
+	default:
+		return 0, false
+	}
+}
 
-	for i, vs := range values {
-		if len(vs) != len(r.Fields) {
-			return nil, errors.Errorf("row %d has %d column not equal %d", i, len(vs), len(r.Fields))
-		}
+func toString(value interface{}) string {
+	if b, ok := value.([]byte); ok {
+		return hack.String(b)
+	}
+	return value.(string)
+}
 
-		var row []byte
-		nullBitmap := make([]byte, bitmapLen)
+// toTime converts the date or datetime value, the text like 2006-01-02 15:04:05.999999 is parsed.
+func toTime(value interface{}) (time.Time, error) {
+	switch v := value.(type) {
+	case time.Time:
+		return v, nil
+	case []byte, string:
+		s := toString(v)
+		if strings.HasPrefix(s, "0000-00-00") {
+			return time.Time{}, nil
+		}
 
-		row = append(row, 0)
-		row = append(row, nullBitmap...)
+		layout := TimeFormat + ".999999"
+		if len(s) == len("2006-01-02") {
+			layout = "2006-01-02"
+		}
+		t, err := time.ParseInLocation(layout, s, time.UTC)
+		return t, errors.Trace(err)
+	default:
+		return time.Time{}, errors.Errorf("invalid type %T for time", value)
+	}
+}
 
-		for j, value := range vs {
-			if i == 0 {
-				field := &Field{}
-				r.Fields[j] = field
-				field.Name = hack.Slice(names[j])
+// toDuration converts the time value, the text like -838:59:59.999999 is parsed.
+func toDuration(value interface{}) (time.Duration, error) {
+	switch v := value.(type) {
+	case time.Duration:
+		return v, nil
+	case []byte, string:
+		s := toString(v)
+		neg := strings.HasPrefix(s, "-")
+		if neg {
+			s = s[1:]
+		}
 
-				if err = formatField(field, value); err != nil {
-					return nil, errors.Trace(err)
-				}
+		var usec int64
+		if i := strings.IndexByte(s, '.'); i >= 0 {
+			frac := s[i+1:]
+			if len(frac) == 0 || len(frac) > 6 {
+				return 0, errors.Errorf("invalid time %q", toString(v))
 			}
-			if value == nil {
-				nullBitmap[(i+2)/8] |= (1 << (uint(i+2) % 8))
-				continue
+
+			var err error
+			if usec, err = strconv.ParseInt((frac + "00000")[:6], 10, 64); err != nil {
+				return 0, errors.Errorf("invalid time %q", toString(v))
 			}
+			s = s[:i]
+		}
 
-			b, err = formatBinaryValue(value)
+		parts := strings.Split(s, ":")
+		if len(parts) != 3 {
+			return 0, errors.Errorf("invalid time %q", toString(v))
+		}
 
-			if err != nil {
-				return nil, errors.Trace(err)
+		var clock [3]int64
+		for i, part := range parts {
+			n, err := strconv.ParseUint(part, 10, 32)
+			if err != nil || (i > 0 && n >= 60) {
+				return 0, errors.Errorf("invalid time %q", toString(v))
 			}
+			clock[i] = int64(n)
+		}
 
-			if r.Fields[j].Type == MYSQL_TYPE_VAR_STRING {
-				row = append(row, PutLengthEncodedString(b)...)
-			} else {
-				row = append(row, b...)
-			}
+		d := time.Duration(clock[0])*time.Hour + time.Duration(clock[1])*time.Minute +
+			time.Duration(clock[2])*time.Second + time.Duration(usec)*time.Microsecond
+		if neg {
+			d = -d
 		}
+		return d, nil
+	default:
+		return 0, errors.Errorf("invalid type %T for time", value)
+	}
+}
 
-		copy(row[1:], nullBitmap)
+// formatBinaryDuration encodes the time with the length, the sign, the days and the clock, the zero microseconds are omitted.
+func formatBinaryDuration(d time.Duration) []byte {
+	if d == 0 {
+		return []byte{0}
+	}
 
-		r.RowDatas = append(r.RowDatas, row)
+	var sign byte
+	if d < 0 {
+		sign = 1
+		d = -d
 	}
 
-	return r, nil
+	usec := uint32(d % time.Second / time.Microsecond)
+	sec := int64(d / time.Second)
+
+	data := []byte{8, sign}
+	data = append(data, Uint32ToBytes(uint32(sec/86400))...)
+	data = append(data, byte(sec/3600%24), byte(sec/60%60), byte(sec%60))
+	if usec == 0 {
+		return data
+	}
+
+	data[0] = 12
+	return append(data, Uint32ToBytes(usec)...)
 }
 
-func BuildSimpleResultset(names []string, values [][]interface{}, binary bool) (*Resultset, error) {
-	if binary {
-		return BuildSimpleBinaryResultset(names, values)
-	} else {
-		return BuildSimpleTextResultset(names, values)
+// formatBinaryTime encodes the date or datetime with the length, the zero parts are omitted.
+func formatBinaryTime(tp uint8, t time.Time) []byte {
+	if t.IsZero() {
+		return []byte{0}
 	}
+
+	year, month, day := t.Date()
+	data := []byte{4, byte(year), byte(year >> 8), byte(month), byte(day)}
+	if tp == MYSQL_TYPE_DATE || tp == MYSQL_TYPE_NEWDATE {
+		return data
+	}
+
+	hour, min, sec := t.Clock()
+	usec := t.Nanosecond() / 1000
+	if hour == 0 && min == 0 && sec == 0 && usec == 0 {
+		return data
+	}
+
+	data[0] = 7
+	data = append(data, byte(hour), byte(min), byte(sec))
+	if usec == 0 {
+		return data
+	}
+
+	data[0] = 11
+	return append(data, Uint32ToBytes(uint32(usec))...)
 }
@@ -3,6 +3,7 @@ package mysql
 import (
 	"crypto/rand"
 	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -48,6 +49,32 @@ func CalcPassword(scramble, password []byte) []byte {
 	return scramble
 }
 
+// CalcCachingSha2Password calculates the scramble for caching_sha2_password:
+// XOR(SHA256(password), SHA256(SHA256(SHA256(password)), scramble))
+func CalcCachingSha2Password(scramble, password []byte) []byte {
+	if len(password) == 0 {
+		return nil
+	}
+
+	crypt := sha256.New()
+	crypt.Write(password)
+	stage1 := crypt.Sum(nil)
+
+	crypt.Reset()
+	crypt.Write(stage1)
+	stage2 := crypt.Sum(nil)
+
+	crypt.Reset()
+	crypt.Write(stage2)
+	crypt.Write(scramble)
+	token := crypt.Sum(nil)
+
+	for i := range token {
+		token[i] ^= stage1[i]
+	}
+	return token
+}
+
 func RandomBuf(size int) ([]byte, error) {
 	buf := make([]byte, size)
 
@@ -254,28 +281,31 @@ func FormatBinaryDateTime(n int, data []byte) ([]byte, error) {
 
 func FormatBinaryTime(n int, data []byte) ([]byte, error) {
 	if n == 0 {
-		return []byte("0000-00-00"), nil
+		return []byte("00:00:00"), nil
+	} else if len(data) < n {
+		return nil, ErrMalformPacket
 	}
 
-	var sign byte
+	// the sign is only written for the negative time
+	var sign string
 	if data[0] == 1 {
-		sign = byte('-')
+		sign = "-"
 	}
 
 	switch n {
 	case 8:
 		return []byte(fmt.Sprintf(
-			"%c%02d:%02d:%02d",
+			"%s%02d:%02d:%02d",
 			sign,
-			uint16(data[1])*24+uint16(data[5]),
+			binary.LittleEndian.Uint32(data[1:5])*24+uint32(data[5]),
 			data[6],
 			data[7],
 		)), nil
 	case 12:
 		return []byte(fmt.Sprintf(
-			"%c%02d:%02d:%02d.%06d",
+			"%s%02d:%02d:%02d.%06d",
 			sign,
-			uint16(data[1])*24+uint16(data[5]),
+			binary.LittleEndian.Uint32(data[1:5])*24+uint32(data[5]),
 			data[6],
 			data[7],
 			binary.LittleEndian.Uint32(data[8:12]),
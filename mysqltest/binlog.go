@@ -0,0 +1,338 @@
+package mysqltest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"path"
+	"time"
+
+	"github.com/gdey/go-mysql/mysql"
+	"github.com/gdey/go-mysql/replication"
+	"github.com/gdey/go-mysql/server"
+	"github.com/juju/errors"
+)
+
+// binlogFile is a binlog file the server serves, the data is only appended.
+type binlogFile struct {
+	name string
+	data []byte
+
+	// the format description event
+	format      []byte
+	checksumAlg byte
+}
+
+// AddBinlogFile adds a binlog file after the others, data must start with the binlog file header
+// and a format description event, like a binlog file of MySQL or written by replication.BinlogWriter.
+// The binlog_checksum variable is set by the format description event.
+func (s *Server) AddBinlogFile(name string, data []byte) error {
+	if !bytes.HasPrefix(data, replication.BinLogFileHeader) {
+		return errors.Errorf("binlog %s has no file header", name)
+	}
+
+	start := len(replication.BinLogFileHeader)
+	if err := checkEvents(data[start:]); err != nil {
+		return errors.Annotatef(err, "binlog %s", name)
+	}
+
+	if len(data) == start || replication.EventType(data[start+4]) != replication.FORMAT_DESCRIPTION_EVENT {
+		return errors.Errorf("no FormatDescriptionEvent in binlog %s", name)
+	}
+
+	f := &binlogFile{name: name, data: append([]byte(nil), data...)}
+	f.format = f.data[start : start+int(binary.LittleEndian.Uint32(data[start+9:]))]
+
+	e := new(replication.FormatDescriptionEvent)
+	if err := e.Decode(f.format[replication.EventHeaderSize:]); err != nil {
+		return errors.Annotatef(err, "binlog %s", name)
+	}
+	f.checksumAlg = e.ChecksumAlgorithm
+
+	checksum := "NONE"
+	if f.checksumAlg == replication.BINLOG_CHECKSUM_ALG_CRC32 {
+		checksum = "CRC32"
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.binlog(name) != nil {
+		return errors.Errorf("binlog %s exists", name)
+	}
+
+	s.binlogs = append(s.binlogs, f)
+	s.variables["binlog_checksum"] = checksum
+	s.notifyBinlog()
+
+	return nil
+}
+
+// LoadBinlogFile adds the binlog file on the disk with its base name, see AddBinlogFile.
+func (s *Server) LoadBinlogFile(file string) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	return s.AddBinlogFile(path.Base(file), data)
+}
+
+// AppendBinlogEvents appends the encoded events to the binlog file, the binlog dumps waiting
+// for more events get them. The log positions in the event headers must be right.
+func (s *Server) AppendBinlogEvents(name string, data []byte) error {
+	if err := checkEvents(data); err != nil {
+		return errors.Annotatef(err, "binlog %s", name)
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	f := s.binlog(name)
+	if f == nil {
+		return errors.Errorf("binlog %s not found", name)
+	}
+
+	f.data = append(f.data, data...)
+	s.notifyBinlog()
+
+	return nil
+}
+
+// checkEvents checks the data is made of whole events.
+func checkEvents(data []byte) error {
+	for pos := 0; pos < len(data); {
+		if len(data)-pos < replication.EventHeaderSize {
+			return errors.Errorf("truncated event header at %d", pos)
+		}
+
+		size := int(binary.LittleEndian.Uint32(data[pos+9:]))
+		if size < replication.EventHeaderSize || size > len(data)-pos {
+			return errors.Errorf("invalid event size %d at %d", size, pos)
+		}
+		pos += size
+	}
+
+	return nil
+}
+
+// binlog returns the binlog file of the name, it must be called with the lock.
+func (s *Server) binlog(name string) *binlogFile {
+	for _, f := range s.binlogs {
+		if f.name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// notifyBinlog wakes up the binlog dumps, it must be called with the lock.
+func (s *Server) notifyBinlog() {
+	if !s.closed {
+		close(s.binlogNotify)
+		s.binlogNotify = make(chan struct{})
+	}
+}
+
+// binlogState returns the data of the binlog file, the name of the next one, and the channel
+// notified when they are changed. closed is true if the server is closed.
+func (s *Server) binlogState(name string) (data []byte, next string, notify <-chan struct{}, closed bool) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	for i, f := range s.binlogs {
+		if f.name == name {
+			data = f.data
+			if i+1 < len(s.binlogs) {
+				next = s.binlogs[i+1].name
+			}
+			break
+		}
+	}
+
+	return data, next, s.binlogNotify, s.closed
+}
+
+// binlogSource serves the events in the binlog files for a binlog dump, like the relay does.
+type binlogSource struct {
+	s *Server
+
+	nonBlock bool
+
+	// the binlog file and the position of the next event, f is nil after a rotate event
+	f           *binlogFile
+	name        string
+	pos         int
+	checksumAlg byte
+	// whether the slave has got a format description event, then the fake rotate event needs a checksum too
+	hasFormat bool
+
+	// the events to send before reading the file, like the fake rotate event
+	pending [][]byte
+}
+
+func newBinlogSource(s *Server, r *server.BinlogDumpRequest) (*binlogSource, error) {
+	b := new(binlogSource)
+
+	b.s = s
+	b.nonBlock = r.NonBlock()
+
+	p := r.Position
+	if len(p.Name) == 0 {
+		// like MySQL, start from the first binlog file
+		s.m.Lock()
+		if len(s.binlogs) > 0 {
+			p.Name = s.binlogs[0].name
+		}
+		s.m.Unlock()
+
+		if len(p.Name) == 0 {
+			return nil, mysql.NewError(mysql.ER_MASTER_FATAL_ERROR_READING_BINLOG, "no binlog file in fake server")
+		}
+	}
+
+	if p.Pos < 4 {
+		p.Pos = 4
+	}
+
+	if err := b.openFile(p.Name, int(p.Pos)); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return b, nil
+}
+
+// openFile opens the binlog file, and sends a fake rotate event and its format description event first, like MySQL.
+func (b *binlogSource) openFile(name string, pos int) error {
+	b.s.m.Lock()
+	f := b.s.binlog(name)
+	b.s.m.Unlock()
+
+	if f == nil {
+		return mysql.NewError(mysql.ER_MASTER_FATAL_ERROR_READING_BINLOG, "could not find binlog "+name)
+	}
+
+	start := len(replication.BinLogFileHeader)
+	format := f.format
+	if pos == start {
+		pos += len(format)
+	} else if pos < start+len(format) {
+		return mysql.NewError(mysql.ER_MASTER_FATAL_ERROR_READING_BINLOG, "invalid binlog position")
+	} else {
+		// the format description event is not at the position the slave wants,
+		// set log pos to 0, so the slave won't update its position with it.
+		format = append([]byte(nil), format...)
+		binary.LittleEndian.PutUint32(format[13:], 0)
+		if f.checksumAlg == replication.BINLOG_CHECKSUM_ALG_CRC32 {
+			n := len(format) - 4
+			binary.LittleEndian.PutUint32(format[n:], crc32.ChecksumIEEE(format[0:n]))
+		}
+	}
+
+	h := &replication.EventHeader{
+		EventType: replication.ROTATE_EVENT,
+		ServerID:  binary.LittleEndian.Uint32(format[5:]),
+		Flags:     replication.LOG_EVENT_ARTIFICIAL_F,
+	}
+
+	rotateChecksumAlg := replication.BINLOG_CHECKSUM_ALG_OFF
+	if b.hasFormat {
+		rotateChecksumAlg = b.checksumAlg
+	}
+
+	rotate, err := replication.EncodeEvent(h, &replication.RotateEvent{Position: uint64(pos), NextLogName: []byte(name)},
+		rotateChecksumAlg)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	b.f = f
+	b.name = name
+	b.pos = pos
+	b.checksumAlg = f.checksumAlg
+	b.hasFormat = true
+	b.pending = append(b.pending, rotate, format)
+
+	return nil
+}
+
+func (b *binlogSource) GetEvent(timeout time.Duration) ([]byte, error) {
+	var timer <-chan time.Time
+	if timeout > 0 {
+		timer = time.After(timeout)
+	}
+
+	for {
+		if len(b.pending) > 0 {
+			data := b.pending[0]
+			b.pending = b.pending[1:]
+			return data, nil
+		}
+
+		data, next, notify, closed := b.s.binlogState(b.name)
+		if closed {
+			return nil, io.EOF
+		}
+
+		if b.f == nil {
+			// wait for the binlog file the rotate event points to
+			if data != nil {
+				if err := b.openFile(b.name, b.pos); err != nil {
+					return nil, errors.Trace(err)
+				}
+				continue
+			}
+		} else if b.pos < len(data) {
+			size := int(binary.LittleEndian.Uint32(data[b.pos+9:]))
+			event := data[b.pos : b.pos+size]
+			b.pos += size
+
+			if replication.EventType(event[4]) == replication.ROTATE_EVENT {
+				if err := b.rotate(event); err != nil {
+					return nil, errors.Trace(err)
+				}
+			}
+			return event, nil
+		} else if len(next) > 0 {
+			// the binlog has no rotate event at the end, go on with the next binlog
+			if err := b.openFile(next, 4); err != nil {
+				return nil, errors.Trace(err)
+			}
+			continue
+		}
+
+		if b.nonBlock {
+			return nil, io.EOF
+		}
+
+		select {
+		case <-notify:
+		case <-timer:
+			return nil, replication.ErrGetEventTimeout
+		}
+	}
+}
+
+// rotate goes on with the binlog the rotate event points to.
+func (b *binlogSource) rotate(event []byte) error {
+	body := event[replication.EventHeaderSize:]
+	if b.checksumAlg == replication.BINLOG_CHECKSUM_ALG_CRC32 {
+		body = body[0 : len(body)-4]
+	}
+
+	e := new(replication.RotateEvent)
+	if err := e.Decode(body); err != nil {
+		return errors.Trace(err)
+	}
+
+	b.f = nil
+	b.name = string(e.NextLogName)
+	b.pos = int(e.Position)
+	return nil
+}
+
+func (b *binlogSource) Close() error {
+	return nil
+}
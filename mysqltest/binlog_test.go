@@ -0,0 +1,195 @@
+package mysqltest
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/gdey/go-mysql/client"
+	"github.com/gdey/go-mysql/mysql"
+	"github.com/gdey/go-mysql/replication"
+	. "gopkg.in/check.v1"
+)
+
+type binlogTestSuite struct {
+	s *Server
+}
+
+var _ = Suite(&binlogTestSuite{})
+
+func (t *binlogTestSuite) SetUpTest(c *C) {
+	var err error
+	t.s, err = NewServer()
+	c.Assert(err, IsNil)
+}
+
+func (t *binlogTestSuite) TearDownTest(c *C) {
+	if t.s != nil {
+		t.s.Close()
+	}
+}
+
+// writeTestBinlog writes a binlog file inserting the ids into test.t in a transaction for every id,
+// it rotates to next at the end if next is not empty. The end positions of the transactions are returned too.
+func writeTestBinlog(c *C, ids []int32, next string) ([]byte, []uint32) {
+	var buf bytes.Buffer
+	w := replication.NewBinlogWriter(&buf, 1)
+	c.Assert(w.WriteFileHeader(), IsNil)
+
+	var ends []uint32
+	err := w.WriteEvent(replication.FORMAT_DESCRIPTION_EVENT,
+		replication.NewFormatDescriptionEvent(replication.DefaultBinlogServerVersion, replication.BINLOG_CHECKSUM_ALG_CRC32))
+	c.Assert(err, IsNil)
+
+	table := &replication.TableMapEvent{
+		TableID:     100,
+		Schema:      []byte("test"),
+		Table:       []byte("t"),
+		ColumnCount: 2,
+		ColumnType:  []byte{mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_VARCHAR},
+		ColumnMeta:  []uint16{0, 100},
+		NullBitmap:  []byte{0x02},
+	}
+
+	for _, id := range ids {
+		err = w.WriteEvent(replication.QUERY_EVENT, &replication.QueryEvent{Schema: []byte("test"), Query: []byte("BEGIN")})
+		c.Assert(err, IsNil)
+
+		err = w.WriteEvent(replication.TABLE_MAP_EVENT, table)
+		c.Assert(err, IsNil)
+
+		rows := [][]interface{}{{id, strconv.Itoa(int(id))}}
+		err = w.WriteEvent(replication.WRITE_ROWS_EVENTv2, &replication.RowsEvent{TableID: 100, Table: table, Rows: rows})
+		c.Assert(err, IsNil)
+
+		err = w.WriteEvent(replication.XID_EVENT, &replication.XIDEvent{XID: uint64(id)})
+		c.Assert(err, IsNil)
+
+		ends = append(ends, w.Position())
+	}
+
+	if len(next) > 0 {
+		err = w.WriteEvent(replication.ROTATE_EVENT, &replication.RotateEvent{Position: 4, NextLogName: []byte(next)})
+		c.Assert(err, IsNil)
+	}
+
+	return buf.Bytes(), ends
+}
+
+// getRows returns the ids in the rows events of n transactions, and the position after them.
+func getRows(c *C, s *replication.BinlogStreamer, n int) ([]int32, mysql.Position) {
+	var ids []int32
+	var pos mysql.Position
+	for xids := 0; xids < n; {
+		e, err := s.GetEventTimeout(5 * time.Second)
+		c.Assert(err, IsNil)
+
+		switch ev := e.Event.(type) {
+		case *replication.RotateEvent:
+			pos = mysql.Position{Name: string(ev.NextLogName), Pos: uint32(ev.Position)}
+			continue
+		case *replication.RowsEvent:
+			c.Assert(string(ev.Table.Table), Equals, "t")
+			ids = append(ids, ev.Rows[0][0].(int32))
+		case *replication.XIDEvent:
+			xids++
+		}
+
+		if e.Header.LogPos > 0 {
+			pos.Pos = e.Header.LogPos
+		}
+	}
+
+	return ids, pos
+}
+
+func (t *binlogTestSuite) newSyncer(c *C) *replication.BinlogSyncer {
+	host, port, err := net.SplitHostPort(t.s.Addr())
+	c.Assert(err, IsNil)
+	p, err := strconv.ParseUint(port, 10, 16)
+	c.Assert(err, IsNil)
+
+	b := replication.NewBinlogSyncer(100, mysql.MySQLFlavor)
+	err = b.RegisterSlave(host, uint16(p), "root", "")
+	c.Assert(err, IsNil)
+	return b
+}
+
+func (t *binlogTestSuite) TestBinlogSyncer(c *C) {
+	data, _ := writeTestBinlog(c, []int32{1, 2}, "mysql-bin.000002")
+	err := t.s.AddBinlogFile("mysql-bin.000001", data)
+	c.Assert(err, IsNil)
+
+	// the second binlog has no rotate event, and its second transaction is appended later
+	data, ends := writeTestBinlog(c, []int32{3, 4}, "")
+	err = t.s.AddBinlogFile("mysql-bin.000002", data[0:ends[0]])
+	c.Assert(err, IsNil)
+
+	err = t.s.AddBinlogFile("mysql-bin.000002", data)
+	c.Assert(err, NotNil)
+	err = t.s.AddBinlogFile("mysql-bin.000003", data[4:])
+	c.Assert(err, NotNil)
+
+	b := t.newSyncer(c)
+	defer b.Close()
+
+	c.Assert(t.s.Slaves(), HasLen, 1)
+	c.Assert(t.s.Slaves()[0].ServerID, Equals, uint32(100))
+
+	s, err := b.StartSync(mysql.Position{Name: "mysql-bin.000001", Pos: 4})
+	c.Assert(err, IsNil)
+
+	ids, pos := getRows(c, s, 3)
+	c.Assert(ids, DeepEquals, []int32{1, 2, 3})
+	c.Assert(pos, Equals, mysql.Position{Name: "mysql-bin.000002", Pos: ends[0]})
+
+	// the dump is waiting for more events
+	_, err = s.GetEventTimeout(100 * time.Millisecond)
+	c.Assert(err, Equals, replication.ErrGetEventTimeout)
+
+	err = t.s.AppendBinlogEvents("mysql-bin.000002", data[ends[0]:])
+	c.Assert(err, IsNil)
+
+	ids, pos = getRows(c, s, 1)
+	c.Assert(ids, DeepEquals, []int32{4})
+	c.Assert(pos.Pos, Equals, ends[1])
+
+	conn, err := client.Connect(t.s.Addr(), "root", "", "")
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	r, err := conn.Execute("SHOW MASTER STATUS")
+	c.Assert(err, IsNil)
+	name, _ := r.GetString(0, 0)
+	c.Assert(name, Equals, "mysql-bin.000002")
+	end, _ := r.GetUint(0, 1)
+	c.Assert(end, Equals, uint64(ends[1]))
+}
+
+func (t *binlogTestSuite) TestStartPosition(c *C) {
+	data, ends := writeTestBinlog(c, []int32{1, 2}, "")
+	err := t.s.AddBinlogFile("mysql-bin.000001", data)
+	c.Assert(err, IsNil)
+
+	b := t.newSyncer(c)
+	defer b.Close()
+
+	s, err := b.StartSync(mysql.Position{Name: "mysql-bin.000001", Pos: ends[0]})
+	c.Assert(err, IsNil)
+
+	ids, pos := getRows(c, s, 1)
+	c.Assert(ids, DeepEquals, []int32{2})
+	c.Assert(pos, Equals, mysql.Position{Name: "mysql-bin.000001", Pos: ends[1]})
+
+	b.Close()
+
+	b = t.newSyncer(c)
+	defer b.Close()
+
+	s, err = b.StartSync(mysql.Position{Name: "mysql-bin.000009", Pos: 4})
+	c.Assert(err, IsNil)
+
+	_, err = s.GetEventTimeout(5 * time.Second)
+	c.Assert(errorCode(err), Equals, uint16(mysql.ER_MASTER_FATAL_ERROR_READING_BINLOG))
+}
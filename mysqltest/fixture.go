@@ -0,0 +1,329 @@
+package mysqltest
+
+import (
+	"bytes"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gdey/go-mysql/mysql"
+	"github.com/gdey/go-mysql/server"
+	"github.com/satori/go.uuid"
+)
+
+var (
+	showVariablesRegexp  = regexp.MustCompile(`(?i)^SHOW (GLOBAL |SESSION )?VARIABLES( LIKE ['"]([^'"]*)['"])?$`)
+	selectVariableRegexp = regexp.MustCompile(`(?i)^SELECT (@@(GLOBAL\.|SESSION\.)?(\w+))( LIMIT \d+)?$`)
+	showMasterRegexp     = regexp.MustCompile(`(?i)^SHOW MASTER STATUS$`)
+	showBinaryLogsRegexp = regexp.MustCompile(`(?i)^SHOW (BINARY|MASTER) LOGS$`)
+	describeRegexp       = regexp.MustCompile(`(?i)^(DESCRIBE|DESC|EXPLAIN) ([^ ]+)$`)
+	showColumnsRegexp    = regexp.MustCompile(`(?i)^SHOW (FULL )?(COLUMNS|FIELDS) (FROM|IN) ([^ ]+)( (FROM|IN) ([^ ]+))?$`)
+	showIndexRegexp      = regexp.MustCompile(`(?i)^SHOW (INDEX|INDEXES|KEYS) (FROM|IN) ([^ ]+)( (FROM|IN) ([^ ]+))?$`)
+	setRegexp            = regexp.MustCompile(`(?i)^SET `)
+)
+
+// Column is a column of a table fixture, like a row of SHOW FULL COLUMNS.
+type Column struct {
+	Name string
+	// the column type, like int(11) unsigned or varchar(255)
+	Type string
+	// empty for NULL, like the columns which are not strings
+	Collation string
+	Nullable  bool
+	// PRI, UNI or MUL
+	Key string
+	// nil for NULL
+	Default interface{}
+	// like auto_increment
+	Extra   string
+	Comment string
+}
+
+// Index is an index of a table fixture, the primary key must be named PRIMARY.
+type Index struct {
+	Name    string
+	Columns []string
+	Unique  bool
+	// the cardinality of every column, 0 if not set
+	Cardinality []uint64
+}
+
+// Table is a table fixture for DESCRIBE, SHOW [FULL] COLUMNS and SHOW INDEX.
+type Table struct {
+	Schema  string
+	Name    string
+	Columns []Column
+	Indexes []Index
+}
+
+func defaultVariables() map[string]string {
+	return map[string]string{
+		"version":                      mysql.ServerVersion,
+		"version_comment":              "go-mysql fake server",
+		"server_id":                    "1",
+		"server_uuid":                  uuid.NewV4().String(),
+		"log_bin":                      "ON",
+		"binlog_format":                "ROW",
+		"binlog_row_image":             "FULL",
+		"binlog_checksum":              "CRC32",
+		"gtid_mode":                    "OFF",
+		"rpl_semi_sync_master_enabled": "OFF",
+		"autocommit":                   "ON",
+	}
+}
+
+// SetVariable sets the value of the variable for SHOW VARIABLES and SELECT @@name,
+// the global and session variables are the same. The defaults are for a MySQL
+// with row based binlog, and binlog_checksum is set by the format description event of a new binlog file.
+func (s *Server) SetVariable(name string, value string) {
+	s.m.Lock()
+	s.variables[strings.ToLower(name)] = value
+	s.m.Unlock()
+}
+
+// SetMasterStatus sets the result of SHOW MASTER STATUS,
+// it's the end of the last binlog file by default.
+func (s *Server) SetMasterStatus(pos mysql.Position, executedGTIDSet string) {
+	s.m.Lock()
+	s.masterPos = &pos
+	s.executedGTIDSet = executedGTIDSet
+	s.m.Unlock()
+}
+
+// AddTable adds or replaces the table fixture.
+func (s *Server) AddTable(t *Table) {
+	s.m.Lock()
+	s.tables[tableKey(t.Schema, t.Name)] = t
+	s.m.Unlock()
+}
+
+func tableKey(db string, table string) string {
+	return strings.ToLower(db + "." + table)
+}
+
+// table returns the table fixture of the name, which may be qualified with the schema,
+// db is the schema in the other clause like SHOW COLUMNS FROM t FROM db, or the current database.
+func (s *Server) table(name string, db string) (*Table, error) {
+	name = strings.Replace(name, "`", "", -1)
+	db = strings.Replace(db, "`", "", -1)
+	if n := strings.IndexByte(name, '.'); n >= 0 {
+		db, name = name[0:n], name[n+1:]
+	}
+
+	s.m.Lock()
+	t, ok := s.tables[tableKey(db, name)]
+	s.m.Unlock()
+
+	if !ok {
+		return nil, mysql.NewDefaultError(mysql.ER_NO_SUCH_TABLE, db, name)
+	}
+	return t, nil
+}
+
+// fixture returns the response of the query by the fixtures, ok is false if the query has no fixture.
+func (s *Server) fixture(c *server.Conn, query string) (r *mysql.Result, err error, ok bool) {
+	var names []string
+	var values [][]interface{}
+
+	if m := showVariablesRegexp.FindStringSubmatch(query); m != nil {
+		names = []string{"Variable_name", "Value"}
+		values = s.showVariables(m[3])
+	} else if m := selectVariableRegexp.FindStringSubmatch(query); m != nil {
+		s.m.Lock()
+		value, found := s.variables[strings.ToLower(m[3])]
+		s.m.Unlock()
+
+		if !found {
+			return nil, mysql.NewDefaultError(mysql.ER_UNKNOWN_SYSTEM_VARIABLE, m[3]), true
+		}
+
+		names = []string{m[1]}
+		values = [][]interface{}{{value}}
+	} else if showMasterRegexp.MatchString(query) {
+		names = []string{"File", "Position", "Binlog_Do_DB", "Binlog_Ignore_DB", "Executed_Gtid_Set"}
+		if pos, gset := s.masterStatus(); len(pos.Name) > 0 {
+			values = [][]interface{}{{pos.Name, pos.Pos, "", "", gset}}
+		}
+	} else if showBinaryLogsRegexp.MatchString(query) {
+		names = []string{"Log_name", "File_size"}
+
+		s.m.Lock()
+		for _, f := range s.binlogs {
+			values = append(values, []interface{}{f.name, uint64(len(f.data))})
+		}
+		s.m.Unlock()
+	} else if m := describeRegexp.FindStringSubmatch(query); m != nil {
+		t, err := s.table(m[2], c.GetDatabase())
+		if err != nil {
+			return nil, err, true
+		}
+		names, values = t.columns(false)
+	} else if m := showColumnsRegexp.FindStringSubmatch(query); m != nil {
+		db := m[7]
+		if len(db) == 0 {
+			db = c.GetDatabase()
+		}
+
+		t, err := s.table(m[4], db)
+		if err != nil {
+			return nil, err, true
+		}
+		names, values = t.columns(len(m[1]) > 0)
+	} else if m := showIndexRegexp.FindStringSubmatch(query); m != nil {
+		db := m[6]
+		if len(db) == 0 {
+			db = c.GetDatabase()
+		}
+
+		t, err := s.table(m[3], db)
+		if err != nil {
+			return nil, err, true
+		}
+		names, values = t.indexes()
+	} else if setRegexp.MatchString(query) {
+		// the variables the clients set, like @master_binlog_checksum, are ignored
+		return &mysql.Result{}, nil, true
+	} else {
+		return nil, nil, false
+	}
+
+	r, err = buildResult(names, values)
+	return r, err, true
+}
+
+func (s *Server) showVariables(pattern string) [][]interface{} {
+	var re *regexp.Regexp
+	if len(pattern) > 0 {
+		re = likeRegexp(pattern)
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	names := make([]string, 0, len(s.variables))
+	for name := range s.variables {
+		if re == nil || re.MatchString(name) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	values := make([][]interface{}, 0, len(names))
+	for _, name := range names {
+		values = append(values, []interface{}{name, s.variables[name]})
+	}
+	return values
+}
+
+// likeRegexp converts the LIKE pattern into a case insensitive regexp.
+func likeRegexp(pattern string) *regexp.Regexp {
+	var b bytes.Buffer
+	b.WriteString("(?is)^")
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '%':
+			b.WriteString(".*")
+		case '_':
+			b.WriteString(".")
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		default:
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+func (s *Server) masterStatus() (mysql.Position, string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.masterPos != nil {
+		return *s.masterPos, s.executedGTIDSet
+	}
+
+	if len(s.binlogs) == 0 {
+		return mysql.Position{}, s.executedGTIDSet
+	}
+
+	f := s.binlogs[len(s.binlogs)-1]
+	return mysql.Position{Name: f.name, Pos: uint32(len(f.data))}, s.executedGTIDSet
+}
+
+// columns returns the result of DESCRIBE, or SHOW FULL COLUMNS if full.
+func (t *Table) columns(full bool) ([]string, [][]interface{}) {
+	var names []string
+	if full {
+		names = []string{"Field", "Type", "Collation", "Null", "Key", "Default", "Extra", "Privileges", "Comment"}
+	} else {
+		names = []string{"Field", "Type", "Null", "Key", "Default", "Extra"}
+	}
+
+	values := make([][]interface{}, 0, len(t.Columns))
+	for _, col := range t.Columns {
+		null := "NO"
+		if col.Nullable {
+			null = "YES"
+		}
+
+		if !full {
+			values = append(values, []interface{}{col.Name, col.Type, null, col.Key, col.Default, col.Extra})
+			continue
+		}
+
+		var collation interface{}
+		if len(col.Collation) > 0 {
+			collation = col.Collation
+		}
+		values = append(values, []interface{}{col.Name, col.Type, collation, null, col.Key, col.Default, col.Extra,
+			"select,insert,update,references", col.Comment})
+	}
+
+	return names, values
+}
+
+// indexes returns the result of SHOW INDEX, the primary key is the first like MySQL.
+func (t *Table) indexes() ([]string, [][]interface{}) {
+	names := []string{"Table", "Non_unique", "Key_name", "Seq_in_index", "Column_name", "Collation", "Cardinality",
+		"Sub_part", "Packed", "Null", "Index_type", "Comment", "Index_comment"}
+
+	indexes := make([]Index, 0, len(t.Indexes))
+	for _, index := range t.Indexes {
+		if index.Name == "PRIMARY" {
+			indexes = append([]Index{index}, indexes...)
+		} else {
+			indexes = append(indexes, index)
+		}
+	}
+
+	var values [][]interface{}
+	for _, index := range indexes {
+		nonUnique := 1
+		if index.Unique || index.Name == "PRIMARY" {
+			nonUnique = 0
+		}
+
+		for i, name := range index.Columns {
+			var cardinality uint64
+			if i < len(index.Cardinality) {
+				cardinality = index.Cardinality[i]
+			}
+
+			null := ""
+			for _, col := range t.Columns {
+				if strings.EqualFold(col.Name, name) && col.Nullable {
+					null = "YES"
+				}
+			}
+
+			values = append(values, []interface{}{t.Name, nonUnique, index.Name, i + 1, name, "A", cardinality,
+				nil, nil, null, "BTREE", "", ""})
+		}
+	}
+
+	return names, values
+}
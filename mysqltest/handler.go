@@ -0,0 +1,83 @@
+package mysqltest
+
+import (
+	"fmt"
+
+	"github.com/gdey/go-mysql/mysql"
+	"github.com/gdey/go-mysql/server"
+	"github.com/juju/errors"
+)
+
+// handler handles the commands of a connection to the fake server.
+type handler struct {
+	server.EmptyHandler
+
+	s *Server
+	c *server.Conn
+}
+
+func (h *handler) HandleQuery(query string) (*mysql.Result, error) {
+	query = normalizeQuery(query)
+
+	if r, err, ok := h.s.scripted(query); ok {
+		return r, err
+	}
+
+	if r, err, ok := h.s.fixture(h.c, query); ok {
+		return r, err
+	}
+
+	return nil, mysql.NewError(mysql.ER_UNKNOWN_ERROR, fmt.Sprintf("query %s is not scripted in the fake server", query))
+}
+
+// HandleStmtPrepare prepares any query, the placeholders out of the quotes are the params,
+// the statement is answered like the query without the args.
+func (h *handler) HandleStmtPrepare(query string) (int, int, interface{}, error) {
+	return countParams(query), 0, nil, nil
+}
+
+func (h *handler) HandleStmtExecute(context interface{}, query string, args []interface{}) (*mysql.Result, error) {
+	return h.HandleQuery(query)
+}
+
+func (h *handler) HandleRegisterSlave(s *server.Slave) error {
+	h.s.m.Lock()
+	h.s.slaves = append(h.s.slaves, *s)
+	h.s.m.Unlock()
+
+	return nil
+}
+
+func (h *handler) HandleBinlogDump(r *server.BinlogDumpRequest) (server.BinlogEventSource, error) {
+	if r.GTIDSet != nil {
+		return nil, mysql.NewError(mysql.ER_MASTER_FATAL_ERROR_READING_BINLOG, "GTID binlog dump is not supported by the fake server")
+	}
+
+	b, err := newBinlogSource(h.s, r)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return b, nil
+}
+
+// countParams returns the number of the placeholders out of the strings and the quoted identifiers.
+func countParams(query string) int {
+	n := 0
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		ch := query[i]
+		switch {
+		case quote != 0:
+			if ch == '\\' && quote != '`' {
+				i++
+			} else if ch == quote {
+				quote = 0
+			}
+		case ch == '\'' || ch == '"' || ch == '`':
+			quote = ch
+		case ch == '?':
+			n++
+		}
+	}
+	return n
+}
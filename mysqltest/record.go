@@ -0,0 +1,210 @@
+package mysqltest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/gdey/go-mysql/mysql"
+	"github.com/juju/errors"
+)
+
+// the recorded response of a query in the recording file
+type recordedResponse struct {
+	Query string `json:"query"`
+
+	Fields []recordedField `json:"fields,omitempty"`
+	// the values in text, nil for NULL
+	Rows [][]*string `json:"rows,omitempty"`
+
+	AffectedRows uint64 `json:"affected_rows,omitempty"`
+	InsertId     uint64 `json:"insert_id,omitempty"`
+
+	Error *recordedError `json:"error,omitempty"`
+}
+
+type recordedError struct {
+	Code    uint16 `json:"code"`
+	State   string `json:"state"`
+	Message string `json:"message"`
+}
+
+type recordedField struct {
+	Name    string `json:"name"`
+	Type    uint8  `json:"type"`
+	Flag    uint16 `json:"flag,omitempty"`
+	Charset uint16 `json:"charset,omitempty"`
+}
+
+// Record executes the queries with the executer, like a client.Conn to a real MySQL,
+// and scripts the server to answer them with the results or the MySQL errors.
+// The other errors, like a broken connection, are returned.
+func (s *Server) Record(e mysql.Executer, queries ...string) error {
+	for _, query := range queries {
+		r, err := e.Execute(query)
+		if err != nil {
+			if _, ok := errors.Cause(err).(*mysql.MyError); !ok {
+				return errors.Trace(err)
+			}
+			s.AddQueryError(query, errors.Cause(err))
+			continue
+		}
+
+		// keep the recorded result like the one loaded from a recording
+		rsp, err := newRecordedResponse(query, r, nil)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		if r, err = rsp.result(); err != nil {
+			return errors.Trace(err)
+		}
+		s.AddQuery(query, r)
+	}
+
+	return nil
+}
+
+// SaveRecording saves the responses of the exact queries into the file in JSON,
+// so the tests can load them instead of connecting to MySQL. The responses of HandleQueryFunc are not saved.
+func (s *Server) SaveRecording(file string) error {
+	s.m.Lock()
+	rsps := make([]*recordedResponse, 0, len(s.order))
+	for _, query := range s.order {
+		rsp := s.responses[query]
+
+		if rsp.err != nil {
+			if _, ok := rsp.err.(*mysql.MyError); !ok {
+				s.m.Unlock()
+				return errors.Errorf("query %s has an error which is not a MySQL error: %v", query, rsp.err)
+			}
+		}
+
+		r, err := newRecordedResponse(query, rsp.r, rsp.err)
+		if err != nil {
+			s.m.Unlock()
+			return errors.Trace(err)
+		}
+		rsps = append(rsps, r)
+	}
+	s.m.Unlock()
+
+	data, err := json.MarshalIndent(rsps, "", "  ")
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	return errors.Trace(ioutil.WriteFile(file, data, 0644))
+}
+
+// LoadRecording scripts the server with the responses in the file saved by SaveRecording.
+func (s *Server) LoadRecording(file string) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	var rsps []*recordedResponse
+	if err = json.Unmarshal(data, &rsps); err != nil {
+		return errors.Annotatef(err, "recording %s", file)
+	}
+
+	for _, rsp := range rsps {
+		if e := rsp.Error; e != nil {
+			s.AddQueryError(rsp.Query, &mysql.MyError{Code: e.Code, State: e.State, Message: e.Message})
+			continue
+		}
+
+		r, err := rsp.result()
+		if err != nil {
+			return errors.Annotatef(err, "recording %s query %s", file, rsp.Query)
+		}
+		s.AddQuery(rsp.Query, r)
+	}
+
+	return nil
+}
+
+func newRecordedResponse(query string, r *mysql.Result, err error) (*recordedResponse, error) {
+	rsp := &recordedResponse{Query: query}
+
+	if err != nil {
+		e := err.(*mysql.MyError)
+		rsp.Error = &recordedError{Code: e.Code, State: e.State, Message: e.Message}
+		return rsp, nil
+	}
+
+	if r == nil {
+		return rsp, nil
+	}
+
+	rsp.AffectedRows = r.AffectedRows
+	rsp.InsertId = r.InsertId
+
+	if r.Resultset == nil {
+		return rsp, nil
+	}
+
+	for _, f := range r.Fields {
+		rsp.Fields = append(rsp.Fields, recordedField{Name: string(f.Name), Type: f.Type, Flag: f.Flag, Charset: f.Charset})
+	}
+
+	// an empty resultset is still a resultset
+	rsp.Rows = make([][]*string, 0, r.RowNumber())
+	for i := 0; i < r.RowNumber(); i++ {
+		row := make([]*string, len(r.Fields))
+		for j := range r.Fields {
+			if null, err := r.IsNull(i, j); err != nil {
+				return nil, errors.Trace(err)
+			} else if null {
+				continue
+			}
+
+			v, err := r.GetString(i, j)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			row[j] = &v
+		}
+		rsp.Rows = append(rsp.Rows, row)
+	}
+
+	return rsp, nil
+}
+
+// result builds the result, the values are parsed by the field types like the client does.
+func (rsp *recordedResponse) result() (*mysql.Result, error) {
+	r := &mysql.Result{AffectedRows: rsp.AffectedRows, InsertId: rsp.InsertId}
+	if rsp.Rows == nil && len(rsp.Fields) == 0 {
+		return r, nil
+	}
+
+	rs := new(mysql.Resultset)
+	rs.FieldNames = make(map[string]int, len(rsp.Fields))
+	for i, f := range rsp.Fields {
+		rs.Fields = append(rs.Fields, &mysql.Field{Name: []byte(f.Name), Type: f.Type, Flag: f.Flag, Charset: f.Charset})
+		rs.FieldNames[f.Name] = i
+	}
+
+	for i, row := range rsp.Rows {
+		values := make([]interface{}, len(row))
+		for j, v := range row {
+			if v != nil {
+				values[j] = *v
+			}
+		}
+
+		data, err := mysql.BuildRowData(rs.Fields, values, false)
+		if err != nil {
+			return nil, errors.Annotatef(err, "row %d", i)
+		}
+
+		if values, err = data.ParseText(rs.Fields); err != nil {
+			return nil, errors.Annotatef(err, "row %d", i)
+		}
+
+		rs.RowDatas = append(rs.RowDatas, data)
+		rs.Values = append(rs.Values, values)
+	}
+
+	r.Resultset = rs
+	return r, nil
+}
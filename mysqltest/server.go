@@ -0,0 +1,266 @@
+// Package mysqltest provides a fake MySQL server for tests, so the clients like canal and BinlogSyncer
+// can be tested without a real MySQL.
+//
+// The server answers the queries with the scripted or recorded responses, and the fixtures for the queries
+// the replication clients use, like SHOW VARIABLES, SHOW MASTER STATUS and SHOW FULL COLUMNS.
+// It also acts as a binlog master, and serves the binlog dumps from the prepared binlog files.
+package mysqltest
+
+import (
+	"context"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gdey/go-mysql/mysql"
+	"github.com/gdey/go-mysql/server"
+	"github.com/gdey/go/log"
+	"github.com/juju/errors"
+)
+
+// the time Close waits for the commands being handled
+const closeTimeout = 5 * time.Second
+
+// QueryFunc returns the response for a query matching its pattern, m is the submatches of the pattern.
+type QueryFunc func(m []string) (*mysql.Result, error)
+
+type response struct {
+	r   *mysql.Result
+	err error
+}
+
+type queryFunc struct {
+	re *regexp.Regexp
+	f  QueryFunc
+}
+
+// Server is a fake MySQL server listening on a local port, it accepts the user root without password by default.
+// The queries are answered with the scripted responses first, then the fixtures,
+// the others fail with ER_UNKNOWN_ERROR.
+type Server struct {
+	l        net.Listener
+	srv      *server.Server
+	provider *server.InMemoryProvider
+
+	m sync.Mutex
+
+	// the scripted responses by the normalized query, and the queries in the order they are added
+	responses map[string]*response
+	order     []string
+	funcs     []queryFunc
+
+	variables       map[string]string
+	masterPos       *mysql.Position
+	executedGTIDSet string
+	tables          map[string]*Table
+
+	binlogs []*binlogFile
+	// closed and replaced when the binlogs are changed, to wake up the binlog dumps
+	binlogNotify chan struct{}
+
+	queries []string
+	slaves  []server.Slave
+
+	closed bool
+
+	wg sync.WaitGroup
+}
+
+// NewServer starts a fake server on a random port of 127.0.0.1.
+func NewServer() (*Server, error) {
+	s := new(Server)
+
+	s.responses = make(map[string]*response)
+	s.variables = defaultVariables()
+	s.tables = make(map[string]*Table)
+	s.binlogNotify = make(chan struct{})
+
+	var err error
+	if s.l, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	s.provider = server.NewInMemoryProvider()
+	s.provider.AddUser("root", "")
+
+	s.srv = server.NewServer(&server.ServerConfig{ConnConfig: server.ConnConfig{Provider: s.provider}},
+		func(c *server.Conn) server.Handler {
+			return &handler{s: s, c: c}
+		})
+
+	s.wg.Add(1)
+	go s.run()
+
+	return s, nil
+}
+
+func (s *Server) run() {
+	defer s.wg.Done()
+
+	if err := s.srv.Serve(s.l); err != server.ErrServerClosed {
+		log.Errorf("fake server serve err: %v", err)
+	}
+}
+
+// Addr returns the host:port address the server listens on.
+func (s *Server) Addr() string {
+	return s.l.Addr().String()
+}
+
+// AddUser adds the user or updates its password.
+func (s *Server) AddUser(user string, password string) {
+	s.provider.AddUser(user, password)
+}
+
+// Close closes the server and its connections, the binlog dumps are finished.
+func (s *Server) Close() {
+	s.m.Lock()
+	if s.closed {
+		s.m.Unlock()
+		return
+	}
+	s.closed = true
+	close(s.binlogNotify)
+	s.m.Unlock()
+
+	// the binlog dumps end after binlogNotify is closed, and the other commands are answered at once,
+	// so the connections are closed after them, unless a client doesn't read
+	ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+	defer cancel()
+	if err := s.srv.Shutdown(ctx); err != nil {
+		log.Warnf("fake server shutdown err: %v", err)
+	}
+	s.l.Close()
+
+	s.wg.Wait()
+}
+
+// normalizeQuery trims the query and its trailing semicolons, and collapses the spaces.
+func normalizeQuery(query string) string {
+	return strings.Join(strings.Fields(strings.TrimRight(strings.TrimSpace(query), "; \t\r\n")), " ")
+}
+
+func (s *Server) addResponse(query string, rsp *response) {
+	query = normalizeQuery(query)
+
+	s.m.Lock()
+	if _, ok := s.responses[query]; !ok {
+		s.order = append(s.order, query)
+	}
+	s.responses[query] = rsp
+	s.m.Unlock()
+}
+
+// AddQuery scripts the result of the query, the query is matched case sensitively after the spaces are normalized.
+func (s *Server) AddQuery(query string, r *mysql.Result) {
+	s.addResponse(query, &response{r: r})
+}
+
+// AddQueryError scripts the query to fail, the error is sent as is if it's a mysql.MyError.
+func (s *Server) AddQueryError(query string, err error) {
+	s.addResponse(query, &response{err: err})
+}
+
+// AddResultset scripts the resultset of the query, like mysql.BuildSimpleResultset,
+// but a column is a string if its type can't be known from the first row, like a NULL.
+func (s *Server) AddResultset(query string, names []string, values [][]interface{}) error {
+	r, err := buildResult(names, values)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	s.AddQuery(query, r)
+	return nil
+}
+
+// HandleQueryFunc scripts the queries matching the regexp pattern with f, it's case insensitive.
+// The patterns are tried in the order they are added, after the exact queries.
+func (s *Server) HandleQueryFunc(pattern string, f QueryFunc) error {
+	re, err := regexp.Compile("(?i)" + pattern)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	s.m.Lock()
+	s.funcs = append(s.funcs, queryFunc{re: re, f: f})
+	s.m.Unlock()
+
+	return nil
+}
+
+// Queries returns the queries and the statements to execute the server has received.
+func (s *Server) Queries() []string {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return append([]string(nil), s.queries...)
+}
+
+// Slaves returns the slaves registered to the server.
+func (s *Server) Slaves() []server.Slave {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return append([]server.Slave(nil), s.slaves...)
+}
+
+// scripted returns the scripted response of the normalized query, ok is false if not scripted.
+func (s *Server) scripted(query string) (r *mysql.Result, err error, ok bool) {
+	s.m.Lock()
+	s.queries = append(s.queries, query)
+
+	if rsp, found := s.responses[query]; found {
+		s.m.Unlock()
+		return rsp.r, rsp.err, true
+	}
+
+	funcs := s.funcs
+	s.m.Unlock()
+
+	// f is called without the lock, so it can script the server
+	for _, qf := range funcs {
+		if m := qf.re.FindStringSubmatch(query); m != nil {
+			r, err = qf.f(m)
+			return r, err, true
+		}
+	}
+
+	return nil, nil, false
+}
+
+// buildResult builds a text resultset, the types of the columns are from the first row,
+// and string if unknown.
+func buildResult(names []string, values [][]interface{}) (*mysql.Result, error) {
+	rs, err := mysql.BuildSimpleResultset(names, nil, false)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if len(values) > 0 && len(values[0]) == len(names) {
+		for j, value := range values[0] {
+			if value == nil {
+				continue
+			}
+
+			// the type of the column, like the one BuildSimpleResultset uses
+			one, err := mysql.BuildSimpleResultset(names[j:j+1], [][]interface{}{{value}}, false)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			rs.Fields[j] = one.Fields[0]
+		}
+	}
+
+	for i, row := range values {
+		data, err := mysql.BuildRowData(rs.Fields, row, false)
+		if err != nil {
+			return nil, errors.Annotatef(err, "row %d", i)
+		}
+		rs.RowDatas = append(rs.RowDatas, data)
+	}
+	rs.Values = values
+
+	return &mysql.Result{Resultset: rs}, nil
+}
@@ -0,0 +1,257 @@
+package mysqltest
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/gdey/go-mysql/client"
+	"github.com/gdey/go-mysql/mysql"
+	"github.com/gdey/go-mysql/schema"
+	"github.com/juju/errors"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type serverTestSuite struct {
+	s *Server
+	c *client.Conn
+}
+
+var _ = Suite(&serverTestSuite{})
+
+func (t *serverTestSuite) SetUpTest(c *C) {
+	var err error
+	t.s, err = NewServer()
+	c.Assert(err, IsNil)
+
+	t.c, err = client.Connect(t.s.Addr(), "root", "", "")
+	c.Assert(err, IsNil)
+}
+
+func (t *serverTestSuite) TearDownTest(c *C) {
+	if t.c != nil {
+		t.c.Close()
+	}
+	if t.s != nil {
+		t.s.Close()
+	}
+}
+
+func errorCode(err error) uint16 {
+	if e, ok := errors.Cause(err).(*mysql.MyError); ok {
+		return e.Code
+	}
+	return 0
+}
+
+func (t *serverTestSuite) TestScripted(c *C) {
+	err := t.s.AddResultset("SELECT id, name FROM test.t", []string{"id", "name"},
+		[][]interface{}{{1, nil}, {2, "b"}})
+	c.Assert(err, IsNil)
+
+	t.s.AddQuery("DELETE FROM test.t", &mysql.Result{AffectedRows: 2})
+	t.s.AddQueryError("DROP TABLE test.t", mysql.NewDefaultError(mysql.ER_TABLEACCESS_DENIED_ERROR, "DROP", "root", "localhost", "t"))
+
+	err = t.s.HandleQueryFunc(`^SELECT (\d+)$`, func(m []string) (*mysql.Result, error) {
+		return buildResult([]string{m[1]}, [][]interface{}{{m[1]}})
+	})
+	c.Assert(err, IsNil)
+
+	// the spaces and the semicolon are normalized
+	r, err := t.c.Execute("SELECT  id, name\n FROM test.t;")
+	c.Assert(err, IsNil)
+	c.Assert(r.RowNumber(), Equals, 2)
+
+	id, _ := r.GetInt(0, 0)
+	c.Assert(id, Equals, int64(1))
+	null, _ := r.IsNull(0, 1)
+	c.Assert(null, Equals, true)
+	name, _ := r.GetString(1, 1)
+	c.Assert(name, Equals, "b")
+
+	r, err = t.c.Execute("DELETE FROM test.t")
+	c.Assert(err, IsNil)
+	c.Assert(r.AffectedRows, Equals, uint64(2))
+
+	_, err = t.c.Execute("DROP TABLE test.t")
+	c.Assert(errorCode(err), Equals, uint16(mysql.ER_TABLEACCESS_DENIED_ERROR))
+
+	r, err = t.c.Execute("select 42")
+	c.Assert(err, IsNil)
+	v, _ := r.GetString(0, 0)
+	c.Assert(v, Equals, "42")
+
+	// the statement is answered like the query
+	err = t.s.AddResultset("SELECT name FROM test.t WHERE id = ?", []string{"name"}, [][]interface{}{{"b"}})
+	c.Assert(err, IsNil)
+
+	r, err = t.c.Execute("SELECT name FROM test.t WHERE id = ?", 2)
+	c.Assert(err, IsNil)
+	c.Assert(r.RowNumber(), Equals, 1)
+
+	_, err = t.c.Execute("SELECT 'unknown'")
+	c.Assert(errorCode(err), Equals, uint16(mysql.ER_UNKNOWN_ERROR))
+
+	c.Assert(t.s.Queries(), DeepEquals, []string{"SELECT id, name FROM test.t", "DELETE FROM test.t", "DROP TABLE test.t",
+		"select 42", "SELECT name FROM test.t WHERE id = ?", "SELECT 'unknown'"})
+}
+
+func (t *serverTestSuite) TestVariables(c *C) {
+	t.s.SetVariable("binlog_format", "MIXED")
+
+	r, err := t.c.Execute(`SHOW GLOBAL VARIABLES LIKE "binlog_f%";`)
+	c.Assert(err, IsNil)
+	c.Assert(r.RowNumber(), Equals, 1)
+	v, _ := r.GetString(0, 1)
+	c.Assert(v, Equals, "MIXED")
+
+	r, err = t.c.Execute("SHOW VARIABLES LIKE 'BINLOG\\_%'")
+	c.Assert(err, IsNil)
+	c.Assert(r.RowNumber(), Equals, 3)
+	name, _ := r.GetString(0, 0)
+	c.Assert(name, Equals, "binlog_checksum")
+
+	r, err = t.c.Execute("SELECT @@global.server_id")
+	c.Assert(err, IsNil)
+	id, _ := r.GetInt(0, 0)
+	c.Assert(id, Equals, int64(1))
+
+	_, err = t.c.Execute("SELECT @@unknown_variable")
+	c.Assert(errorCode(err), Equals, uint16(mysql.ER_UNKNOWN_SYSTEM_VARIABLE))
+
+	_, err = t.c.Execute("SET @master_binlog_checksum='NONE'")
+	c.Assert(err, IsNil)
+
+	// no binlog yet
+	r, err = t.c.Execute("SHOW MASTER STATUS")
+	c.Assert(err, IsNil)
+	c.Assert(r.RowNumber(), Equals, 0)
+
+	t.s.SetMasterStatus(mysql.Position{Name: "mysql-bin.000003", Pos: 120}, "")
+
+	r, err = t.c.Execute("SHOW MASTER STATUS")
+	c.Assert(err, IsNil)
+	name, _ = r.GetString(0, 0)
+	pos, _ := r.GetInt(0, 1)
+	c.Assert(name, Equals, "mysql-bin.000003")
+	c.Assert(pos, Equals, int64(120))
+}
+
+func testTable() *Table {
+	return &Table{
+		Schema: "test",
+		Name:   "t",
+		Columns: []Column{
+			{Name: "id", Type: "int(11)", Key: "PRI", Extra: "auto_increment"},
+			{Name: "name", Type: "varchar(100)", Collation: "utf8_general_ci", Nullable: true, Key: "MUL", Comment: "the name"},
+			{Name: "age", Type: "int(11)", Default: 0},
+		},
+		Indexes: []Index{
+			{Name: "name", Columns: []string{"name"}},
+			{Name: "PRIMARY", Columns: []string{"id"}, Cardinality: []uint64{10}},
+		},
+	}
+}
+
+func (t *serverTestSuite) TestTable(c *C) {
+	t.s.AddTable(testTable())
+
+	ta, err := schema.NewTable(t.c, "test", "t")
+	c.Assert(err, IsNil)
+	c.Assert(ta.Columns, HasLen, 3)
+	c.Assert(ta.Columns[0].Type, Equals, schema.TYPE_NUMBER)
+	c.Assert(ta.Columns[0].IsAuto, Equals, true)
+	c.Assert(ta.Columns[1].Type, Equals, schema.TYPE_STRING)
+	c.Assert(ta.Indexes, HasLen, 2)
+	c.Assert(ta.Indexes[0].Name, Equals, "PRIMARY")
+	c.Assert(ta.Indexes[0].Cardinality, DeepEquals, []uint64{10})
+	c.Assert(ta.PKColumns, DeepEquals, []int{0})
+
+	err = t.c.UseDB("test")
+	c.Assert(err, IsNil)
+
+	r, err := t.c.Execute("SHOW FULL COLUMNS FROM `t`")
+	c.Assert(err, IsNil)
+	c.Assert(r.RowNumber(), Equals, 3)
+
+	null, _ := r.IsNullByName(0, "Collation")
+	c.Assert(null, Equals, true)
+	collation, _ := r.GetStringByName(1, "Collation")
+	c.Assert(collation, Equals, "utf8_general_ci")
+	comment, _ := r.GetStringByName(1, "Comment")
+	c.Assert(comment, Equals, "the name")
+	null, _ = r.IsNullByName(1, "Default")
+	c.Assert(null, Equals, true)
+	def, _ := r.GetStringByName(2, "Default")
+	c.Assert(def, Equals, "0")
+
+	r, err = t.c.Execute("SHOW INDEX FROM t FROM test")
+	c.Assert(err, IsNil)
+	c.Assert(r.RowNumber(), Equals, 2)
+	nonUnique, _ := r.GetIntByName(1, "Non_unique")
+	c.Assert(nonUnique, Equals, int64(1))
+
+	_, err = t.c.Execute("DESCRIBE test.unknown")
+	c.Assert(errorCode(err), Equals, uint16(mysql.ER_NO_SUCH_TABLE))
+}
+
+func (t *serverTestSuite) TestRecording(c *C) {
+	// record from another fake server as if it's MySQL
+	t.s.AddTable(testTable())
+
+	// the queries schema.NewTable executes, the recorded queries are matched case sensitively
+	queries := []string{"describe test.t", "show index from test.t", "DESCRIBE test.unknown", "SET autocommit = 1"}
+
+	s, err := NewServer()
+	c.Assert(err, IsNil)
+	defer s.Close()
+
+	err = s.Record(t.c, queries...)
+	c.Assert(err, IsNil)
+
+	dir, err := ioutil.TempDir("", "mysqltest")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	file := path.Join(dir, "recording.json")
+	err = s.SaveRecording(file)
+	c.Assert(err, IsNil)
+
+	replay, err := NewServer()
+	c.Assert(err, IsNil)
+	defer replay.Close()
+
+	err = replay.LoadRecording(file)
+	c.Assert(err, IsNil)
+
+	conn, err := client.Connect(replay.Addr(), "root", "", "")
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	for _, query := range queries {
+		expected, expectedErr := t.c.Execute(query)
+
+		r, err := conn.Execute(query)
+		if expectedErr != nil {
+			c.Assert(errors.Cause(err), DeepEquals, errors.Cause(expectedErr))
+			continue
+		}
+
+		c.Assert(err, IsNil)
+		if expected.Resultset == nil {
+			c.Assert(r.Resultset, IsNil)
+			continue
+		}
+
+		c.Assert(r.Values, DeepEquals, expected.Values, Commentf("query %s", query))
+	}
+
+	ta, err := schema.NewTable(conn, "test", "t")
+	c.Assert(err, IsNil)
+	c.Assert(ta.PKColumns, DeepEquals, []int{0})
+}
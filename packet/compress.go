@@ -0,0 +1,260 @@
+package packet
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+
+	"github.com/gdey/go-mysql/mysql"
+	"github.com/juju/errors"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// the payload shorter than this is sent uncompressed, like MIN_COMPRESS_LENGTH in MySQL
+	minCompressLength = 50
+
+	// the packets are buffered to be compressed together until the buffer is this long, or flushed,
+	// like net_buffer_length in MySQL
+	compressBufferSize = 16 * 1024
+
+	// the default level of zstd in MySQL
+	DefaultZstdLevel = 3
+)
+
+// compressor compresses and decompresses the payload of the compressed packets,
+// every compressed packet is compressed alone.
+type compressor struct {
+	algorithm int
+
+	zw *zlib.Writer
+	ze *zstd.Encoder
+	zd *zstd.Decoder
+
+	buf bytes.Buffer
+}
+
+func newCompressor(algorithm int, level int) (*compressor, error) {
+	p := &compressor{algorithm: algorithm}
+
+	var err error
+	switch algorithm {
+	case mysql.MYSQL_COMPRESS_ZLIB:
+		p.zw = zlib.NewWriter(&p.buf)
+	case mysql.MYSQL_COMPRESS_ZSTD:
+		if level <= 0 {
+			level = DefaultZstdLevel
+		}
+
+		// no goroutine is started with concurrency 1, so we don't need to close them
+		if p.ze, err = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)),
+			zstd.WithEncoderConcurrency(1)); err != nil {
+			return nil, errors.Trace(err)
+		}
+
+		if p.zd, err = zstd.NewReader(nil, zstd.WithDecoderConcurrency(1)); err != nil {
+			return nil, errors.Trace(err)
+		}
+	default:
+		return nil, errors.Errorf("invalid compression algorithm %d", algorithm)
+	}
+
+	return p, nil
+}
+
+func (p *compressor) compress(data []byte) ([]byte, error) {
+	if p.algorithm == mysql.MYSQL_COMPRESS_ZSTD {
+		return p.ze.EncodeAll(data, nil), nil
+	}
+
+	p.buf.Reset()
+	p.zw.Reset(&p.buf)
+
+	if _, err := p.zw.Write(data); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if err := p.zw.Close(); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return append([]byte(nil), p.buf.Bytes()...), nil
+}
+
+func (p *compressor) decompress(data []byte, length int) ([]byte, error) {
+	if p.algorithm == mysql.MYSQL_COMPRESS_ZSTD {
+		d, err := p.zd.DecodeAll(data, make([]byte, 0, length))
+		return d, errors.Trace(err)
+	}
+
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer zr.Close()
+
+	d := make([]byte, length)
+	if _, err = io.ReadFull(zr, d); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return d, nil
+}
+
+// compressedReader reads the compressed packets, and returns the packets in them.
+type compressedReader struct {
+	c *Conn
+
+	buf bytes.Buffer
+}
+
+func (r *compressedReader) Read(b []byte) (int, error) {
+	if r.buf.Len() == 0 {
+		if err := r.readPacket(); err != nil {
+			return 0, err
+		}
+	}
+
+	return r.buf.Read(b)
+}
+
+func (r *compressedReader) readPacket() error {
+	c := r.c
+
+	//compressed length 3, compressed sequence 1, uncompressed length 3
+	header := []byte{0, 0, 0, 0, 0, 0, 0}
+	if _, err := io.ReadFull(c.br, header); err != nil {
+		return mysql.ErrBadConn
+	}
+
+	length := int(uint32(header[0]) | uint32(header[1])<<8 | uint32(header[2])<<16)
+
+	sequence := header[3]
+	if sequence != c.CompressedSequence {
+		return errors.Errorf("invalid compressed sequence %d != %d", sequence, c.CompressedSequence)
+	}
+
+	c.CompressedSequence++
+
+	uncompressedLength := int(uint32(header[4]) | uint32(header[5])<<8 | uint32(header[6])<<16)
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(c.br, data); err != nil {
+		return mysql.ErrBadConn
+	}
+
+	if uncompressedLength == 0 {
+		// not compressed
+		r.buf.Write(data)
+		return nil
+	}
+
+	data, err := c.compressor.decompress(data, uncompressedLength)
+	if err != nil {
+		return errors.Trace(err)
+	} else if len(data) != uncompressedLength {
+		return errors.Errorf("invalid uncompressed length %d != %d", len(data), uncompressedLength)
+	}
+
+	r.buf.Write(data)
+	return nil
+}
+
+// EnableCompression turns on the compressed protocol with the algorithm negotiated in the handshake,
+// it must be called after the handshake, when no data is pending.
+// The level is only used by zstd, 0 means DefaultZstdLevel.
+func (c *Conn) EnableCompression(algorithm int, level int) error {
+	if algorithm == mysql.MYSQL_COMPRESS_NONE {
+		return nil
+	}
+
+	p, err := newCompressor(algorithm, level)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	c.compressor = p
+	c.cr = &compressedReader{c: c}
+	c.CompressedSequence = 0
+	c.writing = false
+	c.wbuf = nil
+
+	return nil
+}
+
+// Compression returns the compression algorithm of the connection.
+func (c *Conn) Compression() int {
+	if c.compressor == nil {
+		return mysql.MYSQL_COMPRESS_NONE
+	}
+	return c.compressor.algorithm
+}
+
+// bufferCompressed buffers the packets in data, they're written as compressed packets when the buffer is full.
+func (c *Conn) bufferCompressed(data []byte) (int, error) {
+	c.wbuf = append(c.wbuf, data...)
+	if len(c.wbuf) >= compressBufferSize {
+		if err := c.Flush(); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+// Flush writes the packets buffered by the compressed protocol, they're also flushed before reading
+// and by ResetSequence. It does nothing if the connection is not compressed.
+func (c *Conn) Flush() error {
+	if len(c.wbuf) == 0 {
+		return nil
+	}
+
+	_, err := c.writeCompressed(c.wbuf)
+	c.wbuf = c.wbuf[:0]
+	return err
+}
+
+// writeCompressed writes the packets in data as compressed packets, no more than MaxPayloadLen in each one.
+func (c *Conn) writeCompressed(data []byte) (int, error) {
+	n := 0
+	for len(data) > 0 {
+		chunk := data
+		if len(chunk) > mysql.MaxPayloadLen {
+			chunk = chunk[:mysql.MaxPayloadLen]
+		}
+		data = data[len(chunk):]
+
+		// the uncompressed length is 0 if the payload is not compressed
+		payload, uncompressedLength := chunk, 0
+
+		if len(chunk) >= minCompressLength {
+			compressed, err := c.compressor.compress(chunk)
+			if err != nil {
+				return n, errors.Trace(err)
+			}
+
+			// send it uncompressed if it doesn't get shorter
+			if len(compressed) < len(chunk) {
+				payload, uncompressedLength = compressed, len(chunk)
+			}
+		}
+
+		buf := make([]byte, 7, 7+len(payload))
+		buf[0] = byte(len(payload))
+		buf[1] = byte(len(payload) >> 8)
+		buf[2] = byte(len(payload) >> 16)
+		buf[3] = c.CompressedSequence
+		buf[4] = byte(uncompressedLength)
+		buf[5] = byte(uncompressedLength >> 8)
+		buf[6] = byte(uncompressedLength >> 16)
+		buf = append(buf, payload...)
+
+		if _, err := c.Conn.Write(buf); err != nil {
+			return n, err
+		}
+
+		c.CompressedSequence++
+		n += len(chunk)
+	}
+
+	return n, nil
+}
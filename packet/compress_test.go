@@ -0,0 +1,53 @@
+package packet
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/gdey/go-mysql/mysql"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+type compressTestSuite struct{}
+
+var _ = Suite(&compressTestSuite{})
+
+func (s *compressTestSuite) TestBuffer(c *C) {
+	for _, algorithm := range []int{mysql.MYSQL_COMPRESS_ZLIB, mysql.MYSQL_COMPRESS_ZSTD} {
+		a, b := net.Pipe()
+		w, r := NewConn(a), NewConn(b)
+		c.Assert(w.EnableCompression(algorithm, 0), IsNil)
+		c.Assert(r.EnableCompression(algorithm, 0), IsNil)
+
+		payloads := [][]byte{bytes.Repeat([]byte{'a'}, 100), []byte("b"), bytes.Repeat([]byte{'c'}, 3*compressBufferSize)}
+
+		errs := make(chan error, 1)
+		go func() {
+			for _, p := range payloads {
+				if err := w.WritePacket(append(make([]byte, 4), p...)); err != nil {
+					errs <- err
+					return
+				}
+			}
+			errs <- w.Flush()
+		}()
+
+		for _, p := range payloads {
+			data, err := r.ReadPacket()
+			c.Assert(err, IsNil)
+			c.Assert(data, DeepEquals, p)
+		}
+		c.Assert(<-errs, IsNil)
+
+		// the small packets are in the compressed packet of the large one
+		c.Assert(r.CompressedSequence, Equals, uint8(1))
+
+		w.Close()
+		r.Close()
+	}
+}
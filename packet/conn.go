@@ -3,8 +3,10 @@ package packet
 import (
 	"bufio"
 	"bytes"
+	"crypto/tls"
 	"io"
 	"net"
+	"time"
 
 	"github.com/gdey/go-mysql/mysql"
 	"github.com/juju/errors"
@@ -18,6 +20,16 @@ type Conn struct {
 	br *bufio.Reader
 
 	Sequence uint8
+
+	// the compressed protocol, see EnableCompression
+	CompressedSequence uint8
+	compressor         *compressor
+	cr                 *compressedReader
+	// the last packet was written, the sequence is synced with the compressed one
+	// when we turn to read or write, like MySQL does
+	writing bool
+	// the packets to be compressed, see Flush
+	wbuf []byte
 }
 
 func NewConn(conn net.Conn) *Conn {
@@ -29,11 +41,36 @@ func NewConn(conn net.Conn) *Conn {
 	return c
 }
 
+// UpgradeTLS wraps the connection with tlsConn, which is tls.Client or tls.Server, and does the TLS handshake.
+func (c *Conn) UpgradeTLS(tlsConn func(net.Conn, *tls.Config) *tls.Conn, config *tls.Config) error {
+	// the peer may have sent the TLS data which is in our read buffer now
+	tc := tlsConn(&bufferedConn{c.Conn, c.br}, config)
+	if err := tc.Handshake(); err != nil {
+		return errors.Trace(err)
+	}
+
+	c.br = bufio.NewReaderSize(tc, 4096)
+	c.Conn = tc
+
+	return nil
+}
+
+type bufferedConn struct {
+	net.Conn
+	br *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.br.Read(b)
+}
+
 func (c *Conn) ReadPacket() ([]byte, error) {
 	var buf bytes.Buffer
 
 	if err := c.ReadPacketTo(&buf); err != nil {
 		return nil, errors.Trace(err)
+	} else if buf.Len() == 0 {
+		return nil, errors.Errorf("invalid payload length 0")
 	} else {
 		return buf.Bytes(), nil
 	}
@@ -75,17 +112,54 @@ func (c *Conn) ReadPacket() ([]byte, error) {
 	// }
 }
 
+// WaitData waits the data from the peer in timeout without reading it, it returns whether there's data,
+// false if the timeout expires, or the error of the connection, like io.EOF if the peer closed it.
+// It's used to find the peer which is gone or quits when we only write to it, like the binlog dump.
+func (c *Conn) WaitData(timeout time.Duration) (bool, error) {
+	if err := c.Flush(); err != nil {
+		return false, mysql.ErrBadConn
+	}
+
+	if err := c.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return false, errors.Trace(err)
+	}
+	_, err := c.br.Peek(1)
+	c.SetReadDeadline(time.Time{})
+
+	if err == nil {
+		return true, nil
+	} else if e, ok := err.(net.Error); ok && e.Timeout() {
+		return false, nil
+	}
+	return false, errors.Trace(err)
+}
+
+// reader returns the reader of the packets, which are in the compressed packets if compressed.
+func (c *Conn) reader() io.Reader {
+	if c.cr != nil {
+		return c.cr
+	}
+	return c.br
+}
+
 func (c *Conn) ReadPacketTo(w io.Writer) error {
+	if c.compressor != nil && c.writing {
+		if err := c.Flush(); err != nil {
+			return mysql.ErrBadConn
+		}
+		c.writing = false
+		c.Sequence = c.CompressedSequence
+	}
+
 	header := []byte{0, 0, 0, 0}
 
-	if _, err := io.ReadFull(c.br, header); err != nil {
+	if _, err := io.ReadFull(c.reader(), header); err != nil {
 		return mysql.ErrBadConn
 	}
 
+	// the packet may be empty, like the end of LOAD DATA LOCAL INFILE,
+	// or after a packet of MaxPayloadLen
 	length := int(uint32(header[0]) | uint32(header[1])<<8 | uint32(header[2])<<16)
-	if length < 1 {
-		return errors.Errorf("invalid payload length %d", length)
-	}
 
 	sequence := uint8(header[3])
 
@@ -95,7 +169,7 @@ func (c *Conn) ReadPacketTo(w io.Writer) error {
 
 	c.Sequence++
 
-	if n, err := io.CopyN(w, c.br, int64(length)); err != nil {
+	if n, err := io.CopyN(w, c.reader(), int64(length)); err != nil {
 		return mysql.ErrBadConn
 	} else if n != int64(length) {
 		return mysql.ErrBadConn
@@ -115,6 +189,11 @@ func (c *Conn) ReadPacketTo(w io.Writer) error {
 // data already has 4 bytes header
 // will modify data inplace
 func (c *Conn) WritePacket(data []byte) error {
+	if c.compressor != nil && !c.writing {
+		c.writing = true
+		c.Sequence = c.CompressedSequence
+	}
+
 	length := len(data) - 4
 
 	for length >= mysql.MaxPayloadLen {
@@ -124,7 +203,7 @@ func (c *Conn) WritePacket(data []byte) error {
 
 		data[3] = c.Sequence
 
-		if n, err := c.Write(data[:4+mysql.MaxPayloadLen]); err != nil {
+		if n, err := c.write(data[:4+mysql.MaxPayloadLen]); err != nil {
 			return mysql.ErrBadConn
 		} else if n != (4 + mysql.MaxPayloadLen) {
 			return mysql.ErrBadConn
@@ -140,7 +219,7 @@ func (c *Conn) WritePacket(data []byte) error {
 	data[2] = byte(length >> 16)
 	data[3] = c.Sequence
 
-	if n, err := c.Write(data); err != nil {
+	if n, err := c.write(data); err != nil {
 		return mysql.ErrBadConn
 	} else if n != len(data) {
 		return mysql.ErrBadConn
@@ -150,8 +229,21 @@ func (c *Conn) WritePacket(data []byte) error {
 	}
 }
 
+func (c *Conn) write(data []byte) (int, error) {
+	if c.compressor != nil {
+		return c.bufferCompressed(data)
+	}
+	return c.Write(data)
+}
+
+// ResetSequence resets the sequences for the next command, the buffered packets are flushed first,
+// the error is returned by the next read or write.
 func (c *Conn) ResetSequence() {
+	c.Flush()
+
 	c.Sequence = 0
+	c.CompressedSequence = 0
+	c.writing = false
 }
 
 func (c *Conn) Close() error {
@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"context"
+	"sync"
+
+	"github.com/gdey/go-mysql/client"
+	"github.com/juju/errors"
+)
+
+// backend is a MySQL server the proxy forwards to, it has a connection pool without the default schema,
+// the schema of the session is used on the connection got from it.
+type backend struct {
+	cfg BackendConfig
+
+	// the options for the new connections, the multi statements are allowed for the clients allowing them
+	options []func(c *client.Conn)
+
+	maxIdle int
+	maxOpen int
+
+	replicas []*replica
+
+	m      sync.Mutex
+	p      *client.Pool
+	closed bool
+}
+
+func newBackend(cfg BackendConfig, maxIdle int, maxOpen int) *backend {
+	b := new(backend)
+
+	b.cfg = cfg
+	if len(b.cfg.Name) == 0 {
+		b.cfg.Name = cfg.Addr
+	}
+
+	b.maxIdle = maxIdle
+	b.maxOpen = maxOpen
+
+	for _, addr := range cfg.Replicas {
+		b.replicas = append(b.replicas, newReplica(b, addr))
+	}
+
+	return b
+}
+
+func (b *backend) name() string {
+	return b.cfg.Name
+}
+
+func (b *backend) setOptions(options []func(c *client.Conn)) {
+	b.options = options
+	for _, r := range b.replicas {
+		r.options = options
+	}
+}
+
+// pool returns the pool of the connections, it's created when it's used first.
+func (b *backend) pool() (*client.Pool, error) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	if b.closed {
+		return nil, client.ErrPoolClosed
+	}
+
+	if b.p == nil {
+		b.p = client.NewPool(client.PoolConfig{
+			Addr:     b.cfg.Addr,
+			User:     b.cfg.User,
+			Password: b.cfg.Password,
+			Options:  append([]func(c *client.Conn){allowMultiStatements}, b.options...),
+			MaxIdle:  b.maxIdle,
+			MaxOpen:  b.maxOpen,
+		})
+	}
+
+	return b.p, nil
+}
+
+// conn gets a connection from the pool, and changes to the schema. The schema can't be unset,
+// so the connection using another one is closed for the session without schema.
+func (b *backend) conn(db string) (*client.Conn, *client.Pool, error) {
+	p, err := b.pool()
+	if err != nil {
+		return nil, nil, errors.Trace(err)
+	}
+
+	for {
+		c, err := p.Get(context.Background())
+		if err != nil {
+			return nil, nil, errors.Trace(err)
+		}
+
+		if len(db) > 0 {
+			if err = c.UseDB(db); err != nil {
+				p.Put(c)
+				return nil, nil, errors.Trace(err)
+			}
+			return c, p, nil
+		} else if len(c.GetDB()) == 0 {
+			return c, p, nil
+		}
+
+		c.Close()
+		p.Put(c)
+	}
+}
+
+func allowMultiStatements(c *client.Conn) {
+	c.SetMultiStatements(true)
+}
+
+func (b *backend) close() {
+	b.m.Lock()
+	b.closed = true
+	p := b.p
+	b.m.Unlock()
+
+	if p != nil {
+		p.Close()
+	}
+
+	for _, r := range b.replicas {
+		r.close()
+	}
+}
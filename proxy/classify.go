@@ -0,0 +1,325 @@
+package proxy
+
+import (
+	"strings"
+
+	"github.com/gdey/go-mysql/mysql"
+)
+
+// StmtType is the kind of a statement for routing.
+type StmtType int
+
+const (
+	// StmtOther changes or depends on the session, like SET, USE and LAST_INSERT_ID()
+	StmtOther StmtType = iota
+	StmtRead
+	StmtWrite
+	StmtDDL
+	// StmtTransaction controls the transaction, like BEGIN, COMMIT and SET autocommit
+	StmtTransaction
+)
+
+func (t StmtType) String() string {
+	switch t {
+	case StmtRead:
+		return "read"
+	case StmtWrite:
+		return "write"
+	case StmtDDL:
+		return "ddl"
+	case StmtTransaction:
+		return "transaction"
+	default:
+		return "other"
+	}
+}
+
+var stmtTypes = map[string]StmtType{
+	"SELECT":   StmtRead,
+	"WITH":     StmtRead,
+	"SHOW":     StmtRead,
+	"DESCRIBE": StmtRead,
+	"DESC":     StmtRead,
+	"EXPLAIN":  StmtRead,
+	"HELP":     StmtRead,
+
+	"INSERT":  StmtWrite,
+	"UPDATE":  StmtWrite,
+	"DELETE":  StmtWrite,
+	"REPLACE": StmtWrite,
+	"LOAD":    StmtWrite,
+	"CALL":    StmtWrite,
+	"DO":      StmtWrite,
+	"HANDLER": StmtWrite,
+	"LOCK":    StmtWrite,
+	"UNLOCK":  StmtWrite,
+
+	"CREATE":   StmtDDL,
+	"ALTER":    StmtDDL,
+	"DROP":     StmtDDL,
+	"TRUNCATE": StmtDDL,
+	"RENAME":   StmtDDL,
+	"GRANT":    StmtDDL,
+	"REVOKE":   StmtDDL,
+	"ANALYZE":  StmtDDL,
+	"OPTIMIZE": StmtDDL,
+	"REPAIR":   StmtDDL,
+
+	"BEGIN":     StmtTransaction,
+	"COMMIT":    StmtTransaction,
+	"ROLLBACK":  StmtTransaction,
+	"SAVEPOINT": StmtTransaction,
+	"RELEASE":   StmtTransaction,
+	"XA":        StmtTransaction,
+}
+
+// the functions of the session or the master, a read using them is StmtOther
+var sessionFuncs = map[string]bool{
+	"LAST_INSERT_ID":    true,
+	"FOUND_ROWS":        true,
+	"ROW_COUNT":         true,
+	"GET_LOCK":          true,
+	"RELEASE_LOCK":      true,
+	"RELEASE_ALL_LOCKS": true,
+	"IS_USED_LOCK":      true,
+	"IS_FREE_LOCK":      true,
+	"MASTER_POS_WAIT":   true,
+}
+
+// the SHOW statements of the session or the master
+var sessionShows = map[string]bool{
+	"WARNINGS":    true,
+	"ERRORS":      true,
+	"PROCESSLIST": true,
+	"MASTER":      true,
+	"SLAVE":       true,
+	"REPLICA":     true,
+	"REPLICAS":    true,
+	"BINARY":      true,
+	"BINLOG":      true,
+}
+
+// ClassifyStatement returns the type of the query, a multi statement is StmtRead only if all of its statements are,
+// or the type of the first one which is not.
+func ClassifyStatement(query string) StmtType {
+	stmts := statementWords(query)
+	if len(stmts) == 0 {
+		return StmtOther
+	}
+
+	for _, words := range stmts {
+		if t := classifyWords(words); t != StmtRead {
+			return t
+		}
+	}
+	return StmtRead
+}
+
+func classifyWords(words []string) StmtType {
+	t, ok := stmtTypes[words[0]]
+	if !ok {
+		switch words[0] {
+		case "START":
+			if len(words) > 1 && words[1] == "TRANSACTION" {
+				return StmtTransaction
+			}
+		case "SET":
+			for _, w := range words[1:] {
+				if w == "TRANSACTION" || w == "AUTOCOMMIT" {
+					return StmtTransaction
+				}
+			}
+		}
+		return StmtOther
+	}
+
+	if t != StmtRead {
+		return t
+	}
+
+	if words[0] == "SHOW" && len(words) > 1 && sessionShows[words[1]] {
+		return StmtOther
+	}
+
+	for i, w := range words {
+		switch {
+		case w == "INTO":
+			// SELECT INTO OUTFILE or variables
+			return StmtWrite
+		case w == "FOR" && i+1 < len(words) && (words[i+1] == "UPDATE" || words[i+1] == "SHARE"):
+			return StmtWrite
+		case w == "LOCK" && i+1 < len(words) && words[i+1] == "IN":
+			// LOCK IN SHARE MODE
+			return StmtWrite
+		case sessionFuncs[w]:
+			return StmtOther
+		case strings.HasPrefix(w, "@") && !strings.HasPrefix(w, "@@"):
+			// the user variables are in the session
+			return StmtOther
+		}
+	}
+
+	return StmtRead
+}
+
+// statementWords splits the query into the statements, and returns the upper case words of every statement.
+// The strings, quoted identifiers and comments are skipped, and the executable comments /*! */ are read.
+func statementWords(query string) [][]string {
+	var stmts [][]string
+	var words []string
+
+	endStmt := func() {
+		if len(words) > 0 {
+			stmts = append(stmts, words)
+			words = nil
+		}
+	}
+
+	for i := 0; i < len(query); {
+		ch := query[i]
+		switch {
+		case isWordChar(ch) || ch == '@':
+			j := i + 1
+			for j < len(query) && (isWordChar(query[j]) || query[j] == '@') {
+				j++
+			}
+			words = append(words, strings.ToUpper(query[i:j]))
+			i = j
+		case ch == '\'' || ch == '"' || ch == '`':
+			i = skipQuoted(query, i)
+		case ch == '#' || (ch == '-' && strings.HasPrefix(query[i:], "-- ")):
+			if j := strings.IndexByte(query[i:], '\n'); j == -1 {
+				i = len(query)
+			} else {
+				i += j + 1
+			}
+		case strings.HasPrefix(query[i:], "/*!"):
+			// the version number of the executable comment
+			i += 3
+			for i < len(query) && query[i] >= '0' && query[i] <= '9' {
+				i++
+			}
+		case strings.HasPrefix(query[i:], "/*"):
+			if j := strings.Index(query[i+2:], "*/"); j == -1 {
+				i = len(query)
+			} else {
+				i += 2 + j + 2
+			}
+		case ch == ';':
+			endStmt()
+			i++
+		default:
+			// the end of the executable comment, operators and spaces
+			i++
+		}
+	}
+	endStmt()
+
+	return stmts
+}
+
+// parseUse returns the schema if the query is USE db, the schema may be quoted by backticks.
+// USE in a multi statement is not supported, because we can't follow the schema of the session.
+func parseUse(query string) (string, bool, error) {
+	stmts := statementWords(query)
+	for _, words := range stmts {
+		if words[0] != "USE" {
+			continue
+		} else if len(stmts) > 1 {
+			return "", true, mysql.NewDefaultError(mysql.ER_NOT_SUPPORTED_YET, "USE in a multi statement")
+		}
+
+		// the USE keyword is the first word
+		i := skipSpaces(query, 0) + len("USE")
+		i = skipSpaces(query, i)
+
+		var db string
+		if i < len(query) && query[i] == '`' {
+			// the backtick is escaped by doubling it
+			var name []byte
+			j := i + 1
+			for ; j < len(query); j++ {
+				if query[j] == '`' {
+					if j+1 < len(query) && query[j+1] == '`' {
+						j++
+					} else {
+						break
+					}
+				}
+				name = append(name, query[j])
+			}
+
+			if j == len(query) {
+				return "", true, mysql.NewDefaultError(mysql.ER_PARSE_ERROR, "You have an error in your SQL syntax", query, 1)
+			}
+			db = string(name)
+		} else {
+			j := i
+			for j < len(query) && isWordChar(query[j]) {
+				j++
+			}
+			db = query[i:j]
+		}
+
+		if len(db) == 0 {
+			return "", true, mysql.NewDefaultError(mysql.ER_PARSE_ERROR, "You have an error in your SQL syntax", query, 1)
+		}
+		return db, true, nil
+	}
+	return "", false, nil
+}
+
+// skipSpaces returns the position of the first word or symbol from i, after the spaces and comments.
+func skipSpaces(query string, i int) int {
+	for i < len(query) {
+		switch ch := query[i]; {
+		case ch == ' ' || ch == '\t' || ch == '\r' || ch == '\n':
+			i++
+		case ch == '#' || (ch == '-' && strings.HasPrefix(query[i:], "-- ")):
+			if j := strings.IndexByte(query[i:], '\n'); j == -1 {
+				i = len(query)
+			} else {
+				i += j + 1
+			}
+		case strings.HasPrefix(query[i:], "/*!"):
+			i += 3
+			for i < len(query) && query[i] >= '0' && query[i] <= '9' {
+				i++
+			}
+		case strings.HasPrefix(query[i:], "/*"):
+			if j := strings.Index(query[i+2:], "*/"); j == -1 {
+				i = len(query)
+			} else {
+				i += 2 + j + 2
+			}
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+func isWordChar(ch byte) bool {
+	return ch == '_' || ch == '$' || ch >= '0' && ch <= '9' || ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= 0x80
+}
+
+// skipQuoted returns the position after the quoted string or identifier at i,
+// the quote is escaped by doubling it, or by a backslash in a string.
+func skipQuoted(query string, i int) int {
+	quote := query[i]
+	for i++; i < len(query); i++ {
+		switch query[i] {
+		case '\\':
+			if quote != '`' {
+				i++
+			}
+		case quote:
+			if i+1 < len(query) && query[i+1] == quote {
+				i++
+			} else {
+				return i + 1
+			}
+		}
+	}
+	return i
+}
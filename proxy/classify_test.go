@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+type classifyTestSuite struct{}
+
+var _ = Suite(&classifyTestSuite{})
+
+func (s *classifyTestSuite) TestClassify(c *C) {
+	tbls := []struct {
+		query string
+		t     StmtType
+	}{
+		{"SELECT * FROM t", StmtRead},
+		{"  select 1", StmtRead},
+		{"/* comment */ SELECT 1", StmtRead},
+		{"-- comment\nSELECT 1 # comment", StmtRead},
+		{"(SELECT 1) UNION (SELECT 2)", StmtRead},
+		{"WITH c AS (SELECT 1) SELECT * FROM c", StmtRead},
+		{"SHOW TABLES", StmtRead},
+		{"EXPLAIN SELECT 1", StmtRead},
+		{"SELECT @@version", StmtRead},
+		{"SELECT 'INSERT INTO t', `into`, \"it's\" FROM t", StmtRead},
+		{"SELECT 'it\\'s', 'it''s' FROM t", StmtRead},
+		{"SELECT 1; SELECT 2", StmtRead},
+
+		{"SELECT * FROM t FOR UPDATE", StmtWrite},
+		{"SELECT * FROM t LOCK IN SHARE MODE", StmtWrite},
+		{"SELECT 1 INTO @a", StmtWrite},
+		{"INSERT INTO t VALUES (1)", StmtWrite},
+		{"/*!40101 INSERT */ INTO t VALUES (1)", StmtWrite},
+		{"update t set a = 1", StmtWrite},
+		{"DELETE FROM t", StmtWrite},
+		{"REPLACE INTO t VALUES (1)", StmtWrite},
+		{"CALL p()", StmtWrite},
+		{"LOCK TABLES t READ", StmtWrite},
+		{"SELECT 1; INSERT INTO t VALUES (1)", StmtWrite},
+
+		{"CREATE TABLE t (id int)", StmtDDL},
+		{"ALTER TABLE t ADD COLUMN a int", StmtDDL},
+		{"DROP TABLE t", StmtDDL},
+		{"TRUNCATE t", StmtDDL},
+
+		{"BEGIN", StmtTransaction},
+		{"START TRANSACTION READ ONLY", StmtTransaction},
+		{"COMMIT", StmtTransaction},
+		{"ROLLBACK TO SAVEPOINT a", StmtTransaction},
+		{"SET autocommit = 0", StmtTransaction},
+		{"SET SESSION TRANSACTION ISOLATION LEVEL READ COMMITTED", StmtTransaction},
+
+		{"SET NAMES utf8", StmtOther},
+		{"USE db", StmtOther},
+		{"SELECT @a", StmtOther},
+		{"SELECT LAST_INSERT_ID()", StmtOther},
+		{"SELECT GET_LOCK('a', 1)", StmtOther},
+		{"SHOW WARNINGS", StmtOther},
+		{"SHOW MASTER STATUS", StmtOther},
+		{"", StmtOther},
+		{"/* SELECT */", StmtOther},
+	}
+
+	for _, t := range tbls {
+		c.Assert(ClassifyStatement(t.query), Equals, t.t, Commentf("query %q", t.query))
+	}
+}
+
+func (s *classifyTestSuite) TestParseUse(c *C) {
+	tbls := []struct {
+		query string
+		db    string
+		ok    bool
+	}{
+		{"USE db", "db", true},
+		{"  use db1;", "db1", true},
+		{"/* comment */ USE\n`my db`", "my db", true},
+		{"USE `a``b`", "a`b", true},
+		{"SELECT 1", "", false},
+		{"SELECT 'USE db'", "", false},
+	}
+
+	for _, t := range tbls {
+		db, ok, err := parseUse(t.query)
+		c.Assert(err, IsNil, Commentf("query %q", t.query))
+		c.Assert(ok, Equals, t.ok, Commentf("query %q", t.query))
+		c.Assert(db, Equals, t.db, Commentf("query %q", t.query))
+	}
+
+	for _, query := range []string{"USE", "USE `db", "SELECT 1; USE db"} {
+		_, ok, err := parseUse(query)
+		c.Assert(ok, Equals, true, Commentf("query %q", query))
+		c.Assert(err, NotNil, Commentf("query %q", query))
+	}
+}
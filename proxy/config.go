@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"io/ioutil"
+
+	"github.com/BurntSushi/toml"
+	"github.com/juju/errors"
+)
+
+type BackendConfig struct {
+	// the name used by the route hook, the address by default
+	Name string `toml:"name"`
+
+	Addr     string `toml:"addr"`
+	User     string `toml:"user"`
+	Password string `toml:"password"`
+
+	// the schemas routed to the backend, the other schemas are routed to the first backend
+	Schemas []string `toml:"schemas"`
+
+	// the replicas of the backend, connected with the same user, the reads out of transactions
+	// are routed to the replica with the least lag, see Config.MaxReplicaLag
+	Replicas []string `toml:"replicas"`
+}
+
+type Config struct {
+	// the address the proxy listens for clients, and the user the clients authenticate with,
+	// the backends are connected with their own users.
+	Addr     string `toml:"addr"`
+	User     string `toml:"user"`
+	Password string `toml:"password"`
+
+	// the max number of the client connections, 0 means no limit
+	MaxConns int `toml:"max_conns"`
+
+	// the pool of the backend connections, there is one for every backend and replica, see client.PoolConfig
+	MaxIdle int `toml:"max_idle"`
+	MaxOpen int `toml:"max_open"`
+
+	// the max seconds a replica can be behind the master to serve the reads, by Seconds_Behind_Master
+	MaxReplicaLag int `toml:"max_replica_lag"`
+
+	Backends []BackendConfig `toml:"backend"`
+}
+
+func NewConfigWithFile(name string) (*Config, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return NewConfig(string(data))
+}
+
+func NewConfig(data string) (*Config, error) {
+	var c Config
+
+	_, err := toml.Decode(data, &c)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return &c, nil
+}
+
+func NewDefaultConfig() *Config {
+	c := new(Config)
+
+	c.Addr = "127.0.0.1:3307"
+	c.User = "root"
+	c.Password = ""
+
+	c.MaxReplicaLag = 10
+
+	c.Backends = []BackendConfig{{Addr: "127.0.0.1:3306", User: "root"}}
+
+	return c
+}
@@ -0,0 +1,206 @@
+package proxy
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gdey/go-mysql/client"
+	"github.com/gdey/go-mysql/server"
+	"github.com/gdey/go/log"
+	"github.com/juju/errors"
+)
+
+// QueryHook is called with every query and statement to prepare before it's forwarded,
+// it returns the query to forward, which may be rewritten, or an error to block it, like a mysql.MyError.
+type QueryHook func(s *server.Session, query string) (string, error)
+
+// RouteHook returns the name of the backend for the schema, or empty to route by the schemas in the config.
+type RouteHook func(s *server.Session, db string) string
+
+// ClassifyHook returns the type of the query for the read/write splitting, like ClassifyStatement.
+type ClassifyHook func(s *server.Session, query string) StmtType
+
+// Proxy accepts the MySQL clients, authenticates them with its own user, and forwards their commands
+// to the backends. Every client session holds a backend connection from the pool of the backend of its schema,
+// and puts it back after the session state is reset when the client quits.
+//
+// If the backend has replicas, the reads are routed to the replica with the least lag, unless the session
+// is in a transaction, or pinned to the backend after a write, DDL, or a statement of the session like SET.
+// The prepared statements always use the backend.
+type Proxy struct {
+	cfg *Config
+
+	backends []*backend
+	names    map[string]*backend
+	schemas  map[string]*backend
+
+	queryHook    QueryHook
+	routeHook    RouteHook
+	classifyHook ClassifyHook
+
+	l   net.Listener
+	srv *server.Server
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewProxy(cfg *Config) (*Proxy, error) {
+	if len(cfg.Backends) == 0 {
+		return nil, errors.New("proxy must have a backend")
+	}
+
+	p := new(Proxy)
+
+	p.cfg = cfg
+	p.quit = make(chan struct{})
+	p.names = make(map[string]*backend)
+	p.schemas = make(map[string]*backend)
+
+	for _, bc := range cfg.Backends {
+		b := newBackend(bc, cfg.MaxIdle, cfg.MaxOpen)
+		if _, ok := p.names[b.name()]; ok {
+			return nil, errors.Errorf("duplicated backend %s", b.name())
+		}
+
+		for _, db := range bc.Schemas {
+			db = strings.ToLower(db)
+			if other, ok := p.schemas[db]; ok && other != b {
+				return nil, errors.Errorf("schema %s is routed to both backend %s and %s", db, other.name(), b.name())
+			}
+			p.schemas[db] = b
+		}
+
+		p.backends = append(p.backends, b)
+		p.names[b.name()] = b
+	}
+
+	var err error
+	if p.l, err = net.Listen("tcp", cfg.Addr); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	provider := server.NewInMemoryProvider()
+	provider.AddUser(cfg.User, cfg.Password)
+
+	p.srv = server.NewServer(&server.ServerConfig{ConnConfig: server.ConnConfig{Provider: provider}, MaxConns: cfg.MaxConns},
+		func(c *server.Conn) server.Handler {
+			return &session{p: p}
+		})
+
+	return p, nil
+}
+
+// SetQueryHook sets the hook to rewrite or block the queries, it must be called before Start.
+func (p *Proxy) SetQueryHook(hook QueryHook) {
+	p.queryHook = hook
+}
+
+// SetRouteHook sets the hook to choose the backend by the schema, it must be called before Start.
+func (p *Proxy) SetRouteHook(hook RouteHook) {
+	p.routeHook = hook
+}
+
+// SetClassifyHook sets the hook to classify the queries for the read/write splitting, it must be called before Start.
+func (p *Proxy) SetClassifyHook(hook ClassifyHook) {
+	p.classifyHook = hook
+}
+
+// SetBackendOptions sets the options for the new backend connections, like TLS, see client.Connect.
+// It must be called before Start.
+func (p *Proxy) SetBackendOptions(options ...func(c *client.Conn)) {
+	for _, b := range p.backends {
+		b.setOptions(options)
+	}
+}
+
+func (p *Proxy) Start() error {
+	p.wg.Add(2)
+	go p.runServe()
+	go p.runCheckReplicas()
+
+	return nil
+}
+
+// Addr returns the address the proxy listens for clients.
+func (p *Proxy) Addr() net.Addr {
+	return p.l.Addr()
+}
+
+// Close closes the client connections, then the backend connections.
+func (p *Proxy) Close() {
+	log.Infof("close proxy")
+
+	close(p.quit)
+
+	p.srv.Close()
+	p.l.Close()
+
+	p.wg.Wait()
+
+	for _, b := range p.backends {
+		b.close()
+	}
+}
+
+func (p *Proxy) runServe() {
+	defer p.wg.Done()
+
+	if err := p.srv.Serve(p.l); err != server.ErrServerClosed {
+		log.Errorf("proxy serve err: %v", err)
+	}
+}
+
+// runCheckReplicas checks the lag of the replicas in replicaCheckInterval.
+func (p *Proxy) runCheckReplicas() {
+	defer p.wg.Done()
+
+	t := time.NewTicker(replicaCheckInterval)
+	defer t.Stop()
+
+	for {
+		for _, b := range p.backends {
+			b.checkReplicas()
+		}
+
+		select {
+		case <-p.quit:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// route returns the backend of the schema.
+func (p *Proxy) route(s *server.Session, db string) (*backend, error) {
+	if p.routeHook != nil {
+		if name := p.routeHook(s, db); len(name) > 0 {
+			b, ok := p.names[name]
+			if !ok {
+				return nil, errors.Errorf("unknown backend %s", name)
+			}
+			return b, nil
+		}
+	}
+
+	if b, ok := p.schemas[strings.ToLower(db)]; ok {
+		return b, nil
+	}
+	return p.backends[0], nil
+}
+
+func (p *Proxy) hookQuery(s *server.Session, query string) (string, error) {
+	if p.queryHook == nil {
+		return query, nil
+	}
+	return p.queryHook(s, query)
+}
+
+func (p *Proxy) classify(s *server.Session, query string) StmtType {
+	if p.classifyHook == nil {
+		return ClassifyStatement(query)
+	}
+	return p.classifyHook(s, query)
+}
@@ -0,0 +1,478 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gdey/go-mysql/client"
+	"github.com/gdey/go-mysql/mysql"
+	"github.com/gdey/go-mysql/server"
+	"github.com/juju/errors"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+// testBackend answers every statement of the query with the backend name, schema and connection id,
+// "SELECT @a" returns the user variable or empty, "DOUBLE" is a statement doubling the param, "FAIL" fails,
+// and "SELECT close" closes the connection.
+// It's a replica with the lag if lag is not nil.
+type testBackend struct {
+	server.EmptyHandler
+
+	name string
+	lag  *int64
+	c    *server.Conn
+	s    *server.Session
+}
+
+func (h *testBackend) SetSession(s *server.Session) {
+	h.s = s
+}
+
+func (h *testBackend) result(names []string, values ...interface{}) (*mysql.Result, error) {
+	r, err := mysql.BuildSimpleResultset(names, [][]interface{}{values}, false)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &mysql.Result{Resultset: r}, nil
+}
+
+func (h *testBackend) handle(query string) (*mysql.Result, error) {
+	switch {
+	case query == "SELECT backend":
+		return h.result([]string{"name", "db", "id"}, h.name, h.s.Database(), int64(h.c.ConnectionID()))
+	case query == "SELECT @a":
+		a, ok := h.s.UserVariable("a")
+		if !ok {
+			a = ""
+		}
+		return h.result([]string{"@a"}, a)
+	case strings.HasPrefix(query, "USE "):
+		h.s.SetDatabase(strings.TrimPrefix(query, "USE "))
+	case strings.HasPrefix(query, "SET @a = "):
+		h.s.SetUserVariable("a", strings.TrimPrefix(query, "SET @a = "))
+	case query == "BEGIN":
+		h.s.SetInTransaction()
+	case query == "COMMIT":
+		h.s.ClearInTransaction()
+	case query == "INSERT":
+		return &mysql.Result{AffectedRows: 1, InsertId: 2}, nil
+	case query == "SHOW SLAVE STATUS":
+		if h.lag == nil {
+			return h.result([]string{"Slave_IO_Running"})
+		}
+		return h.result([]string{"Slave_IO_Running", "Slave_SQL_Running", "Seconds_Behind_Master"}, "Yes", "Yes", atomic.LoadInt64(h.lag))
+	case query == "FAIL":
+		return nil, mysql.NewError(mysql.ER_UNKNOWN_ERROR, "failed in "+h.name)
+	case query == "SELECT close":
+		h.c.Close()
+	}
+	return nil, nil
+}
+
+func (h *testBackend) HandleMultiQuery(query string) ([]*mysql.Result, error) {
+	if strings.Contains(query, ";") && !h.s.MultiStatements() {
+		return nil, mysql.NewDefaultError(mysql.ER_PARSE_ERROR, "multi statements", query, 1)
+	}
+
+	var results []*mysql.Result
+	for _, q := range strings.Split(query, ";") {
+		r, err := h.handle(strings.TrimSpace(q))
+		if err != nil {
+			return results, err
+		}
+		results = append(results, r)
+	}
+	return results, nil
+}
+
+func (h *testBackend) HandleStmtPrepare(query string) (int, int, interface{}, error) {
+	if query != "DOUBLE" {
+		return 0, 0, nil, mysql.NewDefaultError(mysql.ER_PARSE_ERROR, query, 1)
+	}
+	return 1, 1, nil, nil
+}
+
+func (h *testBackend) HandleStmtExecute(context interface{}, query string, args []interface{}) (*mysql.Result, error) {
+	return h.result([]string{"n"}, args[0].(int64)*2)
+}
+
+type proxyTestSuite struct {
+	backends []*server.Server
+	p        *Proxy
+
+	// the lag of the replicas by the name
+	lags map[string]*int64
+}
+
+var _ = Suite(&proxyTestSuite{})
+
+func (s *proxyTestSuite) startBackend(c *C, name string) string {
+	p := server.NewInMemoryProvider()
+	p.AddUser("backend", "backend_pass")
+
+	lag := s.lags[name]
+	srv := server.NewServer(&server.ServerConfig{ConnConfig: server.ConnConfig{Provider: p}}, func(conn *server.Conn) server.Handler {
+		return &testBackend{name: name, lag: lag, c: conn}
+	})
+	s.backends = append(s.backends, srv)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+
+	go srv.Serve(l)
+	return l.Addr().String()
+}
+
+func (s *proxyTestSuite) SetUpSuite(c *C) {
+	replicaCheckInterval = 10 * time.Millisecond
+	s.lags = map[string]*int64{"r1": new(int64), "r2": new(int64)}
+
+	cfg := NewDefaultConfig()
+	cfg.Addr = "127.0.0.1:0"
+	cfg.Backends = []BackendConfig{
+		{Name: "b1", Addr: s.startBackend(c, "b1"), User: "backend", Password: "backend_pass"},
+		{Name: "b2", Addr: s.startBackend(c, "b2"), User: "backend", Password: "backend_pass", Schemas: []string{"db2"}},
+		{Name: "b3", Addr: s.startBackend(c, "b3"), User: "backend", Password: "backend_pass", Schemas: []string{"rw"},
+			Replicas: []string{s.startBackend(c, "r1"), s.startBackend(c, "r2")}},
+	}
+
+	var err error
+	s.p, err = NewProxy(cfg)
+	c.Assert(err, IsNil)
+
+	s.p.SetQueryHook(func(session *server.Session, query string) (string, error) {
+		if strings.HasPrefix(query, "DROP") {
+			return "", mysql.NewError(mysql.ER_SPECIFIC_ACCESS_DENIED_ERROR, "DROP is not allowed by "+session.User())
+		}
+		return strings.Replace(query, "SELECT name", "SELECT backend", -1), nil
+	})
+	s.p.SetRouteHook(func(session *server.Session, db string) string {
+		if db == "db3" {
+			return "b2"
+		}
+		return ""
+	})
+
+	c.Assert(s.p.Start(), IsNil)
+}
+
+func (s *proxyTestSuite) TearDownSuite(c *C) {
+	if s.p != nil {
+		s.p.Close()
+	}
+
+	for _, srv := range s.backends {
+		srv.Close()
+	}
+}
+
+func (s *proxyTestSuite) connect(c *C, db string, options ...func(conn *client.Conn)) *client.Conn {
+	conn, err := client.Connect(s.p.Addr().String(), "root", "", db, options...)
+	c.Assert(err, IsNil)
+	return conn
+}
+
+// backend returns the backend name, schema and connection id of the client session.
+func (s *proxyTestSuite) backend(c *C, conn *client.Conn) (string, string, int64) {
+	r, err := conn.Execute("SELECT backend")
+	c.Assert(err, IsNil)
+
+	name, _ := r.GetString(0, 0)
+	db, _ := r.GetString(0, 1)
+	id, _ := r.GetInt(0, 2)
+	return name, db, id
+}
+
+func (s *proxyTestSuite) TestForward(c *C) {
+	conn := s.connect(c, "", func(conn *client.Conn) {
+		conn.SetMultiStatements(true)
+	})
+	defer conn.Close()
+
+	name, db, _ := s.backend(c, conn)
+	c.Assert(name, Equals, "b1")
+	c.Assert(db, Equals, "")
+
+	r, err := conn.Execute("INSERT")
+	c.Assert(err, IsNil)
+	c.Assert(r.AffectedRows, Equals, uint64(1))
+	c.Assert(r.InsertId, Equals, uint64(2))
+
+	_, err = conn.Execute("FAIL")
+	c.Assert(errors.Cause(err).(*mysql.MyError).Message, Equals, "failed in b1")
+
+	// the results of a multi statement, with the OK in the middle
+	results, err := conn.ExecuteMultiple("SELECT backend; INSERT; SET @a = 1; SELECT @a")
+	c.Assert(err, IsNil)
+	c.Assert(results, HasLen, 4)
+	c.Assert(results[1].InsertId, Equals, uint64(2))
+	a, _ := results[3].GetString(0, 0)
+	c.Assert(a, Equals, "1")
+
+	stmt, err := conn.Prepare("DOUBLE")
+	c.Assert(err, IsNil)
+	defer stmt.Close()
+
+	r, err = stmt.Execute(21)
+	c.Assert(err, IsNil)
+	c.Assert(r.Values[0][0], Equals, int64(42))
+
+	_, err = conn.Prepare("SELECT 1")
+	c.Assert(errors.Cause(err).(*mysql.MyError).Code, Equals, uint16(mysql.ER_PARSE_ERROR))
+}
+
+func (s *proxyTestSuite) TestRoute(c *C) {
+	conn := s.connect(c, "db2")
+	defer conn.Close()
+
+	name, db, _ := s.backend(c, conn)
+	c.Assert(name, Equals, "b2")
+	c.Assert(db, Equals, "db2")
+
+	stmt, err := conn.Prepare("DOUBLE")
+	c.Assert(err, IsNil)
+	defer stmt.Close()
+
+	// the statement is prepared again in the new backend
+	c.Assert(conn.UseDB("db1"), IsNil)
+	name, db, _ = s.backend(c, conn)
+	c.Assert(name, Equals, "b1")
+	c.Assert(db, Equals, "db1")
+
+	r, err := stmt.Execute(1)
+	c.Assert(err, IsNil)
+	c.Assert(r.Values[0][0], Equals, int64(2))
+
+	// by the route hook
+	c.Assert(conn.UseDB("db3"), IsNil)
+	name, _, _ = s.backend(c, conn)
+	c.Assert(name, Equals, "b2")
+
+	// the backend can't be changed in a transaction, the schema of the same backend can
+	c.Assert(conn.Begin(), IsNil)
+	c.Assert(conn.IsInTransaction(), Equals, true)
+	c.Assert(conn.UseDB("db1"), NotNil)
+	c.Assert(conn.UseDB("db2"), IsNil)
+	c.Assert(conn.Commit(), IsNil)
+	c.Assert(conn.IsInTransaction(), Equals, false)
+	c.Assert(conn.UseDB("db1"), IsNil)
+}
+
+func (s *proxyTestSuite) TestQueryHook(c *C) {
+	conn := s.connect(c, "")
+	defer conn.Close()
+
+	_, err := conn.Execute("DROP TABLE t")
+	c.Assert(errors.Cause(err).(*mysql.MyError).Message, Equals, "DROP is not allowed by root")
+
+	// not forwarded if the client doesn't allow the multi statements
+	_, err = conn.Execute("SELECT name; DROP TABLE t")
+	c.Assert(errors.Cause(err).(*mysql.MyError).Code, Equals, uint16(mysql.ER_PARSE_ERROR))
+	_, err = conn.Execute("SELECT name; -- DROP TABLE t")
+	c.Assert(err, IsNil)
+
+	r, err := conn.Execute("SELECT name")
+	c.Assert(err, IsNil)
+	name, _ := r.GetString(0, 0)
+	c.Assert(name, Equals, "b1")
+}
+
+func (s *proxyTestSuite) TestUse(c *C) {
+	conn := s.connect(c, "db4")
+	_, _, id := s.backend(c, conn)
+
+	_, err := conn.Execute("USE db5")
+	c.Assert(err, IsNil)
+	name, db, reused := s.backend(c, conn)
+	c.Assert(name, Equals, "b1")
+	c.Assert(db, Equals, "db5")
+	c.Assert(reused, Equals, id)
+
+	// routed like COM_INIT_DB
+	_, err = conn.Execute("USE db2")
+	c.Assert(err, IsNil)
+	name, db, _ = s.backend(c, conn)
+	c.Assert(name, Equals, "b2")
+	c.Assert(db, Equals, "db2")
+
+	_, err = conn.Execute("USE db4")
+	c.Assert(err, IsNil)
+	_, err = conn.Execute("USE db5")
+	c.Assert(err, IsNil)
+	_, _, id = s.backend(c, conn)
+	s.closeWait(c, conn, "b1")
+
+	// the backend connection is put back, and changed to the schema of the next session
+	conn = s.connect(c, "db4")
+	_, db, reused = s.backend(c, conn)
+	c.Assert(db, Equals, "db4")
+	c.Assert(reused, Equals, id)
+	s.closeWait(c, conn, "b1")
+
+	// but not used by the session without schema, as the schema can't be unset
+	conn = s.connect(c, "")
+	defer conn.Close()
+
+	_, db, reused = s.backend(c, conn)
+	c.Assert(db, Equals, "")
+	c.Assert(reused, Not(Equals), id)
+}
+
+// closeWait closes the client connection, and waits for its connection of the backend to be put back.
+func (s *proxyTestSuite) closeWait(c *C, conn *client.Conn, name string) {
+	pool, err := s.p.names[name].pool()
+	c.Assert(err, IsNil)
+
+	_, idle := pool.Stats()
+	conn.Close()
+	for i := 0; i < 100; i++ {
+		if _, n := pool.Stats(); n > idle {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Fatalf("connection of backend %s is not put back", name)
+}
+
+func (s *proxyTestSuite) TestPool(c *C) {
+	conn := s.connect(c, "db4")
+	_, _, id := s.backend(c, conn)
+	_, err := conn.Execute("SET @a = 1")
+	c.Assert(err, IsNil)
+
+	// the backend connection is put back when the client quits
+	s.closeWait(c, conn, "b1")
+
+	conn = s.connect(c, "db4")
+	defer conn.Close()
+
+	_, _, reused := s.backend(c, conn)
+	c.Assert(reused, Equals, id)
+
+	// the session state is reset
+	r, err := conn.Execute("SELECT @a")
+	c.Assert(err, IsNil)
+	a, _ := r.GetString(0, 0)
+	c.Assert(a, Equals, "")
+}
+
+func (s *proxyTestSuite) TestBackendClosed(c *C) {
+	conn := s.connect(c, "db4")
+	defer conn.Close()
+
+	// another backend connection is used for the clean session
+	_, _, id := s.backend(c, conn)
+	_, err := conn.Execute("SELECT close")
+	c.Assert(err, NotNil)
+	_, _, another := s.backend(c, conn)
+	c.Assert(another, Not(Equals), id)
+
+	// the client connection is closed with the session state
+	_, err = conn.Execute("SET @a = 1")
+	c.Assert(err, IsNil)
+	_, err = conn.Execute("SELECT close")
+	c.Assert(err, NotNil)
+	_, ok := errors.Cause(err).(*mysql.MyError)
+	c.Assert(ok, Equals, false)
+	_, err = conn.Execute("SELECT @a")
+	c.Assert(err, NotNil)
+
+	// and in a transaction
+	conn = s.connect(c, "db4")
+	defer conn.Close()
+
+	c.Assert(conn.Begin(), IsNil)
+	_, err = conn.Execute("SELECT close")
+	c.Assert(err, NotNil)
+	_, ok = errors.Cause(err).(*mysql.MyError)
+	c.Assert(ok, Equals, false)
+}
+
+// waitBackend waits for the reads of the session to be routed to one of the backends.
+func (s *proxyTestSuite) waitBackend(c *C, conn *client.Conn, names ...string) {
+	var name string
+	for i := 0; i < 100; i++ {
+		name, _, _ = s.backend(c, conn)
+		for _, n := range names {
+			if name == n {
+				return
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Fatalf("read from %s, not %v", name, names)
+}
+
+func (s *proxyTestSuite) TestReadWriteSplit(c *C) {
+	atomic.StoreInt64(s.lags["r1"], 1)
+	atomic.StoreInt64(s.lags["r2"], 5)
+
+	conn := s.connect(c, "rw")
+	defer conn.Close()
+
+	// the replica with the least lag in MaxReplicaLag
+	s.waitBackend(c, conn, "r1")
+	atomic.StoreInt64(s.lags["r1"], 20)
+	s.waitBackend(c, conn, "r2")
+	atomic.StoreInt64(s.lags["r2"], 20)
+	s.waitBackend(c, conn, "b3")
+
+	atomic.StoreInt64(s.lags["r1"], 0)
+	atomic.StoreInt64(s.lags["r2"], 0)
+	s.waitBackend(c, conn, "r1", "r2")
+
+	// the reads in a transaction
+	c.Assert(conn.Begin(), IsNil)
+	name, _, _ := s.backend(c, conn)
+	c.Assert(name, Equals, "b3")
+	c.Assert(conn.Commit(), IsNil)
+	name, _, _ = s.backend(c, conn)
+	c.Assert(name, Not(Equals), "b3")
+
+	// the statements use the backend
+	stmt, err := conn.Prepare("DOUBLE")
+	c.Assert(err, IsNil)
+	c.Assert(stmt.Close(), IsNil)
+
+	// the session is pinned after a write, until it's reset
+	_, err = conn.Execute("INSERT")
+	c.Assert(err, IsNil)
+	name, _, _ = s.backend(c, conn)
+	c.Assert(name, Equals, "b3")
+
+	c.Assert(conn.ResetConnection(), IsNil)
+	name, _, _ = s.backend(c, conn)
+	c.Assert(name, Not(Equals), "b3")
+}
+
+func (s *proxyTestSuite) TestConfig(c *C) {
+	cfg, err := NewConfig(fmt.Sprintf(`
+addr = "127.0.0.1:0"
+user = "root"
+
+[[backend]]
+addr = "%s"
+user = "backend"
+password = "backend_pass"
+schemas = ["db1", "db2"]
+
+[[backend]]
+addr = "%s"
+schemas = ["DB2"]
+`, "127.0.0.1:1", "127.0.0.1:2"))
+	c.Assert(err, IsNil)
+	c.Assert(cfg.Backends, HasLen, 2)
+	c.Assert(cfg.Backends[0].Password, Equals, "backend_pass")
+	c.Assert(cfg.Backends[1].Schemas, DeepEquals, []string{"DB2"})
+
+	_, err = NewProxy(cfg)
+	c.Assert(err, ErrorMatches, "schema db2 is routed to both backend 127.0.0.1:1 and 127.0.0.1:2")
+}
@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/gdey/go-mysql/failover"
+	"github.com/gdey/go/log"
+	"github.com/juju/errors"
+)
+
+// the replicas are checked in this interval, it's changed in the tests
+var replicaCheckInterval = time.Second
+
+// replica is a replica of a backend, it's used for the reads if its lag is known and not too large.
+type replica struct {
+	*backend
+
+	s *failover.Server
+
+	// the seconds behind the master, -1 if it can't be used, guarded by the mutex of the master backend
+	lag int
+}
+
+func newReplica(master *backend, addr string) *replica {
+	cfg := master.cfg
+	cfg.Name = addr
+	cfg.Addr = addr
+	cfg.Schemas = nil
+	cfg.Replicas = nil
+
+	r := new(replica)
+	r.backend = newBackend(cfg, master.maxIdle, master.maxOpen)
+	r.s = failover.NewServer(addr, failover.User{Name: cfg.User, Password: cfg.Password}, failover.User{})
+	r.lag = -1
+
+	return r
+}
+
+// checkLag returns the seconds the replica is behind the master with SHOW SLAVE STATUS,
+// it fails if the replication is not running.
+func (r *replica) checkLag() (int, error) {
+	rs, err := r.s.SlaveStatus()
+	if err != nil {
+		return -1, errors.Trace(err)
+	} else if rs.RowNumber() == 0 {
+		return -1, errors.New("not a replica")
+	}
+
+	io, _ := rs.GetStringByName(0, "Slave_IO_Running")
+	sql, _ := rs.GetStringByName(0, "Slave_SQL_Running")
+	if io != "Yes" || sql != "Yes" {
+		return -1, errors.Errorf("replication is not running, io %s, sql %s", io, sql)
+	}
+
+	// NULL if the lag is unknown
+	if null, err := rs.IsNullByName(0, "Seconds_Behind_Master"); err != nil {
+		return -1, errors.Trace(err)
+	} else if null {
+		return -1, errors.New("lag is unknown")
+	}
+
+	lag, err := rs.GetIntByName(0, "Seconds_Behind_Master")
+	if err != nil {
+		return -1, errors.Trace(err)
+	}
+	return int(lag), nil
+}
+
+func (r *replica) close() {
+	r.s.Close()
+	r.backend.close()
+}
+
+// checkReplicas updates the lag of the replicas of the backend.
+func (b *backend) checkReplicas() {
+	for _, r := range b.replicas {
+		lag, err := r.checkLag()
+		if err != nil {
+			log.Warnf("proxy check replica %s of backend %s err: %v", r.name(), b.name(), err)
+		}
+
+		b.m.Lock()
+		r.lag = lag
+		b.m.Unlock()
+	}
+}
+
+// replica returns the replica with the least lag, a random one if more than one,
+// or nil if no replica is in maxLag.
+func (b *backend) replica(maxLag int) *replica {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	var candidates []*replica
+	for _, r := range b.replicas {
+		if r.lag < 0 || r.lag > maxLag {
+			continue
+		}
+
+		if len(candidates) > 0 && r.lag < candidates[0].lag {
+			candidates = candidates[:0]
+		}
+		if len(candidates) == 0 || r.lag == candidates[0].lag {
+			candidates = append(candidates, r)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return nil
+	}
+	return candidates[rand.Intn(len(candidates))]
+}
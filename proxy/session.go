@@ -0,0 +1,422 @@
+package proxy
+
+import (
+	"github.com/gdey/go-mysql/client"
+	"github.com/gdey/go-mysql/mysql"
+	"github.com/gdey/go-mysql/server"
+	"github.com/gdey/go/log"
+	"github.com/juju/errors"
+)
+
+// session forwards the commands of a client to the backend connection it holds,
+// the connection is got when the first command needs it, and put back when the client quits.
+type session struct {
+	p *Proxy
+	s *server.Session
+
+	backend *backend
+	pool    *client.Pool
+	conn    *client.Conn
+
+	// the reads use the backend too, after a write, DDL or a statement of the session
+	pinned bool
+}
+
+// stmt is the context of a prepared statement, it's prepared again if the backend connection is changed.
+type stmt struct {
+	// the query after the query hook
+	query string
+
+	conn *client.Conn
+	s    *client.Stmt
+}
+
+func (h *session) SetSession(s *server.Session) {
+	h.s = s
+}
+
+// backendConn returns the connection held, or gets one from the backend of the current schema.
+func (h *session) backendConn() (*client.Conn, error) {
+	if h.conn != nil {
+		return h.conn, nil
+	}
+
+	db := h.s.Database()
+
+	b, err := h.p.route(h.s, db)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	conn, pool, err := b.conn(db)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	h.backend = b
+	h.pool = pool
+	h.conn = conn
+	return conn, nil
+}
+
+// release puts back the backend connection, the pool resets its session state.
+func (h *session) release() {
+	if h.conn == nil {
+		return
+	}
+
+	h.put()
+}
+
+// put puts back the backend connection, the pool discards it if it's closed.
+func (h *session) put() {
+	h.pool.Put(h.conn)
+
+	h.backend = nil
+	h.pool = nil
+	h.conn = nil
+}
+
+// check closes the backend connection if err is not a MySQL error, so the next command gets another one.
+// The client connection is closed too if the session is in a transaction or has the session state,
+// which is lost with the backend connection.
+func (h *session) check(err error) error {
+	if err == nil || h.conn == nil {
+		return err
+	}
+
+	if _, ok := errors.Cause(err).(*mysql.MyError); ok {
+		return err
+	}
+
+	log.Errorf("proxy backend %s err: %v", h.backend.name(), err)
+	h.conn.Close()
+	h.put()
+
+	if h.pinned || h.s.IsInTransaction() || !h.s.IsAutoCommit() {
+		return errors.Trace(server.ErrCloseConn)
+	}
+	return err
+}
+
+// syncStatus copies the transaction status of the backend connection to the session.
+func (h *session) syncStatus() {
+	if h.conn == nil {
+		h.s.ClearInTransaction()
+		return
+	}
+
+	if h.conn.IsInTransaction() {
+		h.s.SetInTransaction()
+	} else {
+		h.s.ClearInTransaction()
+	}
+
+	if autoCommit := h.conn.IsAutoCommit(); autoCommit != h.s.IsAutoCommit() {
+		h.s.SetAutoCommit(autoCommit)
+	}
+}
+
+// replicaConn returns a connection of a replica of the current backend for the query,
+// or nil if the query must use the backend.
+func (h *session) replicaConn(query string) (*client.Conn, *client.Pool) {
+	t := h.p.classify(h.s, query)
+	if t != StmtRead && t != StmtTransaction {
+		h.pinned = true
+	}
+
+	if t != StmtRead || h.pinned || h.s.IsInTransaction() || !h.s.IsAutoCommit() {
+		return nil, nil
+	}
+
+	db := h.s.Database()
+
+	b, err := h.p.route(h.s, db)
+	if err != nil {
+		return nil, nil
+	}
+
+	r := b.replica(h.p.cfg.MaxReplicaLag)
+	if r == nil {
+		return nil, nil
+	}
+
+	conn, pool, err := r.conn(db)
+	if err != nil {
+		log.Warnf("proxy get replica %s connection err: %v, use backend %s", r.name(), err, b.name())
+		return nil, nil
+	}
+	return conn, pool
+}
+
+// putReplicaConn puts back the replica connection, it's closed if err is not a MySQL error.
+func (h *session) putReplicaConn(pool *client.Pool, conn *client.Conn, err error) {
+	if err != nil {
+		if _, ok := errors.Cause(err).(*mysql.MyError); !ok {
+			log.Errorf("proxy replica err: %v", err)
+			conn.Close()
+		}
+	}
+
+	pool.Put(conn)
+}
+
+func (h *session) UseDB(db string) error {
+	b, err := h.p.route(h.s, db)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	if h.conn != nil && h.backend != b {
+		if h.s.IsInTransaction() {
+			return mysql.NewError(mysql.ER_UNKNOWN_ERROR, "can't change to schema "+db+" of another backend in a transaction")
+		}
+		h.release()
+	}
+
+	if h.conn == nil {
+		// the backend connection is got from the pool of the schema later
+		return nil
+	}
+
+	return h.check(h.conn.UseDB(db))
+}
+
+// checkMultiStatements fails the multi statement like MySQL if the client doesn't allow it,
+// the backend connections allow it, so it's not forwarded.
+func (h *session) checkMultiStatements(query string) error {
+	if !h.s.MultiStatements() && len(statementWords(query)) > 1 {
+		return mysql.NewDefaultError(mysql.ER_PARSE_ERROR, "You have an error in your SQL syntax", query, 1)
+	}
+	return nil
+}
+
+// handleUse changes the schema for USE db in a query like mysql.COM_INIT_DB, instead of forwarding it,
+// so the session and the backend connection know the schema, false if the query is not USE.
+func (h *session) handleUse(query string) (bool, error) {
+	db, ok, err := parseUse(query)
+	if !ok || err != nil {
+		return ok, err
+	}
+
+	if err = h.UseDB(db); err != nil {
+		return true, err
+	}
+	h.s.SetDatabase(db)
+	return true, nil
+}
+
+func (h *session) HandleQuery(query string) (*mysql.Result, error) {
+	if err := h.checkMultiStatements(query); err != nil {
+		return nil, err
+	}
+
+	query, err := h.p.hookQuery(h.s, query)
+	if err != nil {
+		return nil, err
+	}
+
+	if ok, err := h.handleUse(query); ok {
+		return nil, err
+	}
+
+	if conn, pool := h.replicaConn(query); conn != nil {
+		r, err := conn.Execute(query)
+		h.putReplicaConn(pool, conn, err)
+		return r, err
+	}
+
+	conn, err := h.backendConn()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer h.syncStatus()
+
+	r, err := conn.Execute(query)
+	return r, h.check(err)
+}
+
+func (h *session) HandleQueryStreaming(query string, w *server.ResultsetWriter) (*mysql.Result, error) {
+	if err := h.checkMultiStatements(query); err != nil {
+		return nil, err
+	}
+
+	query, err := h.p.hookQuery(h.s, query)
+	if err != nil {
+		return nil, err
+	}
+
+	if ok, err := h.handleUse(query); ok {
+		return nil, err
+	}
+
+	if conn, pool := h.replicaConn(query); conn != nil {
+		var r *mysql.Result
+		rows, err := conn.Query(query)
+		if err == nil {
+			r, err = h.forward(rows, w)
+		}
+		h.putReplicaConn(pool, conn, err)
+		return r, err
+	}
+
+	conn, err := h.backendConn()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer h.syncStatus()
+
+	rows, err := conn.Query(query)
+	if err != nil {
+		return nil, h.check(err)
+	}
+
+	r, err := h.forward(rows, w)
+	return r, h.check(err)
+}
+
+// forward writes the results of the backend to the client, the rows are written as is.
+func (h *session) forward(rows *client.Rows, w *server.ResultsetWriter) (*mysql.Result, error) {
+	defer rows.Close()
+
+	for {
+		if fields := rows.Fields(); fields == nil {
+			if !rows.HasNextResultSet() {
+				return rows.Result(), nil
+			}
+
+			if err := w.WriteResult(rows.Result()); err != nil {
+				return nil, errors.Trace(err)
+			}
+		} else {
+			if err := w.WriteFields(fields); err != nil {
+				return nil, errors.Trace(err)
+			}
+
+			for rows.Next() {
+				if err := w.WriteRowData(rows.RowData()); err != nil {
+					return nil, errors.Trace(err)
+				}
+			}
+
+			if err := rows.Err(); err != nil {
+				return nil, err
+			} else if !rows.HasNextResultSet() {
+				return nil, nil
+			}
+
+			if err := w.NextResultset(); err != nil {
+				return nil, errors.Trace(err)
+			}
+		}
+
+		if !rows.NextResultSet() {
+			return nil, rows.Err()
+		}
+	}
+}
+
+func (h *session) HandleFieldList(table string, fieldWildcard string) ([]*mysql.Field, error) {
+	conn, err := h.backendConn()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	fields, err := conn.FieldList(table, fieldWildcard)
+	return fields, h.check(err)
+}
+
+func (h *session) HandleStmtPrepare(query string) (int, int, interface{}, error) {
+	query, err := h.p.hookQuery(h.s, query)
+	if err != nil {
+		return 0, 0, nil, err
+	}
+
+	st := &stmt{query: query}
+	if err = h.prepare(st); err != nil {
+		return 0, 0, nil, err
+	}
+
+	return st.s.ParamNum(), st.s.ColumnNum(), st, nil
+}
+
+// prepare prepares the statement with the backend connection held.
+func (h *session) prepare(st *stmt) error {
+	conn, err := h.backendConn()
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	s, err := conn.Prepare(st.query)
+	if err != nil {
+		return h.check(err)
+	}
+
+	st.conn = conn
+	st.s = s
+	return nil
+}
+
+func (h *session) HandleStmtExecute(context interface{}, query string, args []interface{}) (*mysql.Result, error) {
+	st := context.(*stmt)
+	if st.conn != h.conn {
+		if err := h.prepare(st); err != nil {
+			return nil, err
+		}
+	}
+	defer h.syncStatus()
+
+	r, err := st.s.Execute(args...)
+	return r, h.check(err)
+}
+
+func (h *session) HandleStmtExecuteStreaming(context interface{}, query string, args []interface{}, w *server.ResultsetWriter) (*mysql.Result, error) {
+	st := context.(*stmt)
+	if st.conn != h.conn {
+		if err := h.prepare(st); err != nil {
+			return nil, err
+		}
+	}
+	defer h.syncStatus()
+
+	rows, err := st.s.Query(args...)
+	if err != nil {
+		return nil, h.check(err)
+	}
+
+	r, err := h.forward(rows, w)
+	return r, h.check(err)
+}
+
+func (h *session) HandleStmtClose(context interface{}) error {
+	st := context.(*stmt)
+	if st.conn != h.conn {
+		// closed with the previous backend connection
+		return nil
+	}
+
+	return h.check(st.s.Close())
+}
+
+func (h *session) HandleResetConnection() error {
+	h.pinned = false
+
+	if h.conn == nil {
+		return nil
+	}
+	defer h.syncStatus()
+
+	return h.check(h.conn.ResetConnection())
+}
+
+// HandleChangeUser puts back the backend connection, the new user gets another one.
+func (h *session) HandleChangeUser(user string, db string) error {
+	h.pinned = false
+	h.release()
+	h.syncStatus()
+	return nil
+}
+
+func (h *session) HandleClose() {
+	h.release()
+}
@@ -0,0 +1,74 @@
+package relay
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/juju/errors"
+)
+
+type Config struct {
+	// the master we pull binlog from
+	MasterAddr     string `toml:"master_addr"`
+	MasterUser     string `toml:"master_user"`
+	MasterPassword string `toml:"master_password"`
+
+	// the server id to register to the master, it's also the relay's server id for its slaves
+	ServerID uint32 `toml:"server_id"`
+	Flavor   string `toml:"flavor"`
+
+	// the binlog file to start with if there is no binlog in data dir, empty means the first binlog of the master,
+	// we always pull a binlog from its beginning.
+	StartFile string `toml:"start_file"`
+
+	// the dir to store the binlog files
+	DataDir string `toml:"data_dir"`
+
+	// the address the relay listens for slaves
+	Addr     string `toml:"addr"`
+	User     string `toml:"user"`
+	Password string `toml:"password"`
+}
+
+func NewConfigWithFile(name string) (*Config, error) {
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return NewConfig(string(data))
+}
+
+func NewConfig(data string) (*Config, error) {
+	var c Config
+
+	_, err := toml.Decode(data, &c)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return &c, nil
+}
+
+func NewDefaultConfig() *Config {
+	c := new(Config)
+
+	c.MasterAddr = "127.0.0.1:3306"
+	c.MasterUser = "root"
+	c.MasterPassword = ""
+
+	rand.Seed(time.Now().Unix())
+	c.ServerID = uint32(rand.Intn(1000)) + 1001
+
+	c.Flavor = "mysql"
+
+	c.DataDir = "./var"
+
+	c.Addr = "127.0.0.1:3307"
+	c.User = "root"
+	c.Password = ""
+
+	return c
+}
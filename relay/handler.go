@@ -0,0 +1,126 @@
+package relay
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gdey/go-mysql/mysql"
+	"github.com/gdey/go-mysql/replication"
+	"github.com/gdey/go-mysql/server"
+	"github.com/gdey/go/log"
+	"github.com/juju/errors"
+)
+
+var (
+	showVariablesRegexp   = regexp.MustCompile(`(?i)^\s*SHOW\s+(GLOBAL\s+|SESSION\s+)?VARIABLES\s+LIKE\s+['"]([^'"]*)['"]\s*;?\s*$`)
+	selectVariableRegexp  = regexp.MustCompile(`(?i)^\s*SELECT\s+(@@(GLOBAL\.|SESSION\.)?(\w+))(\s+LIMIT\s+\d+)?\s*;?\s*$`)
+	selectTimestampRegexp = regexp.MustCompile(`(?i)^\s*SELECT\s+UNIX_TIMESTAMP\(\)\s*;?\s*$`)
+	showMasterRegexp      = regexp.MustCompile(`(?i)^\s*SHOW\s+MASTER\s+STATUS\s*;?\s*$`)
+	showBinaryLogsRegexp  = regexp.MustCompile(`(?i)^\s*SHOW\s+(BINARY|MASTER)\s+LOGS\s*;?\s*$`)
+	setRegexp             = regexp.MustCompile(`(?i)^\s*SET\s+`)
+)
+
+// handler handles the commands from a slave of the relay,
+// the relay supports the queries a slave executes before dumping binlog, and some status queries.
+type handler struct {
+	server.EmptyHandler
+
+	r *Relay
+}
+
+func (h *handler) variables() map[string]interface{} {
+	checksum := "NONE"
+	if h.r.storage.getChecksumAlg() == replication.BINLOG_CHECKSUM_ALG_CRC32 {
+		checksum = "CRC32"
+	}
+
+	return map[string]interface{}{
+		"binlog_checksum":              checksum,
+		"server_id":                    h.r.cfg.ServerID,
+		"rpl_semi_sync_master_enabled": "OFF",
+		"version":                      mysql.ServerVersion,
+		"version_comment":              "go-mysql binlog relay",
+	}
+}
+
+func (h *handler) HandleQuery(query string) (*mysql.Result, error) {
+	var names []string
+	var values [][]interface{}
+
+	if m := showVariablesRegexp.FindStringSubmatch(query); m != nil {
+		pattern := strings.Replace(strings.Replace(strings.ToLower(m[2]), "%", "*", -1), "_", "?", -1)
+
+		names = []string{"Variable_name", "Value"}
+		for name, value := range h.variables() {
+			if ok, _ := path.Match(pattern, name); ok {
+				values = append(values, []interface{}{name, value})
+			}
+		}
+	} else if m := selectVariableRegexp.FindStringSubmatch(query); m != nil {
+		value, ok := h.variables()[strings.ToLower(m[3])]
+		if !ok {
+			return nil, mysql.NewDefaultError(mysql.ER_UNKNOWN_SYSTEM_VARIABLE, m[3])
+		}
+
+		names = []string{m[1]}
+		values = [][]interface{}{{value}}
+	} else if selectTimestampRegexp.MatchString(query) {
+		names = []string{"UNIX_TIMESTAMP()"}
+		values = [][]interface{}{{time.Now().Unix()}}
+	} else if showMasterRegexp.MatchString(query) {
+		pos := h.r.Position()
+
+		names = []string{"File", "Position", "Binlog_Do_DB", "Binlog_Ignore_DB", "Executed_Gtid_Set"}
+		if len(pos.Name) > 0 {
+			values = [][]interface{}{{pos.Name, pos.Pos, "", "", ""}}
+		}
+	} else if showBinaryLogsRegexp.MatchString(query) {
+		files, err := h.r.storage.files()
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+
+		names = []string{"Log_name", "File_size"}
+		for _, name := range files {
+			size, _, _, err := h.r.storage.readLimit(name)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			values = append(values, []interface{}{name, size})
+		}
+	} else if setRegexp.MatchString(query) {
+		// we don't care about the user variables the slave sets, like @master_binlog_checksum
+		return &mysql.Result{}, nil
+	} else {
+		return nil, fmt.Errorf("query %s is not supported by relay", query)
+	}
+
+	r, err := mysql.BuildSimpleResultset(names, values, false)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return &mysql.Result{Resultset: r}, nil
+}
+
+func (h *handler) HandleRegisterSlave(s *server.Slave) error {
+	log.Infof("slave %d %s:%d registers to relay", s.ServerID, s.Host, s.Port)
+	return nil
+}
+
+func (h *handler) HandleBinlogDump(r *server.BinlogDumpRequest) (server.BinlogEventSource, error) {
+	if r.GTIDSet != nil {
+		log.Infof("slave %d dumps binlog from GTID %s", r.ServerID, r.GTIDSet)
+	} else {
+		log.Infof("slave %d dumps binlog from %v", r.ServerID, r.Position)
+	}
+
+	s, err := newBinlogSource(h.r.storage, r)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return s, nil
+}
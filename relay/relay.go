@@ -0,0 +1,243 @@
+package relay
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gdey/go-mysql/mysql"
+	"github.com/gdey/go-mysql/replication"
+	"github.com/gdey/go-mysql/server"
+	"github.com/gdey/go/log"
+	"github.com/gdey/go/sync2"
+	"github.com/juju/errors"
+)
+
+var errRelayClosed = errors.New("relay was closed")
+
+// Relay pulls the binlog from the master once, stores it in the data dir with the BinlogSyncer.StartBackup layout,
+// and serves it to many slaves with the replication protocol, the slaves can dump binlog by position or GTID.
+type Relay struct {
+	m sync.Mutex
+
+	cfg *Config
+
+	storage *storage
+
+	syncer *replication.BinlogSyncer
+	// the master binlog we are pulling, only used in the sync goroutine
+	syncName string
+
+	l   net.Listener
+	srv *server.Server
+
+	wg sync.WaitGroup
+
+	quit   chan struct{}
+	closed sync2.AtomicBool
+}
+
+func NewRelay(cfg *Config) (*Relay, error) {
+	r := new(Relay)
+
+	r.cfg = cfg
+	r.quit = make(chan struct{})
+	r.closed.Set(false)
+
+	var err error
+	if r.storage, err = newStorage(cfg.DataDir); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if r.l, err = net.Listen("tcp", cfg.Addr); err != nil {
+		r.storage.close()
+		return nil, errors.Trace(err)
+	}
+
+	p := server.NewInMemoryProvider()
+	p.AddUser(cfg.User, cfg.Password)
+
+	r.srv = server.NewServer(&server.ServerConfig{ConnConfig: server.ConnConfig{Provider: p}}, func(c *server.Conn) server.Handler {
+		return &handler{r: r}
+	})
+
+	return r, nil
+}
+
+func (r *Relay) Start() error {
+	r.wg.Add(2)
+	go r.runSync()
+	go r.runServe()
+
+	return nil
+}
+
+// Addr returns the address the relay listens for slaves.
+func (r *Relay) Addr() net.Addr {
+	return r.l.Addr()
+}
+
+// Position returns the master binlog position the relay has stored.
+func (r *Relay) Position() mysql.Position {
+	return r.storage.position()
+}
+
+func (r *Relay) isClosed() bool {
+	return r.closed.Get()
+}
+
+func (r *Relay) Close() {
+	log.Infof("close relay")
+
+	r.m.Lock()
+	if r.isClosed() {
+		r.m.Unlock()
+		return
+	}
+
+	r.closed.Set(true)
+	close(r.quit)
+
+	syncer := r.syncer
+	r.m.Unlock()
+
+	r.l.Close()
+
+	if syncer != nil {
+		syncer.Close()
+	}
+
+	// the binlog dumps are woken up by closing the storage
+	r.storage.close()
+
+	r.srv.Close()
+
+	r.wg.Wait()
+}
+
+func (r *Relay) runSync() {
+	defer r.wg.Done()
+
+	for {
+		err := r.sync()
+		if r.isClosed() {
+			return
+		}
+
+		log.Errorf("relay sync binlog err: %v, retry later", err)
+
+		select {
+		case <-r.quit:
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (r *Relay) newSyncer() (*replication.BinlogSyncer, error) {
+	host, port, err := net.SplitHostPort(r.cfg.MasterAddr)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	n, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	syncer := replication.NewBinlogSyncer(r.cfg.ServerID, r.cfg.Flavor)
+
+	r.m.Lock()
+	if r.isClosed() {
+		r.m.Unlock()
+		return nil, errRelayClosed
+	}
+	r.syncer = syncer
+	r.m.Unlock()
+
+	if err = syncer.RegisterSlave(host, uint16(n), r.cfg.MasterUser, r.cfg.MasterPassword); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	// we only need the raw data to store
+	if err = syncer.SetRawMode(true); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return syncer, nil
+}
+
+func (r *Relay) sync() error {
+	syncer, err := r.newSyncer()
+	defer func() {
+		r.m.Lock()
+		if r.syncer != nil {
+			r.syncer.Close()
+			r.syncer = nil
+		}
+		r.m.Unlock()
+	}()
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	pos := r.storage.position()
+	if len(pos.Name) == 0 {
+		pos = mysql.Position{Name: r.cfg.StartFile, Pos: 4}
+	}
+
+	r.syncName = pos.Name
+
+	log.Infof("relay start sync binlog at %v", pos)
+
+	s, err := syncer.StartSync(pos)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	for {
+		e, err := s.GetEvent()
+		if err != nil {
+			return errors.Trace(err)
+		}
+
+		if err = r.handleEvent(e); err != nil {
+			return errors.Trace(err)
+		}
+	}
+}
+
+func (r *Relay) handleEvent(e *replication.BinlogEvent) error {
+	switch e.Header.EventType {
+	case replication.ROTATE_EVENT:
+		r.syncName = string(e.Event.(*replication.RotateEvent).NextLogName)
+
+		if e.Header.Timestamp == 0 || e.Header.LogPos == 0 {
+			// fake rotate event
+			return nil
+		}
+	case replication.FORMAT_DESCRIPTION_EVENT:
+		if pos := r.storage.position(); pos.Name == r.syncName && pos.Pos > 4 {
+			// we resume in the middle of the binlog, the master sends the format description event again
+			return nil
+		}
+
+		log.Infof("relay binlog %s", r.syncName)
+
+		return errors.Trace(r.storage.newFile(r.syncName, e.RawData))
+	case replication.HEARTBEAT_EVENT:
+		return nil
+	}
+
+	return errors.Trace(r.storage.append(e.RawData))
+}
+
+func (r *Relay) runServe() {
+	defer r.wg.Done()
+
+	if err := r.srv.Serve(r.l); err != server.ErrServerClosed {
+		log.Errorf("relay serve err: %v", err)
+	}
+}
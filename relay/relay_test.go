@@ -0,0 +1,423 @@
+package relay
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gdey/go-mysql/client"
+	"github.com/gdey/go-mysql/mysql"
+	"github.com/gdey/go-mysql/replication"
+	"github.com/gdey/go-mysql/server"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) {
+	TestingT(t)
+}
+
+const testUUID = "de278ad0-2106-11e4-9f8e-6edd0ca20947"
+
+type testBinlogFile struct {
+	name    string
+	events  [][]byte
+	offsets []uint32
+}
+
+// testMaster is a fake master which serves two binlog files.
+type testMaster struct {
+	server.EmptyHandler
+
+	files []*testBinlogFile
+}
+
+func newTestMaster(c *C) *testMaster {
+	m := new(testMaster)
+
+	prev := &mysql.MysqlGTIDSet{Sets: make(map[string]*mysql.UUIDSet)}
+
+	for i := 1; i <= 2; i++ {
+		f := &testBinlogFile{name: "mysql-bin.00000" + strconv.Itoa(i)}
+
+		var buf bytes.Buffer
+		w := replication.NewBinlogWriter(&buf, 1)
+		c.Assert(w.WriteFileHeader(), IsNil)
+
+		events := []struct {
+			t replication.EventType
+			e replication.Event
+		}{
+			{replication.FORMAT_DESCRIPTION_EVENT, replication.NewFormatDescriptionEvent(replication.DefaultBinlogServerVersion, replication.BINLOG_CHECKSUM_ALG_CRC32)},
+			{replication.PREVIOUS_GTIDS_EVENT, &replication.PreviousGTIDsEvent{GTIDSets: prev}},
+			{replication.GTID_EVENT, &replication.GTIDEvent{SID: mustUUIDBytes(c), GNO: int64(i)}},
+			{replication.QUERY_EVENT, &replication.QueryEvent{Schema: []byte("test"), Query: []byte("BEGIN")}},
+			{replication.XID_EVENT, &replication.XIDEvent{XID: uint64(i)}},
+		}
+
+		if i == 1 {
+			events = append(events, struct {
+				t replication.EventType
+				e replication.Event
+			}{replication.ROTATE_EVENT, &replication.RotateEvent{Position: 4, NextLogName: []byte("mysql-bin.000002")}})
+		}
+
+		for _, e := range events {
+			offset := w.Position()
+			be, err := w.WriteEventWithHeader(&replication.EventHeader{Timestamp: 1, EventType: e.t, ServerID: 1}, e.e)
+			c.Assert(err, IsNil)
+
+			f.events = append(f.events, be.RawData)
+			f.offsets = append(f.offsets, offset)
+		}
+
+		m.files = append(m.files, f)
+
+		set, err := mysql.ParseMysqlGTIDSet(testUUID + ":1-" + strconv.Itoa(i))
+		c.Assert(err, IsNil)
+		prev = set.(*mysql.MysqlGTIDSet)
+	}
+
+	return m
+}
+
+func mustUUIDBytes(c *C) []byte {
+	set, err := mysql.ParseUUIDSet(testUUID + ":1")
+	c.Assert(err, IsNil)
+	return set.SID.Bytes()
+}
+
+func (m *testMaster) HandleQuery(query string) (*mysql.Result, error) {
+	if strings.HasPrefix(strings.ToUpper(query), "SET") {
+		return &mysql.Result{}, nil
+	}
+
+	r, err := mysql.BuildSimpleResultset([]string{"Variable_name", "Value"}, [][]interface{}{
+		[]interface{}{"binlog_checksum", "CRC32"},
+	}, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mysql.Result{Resultset: r}, nil
+}
+
+func (m *testMaster) HandleRegisterSlave(s *server.Slave) error {
+	return nil
+}
+
+func (m *testMaster) HandleBinlogDump(r *server.BinlogDumpRequest) (server.BinlogEventSource, error) {
+	s := server.NewMemoryBinlogSource()
+
+	started := false
+	hasFormat := false
+	for _, f := range m.files {
+		pos := uint32(4)
+		if !started {
+			if len(r.Position.Name) > 0 && f.name != r.Position.Name {
+				continue
+			}
+
+			started = true
+			if len(r.Position.Name) > 0 && r.Position.Pos > 4 {
+				pos = r.Position.Pos
+			}
+		}
+
+		h := &replication.EventHeader{
+			EventType: replication.ROTATE_EVENT,
+			ServerID:  1,
+			Flags:     replication.LOG_EVENT_ARTIFICIAL_F,
+		}
+		// like MySQL, the fake rotate event has a checksum after the slave gets the format description event
+		checksumAlg := replication.BINLOG_CHECKSUM_ALG_OFF
+		if hasFormat {
+			checksumAlg = replication.BINLOG_CHECKSUM_ALG_CRC32
+		}
+		rotate, err := replication.EncodeEvent(h, &replication.RotateEvent{Position: uint64(pos), NextLogName: []byte(f.name)},
+			checksumAlg)
+		if err != nil {
+			return nil, err
+		}
+		s.Append(rotate)
+
+		if pos > 4 {
+			// MySQL sets the log pos of the format description event to 0 if not starting from 4
+			data, err := setLogPos(f.events[0], 0)
+			if err != nil {
+				return nil, err
+			}
+			s.Append(data)
+		} else {
+			s.Append(f.events[0])
+		}
+		hasFormat = true
+
+		for j := 1; j < len(f.events); j++ {
+			if f.offsets[j] >= pos {
+				s.Append(f.events[j])
+			}
+		}
+	}
+
+	return s, nil
+}
+
+func setLogPos(data []byte, pos uint32) ([]byte, error) {
+	h := new(replication.EventHeader)
+	if err := h.Decode(data); err != nil {
+		return nil, err
+	}
+
+	h.LogPos = pos
+
+	e := new(replication.FormatDescriptionEvent)
+	if err := e.Decode(data[replication.EventHeaderSize:]); err != nil {
+		return nil, err
+	}
+
+	return replication.EncodeEvent(h, e, replication.BINLOG_CHECKSUM_ALG_CRC32)
+}
+
+type relayTestSuite struct {
+	master *testMaster
+	l      net.Listener
+
+	dir string
+
+	r *Relay
+}
+
+var _ = Suite(&relayTestSuite{})
+
+func (s *relayTestSuite) SetUpSuite(c *C) {
+	s.master = newTestMaster(c)
+
+	var err error
+	s.l, err = net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+
+	go func() {
+		for {
+			conn, err := s.l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				co, err := server.NewConn(conn, "root", "", s.master)
+				if err != nil {
+					return
+				}
+
+				for co.HandleCommand() == nil {
+				}
+			}()
+		}
+	}()
+
+	s.dir, err = ioutil.TempDir("", "relay")
+	c.Assert(err, IsNil)
+
+	s.startRelay(c)
+}
+
+func (s *relayTestSuite) TearDownSuite(c *C) {
+	if s.r != nil {
+		s.r.Close()
+	}
+
+	if s.l != nil {
+		s.l.Close()
+	}
+
+	os.RemoveAll(s.dir)
+}
+
+func (s *relayTestSuite) startRelay(c *C) {
+	cfg := NewDefaultConfig()
+	cfg.MasterAddr = s.l.Addr().String()
+	cfg.ServerID = 1001
+	cfg.DataDir = s.dir
+	cfg.Addr = "127.0.0.1:0"
+
+	var err error
+	s.r, err = NewRelay(cfg)
+	c.Assert(err, IsNil)
+
+	err = s.r.Start()
+	c.Assert(err, IsNil)
+
+	s.waitRelay(c)
+}
+
+// waitRelay waits the relay to get all the events of the master.
+func (s *relayTestSuite) waitRelay(c *C) {
+	f := s.master.files[1]
+	end := f.offsets[len(f.offsets)-1] + uint32(len(f.events[len(f.events)-1]))
+
+	for i := 0; i < 100; i++ {
+		if s.r.Position() == (mysql.Position{Name: f.name, Pos: end}) {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	c.Fatalf("relay position is %v, not %s:%d", s.r.Position(), f.name, end)
+}
+
+func (s *relayTestSuite) newSyncer(c *C) *replication.BinlogSyncer {
+	b := replication.NewBinlogSyncer(2001, mysql.MySQLFlavor)
+
+	host, port, err := net.SplitHostPort(s.r.Addr().String())
+	c.Assert(err, IsNil)
+
+	n, err := strconv.ParseUint(port, 10, 16)
+	c.Assert(err, IsNil)
+
+	err = b.RegisterSlave(host, uint16(n), "root", "")
+	c.Assert(err, IsNil)
+
+	return b
+}
+
+func (s *relayTestSuite) checkEvents(c *C, st *replication.BinlogStreamer, types []replication.EventType) []*replication.BinlogEvent {
+	events := make([]*replication.BinlogEvent, 0, len(types))
+
+	for _, t := range types {
+		e, err := st.GetEventTimeout(time.Second)
+		c.Assert(err, IsNil)
+		c.Assert(e.Header.EventType, Equals, t)
+
+		events = append(events, e)
+	}
+
+	return events
+}
+
+var testFileEventTypes = []replication.EventType{
+	replication.ROTATE_EVENT,
+	replication.FORMAT_DESCRIPTION_EVENT,
+	replication.PREVIOUS_GTIDS_EVENT,
+	replication.GTID_EVENT,
+	replication.QUERY_EVENT,
+	replication.XID_EVENT,
+}
+
+func (s *relayTestSuite) TestStorage(c *C) {
+	for _, f := range s.master.files {
+		data, err := ioutil.ReadFile(path.Join(s.dir, f.name))
+		c.Assert(err, IsNil)
+		c.Assert(data, DeepEquals, append(replication.BinLogFileHeader, bytes.Join(f.events, nil)...))
+	}
+}
+
+func (s *relayTestSuite) TestDumpPosition(c *C) {
+	b := s.newSyncer(c)
+	defer b.Close()
+
+	st, err := b.StartSync(mysql.Position{Name: "mysql-bin.000001", Pos: 4})
+	c.Assert(err, IsNil)
+
+	types := append(append([]replication.EventType{}, testFileEventTypes...), replication.ROTATE_EVENT)
+	types = append(types, testFileEventTypes...)
+
+	events := s.checkEvents(c, st, types)
+
+	// the real rotate event at the end of mysql-bin.000001
+	c.Assert(string(events[6].Event.(*replication.RotateEvent).NextLogName), Equals, "mysql-bin.000002")
+	c.Assert(events[12].Event.(*replication.XIDEvent).XID, Equals, uint64(2))
+
+	// no more event
+	_, err = st.GetEventTimeout(100 * time.Millisecond)
+	c.Assert(err, Equals, replication.ErrGetEventTimeout)
+
+	conn, err := client.Connect(s.r.Addr().String(), "root", "", "")
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	r, err := conn.Execute("SHOW MASTER STATUS")
+	c.Assert(err, IsNil)
+	name, _ := r.GetString(0, 0)
+	c.Assert(name, Equals, "mysql-bin.000002")
+}
+
+func (s *relayTestSuite) TestDumpMiddlePosition(c *C) {
+	b := s.newSyncer(c)
+	defer b.Close()
+
+	f := s.master.files[1]
+
+	// start from the query event
+	st, err := b.StartSync(mysql.Position{Name: f.name, Pos: f.offsets[3]})
+	c.Assert(err, IsNil)
+
+	events := s.checkEvents(c, st, []replication.EventType{
+		replication.ROTATE_EVENT,
+		replication.FORMAT_DESCRIPTION_EVENT,
+		replication.QUERY_EVENT,
+		replication.XID_EVENT,
+	})
+
+	c.Assert(events[0].Event.(*replication.RotateEvent).Position, Equals, uint64(f.offsets[3]))
+	c.Assert(events[1].Header.LogPos, Equals, uint32(0))
+}
+
+func (s *relayTestSuite) TestDumpGTID(c *C) {
+	b := s.newSyncer(c)
+	defer b.Close()
+
+	gset, err := mysql.ParseMysqlGTIDSet(testUUID + ":1")
+	c.Assert(err, IsNil)
+
+	st, err := b.StartSyncGTID(gset)
+	c.Assert(err, IsNil)
+
+	// mysql-bin.000002 has the previous GTIDs 1, so we start with it
+	events := s.checkEvents(c, st, testFileEventTypes)
+	c.Assert(string(events[0].Event.(*replication.RotateEvent).NextLogName), Equals, "mysql-bin.000002")
+	c.Assert(events[3].Event.(*replication.GTIDEvent).GNO, Equals, int64(2))
+}
+
+func (s *relayTestSuite) TestDumpGTIDSkip(c *C) {
+	b := s.newSyncer(c)
+	defer b.Close()
+
+	gset, err := mysql.ParseMysqlGTIDSet(testUUID + ":2")
+	c.Assert(err, IsNil)
+
+	st, err := b.StartSyncGTID(gset)
+	c.Assert(err, IsNil)
+
+	// start from mysql-bin.000001, and skip the transaction 2 in mysql-bin.000002
+	types := append(append([]replication.EventType{}, testFileEventTypes...), replication.ROTATE_EVENT)
+	types = append(types, testFileEventTypes[0:3]...)
+
+	events := s.checkEvents(c, st, types)
+	c.Assert(events[3].Event.(*replication.GTIDEvent).GNO, Equals, int64(1))
+
+	_, err = st.GetEventTimeout(100 * time.Millisecond)
+	c.Assert(err, Equals, replication.ErrGetEventTimeout)
+}
+
+func (s *relayTestSuite) TestResume(c *C) {
+	s.r.Close()
+	s.r = nil
+
+	// make an incomplete event at the end of the binlog
+	name := path.Join(s.dir, s.master.files[1].name)
+	st, err := os.Stat(name)
+	c.Assert(err, IsNil)
+
+	err = os.Truncate(name, st.Size()-5)
+	c.Assert(err, IsNil)
+
+	s.startRelay(c)
+
+	s.TestStorage(c)
+}
@@ -0,0 +1,387 @@
+package relay
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"time"
+
+	"github.com/gdey/go-mysql/mysql"
+	"github.com/gdey/go-mysql/replication"
+	"github.com/gdey/go-mysql/server"
+	"github.com/juju/errors"
+	"github.com/satori/go.uuid"
+)
+
+// binlogSource serves the binlog events in storage for a binlog dump.
+type binlogSource struct {
+	s *storage
+
+	nonBlock bool
+
+	// the events in gtidSet will be skipped
+	gtidSet  *mysql.MysqlGTIDSet
+	skipping bool
+
+	f           *os.File
+	name        string
+	pos         int64
+	checksumAlg byte
+	// whether the slave has got a format description event, then the fake rotate event needs a checksum too
+	hasFormat bool
+
+	// the events to send before reading the file, like the fake rotate event
+	pending [][]byte
+}
+
+func newBinlogSource(s *storage, r *server.BinlogDumpRequest) (*binlogSource, error) {
+	b := new(binlogSource)
+
+	b.s = s
+	b.nonBlock = r.NonBlock()
+
+	p := r.Position
+
+	if r.GTIDSet != nil {
+		b.gtidSet = r.GTIDSet
+
+		var err error
+		if p.Name, err = b.findGTIDFile(r.GTIDSet); err != nil {
+			return nil, errors.Trace(err)
+		}
+		p.Pos = 4
+	} else if len(p.Name) == 0 {
+		// like MySQL, start from the first binlog file
+		files, err := s.files()
+		if err != nil {
+			return nil, errors.Trace(err)
+		} else if len(files) == 0 {
+			return nil, mysql.NewError(mysql.ER_MASTER_FATAL_ERROR_READING_BINLOG, "no binlog file in relay")
+		}
+
+		p.Name = files[0]
+	}
+
+	if p.Pos < 4 {
+		p.Pos = 4
+	}
+
+	if err := b.openFile(p.Name, int64(p.Pos)); os.IsNotExist(errors.Cause(err)) {
+		return nil, mysql.NewError(mysql.ER_MASTER_FATAL_ERROR_READING_BINLOG, "could not find binlog "+p.Name+" in relay")
+	} else if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return b, nil
+}
+
+// findGTIDFile finds the last binlog file whose previous GTIDs are all in gset,
+// then the events not in gset must be in or after this file.
+func (b *binlogSource) findGTIDFile(gset *mysql.MysqlGTIDSet) (string, error) {
+	files, err := b.s.files()
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+
+	for i := len(files) - 1; i >= 0; i-- {
+		prev, err := b.readPreviousGTIDs(files[i])
+		if err != nil {
+			return "", errors.Trace(err)
+		}
+
+		if gset.Contain(prev) {
+			return files[i], nil
+		}
+	}
+
+	return "", mysql.NewError(mysql.ER_MASTER_FATAL_ERROR_READING_BINLOG,
+		"the slave is connecting using GTID, but the relay has purged binary logs containing GTIDs that the slave requires")
+}
+
+func (b *binlogSource) readPreviousGTIDs(name string) (*mysql.MysqlGTIDSet, error) {
+	f, err := os.Open(path.Join(b.s.dir, name))
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	defer f.Close()
+
+	limit, _, _, err := b.s.readLimit(name)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	pos := int64(len(replication.BinLogFileHeader))
+
+	var checksumAlg byte
+	for pos < limit {
+		data, err := readEvent(f, pos, limit)
+		if err != nil {
+			return nil, errors.Trace(err)
+		} else if data == nil {
+			break
+		}
+		pos += int64(len(data))
+
+		switch replication.EventType(data[4]) {
+		case replication.FORMAT_DESCRIPTION_EVENT:
+			e := new(replication.FormatDescriptionEvent)
+			if err = e.Decode(data[replication.EventHeaderSize:]); err != nil {
+				return nil, errors.Trace(err)
+			}
+			checksumAlg = e.ChecksumAlgorithm
+		case replication.PREVIOUS_GTIDS_EVENT:
+			e := new(replication.PreviousGTIDsEvent)
+			if err = e.Decode(eventBody(data, checksumAlg)); err != nil {
+				return nil, errors.Trace(err)
+			}
+			return e.GTIDSets, nil
+		default:
+			// PreviousGTIDsEvent must follow the FormatDescriptionEvent
+			pos = limit
+		}
+	}
+
+	return nil, mysql.NewError(mysql.ER_MASTER_FATAL_ERROR_READING_BINLOG,
+		"no PreviousGTIDsEvent in binlog "+name+", the master may not enable GTID")
+}
+
+// eventBody returns the event data without the header and checksum.
+func eventBody(data []byte, checksumAlg byte) []byte {
+	body := data[replication.EventHeaderSize:]
+	if checksumAlg == replication.BINLOG_CHECKSUM_ALG_CRC32 {
+		body = body[0 : len(body)-4]
+	}
+	return body
+}
+
+// openFile opens the binlog file, and sends a fake rotate event and its format description event first, like MySQL.
+func (b *binlogSource) openFile(name string, pos int64) error {
+	if b.f != nil {
+		b.f.Close()
+		b.f = nil
+	}
+
+	f, err := os.Open(path.Join(b.s.dir, name))
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	limit, _, _, err := b.s.readLimit(name)
+	if err != nil {
+		f.Close()
+		return errors.Trace(err)
+	}
+
+	start := int64(len(replication.BinLogFileHeader))
+	format, err := readEvent(f, start, limit)
+	if err != nil {
+		f.Close()
+		return errors.Trace(err)
+	} else if format == nil || replication.EventType(format[4]) != replication.FORMAT_DESCRIPTION_EVENT {
+		f.Close()
+		return errors.Errorf("no FormatDescriptionEvent in binlog %s", name)
+	}
+
+	e := new(replication.FormatDescriptionEvent)
+	if err = e.Decode(format[replication.EventHeaderSize:]); err != nil {
+		f.Close()
+		return errors.Trace(err)
+	}
+
+	if pos == start {
+		pos += int64(len(format))
+	} else if pos < start+int64(len(format)) {
+		f.Close()
+		return mysql.NewError(mysql.ER_MASTER_FATAL_ERROR_READING_BINLOG, "invalid binlog position")
+	} else {
+		// the format description event is not at the position the slave wants,
+		// set log pos to 0, so the slave won't update its position with it.
+		format = append([]byte(nil), format...)
+		binary.LittleEndian.PutUint32(format[13:], 0)
+		if e.ChecksumAlgorithm == replication.BINLOG_CHECKSUM_ALG_CRC32 {
+			n := len(format) - 4
+			binary.LittleEndian.PutUint32(format[n:], crc32.ChecksumIEEE(format[0:n]))
+		}
+	}
+
+	h := &replication.EventHeader{
+		EventType: replication.ROTATE_EVENT,
+		ServerID:  binary.LittleEndian.Uint32(format[5:]),
+		Flags:     replication.LOG_EVENT_ARTIFICIAL_F,
+	}
+
+	rotateChecksumAlg := replication.BINLOG_CHECKSUM_ALG_OFF
+	if b.hasFormat {
+		rotateChecksumAlg = b.checksumAlg
+	}
+
+	rotate, err := replication.EncodeEvent(h, &replication.RotateEvent{Position: uint64(pos), NextLogName: []byte(name)},
+		rotateChecksumAlg)
+	if err != nil {
+		f.Close()
+		return errors.Trace(err)
+	}
+
+	b.f = f
+	b.name = name
+	b.pos = pos
+	b.checksumAlg = e.ChecksumAlgorithm
+	b.hasFormat = true
+	b.pending = append(b.pending, rotate, format)
+
+	return nil
+}
+
+func (b *binlogSource) GetEvent(timeout time.Duration) ([]byte, error) {
+	var timer <-chan time.Time
+	if timeout > 0 {
+		timer = time.After(timeout)
+	}
+
+	for {
+		if len(b.pending) > 0 {
+			data := b.pending[0]
+			b.pending = b.pending[1:]
+			return data, nil
+		}
+
+		if b.f == nil {
+			// wait the relay to create the next binlog
+			notify, err := b.s.waitNotify()
+			if err == errStorageClosed {
+				return nil, io.EOF
+			}
+
+			if err = b.openFile(b.name, b.pos); err == nil {
+				continue
+			} else if !os.IsNotExist(errors.Cause(err)) {
+				return nil, errors.Trace(err)
+			}
+
+			if b.nonBlock {
+				return nil, io.EOF
+			}
+
+			select {
+			case <-notify:
+				continue
+			case <-timer:
+				return nil, replication.ErrGetEventTimeout
+			}
+		}
+
+		limit, active, notify, err := b.s.readLimit(b.name)
+		if err == errStorageClosed {
+			return nil, io.EOF
+		} else if err != nil {
+			return nil, errors.Trace(err)
+		}
+
+		data, err := readEvent(b.f, b.pos, limit)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+
+		if data != nil {
+			b.pos += int64(len(data))
+
+			if send, err := b.handleEvent(data); err != nil {
+				return nil, errors.Trace(err)
+			} else if send {
+				return data, nil
+			}
+			continue
+		}
+
+		if !active {
+			// the master may crash without a rotate event at the end of the binlog, go on with the next binlog
+			if next, err := b.nextFile(); err != nil {
+				return nil, errors.Trace(err)
+			} else if len(next) > 0 {
+				if err = b.openFile(next, 4); err != nil {
+					return nil, errors.Trace(err)
+				}
+				continue
+			}
+		}
+
+		if b.nonBlock {
+			return nil, io.EOF
+		}
+
+		select {
+		case <-notify:
+		case <-timer:
+			return nil, replication.ErrGetEventTimeout
+		}
+	}
+}
+
+// handleEvent returns whether we should send the event to the slave.
+func (b *binlogSource) handleEvent(data []byte) (bool, error) {
+	switch replication.EventType(data[4]) {
+	case replication.ROTATE_EVENT:
+		e := new(replication.RotateEvent)
+		if err := e.Decode(eventBody(data, b.checksumAlg)); err != nil {
+			return false, errors.Trace(err)
+		}
+
+		// go on with the next binlog after sending the rotate event
+		b.f.Close()
+		b.f = nil
+		b.name = string(e.NextLogName)
+		b.pos = int64(e.Position)
+		return true, nil
+	case replication.GTID_EVENT:
+		if b.gtidSet == nil {
+			return true, nil
+		}
+
+		e := new(replication.GTIDEvent)
+		if err := e.Decode(eventBody(data, b.checksumAlg)); err != nil {
+			return false, errors.Trace(err)
+		}
+
+		sid, err := uuid.FromBytes(e.SID)
+		if err != nil {
+			return false, errors.Trace(err)
+		}
+
+		gtid := &mysql.MysqlGTIDSet{Sets: make(map[string]*mysql.UUIDSet)}
+		gtid.AddSet(mysql.NewUUIDSet(sid, mysql.Interval{Start: e.GNO, Stop: e.GNO + 1}))
+
+		// skip the whole transaction if the slave has it
+		b.skipping = b.gtidSet.Contain(gtid)
+		return !b.skipping, nil
+	case replication.FORMAT_DESCRIPTION_EVENT, replication.PREVIOUS_GTIDS_EVENT:
+		return true, nil
+	default:
+		return !b.skipping, nil
+	}
+}
+
+func (b *binlogSource) nextFile() (string, error) {
+	files, err := b.s.files()
+	if err != nil {
+		return "", errors.Trace(err)
+	}
+
+	for i, name := range files {
+		if name == b.name && i+1 < len(files) {
+			return files[i+1], nil
+		}
+	}
+
+	return "", nil
+}
+
+func (b *binlogSource) Close() error {
+	if b.f != nil {
+		b.f.Close()
+		b.f = nil
+	}
+
+	return nil
+}
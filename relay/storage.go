@@ -0,0 +1,297 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+
+	"github.com/gdey/go-mysql/mysql"
+	"github.com/gdey/go-mysql/replication"
+	"github.com/juju/errors"
+)
+
+var errStorageClosed = errors.New("relay storage was closed")
+
+// storage keeps the binlog files in the same layout as BinlogSyncer.StartBackup,
+// every binlog file is saved with its master name in the data dir.
+type storage struct {
+	m sync.Mutex
+
+	dir string
+
+	// the binlog file we are writing
+	f    *os.File
+	name string
+	pos  uint32
+
+	checksumAlg byte
+
+	closed bool
+	notify chan struct{}
+}
+
+func newStorage(dir string) (*storage, error) {
+	s := new(storage)
+
+	s.dir = dir
+	s.checksumAlg = replication.BINLOG_CHECKSUM_ALG_OFF
+	s.notify = make(chan struct{})
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if err := s.load(); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return s, nil
+}
+
+// load opens the newest binlog file and truncates the incomplete event at its tail,
+// so we can resume from the end of the last complete event.
+func (s *storage) load() error {
+	files, err := s.files()
+	if err != nil {
+		return errors.Trace(err)
+	} else if len(files) == 0 {
+		return nil
+	}
+
+	name := files[len(files)-1]
+
+	f, err := os.OpenFile(path.Join(s.dir, name), os.O_RDWR, 0644)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	pos, format, err := replication.ScanBinlogFile(f, false)
+	if err != nil {
+		f.Close()
+		return errors.Trace(err)
+	}
+
+	s.name = name
+	s.pos = uint32(len(replication.BinLogFileHeader))
+
+	if format == nil {
+		// no complete event, we will get this file again from the beginning
+		f.Close()
+		return errors.Trace(os.Remove(path.Join(s.dir, name)))
+	}
+
+	if err = f.Truncate(pos); err != nil {
+		f.Close()
+		return errors.Trace(err)
+	}
+
+	if _, err = f.Seek(pos, os.SEEK_SET); err != nil {
+		f.Close()
+		return errors.Trace(err)
+	}
+
+	s.f = f
+	s.pos = uint32(pos)
+	s.checksumAlg = format.ChecksumAlgorithm
+
+	return nil
+}
+
+// files returns the binlog files in the data dir, ordered by the sequence number.
+func (s *storage) files() ([]string, error) {
+	infos, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	header := make([]byte, len(replication.BinLogFileHeader))
+
+	names := make([]string, 0, len(infos))
+	for _, info := range infos {
+		if !info.Mode().IsRegular() {
+			continue
+		}
+
+		f, err := os.Open(path.Join(s.dir, info.Name()))
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+
+		_, err = io.ReadFull(f, header)
+		f.Close()
+
+		if err == nil && bytes.Equal(header, replication.BinLogFileHeader) {
+			names = append(names, info.Name())
+		}
+	}
+
+	replication.SortBinlogNames(names)
+
+	return names, nil
+}
+
+func (s *storage) position() mysql.Position {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return mysql.Position{Name: s.name, Pos: s.pos}
+}
+
+func (s *storage) getChecksumAlg() byte {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.checksumAlg
+}
+
+// newFile creates the binlog file with the format description event.
+func (s *storage) newFile(name string, format []byte) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.closed {
+		return errStorageClosed
+	}
+
+	e := new(replication.FormatDescriptionEvent)
+	if err := e.Decode(format[replication.EventHeaderSize:]); err != nil {
+		return errors.Trace(err)
+	}
+
+	if s.f != nil {
+		if err := s.f.Sync(); err != nil {
+			return errors.Trace(err)
+		}
+		s.f.Close()
+		s.f = nil
+	}
+
+	f, err := os.OpenFile(path.Join(s.dir, name), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	s.f = f
+	s.name = name
+	s.pos = 0
+	s.checksumAlg = e.ChecksumAlgorithm
+
+	if err = s.write(replication.BinLogFileHeader); err != nil {
+		return errors.Trace(err)
+	}
+
+	return errors.Trace(s.write(format))
+}
+
+// append appends the event to the current binlog file.
+func (s *storage) append(data []byte) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.closed {
+		return errStorageClosed
+	} else if s.f == nil {
+		return errors.Errorf("no binlog file to write, must get FormatDescriptionEvent before")
+	}
+
+	return errors.Trace(s.write(data))
+}
+
+func (s *storage) write(data []byte) error {
+	if n, err := s.f.Write(data); err != nil {
+		return errors.Trace(err)
+	} else if n != len(data) {
+		return errors.Trace(io.ErrShortWrite)
+	}
+
+	s.pos += uint32(len(data))
+
+	// wake up the waiting readers
+	close(s.notify)
+	s.notify = make(chan struct{})
+
+	return nil
+}
+
+// readLimit returns the size of the binlog file we can read,
+// active is true if the file is still being written and its size may grow.
+func (s *storage) readLimit(name string) (limit int64, active bool, notify <-chan struct{}, err error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.closed {
+		return 0, false, nil, errStorageClosed
+	}
+
+	if name == s.name && s.f != nil {
+		return int64(s.pos), true, s.notify, nil
+	}
+
+	st, err := os.Stat(path.Join(s.dir, name))
+	if err != nil {
+		return 0, false, nil, errors.Trace(err)
+	}
+
+	return st.Size(), false, s.notify, nil
+}
+
+// waitNotify returns the channel which will be closed when the storage is written.
+func (s *storage) waitNotify() (<-chan struct{}, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.closed {
+		return nil, errStorageClosed
+	}
+
+	return s.notify, nil
+}
+
+func (s *storage) close() {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	s.closed = true
+
+	if s.f != nil {
+		s.f.Sync()
+		s.f.Close()
+		s.f = nil
+	}
+
+	close(s.notify)
+}
+
+// readEvent reads the event at pos of the file, data is nil if there is no complete event before limit.
+func readEvent(f *os.File, pos int64, limit int64) ([]byte, error) {
+	if pos+int64(replication.EventHeaderSize) > limit {
+		return nil, nil
+	}
+
+	header := make([]byte, replication.EventHeaderSize)
+	if _, err := f.ReadAt(header, pos); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	size := int64(binary.LittleEndian.Uint32(header[9:]))
+	if size < int64(replication.EventHeaderSize) {
+		return nil, errors.Errorf("invalid event size %d at %s:%d", size, f.Name(), pos)
+	} else if pos+size > limit {
+		return nil, nil
+	}
+
+	data := make([]byte, size)
+	if _, err := f.ReadAt(data, pos); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return data, nil
+}
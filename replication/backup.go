@@ -1,12 +1,18 @@
 package replication
 
 import (
+	"compress/gzip"
+	"encoding/binary"
+	"hash/crc32"
 	"io"
+	"io/ioutil"
 	"os"
 	"path"
+	"strings"
 	"time"
 
 	"github.com/gdey/go-mysql/mysql"
+	"github.com/gdey/go/log"
 	"github.com/juju/errors"
 )
 
@@ -88,3 +94,413 @@ func (b *BinlogSyncer) StartBackup(backupDir string, p mysql.Position, timeout t
 
 	return nil
 }
+
+// BackupConfig is the config for BinlogSyncer.StartBackupWithConfig.
+type BackupConfig struct {
+	// the dir to store the binlog files, every binlog file is saved with its master name
+	Dir string
+
+	// sync the binlog file to disk every SyncInterval, the file is always synced at rotate,
+	// 0 means only at rotate.
+	SyncInterval time.Duration
+
+	// verify the checksum of every event before writing it
+	VerifyChecksum bool
+
+	// purge the closed binlog files modified before PurgeAge ago, 0 means never
+	PurgeAge time.Duration
+	// keep at most PurgeCount binlog files, 0 means no limit
+	PurgeCount int
+
+	// compress the closed binlog files with gzip, the compressed file name is the binlog name with a .gz suffix
+	Compress bool
+}
+
+const compressedBinlogSuffix = ".gz"
+
+// StartBackupWithConfig backups remote binlog continuously until an error occurs or the syncer is closed.
+// If there are binlog files in the backup dir already, it resumes from the last complete event of the newest one,
+// otherwise it starts from position p.
+func (b *BinlogSyncer) StartBackupWithConfig(p mysql.Position, cfg *BackupConfig) error {
+	b.SetRawMode(true)
+
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return errors.Trace(err)
+	}
+
+	bk := &binlogBackup{cfg: cfg}
+	defer bk.close()
+
+	pos, err := bk.resume()
+	if err != nil {
+		return errors.Trace(err)
+	} else if len(pos.Name) > 0 {
+		p = pos
+	}
+
+	log.Infof("backup binlog from %v to %s", p, cfg.Dir)
+
+	s, err := b.StartSync(p)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	for {
+		var e *BinlogEvent
+		if cfg.SyncInterval > 0 {
+			e, err = s.GetEventTimeout(cfg.SyncInterval)
+		} else {
+			e, err = s.GetEvent()
+		}
+
+		if errors.Cause(err) == ErrGetEventTimeout {
+			// no event for a while, sync the data we have written
+			if err = bk.sync(); err != nil {
+				return errors.Trace(err)
+			}
+			continue
+		} else if err != nil {
+			return errors.Trace(err)
+		}
+
+		if err = bk.handleEvent(e); err != nil {
+			return errors.Trace(err)
+		}
+	}
+}
+
+type binlogBackup struct {
+	cfg *BackupConfig
+
+	// the binlog name from the rotate event
+	name string
+
+	// the binlog file we are writing
+	f           *os.File
+	fileName    string
+	checksumAlg byte
+	dirty       bool
+	lastSync    time.Time
+
+	// we resume in the middle of this binlog, so the format description event the master sends must be skipped
+	skipFormat string
+}
+
+// resume finds the newest binlog file in the backup dir and truncates the incomplete or corrupted events at its tail,
+// then returns the position to sync from.
+func (bk *binlogBackup) resume() (mysql.Position, error) {
+	files, err := backupBinlogFiles(bk.cfg.Dir)
+	if err != nil {
+		return mysql.Position{}, errors.Trace(err)
+	} else if len(files) == 0 {
+		return mysql.Position{}, nil
+	}
+
+	name := files[len(files)-1]
+	fullPath := path.Join(bk.cfg.Dir, name)
+
+	if strings.HasSuffix(name, compressedBinlogSuffix) {
+		// a compressed binlog is always complete, go on after its end
+		name = strings.TrimSuffix(name, compressedBinlogSuffix)
+
+		size, err := compressedBinlogSize(fullPath)
+		if err != nil {
+			return mysql.Position{}, errors.Trace(err)
+		}
+
+		bk.skipFormat = name
+		return mysql.Position{Name: name, Pos: uint32(size)}, nil
+	}
+
+	f, err := os.OpenFile(fullPath, os.O_RDWR, 0644)
+	if err != nil {
+		return mysql.Position{}, errors.Trace(err)
+	}
+
+	end, format, err := ScanBinlogFile(f, bk.cfg.VerifyChecksum)
+	if err != nil {
+		f.Close()
+		return mysql.Position{}, errors.Trace(err)
+	}
+
+	if format == nil {
+		// no format description event, get this binlog again from the beginning
+		f.Close()
+		if err = os.Remove(fullPath); err != nil {
+			return mysql.Position{}, errors.Trace(err)
+		}
+		return mysql.Position{Name: name, Pos: 4}, nil
+	}
+
+	if err = f.Truncate(end); err != nil {
+		f.Close()
+		return mysql.Position{}, errors.Trace(err)
+	}
+
+	if _, err = f.Seek(end, os.SEEK_SET); err != nil {
+		f.Close()
+		return mysql.Position{}, errors.Trace(err)
+	}
+
+	bk.f = f
+	bk.fileName = name
+	bk.checksumAlg = format.ChecksumAlgorithm
+	bk.lastSync = time.Now()
+	bk.skipFormat = name
+
+	return mysql.Position{Name: name, Pos: uint32(end)}, nil
+}
+
+func verifyEventChecksum(data []byte) error {
+	if len(data) < EventHeaderSize+4 {
+		return errors.Errorf("event size %d is too small for checksum", len(data))
+	}
+
+	n := len(data) - 4
+	if expected, actual := binary.LittleEndian.Uint32(data[n:]), crc32.ChecksumIEEE(data[0:n]); expected != actual {
+		return errors.Errorf("invalid event checksum %x, expected %x", actual, expected)
+	}
+
+	return nil
+}
+
+func (bk *binlogBackup) handleEvent(e *BinlogEvent) error {
+	checksumAlg := bk.checksumAlg
+
+	switch e.Header.EventType {
+	case ROTATE_EVENT:
+		bk.name = string(e.Event.(*RotateEvent).NextLogName)
+
+		if e.Header.Timestamp == 0 || e.Header.LogPos == 0 {
+			// fake rotate event
+			return nil
+		}
+	case FORMAT_DESCRIPTION_EVENT:
+		if len(bk.name) == 0 {
+			return errors.Errorf("empty binlog filename for FormateDescriptionEvent")
+		}
+
+		if bk.name == bk.skipFormat {
+			// we resume in the middle of the binlog, it already has the format description event
+			bk.skipFormat = ""
+			return nil
+		}
+
+		checksumAlg = e.Event.(*FormatDescriptionEvent).ChecksumAlgorithm
+	case HEARTBEAT_EVENT:
+		return nil
+	}
+
+	if bk.cfg.VerifyChecksum && checksumAlg == BINLOG_CHECKSUM_ALG_CRC32 {
+		if err := verifyEventChecksum(e.RawData); err != nil {
+			return errors.Annotatef(err, "binlog %s event at %d", bk.name, e.Header.LogPos)
+		}
+	}
+
+	if e.Header.EventType == FORMAT_DESCRIPTION_EVENT {
+		// FormateDescriptionEvent is the first event in binlog, we will close old one and create a new
+		if err := bk.closeFile(); err != nil {
+			return errors.Trace(err)
+		}
+
+		if err := bk.newFile(bk.name, checksumAlg); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	if bk.f == nil {
+		return errors.Errorf("no binlog file for %s event of %s", e.Header.EventType, bk.name)
+	}
+
+	if n, err := bk.f.Write(e.RawData); err != nil {
+		return errors.Trace(err)
+	} else if n != len(e.RawData) {
+		return errors.Trace(io.ErrShortWrite)
+	}
+	bk.dirty = true
+
+	if e.Header.EventType == ROTATE_EVENT {
+		// the binlog is finished
+		return errors.Trace(bk.closeFile())
+	}
+
+	if bk.cfg.SyncInterval > 0 && time.Now().Sub(bk.lastSync) >= bk.cfg.SyncInterval {
+		return errors.Trace(bk.sync())
+	}
+
+	return nil
+}
+
+func (bk *binlogBackup) newFile(name string, checksumAlg byte) error {
+	f, err := os.OpenFile(path.Join(bk.cfg.Dir, name), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	// write binlog header fe'bin'
+	if _, err = f.Write(BinLogFileHeader); err != nil {
+		f.Close()
+		return errors.Trace(err)
+	}
+
+	bk.f = f
+	bk.fileName = name
+	bk.checksumAlg = checksumAlg
+	bk.dirty = true
+
+	return errors.Trace(purgeBackupBinlogFiles(bk.cfg, name))
+}
+
+func (bk *binlogBackup) sync() error {
+	if bk.f == nil || !bk.dirty {
+		return nil
+	}
+
+	if err := bk.f.Sync(); err != nil {
+		return errors.Trace(err)
+	}
+
+	bk.dirty = false
+	bk.lastSync = time.Now()
+	return nil
+}
+
+// closeFile syncs and closes the current binlog file, then compresses it if needed.
+func (bk *binlogBackup) closeFile() error {
+	if bk.f == nil {
+		return nil
+	}
+
+	err := bk.sync()
+	bk.f.Close()
+	bk.f = nil
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	if bk.cfg.Compress {
+		return errors.Trace(compressBinlogFile(path.Join(bk.cfg.Dir, bk.fileName)))
+	}
+
+	return nil
+}
+
+func (bk *binlogBackup) close() {
+	if bk.f != nil {
+		bk.sync()
+		bk.f.Close()
+		bk.f = nil
+	}
+}
+
+// compressBinlogFile compresses the binlog file to name.gz and removes it.
+func compressBinlogFile(name string) error {
+	src, err := os.Open(name)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer src.Close()
+
+	tmpName := name + compressedBinlogSuffix + ".tmp"
+	dst, err := os.OpenFile(tmpName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Trace(err)
+	}
+	defer dst.Close()
+
+	w := gzip.NewWriter(dst)
+	if _, err = io.Copy(w, src); err != nil {
+		return errors.Trace(err)
+	}
+
+	if err = w.Close(); err != nil {
+		return errors.Trace(err)
+	}
+
+	if err = dst.Sync(); err != nil {
+		return errors.Trace(err)
+	}
+
+	if err = os.Rename(tmpName, name+compressedBinlogSuffix); err != nil {
+		return errors.Trace(err)
+	}
+
+	return errors.Trace(os.Remove(name))
+}
+
+func compressedBinlogSize(name string) (int64, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	defer f.Close()
+
+	r, err := gzip.NewReader(f)
+	if err != nil {
+		return 0, errors.Trace(err)
+	}
+	defer r.Close()
+
+	n, err := io.Copy(ioutil.Discard, r)
+	return n, errors.Trace(err)
+}
+
+// purgeBackupBinlogFiles removes the old binlog files by age and count, the binlog file we are writing is kept.
+func purgeBackupBinlogFiles(cfg *BackupConfig, current string) error {
+	if cfg.PurgeAge <= 0 && cfg.PurgeCount <= 0 {
+		return nil
+	}
+
+	files, err := backupBinlogFiles(cfg.Dir)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	for i, name := range files {
+		if strings.TrimSuffix(name, compressedBinlogSuffix) == current {
+			continue
+		}
+
+		purge := cfg.PurgeCount > 0 && len(files)-i > cfg.PurgeCount
+		if !purge && cfg.PurgeAge > 0 {
+			st, err := os.Stat(path.Join(cfg.Dir, name))
+			if err != nil {
+				return errors.Trace(err)
+			}
+			purge = time.Now().Sub(st.ModTime()) > cfg.PurgeAge
+		}
+
+		if purge {
+			log.Infof("purge backup binlog %s", name)
+			if err = os.Remove(path.Join(cfg.Dir, name)); err != nil {
+				return errors.Trace(err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// backupBinlogFiles returns the binlog files in dir ordered by their sequence number,
+// a compressed binlog file keeps the .gz suffix.
+func backupBinlogFiles(dir string) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	files := make([]string, 0, len(infos))
+	for _, info := range infos {
+		if !info.Mode().IsRegular() {
+			continue
+		}
+
+		if _, ok := BinlogSequence(info.Name()); ok {
+			files = append(files, info.Name())
+		}
+	}
+
+	SortBinlogNames(files)
+
+	return files, nil
+}
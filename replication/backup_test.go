@@ -0,0 +1,130 @@
+package replication
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/gdey/go-mysql/mysql"
+	. "gopkg.in/check.v1"
+)
+
+// testBackupBinlog writes the binlog name with a format description event, a xid event,
+// and a rotate event to next if not empty, then returns the events and file data.
+func testBackupBinlog(c *C, name string, next string) ([]*BinlogEvent, []byte) {
+	var buf bytes.Buffer
+
+	w := NewBinlogWriter(&buf, 1)
+	c.Assert(w.WriteFileHeader(), IsNil)
+
+	// the fake rotate event is not in the binlog
+	events := []*BinlogEvent{{
+		Header: &EventHeader{EventType: ROTATE_EVENT},
+		Event:  &RotateEvent{Position: 4, NextLogName: []byte(name)},
+	}}
+
+	e, err := w.WriteEventWithHeader(&EventHeader{Timestamp: 1, EventType: FORMAT_DESCRIPTION_EVENT, ServerID: 1},
+		NewFormatDescriptionEvent(DefaultBinlogServerVersion, BINLOG_CHECKSUM_ALG_CRC32))
+	c.Assert(err, IsNil)
+	events = append(events, e)
+
+	e, err = w.WriteEventWithHeader(&EventHeader{Timestamp: 1, EventType: XID_EVENT, ServerID: 1}, &XIDEvent{XID: 1})
+	c.Assert(err, IsNil)
+	events = append(events, e)
+
+	if len(next) > 0 {
+		e, err = w.WriteEventWithHeader(&EventHeader{Timestamp: 1, EventType: ROTATE_EVENT, ServerID: 1},
+			&RotateEvent{Position: 4, NextLogName: []byte(next)})
+		c.Assert(err, IsNil)
+		events = append(events, e)
+	}
+
+	return events, buf.Bytes()
+}
+
+func (_ *testDecodeSuite) TestBinlogBackup(c *C) {
+	dir, err := ioutil.TempDir("", "backup")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	cfg := &BackupConfig{
+		Dir:            dir,
+		VerifyChecksum: true,
+		PurgeCount:     2,
+		Compress:       true,
+	}
+
+	events1, data1 := testBackupBinlog(c, "mysql-bin.000001", "mysql-bin.000002")
+	events2, data2 := testBackupBinlog(c, "mysql-bin.000002", "mysql-bin.000003")
+	events3, data3 := testBackupBinlog(c, "mysql-bin.000003", "")
+
+	bk := &binlogBackup{cfg: cfg}
+
+	pos, err := bk.resume()
+	c.Assert(err, IsNil)
+	c.Assert(pos.Name, Equals, "")
+
+	for _, e := range append(events1, events2[0:3]...) {
+		c.Assert(bk.handleEvent(e), IsNil)
+	}
+	bk.close()
+
+	// mysql-bin.000001 is compressed after rotate
+	files, err := backupBinlogFiles(dir)
+	c.Assert(err, IsNil)
+	c.Assert(files, DeepEquals, []string{"mysql-bin.000001.gz", "mysql-bin.000002"})
+
+	size, err := compressedBinlogSize(path.Join(dir, "mysql-bin.000001.gz"))
+	c.Assert(err, IsNil)
+	c.Assert(size, Equals, int64(len(data1)))
+
+	// an incomplete event at the tail
+	f, err := os.OpenFile(path.Join(dir, "mysql-bin.000002"), os.O_WRONLY|os.O_APPEND, 0644)
+	c.Assert(err, IsNil)
+	_, err = f.Write(events2[3].RawData[0:10])
+	c.Assert(err, IsNil)
+	f.Close()
+
+	bk = &binlogBackup{cfg: cfg}
+	defer bk.close()
+
+	pos, err = bk.resume()
+	c.Assert(err, IsNil)
+	c.Assert(pos, Equals, mysql.Position{Name: "mysql-bin.000002", Pos: events2[2].Header.LogPos})
+
+	// the master sends the format description event again when resuming
+	c.Assert(bk.handleEvent(events2[0]), IsNil)
+	c.Assert(bk.handleEvent(events2[1]), IsNil)
+
+	// invalid checksum
+	bad := &BinlogEvent{RawData: append([]byte(nil), events2[3].RawData...), Header: events2[3].Header, Event: events2[3].Event}
+	bad.RawData[len(bad.RawData)-1]++
+	c.Assert(bk.handleEvent(bad), NotNil)
+
+	for _, e := range append(events2[3:], events3...) {
+		c.Assert(bk.handleEvent(e), IsNil)
+	}
+	c.Assert(bk.sync(), IsNil)
+
+	// mysql-bin.000001 is purged
+	files, err = backupBinlogFiles(dir)
+	c.Assert(err, IsNil)
+	c.Assert(files, DeepEquals, []string{"mysql-bin.000002.gz", "mysql-bin.000003"})
+
+	size, err = compressedBinlogSize(path.Join(dir, "mysql-bin.000002.gz"))
+	c.Assert(err, IsNil)
+	c.Assert(size, Equals, int64(len(data2)))
+
+	data, err := ioutil.ReadFile(path.Join(dir, "mysql-bin.000003"))
+	c.Assert(err, IsNil)
+	c.Assert(data, DeepEquals, data3)
+
+	// resume after a compressed binlog
+	bk.close()
+	c.Assert(os.Remove(path.Join(dir, "mysql-bin.000003")), IsNil)
+
+	pos, err = bk.resume()
+	c.Assert(err, IsNil)
+	c.Assert(pos, Equals, mysql.Position{Name: "mysql-bin.000002", Pos: uint32(len(data2))})
+}
@@ -0,0 +1,120 @@
+package replication
+
+import (
+	"bytes"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gdey/go/log"
+	"github.com/juju/errors"
+)
+
+// The binlog files saved by StartBackup and the relay have the same layout as the master,
+// every file has the master name, like mysql-bin.000001, and a compressed one has the .gz suffix.
+
+// ScanBinlogFile returns the end of the last complete event in the binlog file, and its format description event,
+// which is nil if the file has no complete event, so it should be fetched again from the beginning.
+// If verifyChecksum, it also stops before the first event with an invalid CRC32 checksum.
+func ScanBinlogFile(f *os.File, verifyChecksum bool) (int64, *FormatDescriptionEvent, error) {
+	st, err := f.Stat()
+	if err != nil {
+		return 0, nil, errors.Trace(err)
+	}
+
+	size := st.Size()
+
+	fileHeader := make([]byte, len(BinLogFileHeader))
+	if size < int64(len(fileHeader)) {
+		return 0, nil, nil
+	}
+
+	if _, err = f.ReadAt(fileHeader, 0); err != nil {
+		return 0, nil, errors.Trace(err)
+	} else if !bytes.Equal(fileHeader, BinLogFileHeader) {
+		return 0, nil, errors.Errorf("invalid binlog file header %q in %s", fileHeader, f.Name())
+	}
+
+	pos := int64(len(BinLogFileHeader))
+	var format *FormatDescriptionEvent
+
+	header := make([]byte, EventHeaderSize)
+	for pos+int64(EventHeaderSize) <= size {
+		if _, err = f.ReadAt(header, pos); err != nil {
+			return 0, nil, errors.Trace(err)
+		}
+
+		h := new(EventHeader)
+		if err = h.Decode(header); err != nil {
+			break
+		}
+
+		if h.EventSize < uint32(EventHeaderSize) || pos+int64(h.EventSize) > size {
+			// the event was not written completely
+			break
+		}
+
+		data := make([]byte, h.EventSize)
+		if _, err = f.ReadAt(data, pos); err != nil {
+			return 0, nil, errors.Trace(err)
+		}
+
+		if format == nil {
+			if h.EventType != FORMAT_DESCRIPTION_EVENT {
+				return 0, nil, errors.Errorf("the first event of binlog %s is %s, not FormatDescriptionEvent", f.Name(), h.EventType)
+			}
+
+			e := new(FormatDescriptionEvent)
+			if err = e.Decode(data[EventHeaderSize:]); err != nil {
+				break
+			}
+			format = e
+		}
+
+		if verifyChecksum && format.ChecksumAlgorithm == BINLOG_CHECKSUM_ALG_CRC32 && verifyEventChecksum(data) != nil {
+			log.Warnf("binlog %s event at %d has an invalid checksum, truncate from it", f.Name(), pos)
+			break
+		}
+
+		pos += int64(h.EventSize)
+	}
+
+	return pos, format, nil
+}
+
+// BinlogSequence returns the sequence number of the binlog, like 1 for mysql-bin.000001 and mysql-bin.000001.gz,
+// false if the name has no sequence number.
+func BinlogSequence(name string) (uint64, bool) {
+	name = strings.TrimSuffix(name, compressedBinlogSuffix)
+
+	i := strings.LastIndex(name, ".")
+	if i == -1 || i == len(name)-1 {
+		return 0, false
+	}
+
+	n, err := strconv.ParseUint(name[i+1:], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return n, true
+}
+
+// SortBinlogNames sorts the binlog names by their sequence number, the names without one by the name.
+func SortBinlogNames(names []string) {
+	sort.Sort(binlogNames(names))
+}
+
+type binlogNames []string
+
+func (s binlogNames) Len() int      { return len(s) }
+func (s binlogNames) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s binlogNames) Less(i, j int) bool {
+	a, oka := BinlogSequence(s[i])
+	b, okb := BinlogSequence(s[j])
+	if !oka || !okb || a == b {
+		return s[i] < s[j]
+	}
+	return a < b
+}
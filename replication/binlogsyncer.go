@@ -1,6 +1,7 @@
 package replication
 
 import (
+	"crypto/tls"
 	"encoding/binary"
 	"fmt"
 	"os"
@@ -32,6 +33,11 @@ type BinlogSyncer struct {
 	user      string
 	password  string
 
+	tlsMode   client.TLSMode
+	tlsConfig *tls.Config
+
+	compression int
+
 	masterID uint32
 
 	wg sync.WaitGroup
@@ -136,6 +142,24 @@ func (b *BinlogSyncer) GetMasterUUID() (uuid.UUID, error) {
 	}
 }
 
+// SetTLSConfig sets the TLS mode and config to connect the master, it must be called before RegisterSlave.
+func (b *BinlogSyncer) SetTLSConfig(mode client.TLSMode, config *tls.Config) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	b.tlsMode = mode
+	b.tlsConfig = config
+}
+
+// SetCompression sets the protocol compression algorithm to connect the master, it must be called before RegisterSlave.
+// The algorithm is mysql.MYSQL_COMPRESS_ZLIB or mysql.MYSQL_COMPRESS_ZSTD, which saves the bandwidth to pull the binlog.
+func (b *BinlogSyncer) SetCompression(algorithm int) {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	b.compression = algorithm
+}
+
 // You must register slave at first before you do other operations
 // This function will close old replication sync if exists
 func (b *BinlogSyncer) RegisterSlave(host string, port uint16, user string, password string) error {
@@ -179,7 +203,10 @@ func (b *BinlogSyncer) ReRegisterSlave() error {
 
 func (b *BinlogSyncer) registerSlave() error {
 	var err error
-	b.c, err = client.Connect(fmt.Sprintf("%s:%d", b.host, b.port), b.user, b.password, "")
+	b.c, err = client.Connect(fmt.Sprintf("%s:%d", b.host, b.port), b.user, b.password, "", func(c *client.Conn) {
+		c.SetTLS(b.tlsMode, b.tlsConfig)
+		c.SetCompression(b.compression)
+	})
 	if err != nil {
 		return errors.Trace(err)
 	}
@@ -239,7 +266,7 @@ func (b *BinlogSyncer) EnableSemiSync() error {
 	}
 
 	_, err := b.c.Execute(`SET @rpl_semi_sync_slave = 1;`)
-	if err != nil {
+	if err == nil {
 		b.semiSyncEnabled = true
 	}
 	return errors.Trace(err)
@@ -458,6 +485,9 @@ func (b *BinlogSyncer) writeRegisterSlaveCommand() error {
 }
 
 func (b *BinlogSyncer) replySemiSyncACK(p mysql.Position) error {
+	// the ack uses its own sequence, and the master won't reply it,
+	// so we must restore the sequence for the following events.
+	seq := b.c.Sequence
 	b.c.ResetSequence()
 
 	data := make([]byte, 4+1+8+len(p.Name))
@@ -472,13 +502,9 @@ func (b *BinlogSyncer) replySemiSyncACK(p mysql.Position) error {
 	copy(data[pos:], p.Name)
 
 	err := b.c.WritePacket(data)
-	if err != nil {
-		return errors.Trace(err)
-	}
 
-	_, err = b.c.ReadOKPacket()
-	if err != nil {
-	}
+	b.c.Sequence = seq
+
 	return errors.Trace(err)
 }
 
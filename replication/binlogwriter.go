@@ -0,0 +1,193 @@
+package replication
+
+import (
+	"hash/crc32"
+	"io"
+	"time"
+
+	"github.com/gdey/go-mysql/mysql"
+	"github.com/juju/errors"
+)
+
+const (
+	// the server version we use for the FormatDescriptionEvent we generate
+	DefaultBinlogServerVersion = "5.6.20-go-mysql-log"
+)
+
+// The post header lengths of MySQL 5.6, event type i's length is in position i - 1
+var defaultEventTypeHeaderLengths = []byte{
+	0x38, 0xd, 0x0, 0x8, 0x0, 0x12, 0x0, 0x4, 0x4, 0x4, 0x4, 0x12, 0x0, 0x0, 0x5c, 0x0, 0x4, 0x1a,
+	0x8, 0x0, 0x0, 0x0, 0x8, 0x8, 0x8, 0x2, 0x0, 0x0, 0x0, 0xa, 0xa, 0xa, 0x19, 0x19, 0x0,
+}
+
+type eventEncoder interface {
+	Encode() ([]byte, error)
+}
+
+// NewFormatDescriptionEvent creates a binlog v4 FormatDescriptionEvent,
+// checksumAlg may be BINLOG_CHECKSUM_ALG_OFF or BINLOG_CHECKSUM_ALG_CRC32.
+func NewFormatDescriptionEvent(serverVersion string, checksumAlg byte) *FormatDescriptionEvent {
+	e := new(FormatDescriptionEvent)
+
+	e.Version = MinBinlogVersion
+
+	e.ServerVersion = make([]byte, 50)
+	copy(e.ServerVersion, serverVersion)
+
+	e.CreateTimestamp = uint32(time.Now().Unix())
+	e.EventHeaderLength = byte(EventHeaderSize)
+	e.EventTypeHeaderLengths = defaultEventTypeHeaderLengths
+	e.ChecksumAlgorithm = checksumAlg
+
+	return e
+}
+
+// EncodeEvent encodes the event into the binlog format, including the header and the checksum.
+// The event size in header will be set, but the log position will not, it's the caller's duty.
+// The FormatDescriptionEvent is always checksummed if its server is checksum-aware,
+// the other events are checksummed if checksumAlg is BINLOG_CHECKSUM_ALG_CRC32.
+func EncodeEvent(h *EventHeader, e Event, checksumAlg byte) ([]byte, error) {
+	body, needChecksum, err := encodeEventBody(h, e, checksumAlg)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	h.EventSize = eventSize(body, needChecksum)
+
+	return packEvent(h, body, needChecksum), nil
+}
+
+func encodeEventBody(h *EventHeader, e Event, checksumAlg byte) ([]byte, bool, error) {
+	if re, ok := e.(*RowsEvent); ok {
+		re.setEventType(h.EventType)
+	}
+
+	ee, ok := e.(eventEncoder)
+	if !ok {
+		return nil, false, errors.Errorf("event %T doesn't support encoding", e)
+	}
+
+	body, err := ee.Encode()
+	if err != nil {
+		return nil, false, errors.Trace(err)
+	}
+
+	needChecksum := checksumAlg == BINLOG_CHECKSUM_ALG_CRC32
+	if fe, ok := e.(*FormatDescriptionEvent); ok {
+		needChecksum = fe.hasChecksumAlgorithm()
+	}
+
+	return body, needChecksum, nil
+}
+
+func eventSize(body []byte, needChecksum bool) uint32 {
+	size := EventHeaderSize + len(body)
+	if needChecksum {
+		size += 4
+	}
+	return uint32(size)
+}
+
+func packEvent(h *EventHeader, body []byte, needChecksum bool) []byte {
+	data := make([]byte, 0, h.EventSize)
+	data = append(data, h.Encode()...)
+	data = append(data, body...)
+
+	if needChecksum {
+		data = append(data, mysql.Uint32ToBytes(crc32.ChecksumIEEE(data))...)
+	}
+
+	return data
+}
+
+// BinlogWriter writes events into a binlog file, the output can be parsed by BinlogParser and mysqlbinlog.
+// You must write the file header and a FormatDescriptionEvent first for a new binlog file.
+type BinlogWriter struct {
+	w io.Writer
+
+	serverID uint32
+
+	format *FormatDescriptionEvent
+
+	pos uint32
+}
+
+func NewBinlogWriter(w io.Writer, serverID uint32) *BinlogWriter {
+	b := new(BinlogWriter)
+
+	b.w = w
+	b.serverID = serverID
+
+	return b
+}
+
+// WriteFileHeader writes binlog header fe'bin'
+func (b *BinlogWriter) WriteFileHeader() error {
+	if err := b.write(BinLogFileHeader); err != nil {
+		return errors.Trace(err)
+	}
+
+	b.pos = uint32(len(BinLogFileHeader))
+	return nil
+}
+
+// WriteEvent writes the event with current time and the writer's server id.
+func (b *BinlogWriter) WriteEvent(t EventType, e Event) error {
+	h := &EventHeader{
+		Timestamp: uint32(time.Now().Unix()),
+		EventType: t,
+		ServerID:  b.serverID,
+	}
+
+	_, err := b.WriteEventWithHeader(h, e)
+	return errors.Trace(err)
+}
+
+// WriteEventWithHeader uses the timestamp, type, server id and flags in header,
+// the event size and log position are updated by the writer.
+func (b *BinlogWriter) WriteEventWithHeader(h *EventHeader, e Event) (*BinlogEvent, error) {
+	checksumAlg := BINLOG_CHECKSUM_ALG_OFF
+	if fe, ok := e.(*FormatDescriptionEvent); ok {
+		b.format = fe
+	} else if b.format == nil {
+		return nil, errors.Errorf("must write FormatDescriptionEvent before %s", h.EventType)
+	}
+
+	if b.format.ChecksumAlgorithm == BINLOG_CHECKSUM_ALG_CRC32 {
+		checksumAlg = BINLOG_CHECKSUM_ALG_CRC32
+	}
+
+	body, needChecksum, err := encodeEventBody(h, e, checksumAlg)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	// log position is the position of the next event
+	h.EventSize = eventSize(body, needChecksum)
+	h.LogPos = b.pos + h.EventSize
+
+	data := packEvent(h, body, needChecksum)
+
+	if err = b.write(data); err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	b.pos = h.LogPos
+
+	return &BinlogEvent{data, h, e}, nil
+}
+
+// Position returns the position for next event
+func (b *BinlogWriter) Position() uint32 {
+	return b.pos
+}
+
+func (b *BinlogWriter) write(data []byte) error {
+	if n, err := b.w.Write(data); err != nil {
+		return errors.Trace(err)
+	} else if n != len(data) {
+		return errors.Trace(io.ErrShortWrite)
+	}
+
+	return nil
+}
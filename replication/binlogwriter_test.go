@@ -0,0 +1,178 @@
+package replication
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"time"
+
+	"github.com/gdey/go-mysql/mysql"
+	. "gopkg.in/check.v1"
+)
+
+func (_ *testDecodeSuite) TestBinlogWriter(c *C) {
+	var buf bytes.Buffer
+
+	w := NewBinlogWriter(&buf, 100)
+
+	// must write FormatDescriptionEvent first
+	err := w.WriteEvent(XID_EVENT, &XIDEvent{XID: 1})
+	c.Assert(err, NotNil)
+
+	err = w.WriteFileHeader()
+	c.Assert(err, IsNil)
+
+	err = w.WriteEvent(FORMAT_DESCRIPTION_EVENT, NewFormatDescriptionEvent(DefaultBinlogServerVersion, BINLOG_CHECKSUM_ALG_CRC32))
+	c.Assert(err, IsNil)
+
+	gset, err := mysql.ParseMysqlGTIDSet("de278ad0-2106-11e4-9f8e-6edd0ca20947:1-2")
+	c.Assert(err, IsNil)
+
+	err = w.WriteEvent(PREVIOUS_GTIDS_EVENT, &PreviousGTIDsEvent{GTIDSets: gset.(*mysql.MysqlGTIDSet)})
+	c.Assert(err, IsNil)
+
+	sid := gset.(*mysql.MysqlGTIDSet).Sets["de278ad0-2106-11e4-9f8e-6edd0ca20947"].SID.Bytes()
+	err = w.WriteEvent(GTID_EVENT, &GTIDEvent{CommitFlag: 1, SID: sid, GNO: 3})
+	c.Assert(err, IsNil)
+
+	err = w.WriteEvent(QUERY_EVENT, &QueryEvent{Schema: []byte("test"), Query: []byte("BEGIN")})
+	c.Assert(err, IsNil)
+
+	table := &TableMapEvent{
+		TableID:     110,
+		Schema:      []byte("test"),
+		Table:       []byte("t"),
+		ColumnCount: 6,
+		ColumnType: []byte{mysql.MYSQL_TYPE_LONG, mysql.MYSQL_TYPE_VARCHAR, mysql.MYSQL_TYPE_DOUBLE,
+			mysql.MYSQL_TYPE_NEWDECIMAL, mysql.MYSQL_TYPE_BLOB, mysql.MYSQL_TYPE_LONGLONG},
+		ColumnMeta: []uint16{0, 255, 8, 10<<8 | 2, 2, 0},
+		NullBitmap: []byte{0x3e},
+	}
+	err = w.WriteEvent(TABLE_MAP_EVENT, table)
+	c.Assert(err, IsNil)
+
+	rows := [][]interface{}{
+		{int32(1), "a", float64(1.5), float64(12.34), []byte("blob"), int64(-1)},
+		{int32(2), nil, float64(-2.5), float64(-1234.56), nil, int64(1 << 40)},
+	}
+
+	err = w.WriteEvent(WRITE_ROWS_EVENTv1, &RowsEvent{TableID: 110, Table: table, Rows: rows})
+	c.Assert(err, IsNil)
+
+	err = w.WriteEvent(UPDATE_ROWS_EVENTv2, &RowsEvent{TableID: 110, Table: table, Rows: rows})
+	c.Assert(err, IsNil)
+
+	// update rows must be pairs
+	err = w.WriteEvent(UPDATE_ROWS_EVENTv2, &RowsEvent{TableID: 110, Table: table, Rows: rows[0:1]})
+	c.Assert(err, NotNil)
+
+	err = w.WriteEvent(XID_EVENT, &XIDEvent{XID: 10})
+	c.Assert(err, IsNil)
+
+	err = w.WriteEvent(ROTATE_EVENT, &RotateEvent{Position: 4, NextLogName: []byte("mysql-bin.000002")})
+	c.Assert(err, IsNil)
+
+	c.Assert(w.Position(), Equals, uint32(buf.Len()))
+
+	data := buf.Bytes()
+	c.Assert(data[0:4], DeepEquals, BinLogFileHeader)
+
+	var events []*BinlogEvent
+	pos := uint32(4)
+
+	p := NewBinlogParser()
+	err = p.ParseReader(bytes.NewReader(data[4:]), func(e *BinlogEvent) error {
+		c.Assert(e.Header.ServerID, Equals, uint32(100))
+
+		raw := data[pos : pos+e.Header.EventSize]
+		c.Assert(crc32.ChecksumIEEE(raw[0:len(raw)-4]), Equals, binary.LittleEndian.Uint32(raw[len(raw)-4:]))
+
+		pos += e.Header.EventSize
+		c.Assert(e.Header.LogPos, Equals, pos)
+
+		events = append(events, e)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Assert(events, HasLen, 9)
+
+	fe := events[0].Event.(*FormatDescriptionEvent)
+	c.Assert(fe.ChecksumAlgorithm, Equals, BINLOG_CHECKSUM_ALG_CRC32)
+	c.Assert(fe.EventTypeHeaderLengths, DeepEquals, defaultEventTypeHeaderLengths)
+
+	pe := events[1].Event.(*PreviousGTIDsEvent)
+	c.Assert(pe.GTIDSets.String(), Equals, gset.String())
+
+	ge := events[2].Event.(*GTIDEvent)
+	c.Assert(ge.SID, DeepEquals, sid)
+	c.Assert(ge.GNO, Equals, int64(3))
+
+	qe := events[3].Event.(*QueryEvent)
+	c.Assert(string(qe.Schema), Equals, "test")
+	c.Assert(string(qe.Query), Equals, "BEGIN")
+
+	te := events[4].Event.(*TableMapEvent)
+	c.Assert(te.TableID, Equals, uint64(110))
+	c.Assert(te.ColumnType, DeepEquals, table.ColumnType)
+	c.Assert(te.ColumnMeta, DeepEquals, table.ColumnMeta)
+	c.Assert(te.NullBitmap, DeepEquals, table.NullBitmap)
+
+	for _, i := range []int{5, 6} {
+		re := events[i].Event.(*RowsEvent)
+		c.Assert(re.Rows, HasLen, 2)
+		c.Assert(re.Rows[0], DeepEquals, rows[0])
+		c.Assert(re.Rows[1], DeepEquals, rows[1])
+	}
+
+	xe := events[7].Event.(*XIDEvent)
+	c.Assert(xe.XID, Equals, uint64(10))
+
+	re := events[8].Event.(*RotateEvent)
+	c.Assert(re.Position, Equals, uint64(4))
+	c.Assert(string(re.NextLogName), Equals, "mysql-bin.000002")
+}
+
+func (_ *testDecodeSuite) TestEncodeValue(c *C) {
+	ts := time.Unix(1600000000, 0)
+
+	tbl := []struct {
+		tp    byte
+		meta  uint16
+		value interface{}
+		// the decoded value
+		expect interface{}
+	}{
+		{mysql.MYSQL_TYPE_STRING, uint16(mysql.MYSQL_TYPE_ENUM)<<8 | 1, int64(3), int64(3)},
+		{mysql.MYSQL_TYPE_STRING, uint16(mysql.MYSQL_TYPE_ENUM)<<8 | 2, int64(300), int64(300)},
+		{mysql.MYSQL_TYPE_STRING, uint16(mysql.MYSQL_TYPE_SET)<<8 | 1, int64(5), int64(5)},
+		{mysql.MYSQL_TYPE_STRING, uint16(mysql.MYSQL_TYPE_SET)<<8 | 2, int64(0x0102), int64(0x0102)},
+		{mysql.MYSQL_TYPE_STRING, uint16(mysql.MYSQL_TYPE_SET)<<8 | 8, int64(1<<62 | 1), int64(1<<62 | 1)},
+		{mysql.MYSQL_TYPE_TIME2, 0, "12:34:56", "12:34:56"},
+		{mysql.MYSQL_TYPE_TIME2, 0, "-12:34:56", "-12:34:56"},
+		{mysql.MYSQL_TYPE_TIME2, 1, "-00:00:01.5", "-00:00:01"},
+		{mysql.MYSQL_TYPE_TIME2, 3, "838:59:59.000", "838:59:59"},
+		{mysql.MYSQL_TYPE_TIME2, 6, "-01:02:03.000004", "-01:02:03"},
+		{mysql.MYSQL_TYPE_TIME2, 0, "00:00:00", "00:00:00"},
+		{mysql.MYSQL_TYPE_DATETIME2, 0, "2026-10-19 08:30:00", "2026-10-19 08:30:00"},
+		{mysql.MYSQL_TYPE_DATETIME2, 6, "2026-10-19 08:30:00.123456", "2026-10-19 08:30:00"},
+		{mysql.MYSQL_TYPE_DATETIME2, 0, "0000-00-00 00:00:00", "0000-00-00 00:00:00"},
+		{mysql.MYSQL_TYPE_TIMESTAMP2, 0, ts, ts.Format(mysql.TimeFormat)},
+		{mysql.MYSQL_TYPE_TIMESTAMP2, 4, ts.Add(1500 * time.Microsecond), ts.Format(mysql.TimeFormat)},
+		{mysql.MYSQL_TYPE_TIMESTAMP2, 0, "0000-00-00 00:00:00", "0000-00-00 00:00:00"},
+	}
+
+	e := &RowsEvent{}
+	for _, t := range tbl {
+		data, err := encodeValue(t.value, t.tp, t.meta)
+		c.Assert(err, IsNil, Commentf("type %d meta %d value %v", t.tp, t.meta, t.value))
+
+		v, n, err := e.decodeValue(data, t.tp, t.meta)
+		c.Assert(err, IsNil, Commentf("type %d meta %d value %v", t.tp, t.meta, t.value))
+		c.Assert(n, Equals, len(data), Commentf("type %d meta %d value %v", t.tp, t.meta, t.value))
+		c.Assert(v, DeepEquals, t.expect, Commentf("type %d meta %d value %v", t.tp, t.meta, t.value))
+	}
+
+	// precision 0 is invalid
+	_, err := encodeValue(float64(1), mysql.MYSQL_TYPE_NEWDECIMAL, 0)
+	c.Assert(err, NotNil)
+}
@@ -94,6 +94,31 @@ func (h *EventHeader) Decode(data []byte) error {
 	return nil
 }
 
+func (h *EventHeader) Encode() []byte {
+	data := make([]byte, EventHeaderSize)
+
+	pos := 0
+
+	binary.LittleEndian.PutUint32(data[pos:], h.Timestamp)
+	pos += 4
+
+	data[pos] = byte(h.EventType)
+	pos++
+
+	binary.LittleEndian.PutUint32(data[pos:], h.ServerID)
+	pos += 4
+
+	binary.LittleEndian.PutUint32(data[pos:], h.EventSize)
+	pos += 4
+
+	binary.LittleEndian.PutUint32(data[pos:], h.LogPos)
+	pos += 4
+
+	binary.LittleEndian.PutUint16(data[pos:], h.Flags)
+
+	return data
+}
+
 func (h *EventHeader) Dump(w io.Writer) {
 	fmt.Fprintf(w, "=== %s ===\n", EventType(h.EventType))
 	fmt.Fprintf(w, "Date: %s\n", time.Unix(int64(h.Timestamp), 0).Format(mysql.TimeFormat))
@@ -169,13 +194,7 @@ func (e *FormatDescriptionEvent) Decode(data []byte) error {
 		return errors.Errorf("invalid event header length %d, must 19", e.EventHeaderLength)
 	}
 
-	server := string(e.ServerVersion)
-	checksumProduct := checksumVersionProductMysql
-	if strings.Contains(strings.ToLower(server), "mariadb") {
-		checksumProduct = checksumVersionProductMariaDB
-	}
-
-	if calcVersionProduct(string(e.ServerVersion)) >= checksumProduct {
+	if e.hasChecksumAlgorithm() {
 		// here, the last 5 bytes is 1 byte check sum alg type and 4 byte checksum if exists
 		e.ChecksumAlgorithm = data[len(data)-5]
 		e.EventTypeHeaderLengths = data[pos : len(data)-5]
@@ -187,6 +206,41 @@ func (e *FormatDescriptionEvent) Decode(data []byte) error {
 	return nil
 }
 
+// Encode doesn't include the trailing checksum, the checksum algorithm byte is
+// appended only for checksum-aware server versions, like Decode expects.
+func (e *FormatDescriptionEvent) Encode() ([]byte, error) {
+	data := make([]byte, 0, 2+50+4+1+len(e.EventTypeHeaderLengths)+1)
+
+	data = append(data, mysql.Uint16ToBytes(e.Version)...)
+
+	serverVersion := make([]byte, 50)
+	copy(serverVersion, e.ServerVersion)
+	data = append(data, serverVersion...)
+
+	data = append(data, mysql.Uint32ToBytes(e.CreateTimestamp)...)
+
+	data = append(data, e.EventHeaderLength)
+
+	data = append(data, e.EventTypeHeaderLengths...)
+
+	if e.hasChecksumAlgorithm() {
+		data = append(data, e.ChecksumAlgorithm)
+	}
+
+	return data, nil
+}
+
+// MySQL 5.6.1+ and MariaDB 5.3+ write the checksum algorithm in the FormatDescriptionEvent
+func (e *FormatDescriptionEvent) hasChecksumAlgorithm() bool {
+	server := string(e.ServerVersion)
+	checksumProduct := checksumVersionProductMysql
+	if strings.Contains(strings.ToLower(server), "mariadb") {
+		checksumProduct = checksumVersionProductMariaDB
+	}
+
+	return calcVersionProduct(server) >= checksumProduct
+}
+
 func (e *FormatDescriptionEvent) Dump(w io.Writer) {
 	fmt.Fprintf(w, "Version: %d\n", e.Version)
 	fmt.Fprintf(w, "Server version: %s\n", e.ServerVersion)
@@ -208,6 +262,15 @@ func (e *RotateEvent) Decode(data []byte) error {
 	return nil
 }
 
+func (e *RotateEvent) Encode() ([]byte, error) {
+	data := make([]byte, 0, 8+len(e.NextLogName))
+
+	data = append(data, mysql.Uint64ToBytes(e.Position)...)
+	data = append(data, e.NextLogName...)
+
+	return data, nil
+}
+
 func (e *RotateEvent) Dump(w io.Writer) {
 	fmt.Fprintf(w, "Position: %d\n", e.Position)
 	fmt.Fprintf(w, "Next log name: %s\n", e.NextLogName)
@@ -223,6 +286,10 @@ func (e *XIDEvent) Decode(data []byte) error {
 	return nil
 }
 
+func (e *XIDEvent) Encode() ([]byte, error) {
+	return mysql.Uint64ToBytes(e.XID), nil
+}
+
 func (e *XIDEvent) Dump(w io.Writer) {
 	fmt.Fprintf(w, "XID: %d\n", e.XID)
 	fmt.Fprintln(w)
@@ -268,6 +335,28 @@ func (e *QueryEvent) Decode(data []byte) error {
 	return nil
 }
 
+func (e *QueryEvent) Encode() ([]byte, error) {
+	if len(e.Schema) > 255 {
+		return nil, errors.Errorf("schema %s is too long", e.Schema)
+	} else if len(e.StatusVars) > 0xffff {
+		return nil, errors.Errorf("status vars length %d is too long", len(e.StatusVars))
+	}
+
+	data := make([]byte, 0, 4+4+1+2+2+len(e.StatusVars)+len(e.Schema)+1+len(e.Query))
+
+	data = append(data, mysql.Uint32ToBytes(e.SlaveProxyID)...)
+	data = append(data, mysql.Uint32ToBytes(e.ExecutionTime)...)
+	data = append(data, byte(len(e.Schema)))
+	data = append(data, mysql.Uint16ToBytes(e.ErrorCode)...)
+	data = append(data, mysql.Uint16ToBytes(uint16(len(e.StatusVars)))...)
+	data = append(data, e.StatusVars...)
+	data = append(data, e.Schema...)
+	data = append(data, 0)
+	data = append(data, e.Query...)
+
+	return data, nil
+}
+
 func (e *QueryEvent) Dump(w io.Writer) {
 	fmt.Fprintf(w, "Slave proxy ID: %d\n", e.SlaveProxyID)
 	fmt.Fprintf(w, "Execution time: %d\n", e.ExecutionTime)
@@ -293,6 +382,20 @@ func (e *GTIDEvent) Decode(data []byte) error {
 	return nil
 }
 
+func (e *GTIDEvent) Encode() ([]byte, error) {
+	if len(e.SID) != 16 {
+		return nil, errors.Errorf("invalid SID length %d, must 16", len(e.SID))
+	}
+
+	data := make([]byte, 0, 1+16+8)
+
+	data = append(data, e.CommitFlag)
+	data = append(data, e.SID...)
+	data = append(data, mysql.Uint64ToBytes(uint64(e.GNO))...)
+
+	return data, nil
+}
+
 func (e *GTIDEvent) Dump(w io.Writer) {
 	fmt.Fprintf(w, "Commit flag: %d\n", e.CommitFlag)
 	u, _ := uuid.FromBytes(e.SID)
@@ -300,6 +403,33 @@ func (e *GTIDEvent) Dump(w io.Writer) {
 	fmt.Fprintln(w)
 }
 
+type PreviousGTIDsEvent struct {
+	GTIDSets *mysql.MysqlGTIDSet
+}
+
+func (e *PreviousGTIDsEvent) Decode(data []byte) error {
+	var err error
+	e.GTIDSets, err = mysql.DecodeMysqlGTIDSet(data)
+	return errors.Trace(err)
+}
+
+func (e *PreviousGTIDsEvent) Encode() ([]byte, error) {
+	if e.GTIDSets == nil {
+		return mysql.Uint64ToBytes(0), nil
+	}
+
+	return e.GTIDSets.Encode(), nil
+}
+
+func (e *PreviousGTIDsEvent) Dump(w io.Writer) {
+	if e.GTIDSets != nil {
+		fmt.Fprintf(w, "Previous GTIDs: %s\n", e.GTIDSets)
+	} else {
+		fmt.Fprintf(w, "Previous GTIDs: \n")
+	}
+	fmt.Fprintln(w)
+}
+
 type BeginLoadQueryEvent struct {
 	FileID    uint32
 	BlockData []byte
@@ -22,6 +22,10 @@ func (e *GenericEvent) Decode(data []byte) error {
 	return nil
 }
 
+func (e *GenericEvent) Encode() ([]byte, error) {
+	return e.Data, nil
+}
+
 //below events are generic events, maybe later I will consider handle some.
 
 // type StartEventV3 struct {
@@ -163,6 +163,8 @@ func (p *BinlogParser) parseEvent(h *EventHeader, data []byte) (Event, error) {
 				e = &RowsQueryEvent{}
 			case GTID_EVENT:
 				e = &GTIDEvent{}
+			case PREVIOUS_GTIDS_EVENT:
+				e = &PreviousGTIDsEvent{}
 			case BEGIN_LOAD_QUERY_EVENT:
 				e = &BeginLoadQueryEvent{}
 			case EXECUTE_LOAD_QUERY_EVENT:
@@ -236,31 +238,9 @@ func (p *BinlogParser) newRowsEvent(h *EventHeader) *RowsEvent {
 		e.tableIDSize = 6
 	}
 
-	e.needBitmap2 = false
 	e.tables = p.tables
 
-	switch h.EventType {
-	case WRITE_ROWS_EVENTv0:
-		e.Version = 0
-	case UPDATE_ROWS_EVENTv0:
-		e.Version = 0
-	case DELETE_ROWS_EVENTv0:
-		e.Version = 0
-	case WRITE_ROWS_EVENTv1:
-		e.Version = 1
-	case DELETE_ROWS_EVENTv1:
-		e.Version = 1
-	case UPDATE_ROWS_EVENTv1:
-		e.Version = 1
-		e.needBitmap2 = true
-	case WRITE_ROWS_EVENTv2:
-		e.Version = 2
-	case UPDATE_ROWS_EVENTv2:
-		e.Version = 2
-		e.needBitmap2 = true
-	case DELETE_ROWS_EVENTv2:
-		e.Version = 2
-	}
+	e.setEventType(h.EventType)
 
 	return e
 }
@@ -6,7 +6,9 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io"
+	"math"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gdey/go-mysql/mysql"
@@ -86,6 +88,56 @@ func (e *TableMapEvent) Decode(data []byte) error {
 	return nil
 }
 
+func (e *TableMapEvent) Encode() ([]byte, error) {
+	if len(e.Schema) > 255 || len(e.Table) > 255 {
+		return nil, errors.Errorf("schema %s or table %s is too long", e.Schema, e.Table)
+	}
+
+	if len(e.ColumnType) != int(e.ColumnCount) || len(e.ColumnMeta) != int(e.ColumnCount) {
+		return nil, errors.Errorf("column count %d is not equal to column types %d or column meta %d",
+			e.ColumnCount, len(e.ColumnType), len(e.ColumnMeta))
+	}
+
+	metaData, err := e.encodeMeta()
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	nullBitmap := e.NullBitmap
+	if nullBitmap == nil {
+		nullBitmap = make([]byte, bitmapByteSize(int(e.ColumnCount)))
+	} else if len(nullBitmap) != bitmapByteSize(int(e.ColumnCount)) {
+		return nil, errors.Errorf("invalid null bitmap length %d", len(nullBitmap))
+	}
+
+	data := make([]byte, 0, 64+len(e.Schema)+len(e.Table)+len(e.ColumnType)+len(metaData))
+
+	data = append(data, mysql.Uint64ToBytes(e.TableID)[0:e.getTableIDSize()]...)
+	data = append(data, mysql.Uint16ToBytes(e.Flags)...)
+
+	data = append(data, byte(len(e.Schema)))
+	data = append(data, e.Schema...)
+	data = append(data, 0)
+
+	data = append(data, byte(len(e.Table)))
+	data = append(data, e.Table...)
+	data = append(data, 0)
+
+	data = append(data, mysql.PutLengthEncodedInt(e.ColumnCount)...)
+	data = append(data, e.ColumnType...)
+	data = append(data, mysql.PutLengthEncodedString(metaData)...)
+	data = append(data, nullBitmap...)
+
+	return data, nil
+}
+
+func (e *TableMapEvent) getTableIDSize() int {
+	if e.tableIDSize == 0 {
+		return 6
+	}
+	return e.tableIDSize
+}
+
 func bitmapByteSize(columnCount int) int {
 	return int(columnCount+7) / 8
 }
@@ -178,6 +230,41 @@ func (e *TableMapEvent) decodeMeta(data []byte) error {
 	return nil
 }
 
+// encodeMeta is the reverse of decodeMeta
+func (e *TableMapEvent) encodeMeta() ([]byte, error) {
+	data := make([]byte, 0, 2*len(e.ColumnType))
+	for i, t := range e.ColumnType {
+		meta := e.ColumnMeta[i]
+		switch t {
+		case mysql.MYSQL_TYPE_STRING,
+			mysql.MYSQL_TYPE_NEWDECIMAL:
+			data = append(data, byte(meta>>8), byte(meta))
+		case mysql.MYSQL_TYPE_VAR_STRING,
+			mysql.MYSQL_TYPE_VARCHAR,
+			mysql.MYSQL_TYPE_BIT:
+			data = append(data, mysql.Uint16ToBytes(meta)...)
+		case mysql.MYSQL_TYPE_BLOB,
+			mysql.MYSQL_TYPE_DOUBLE,
+			mysql.MYSQL_TYPE_FLOAT,
+			mysql.MYSQL_TYPE_GEOMETRY:
+			data = append(data, byte(meta))
+		case mysql.MYSQL_TYPE_TIME2,
+			mysql.MYSQL_TYPE_DATETIME2,
+			mysql.MYSQL_TYPE_TIMESTAMP2:
+			data = append(data, byte(meta))
+		case mysql.MYSQL_TYPE_NEWDATE,
+			mysql.MYSQL_TYPE_ENUM,
+			mysql.MYSQL_TYPE_SET,
+			mysql.MYSQL_TYPE_TINY_BLOB,
+			mysql.MYSQL_TYPE_MEDIUM_BLOB,
+			mysql.MYSQL_TYPE_LONG_BLOB:
+			return nil, errors.Errorf("unsupport type in binlog %d", t)
+		}
+	}
+
+	return data, nil
+}
+
 func (e *TableMapEvent) Dump(w io.Writer) {
 	fmt.Fprintf(w, "TableID: %d\n", e.TableID)
 	fmt.Fprintf(w, "Flags: %d\n", e.Flags)
@@ -219,6 +306,33 @@ type RowsEvent struct {
 	Rows [][]interface{}
 }
 
+func (e *RowsEvent) setEventType(t EventType) {
+	e.needBitmap2 = false
+
+	switch t {
+	case WRITE_ROWS_EVENTv0:
+		e.Version = 0
+	case UPDATE_ROWS_EVENTv0:
+		e.Version = 0
+	case DELETE_ROWS_EVENTv0:
+		e.Version = 0
+	case WRITE_ROWS_EVENTv1:
+		e.Version = 1
+	case DELETE_ROWS_EVENTv1:
+		e.Version = 1
+	case UPDATE_ROWS_EVENTv1:
+		e.Version = 1
+		e.needBitmap2 = true
+	case WRITE_ROWS_EVENTv2:
+		e.Version = 2
+	case UPDATE_ROWS_EVENTv2:
+		e.Version = 2
+		e.needBitmap2 = true
+	case DELETE_ROWS_EVENTv2:
+		e.Version = 2
+	}
+}
+
 func (e *RowsEvent) Decode(data []byte) error {
 	pos := 0
 	e.TableID = mysql.FixedLengthInt(data[0:e.tableIDSize])
@@ -315,6 +429,125 @@ func (e *RowsEvent) decodeRows(data []byte, table *TableMapEvent, bitmap []byte)
 	return pos, nil
 }
 
+// Encode uses Table to know how to encode the row values,
+// the event type must be set before, see EncodeEvent.
+func (e *RowsEvent) Encode() ([]byte, error) {
+	if e.Table == nil {
+		return nil, errors.Errorf("invalid table id %d, no correspond table map event", e.TableID)
+	}
+
+	columnCount := e.ColumnCount
+	if columnCount == 0 {
+		columnCount = e.Table.ColumnCount
+	}
+
+	if columnCount != e.Table.ColumnCount {
+		return nil, errors.Errorf("column count %d is not equal to table %s column count %d",
+			columnCount, e.Table.Table, e.Table.ColumnCount)
+	}
+
+	bitCount := bitmapByteSize(int(columnCount))
+
+	bitmap1 := e.ColumnBitmap1
+	if bitmap1 == nil {
+		bitmap1 = fullBitmap(int(columnCount))
+	}
+
+	bitmap2 := e.ColumnBitmap2
+	if bitmap2 == nil {
+		bitmap2 = fullBitmap(int(columnCount))
+	}
+
+	if len(bitmap1) != bitCount || (e.needBitmap2 && len(bitmap2) != bitCount) {
+		return nil, errors.Errorf("invalid column bitmap length, must %d", bitCount)
+	}
+
+	data := make([]byte, 0, 64)
+
+	data = append(data, mysql.Uint64ToBytes(e.TableID)[0:e.getTableIDSize()]...)
+	data = append(data, mysql.Uint16ToBytes(e.Flags)...)
+
+	if e.Version == 2 {
+		data = append(data, mysql.Uint16ToBytes(uint16(len(e.ExtraData)+2))...)
+		data = append(data, e.ExtraData...)
+	}
+
+	data = append(data, mysql.PutLengthEncodedInt(columnCount)...)
+	data = append(data, bitmap1...)
+
+	if e.needBitmap2 {
+		data = append(data, bitmap2...)
+
+		if len(e.Rows)%2 != 0 {
+			return nil, errors.Errorf("update rows must be pairs of before and after image, but got %d rows", len(e.Rows))
+		}
+	}
+
+	var err error
+	for i, row := range e.Rows {
+		bitmap := bitmap1
+		if e.needBitmap2 && i%2 == 1 {
+			bitmap = bitmap2
+		}
+
+		if data, err = e.encodeRow(data, row, e.Table, bitmap); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+
+	return data, nil
+}
+
+func (e *RowsEvent) getTableIDSize() int {
+	if e.tableIDSize == 0 {
+		return 6
+	}
+	return e.tableIDSize
+}
+
+func fullBitmap(columnCount int) []byte {
+	bitmap := make([]byte, bitmapByteSize(columnCount))
+	for i := 0; i < columnCount; i++ {
+		bitmap[i/8] |= 1 << (uint(i) % 8)
+	}
+	return bitmap
+}
+
+func (e *RowsEvent) encodeRow(data []byte, row []interface{}, table *TableMapEvent, bitmap []byte) ([]byte, error) {
+	if len(row) != int(table.ColumnCount) {
+		return nil, errors.Errorf("row has %d columns, but table %s has %d", len(row), table.Table, table.ColumnCount)
+	}
+
+	count := (bitCount(bitmap) + 7) / 8
+
+	nullBitmapPos := len(data)
+	data = append(data, make([]byte, count)...)
+
+	nullbitIndex := 0
+
+	for i := 0; i < len(row); i++ {
+		if bitGet(bitmap, i) == 0 {
+			continue
+		}
+
+		if row[i] == nil {
+			data[nullBitmapPos+nullbitIndex/8] |= 1 << (uint(nullbitIndex) % 8)
+			nullbitIndex++
+			continue
+		}
+
+		v, err := encodeValue(row[i], table.ColumnType[i], table.ColumnMeta[i])
+		if err != nil {
+			return nil, errors.Annotatef(err, "column %d", i)
+		}
+		data = append(data, v...)
+
+		nullbitIndex++
+	}
+
+	return data, nil
+}
+
 // see mysql sql/log_event.cc log_event_print_value
 func (e *RowsEvent) decodeValue(data []byte, tp byte, meta uint16) (v interface{}, n int, err error) {
 	var length int = 0
@@ -424,16 +657,19 @@ func (e *RowsEvent) decodeValue(data []byte, tp byte, meta uint16) (v interface{
 			v = int64(data[0])
 			n = 1
 		case 2:
-			v = int64(binary.BigEndian.Uint16(data))
+			v = int64(binary.LittleEndian.Uint16(data))
 			n = 2
 		default:
 			err = fmt.Errorf("Unknown ENUM packlen=%d", l)
 		}
 	case mysql.MYSQL_TYPE_SET:
-		nbits := meta & 0xFF
-		n = int(nbits+7) / 8
-
-		v, err = decodeBit(data, int(nbits), n)
+		// the packlen is the bytes of the bitmap in little endian, not the bits like BIT
+		n = int(meta & 0xFF)
+		if n < 1 || n > 8 {
+			err = fmt.Errorf("Unknown SET packlen=%d", n)
+		} else {
+			v = int64(mysql.FixedLengthInt(data[0:n]))
+		}
 	case mysql.MYSQL_TYPE_BLOB:
 		switch meta {
 		case 1:
@@ -664,9 +900,9 @@ func decodeTime2(data []byte, dec uint16) (string, int, error) {
 	tmp := int64(0)
 	intPart := int64(0)
 	frac := int64(0)
+	// go doesn't fall through, the odd decimals are the same as the even ones
 	switch dec {
-	case 1:
-	case 2:
+	case 1, 2:
 		intPart = int64(mysql.BFixedLengthInt(data[0:3])) - TIMEF_INT_OFS
 		frac = int64(data[3])
 		if intPart < 0 && frac > 0 {
@@ -691,8 +927,7 @@ func decodeTime2(data []byte, dec uint16) (string, int, error) {
 			frac -= 0x100 /* -(0x100 - frac) */
 		}
 		tmp = intPart<<24 + frac*10000
-	case 3:
-	case 4:
+	case 3, 4:
 		intPart = int64(mysql.BFixedLengthInt(data[0:3])) - TIMEF_INT_OFS
 		frac = int64(binary.BigEndian.Uint16(data[3:5]))
 		if intPart < 0 && frac > 0 {
@@ -705,15 +940,14 @@ func decodeTime2(data []byte, dec uint16) (string, int, error) {
 		}
 		tmp = intPart<<24 + frac*100
 
-	case 5:
-	case 6:
+	case 5, 6:
 		tmp = int64(mysql.BFixedLengthInt(data[0:6])) - TIMEF_OFS
 	default:
 		intPart = int64(mysql.BFixedLengthInt(data[0:3])) - TIMEF_INT_OFS
 		tmp = intPart << 24
 	}
 
-	if intPart == 0 {
+	if tmp == 0 {
 		return "00:00:00", n, nil
 	}
 
@@ -737,6 +971,538 @@ func decodeTime2(data []byte, dec uint16) (string, int, error) {
 	return fmt.Sprintf("%s%02d:%02d:%02d", sign, hour, minute, second), n, nil
 }
 
+// encodeValue is the reverse of decodeValue, it accepts the values
+// decodeValue returns, and some more natural types like time.Time.
+func encodeValue(v interface{}, tp byte, meta uint16) ([]byte, error) {
+	var length int = 0
+
+	if tp == mysql.MYSQL_TYPE_STRING {
+		if meta >= 256 {
+			b0 := uint8(meta >> 8)
+			b1 := uint8(meta & 0xFF)
+
+			if b0&0x30 != 0x30 {
+				length = int(uint16(b1) | (uint16((b0&0x30)^0x30) << 4))
+				tp = byte(b0 | 0x30)
+			} else {
+				length = int(meta & 0xFF)
+				tp = b0
+			}
+		} else {
+			length = int(meta)
+		}
+	}
+
+	switch tp {
+	case mysql.MYSQL_TYPE_NULL:
+		return nil, nil
+	case mysql.MYSQL_TYPE_LONG:
+		i, err := toInt64(v)
+		return mysql.Uint32ToBytes(uint32(i)), err
+	case mysql.MYSQL_TYPE_TINY:
+		i, err := toInt64(v)
+		return []byte{byte(i)}, err
+	case mysql.MYSQL_TYPE_SHORT:
+		i, err := toInt64(v)
+		return mysql.Uint16ToBytes(uint16(i)), err
+	case mysql.MYSQL_TYPE_INT24:
+		i, err := toInt64(v)
+		return mysql.Uint32ToBytes(uint32(i))[0:3], err
+	case mysql.MYSQL_TYPE_LONGLONG:
+		i, err := toInt64(v)
+		return mysql.Uint64ToBytes(uint64(i)), err
+	case mysql.MYSQL_TYPE_NEWDECIMAL:
+		prec := uint8(meta >> 8)
+		scale := uint8(meta & 0xFF)
+		return encodeDecimal(v, int(prec), int(scale))
+	case mysql.MYSQL_TYPE_FLOAT:
+		f, err := toFloat64(v)
+		return mysql.Uint32ToBytes(math.Float32bits(float32(f))), err
+	case mysql.MYSQL_TYPE_DOUBLE:
+		f, err := toFloat64(v)
+		return mysql.Uint64ToBytes(math.Float64bits(f)), err
+	case mysql.MYSQL_TYPE_BIT:
+		nbits := ((meta >> 8) * 8) + (meta & 0xFF)
+		n := int(nbits+7) / 8
+		i, err := toInt64(v)
+		return encodeBigEndian(uint64(i), n), err
+	case mysql.MYSQL_TYPE_TIMESTAMP:
+		t, err := toTime(v, time.Local)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		var sec int64
+		if !t.IsZero() {
+			sec = t.Unix()
+		}
+		return mysql.Uint32ToBytes(uint32(sec)), nil
+	case mysql.MYSQL_TYPE_TIMESTAMP2:
+		return encodeTimestamp2(v, meta)
+	case mysql.MYSQL_TYPE_DATETIME:
+		t, err := toTime(v, time.UTC)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		var i64 uint64
+		if !t.IsZero() {
+			d := uint64(t.Year()*10000 + int(t.Month())*100 + t.Day())
+			i64 = d*1000000 + uint64(t.Hour()*10000+t.Minute()*100+t.Second())
+		}
+		return mysql.Uint64ToBytes(i64), nil
+	case mysql.MYSQL_TYPE_DATETIME2:
+		return encodeDatetime2(v, meta)
+	case mysql.MYSQL_TYPE_TIME:
+		sign, h, m, sec, _, err := parseTimeValue(v)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		i32 := int32(h*10000 + m*100 + sec)
+		if sign < 0 {
+			i32 = -i32
+		}
+		return mysql.Uint32ToBytes(uint32(i32))[0:3], nil
+	case mysql.MYSQL_TYPE_TIME2:
+		return encodeTime2(v, meta)
+	case mysql.MYSQL_TYPE_DATE:
+		t, err := toTime(v, time.UTC)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		var i32 uint32
+		if !t.IsZero() {
+			i32 = uint32(t.Year()*16*32 + int(t.Month())*32 + t.Day())
+		}
+		return mysql.Uint32ToBytes(i32)[0:3], nil
+	case mysql.MYSQL_TYPE_YEAR:
+		i, err := toInt64(v)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if i == 0 {
+			return []byte{0}, nil
+		}
+		return []byte{byte(i - 1900)}, nil
+	case mysql.MYSQL_TYPE_ENUM:
+		i, err := toInt64(v)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		switch l := meta & 0xFF; l {
+		case 1:
+			return []byte{byte(i)}, nil
+		case 2:
+			return mysql.Uint16ToBytes(uint16(i)), nil
+		default:
+			return nil, errors.Errorf("Unknown ENUM packlen=%d", l)
+		}
+	case mysql.MYSQL_TYPE_SET:
+		i, err := toInt64(v)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return mysql.Uint64ToBytes(uint64(i))[0:int(meta&0xFF)], nil
+	case mysql.MYSQL_TYPE_BLOB:
+		b, err := toBytes(v)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		if meta < 1 || meta > 4 {
+			return nil, errors.Errorf("invalid blob packlen = %d", meta)
+		} else if uint64(len(b)) >= uint64(1)<<(8*meta) {
+			return nil, errors.Errorf("blob length %d is too long for packlen %d", len(b), meta)
+		}
+		data := mysql.Uint32ToBytes(uint32(len(b)))[0:meta]
+		return append(data, b...), nil
+	case mysql.MYSQL_TYPE_VARCHAR,
+		mysql.MYSQL_TYPE_VAR_STRING:
+		return encodeString(v, int(meta))
+	case mysql.MYSQL_TYPE_STRING:
+		return encodeString(v, length)
+	default:
+		return nil, errors.Errorf("unsupport type %d in binlog and don't know how to handle", tp)
+	}
+}
+
+func encodeString(v interface{}, length int) ([]byte, error) {
+	b, err := toBytes(v)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if length < 256 {
+		if len(b) > 255 {
+			return nil, errors.Errorf("string length %d is too long", len(b))
+		}
+		return append([]byte{byte(len(b))}, b...), nil
+	}
+
+	if len(b) > 0xffff {
+		return nil, errors.Errorf("string length %d is too long", len(b))
+	}
+	return append(mysql.Uint16ToBytes(uint16(len(b))), b...), nil
+}
+
+func encodeBigEndian(v uint64, n int) []byte {
+	data := make([]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		data[i] = byte(v)
+		v >>= 8
+	}
+	return data
+}
+
+func toInt64(v interface{}) (int64, error) {
+	switch i := v.(type) {
+	case int:
+		return int64(i), nil
+	case int8:
+		return int64(i), nil
+	case int16:
+		return int64(i), nil
+	case int32:
+		return int64(i), nil
+	case int64:
+		return i, nil
+	case uint:
+		return int64(i), nil
+	case uint8:
+		return int64(i), nil
+	case uint16:
+		return int64(i), nil
+	case uint32:
+		return int64(i), nil
+	case uint64:
+		return int64(i), nil
+	case bool:
+		if i {
+			return 1, nil
+		}
+		return 0, nil
+	case string:
+		return strconv.ParseInt(i, 10, 64)
+	case []byte:
+		return strconv.ParseInt(string(i), 10, 64)
+	default:
+		return 0, errors.Errorf("invalid integer type %T", v)
+	}
+}
+
+func toFloat64(v interface{}) (float64, error) {
+	switch f := v.(type) {
+	case float32:
+		return float64(f), nil
+	case float64:
+		return f, nil
+	case string:
+		return strconv.ParseFloat(f, 64)
+	case []byte:
+		return strconv.ParseFloat(string(f), 64)
+	default:
+		i, err := toInt64(v)
+		return float64(i), err
+	}
+}
+
+func toBytes(v interface{}) ([]byte, error) {
+	switch b := v.(type) {
+	case []byte:
+		return b, nil
+	case string:
+		return hack.Slice(b), nil
+	default:
+		return nil, errors.Errorf("invalid string type %T", v)
+	}
+}
+
+// toTime accepts time.Time or the string formats decodeValue returns,
+// the zero date like 0000-00-00 is returned as the zero time.Time.
+func toTime(v interface{}, loc *time.Location) (time.Time, error) {
+	var s string
+	switch t := v.(type) {
+	case time.Time:
+		return t, nil
+	case string:
+		s = t
+	case []byte:
+		s = string(t)
+	default:
+		return time.Time{}, errors.Errorf("invalid time type %T", v)
+	}
+
+	if strings.HasPrefix(s, "0000-00-00") {
+		return time.Time{}, nil
+	}
+
+	layout := mysql.TimeFormat
+	if len(s) == len("2006-01-02") {
+		layout = "2006-01-02"
+	} else if len(s) > len(mysql.TimeFormat) {
+		layout = "2006-01-02 15:04:05.999999"
+	}
+
+	t, err := time.ParseInLocation(layout, s, loc)
+	return t, errors.Trace(err)
+}
+
+// parseTimeValue parses the time format [-]hh:mm:ss[.ffffff]
+func parseTimeValue(v interface{}) (sign int, hour int, minute int, second int, usec int, err error) {
+	var s string
+	switch t := v.(type) {
+	case string:
+		s = t
+	case []byte:
+		s = string(t)
+	case time.Duration:
+		sign = 1
+		if t < 0 {
+			sign = -1
+			t = -t
+		}
+		hour = int(t / time.Hour)
+		minute = int(t % time.Hour / time.Minute)
+		second = int(t % time.Minute / time.Second)
+		usec = int(t % time.Second / time.Microsecond)
+		return
+	default:
+		err = errors.Errorf("invalid time type %T", v)
+		return
+	}
+
+	sign = 1
+	if strings.HasPrefix(s, "-") {
+		sign = -1
+		s = s[1:]
+	}
+
+	if seps := strings.SplitN(s, ".", 2); len(seps) == 2 {
+		s = seps[0]
+		frac := (seps[1] + "000000")[0:6]
+		if usec, err = strconv.Atoi(frac); err != nil {
+			err = errors.Trace(err)
+			return
+		}
+	}
+
+	seps := strings.Split(s, ":")
+	if len(seps) != 3 {
+		err = errors.Errorf("invalid time format %s, must hh:mm:ss", s)
+		return
+	}
+
+	if hour, err = strconv.Atoi(seps[0]); err != nil {
+		err = errors.Trace(err)
+		return
+	}
+	if minute, err = strconv.Atoi(seps[1]); err != nil {
+		err = errors.Trace(err)
+		return
+	}
+	if second, err = strconv.Atoi(seps[2]); err != nil {
+		err = errors.Trace(err)
+		return
+	}
+
+	return
+}
+
+// encodeFraction encodes the fractional seconds with dec precision,
+// the storage size is (dec + 1) / 2 bytes in big endian.
+func encodeFraction(usec int, dec uint16) []byte {
+	switch dec {
+	case 1, 2:
+		return []byte{byte(usec / 10000)}
+	case 3, 4:
+		return encodeBigEndian(uint64(usec/100), 2)
+	case 5, 6:
+		return encodeBigEndian(uint64(usec), 3)
+	default:
+		return nil
+	}
+}
+
+func encodeTimestamp2(v interface{}, dec uint16) ([]byte, error) {
+	t, err := toTime(v, time.Local)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	var sec int64
+	var usec int
+	if !t.IsZero() {
+		sec = t.Unix()
+		usec = t.Nanosecond() / 1000
+	}
+
+	data := encodeBigEndian(uint64(sec), 4)
+	return append(data, encodeFraction(usec, dec)...), nil
+}
+
+func encodeDatetime2(v interface{}, dec uint16) ([]byte, error) {
+	t, err := toTime(v, time.UTC)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	var intPart int64
+	var usec int
+	if !t.IsZero() {
+		ym := int64(t.Year()*13 + int(t.Month()))
+		ymd := ym<<5 | int64(t.Day())
+		hms := int64(t.Hour()<<12 | t.Minute()<<6 | t.Second())
+		intPart = ymd<<17 | hms
+		usec = t.Nanosecond() / 1000
+	}
+
+	data := encodeBigEndian(uint64(intPart+DATETIMEF_INT_OFS), 5)
+	return append(data, encodeFraction(usec, dec)...), nil
+}
+
+func encodeTime2(v interface{}, dec uint16) ([]byte, error) {
+	sign, hour, minute, second, usec, err := parseTimeValue(v)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	intPart := int64(hour<<12 | minute<<6 | second)
+
+	switch dec {
+	case 1, 2, 3, 4:
+		frac := int64(usec / 10000)
+		size := int64(0x100)
+		if dec > 2 {
+			frac = int64(usec / 100)
+			size = 0x10000
+		}
+
+		if sign < 0 {
+			intPart = -intPart
+			if frac > 0 {
+				// see decodeTime2, negative values store the fractional part in reverse order
+				intPart--
+				frac = size - frac
+			}
+		}
+
+		data := encodeBigEndian(uint64(intPart+TIMEF_INT_OFS), 3)
+		return append(data, encodeBigEndian(uint64(frac), int(dec+1)/2)...), nil
+	case 5, 6:
+		tmp := intPart<<24 + int64(usec)
+		if sign < 0 {
+			tmp = -tmp
+		}
+		return encodeBigEndian(uint64(tmp+TIMEF_OFS), 6), nil
+	default:
+		if sign < 0 {
+			intPart = -intPart
+		}
+		return encodeBigEndian(uint64(intPart+TIMEF_INT_OFS), 3), nil
+	}
+}
+
+// encodeDecimal is the reverse of decodeDecimal, see MySQL strings/decimal.c decimal2bin
+func encodeDecimal(v interface{}, precision int, decimals int) ([]byte, error) {
+	var s string
+	switch d := v.(type) {
+	case float32:
+		s = strconv.FormatFloat(float64(d), 'f', decimals, 32)
+	case float64:
+		s = strconv.FormatFloat(d, 'f', decimals, 64)
+	case string:
+		s = d
+	case []byte:
+		s = string(d)
+	default:
+		i, err := toInt64(v)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		s = strconv.FormatInt(i, 10)
+	}
+
+	negative := false
+	if strings.HasPrefix(s, "-") {
+		negative = true
+		s = s[1:]
+	}
+
+	intStr, fracStr := s, ""
+	if seps := strings.SplitN(s, ".", 2); len(seps) == 2 {
+		intStr, fracStr = seps[0], seps[1]
+	}
+
+	intStr = strings.TrimLeft(intStr, "0")
+
+	if precision <= 0 || decimals < 0 || decimals > precision {
+		return nil, errors.Errorf("invalid decimal precision %d, decimals %d", precision, decimals)
+	}
+
+	integral := precision - decimals
+	if len(intStr) > integral {
+		return nil, errors.Errorf("decimal %s is out of range for precision %d, decimals %d", v, precision, decimals)
+	}
+
+	if len(fracStr) > decimals {
+		fracStr = fracStr[0:decimals]
+	}
+
+	intStr = strings.Repeat("0", integral-len(intStr)) + intStr
+	fracStr = fracStr + strings.Repeat("0", decimals-len(fracStr))
+
+	uncompIntegral := int(integral / digitsPerInteger)
+	uncompFractional := int(decimals / digitsPerInteger)
+	compIntegral := integral - (uncompIntegral * digitsPerInteger)
+	compFractional := decimals - (uncompFractional * digitsPerInteger)
+
+	binSize := uncompIntegral*4 + compressedBytes[compIntegral] +
+		uncompFractional*4 + compressedBytes[compFractional]
+
+	data := make([]byte, 0, binSize)
+
+	appendDigits := func(digits string, size int) error {
+		if size == 0 {
+			return nil
+		}
+		value, err := strconv.ParseUint(digits, 10, 32)
+		if err != nil {
+			return errors.Trace(err)
+		}
+		data = append(data, encodeBigEndian(value, size)...)
+		return nil
+	}
+
+	if err := appendDigits(intStr[0:compIntegral], compressedBytes[compIntegral]); err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < uncompIntegral; i++ {
+		pos := compIntegral + i*digitsPerInteger
+		if err := appendDigits(intStr[pos:pos+digitsPerInteger], 4); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := 0; i < uncompFractional; i++ {
+		pos := i * digitsPerInteger
+		if err := appendDigits(fracStr[pos:pos+digitsPerInteger], 4); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := appendDigits(fracStr[uncompFractional*digitsPerInteger:], compressedBytes[compFractional]); err != nil {
+		return nil, err
+	}
+
+	if negative {
+		for i := range data {
+			data[i] = ^data[i]
+		}
+	}
+
+	data[0] ^= 0x80
+
+	return data, nil
+}
+
 func (e *RowsEvent) Dump(w io.Writer) {
 	fmt.Fprintf(w, "TableID: %d\n", e.TableID)
 	fmt.Fprintf(w, "Flags: %d\n", e.Flags)
@@ -766,6 +1532,20 @@ func (e *RowsQueryEvent) Decode(data []byte) error {
 	return nil
 }
 
+func (e *RowsQueryEvent) Encode() ([]byte, error) {
+	// the length byte is ignored when decoding, MySQL truncates it to 255
+	l := len(e.Query)
+	if l > 255 {
+		l = 255
+	}
+
+	data := make([]byte, 0, 1+len(e.Query))
+	data = append(data, byte(l))
+	data = append(data, e.Query...)
+
+	return data, nil
+}
+
 func (e *RowsQueryEvent) Dump(w io.Writer) {
 	fmt.Fprintf(w, "Query: %s\n", e.Query)
 	fmt.Fprintln(w)
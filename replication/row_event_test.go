@@ -3,6 +3,7 @@ package replication
 import (
 	"fmt"
 
+	"github.com/gdey/go-mysql/mysql"
 	. "gopkg.in/check.v1"
 )
 
@@ -326,3 +327,38 @@ func (_ *testDecodeSuite) TestDecodeDecimal(c *C) {
 		c.Assert(value, DecodeDecimalsEquals, pos, err, tc.Expected, tc.ExpectedPos, tc.ExpectedErr, i)
 	}
 }
+
+func (_ *testDecodeSuite) TestDecodeEnumSet(c *C) {
+	// ENUM and SET come as MYSQL_TYPE_STRING with the real type and packlen in the meta
+	enum := uint16(mysql.MYSQL_TYPE_ENUM) << 8
+	set := uint16(mysql.MYSQL_TYPE_SET) << 8
+
+	testcases := []struct {
+		data []byte
+		meta uint16
+		v    int64
+		n    int
+	}{
+		{[]byte{3}, enum | 1, 3, 1},
+		// the 2 bytes ENUM index is little endian
+		{[]byte{0x02, 0x01}, enum | 2, 0x0102, 2},
+		{[]byte{0x05}, set | 1, 5, 1},
+		// the SET packlen is the bytes of the bitmap, not the bits
+		{[]byte{0x01, 0x80}, set | 2, 0x8001, 2},
+		{[]byte{0x01, 0x00, 0x00, 0x80}, set | 4, 0x80000001, 4},
+		{[]byte{0x01, 0, 0, 0, 0, 0, 0, 0x80}, set | 8, -0x7fffffffffffffff, 8},
+	}
+
+	e := new(RowsEvent)
+	for _, tc := range testcases {
+		v, n, err := e.decodeValue(tc.data, mysql.MYSQL_TYPE_STRING, tc.meta)
+		c.Assert(err, IsNil, Commentf("meta %x", tc.meta))
+		c.Assert(v, Equals, tc.v, Commentf("meta %x", tc.meta))
+		c.Assert(n, Equals, tc.n, Commentf("meta %x", tc.meta))
+	}
+
+	_, _, err := e.decodeValue([]byte{1, 2, 3}, mysql.MYSQL_TYPE_STRING, enum|3)
+	c.Assert(err, NotNil)
+	_, _, err = e.decodeValue([]byte{1}, mysql.MYSQL_TYPE_STRING, set|9)
+	c.Assert(err, NotNil)
+}
@@ -0,0 +1,146 @@
+package server
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/gdey/go-mysql/mysql"
+)
+
+// StatisticsHandler is an optional interface for Handler, implement it to answer mysql.COM_STATISTICS,
+// like mysqladmin status. The connection uptime and questions are returned if it's not implemented.
+type StatisticsHandler interface {
+	//handle mysql.COM_STATISTICS, the returned string is sent to the client as is
+	HandleStatistics() (string, error)
+}
+
+// ProcessHandler is an optional interface for Handler, implement it to handle mysql.COM_PROCESS_INFO and
+// mysql.COM_PROCESS_KILL. If it's not implemented, they are handled like the queries SHOW PROCESSLIST and KILL id.
+type ProcessHandler interface {
+	//handle mysql.COM_PROCESS_INFO, the Result should have a Resultset like SHOW PROCESSLIST
+	HandleProcessInfo() (*mysql.Result, error)
+	//handle mysql.COM_PROCESS_KILL, kill the connection with the id
+	HandleProcessKill(connectionID uint32) error
+}
+
+// DebugHandler is an optional interface for Handler, implement it to dump the debug info for mysql.COM_DEBUG.
+// The command does nothing if it's not implemented.
+type DebugHandler interface {
+	HandleDebug() error
+}
+
+// SetOptionHandler is an optional interface for Handler, implement it to check mysql.COM_SET_OPTION,
+// the multi statements capability of the connection is changed if no error is returned.
+type SetOptionHandler interface {
+	//option is mysql.MYSQL_OPTION_MULTI_STATEMENTS_ON or mysql.MYSQL_OPTION_MULTI_STATEMENTS_OFF
+	HandleSetOption(option uint16) error
+}
+
+// ShutdownHandler is an optional interface for Handler, implement it to allow mysql.COM_SHUTDOWN,
+// the command is denied if it's not implemented.
+type ShutdownHandler interface {
+	//handle mysql.COM_SHUTDOWN, the handler may call Server.Shutdown after the response is sent
+	HandleShutdown() error
+}
+
+func (c *Conn) handleStatistics() interface{} {
+	var stats string
+	if h, ok := c.h.(StatisticsHandler); ok {
+		var err error
+		if stats, err = h.HandleStatistics(); err != nil {
+			return err
+		}
+	} else {
+		uptime := time.Since(c.startTime)
+		stats = fmt.Sprintf("Uptime: %d  Threads: 1  Questions: %d  Slow queries: 0  Opens: 0  Flush tables: 0  Open tables: 0  Queries per second avg: %.3f",
+			int64(uptime/time.Second), c.questions, float64(c.questions)/uptime.Seconds())
+	}
+
+	//the string is sent without any header
+	data := make([]byte, 4, 4+len(stats))
+	data = append(data, stats...)
+	if err := c.WritePacket(data); err != nil {
+		return err
+	}
+	return noResponse{}
+}
+
+func (c *Conn) handleProcessInfo() interface{} {
+	h, ok := c.h.(ProcessHandler)
+	if !ok {
+		return c.handleQuery("SHOW PROCESSLIST")
+	}
+
+	if r, err := h.HandleProcessInfo(); err != nil {
+		return err
+	} else {
+		return r
+	}
+}
+
+func (c *Conn) handleProcessKill(data []byte) interface{} {
+	if len(data) < 4 {
+		return mysql.ErrMalformPacket
+	}
+
+	id := binary.LittleEndian.Uint32(data)
+
+	h, ok := c.h.(ProcessHandler)
+	if !ok {
+		return c.handleQuery(fmt.Sprintf("KILL %d", id))
+	}
+
+	if err := h.HandleProcessKill(id); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *Conn) handleDebug() interface{} {
+	if h, ok := c.h.(DebugHandler); ok {
+		if err := h.HandleDebug(); err != nil {
+			return err
+		}
+	}
+
+	return eofResponse{}
+}
+
+func (c *Conn) handleSetOption(data []byte) interface{} {
+	if len(data) < 2 {
+		return mysql.ErrMalformPacket
+	}
+
+	option := binary.LittleEndian.Uint16(data)
+	if option != mysql.MYSQL_OPTION_MULTI_STATEMENTS_ON && option != mysql.MYSQL_OPTION_MULTI_STATEMENTS_OFF {
+		return mysql.NewDefaultError(mysql.ER_UNKNOWN_COM_ERROR)
+	}
+
+	if h, ok := c.h.(SetOptionHandler); ok {
+		if err := h.HandleSetOption(option); err != nil {
+			return err
+		}
+	}
+
+	if option == mysql.MYSQL_OPTION_MULTI_STATEMENTS_ON {
+		c.capability |= mysql.CLIENT_MULTI_STATEMENTS
+	} else {
+		c.capability &= ^mysql.CLIENT_MULTI_STATEMENTS
+	}
+	c.session.multiStatements = option == mysql.MYSQL_OPTION_MULTI_STATEMENTS_ON
+
+	return eofResponse{}
+}
+
+func (c *Conn) handleShutdown() interface{} {
+	h, ok := c.h.(ShutdownHandler)
+	if !ok {
+		return mysql.NewDefaultError(mysql.ER_SPECIFIC_ACCESS_DENIED_ERROR, "SHUTDOWN")
+	}
+
+	if err := h.HandleShutdown(); err != nil {
+		return err
+	}
+	return eofResponse{}
+}
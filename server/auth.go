@@ -2,15 +2,31 @@ package server
 
 import (
 	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
 	"encoding/binary"
+	"hash"
 
 	"github.com/gdey/go-mysql/mysql"
 )
 
+//caching_sha2_password auth more data
+const cachingSha2FastAuthSuccess = 3
+
 func (c *Conn) writeInitialHandshake() error {
 	capability := mysql.CLIENT_LONG_PASSWORD | mysql.CLIENT_LONG_FLAG |
 		mysql.CLIENT_CONNECT_WITH_DB | mysql.CLIENT_PROTOCOL_41 |
-		mysql.CLIENT_TRANSACTIONS | mysql.CLIENT_SECURE_CONNECTION
+		mysql.CLIENT_TRANSACTIONS | mysql.CLIENT_SECURE_CONNECTION |
+		mysql.CLIENT_PLUGIN_AUTH | mysql.CLIENT_PLUGIN_AUTH_LENENC_CLIENT_DATA |
+		mysql.CLIENT_COMPRESS | mysql.CLIENT_ZSTD_COMPRESSION_ALGORITHM | mysql.CLIENT_LOCAL_FILES |
+		mysql.CLIENT_MULTI_STATEMENTS | mysql.CLIENT_MULTI_RESULTS | mysql.CLIENT_PS_MULTI_RESULTS |
+		mysql.CLIENT_DEPRECATE_EOF | mysql.CLIENT_SESSION_TRACK
+
+	if c.tlsConfig != nil {
+		capability |= mysql.CLIENT_SSL
+	}
 
 	data := make([]byte, 4, 128)
 
@@ -37,7 +53,7 @@ func (c *Conn) writeInitialHandshake() error {
 	data = append(data, uint8(mysql.DEFAULT_COLLATION_ID))
 
 	//status
-	data = append(data, byte(c.status), byte(c.status>>8))
+	data = append(data, byte(c.session.status), byte(c.session.status>>8))
 
 	//below 13 byte may not be used
 	//capability flag upper 2 bytes, using default capability here
@@ -55,65 +71,308 @@ func (c *Conn) writeInitialHandshake() error {
 	//filter [00]
 	data = append(data, 0)
 
+	//auth-plugin name
+	data = append(data, c.cfg.authPlugin()...)
+	data = append(data, 0)
+
 	return c.WritePacket(data)
 }
 
-func (c *Conn) readHandshakeResponse(password string) error {
+func (c *Conn) readHandshakeResponse() error {
 	data, err := c.ReadPacket()
 
 	if err != nil {
 		return err
 	}
 
+	//capability, max packet size, charset and reserved
+	if len(data) < 4+4+1+23 {
+		return mysql.ErrMalformPacket
+	}
+
 	pos := 0
 
 	//capability
 	c.capability = binary.LittleEndian.Uint32(data[:4])
 	pos += 4
 
+	//the SSL request only has capability, max packet size, charset and reserved
+	if c.capability&mysql.CLIENT_SSL > 0 && len(data) == 4+4+1+23 {
+		if c.tlsConfig == nil {
+			return mysql.NewDefaultError(mysql.ER_HANDSHAKE_ERROR)
+		}
+
+		if err = c.UpgradeTLS(tls.Server, c.tlsConfig); err != nil {
+			return err
+		}
+
+		//the client sends the handshake response again with TLS
+		if data, err = c.ReadPacket(); err != nil {
+			return err
+		} else if len(data) < 4+4+1+23 {
+			return mysql.ErrMalformPacket
+		}
+
+		c.capability = binary.LittleEndian.Uint32(data[:4])
+	}
+
+	c.session.multiStatements = c.capability&mysql.CLIENT_MULTI_STATEMENTS > 0
+
 	//skip max packet size
 	pos += 4
 
-	//charset, skip, if you want to use another charset, use set names
-	//c.collation = CollationId(data[pos])
+	//charset
+	c.session.collation = data[pos]
 	pos++
 
 	//skip reserved 23[00]
 	pos += 23
 
 	//user name
-	user := string(data[pos : pos+bytes.IndexByte(data[pos:], 0)])
-	pos += len(user) + 1
-
-	if c.user != user {
-		return mysql.NewDefaultError(mysql.ER_NO_SUCH_USER, user, c.RemoteAddr().String())
+	end := bytes.IndexByte(data[pos:], 0)
+	if end == -1 {
+		return mysql.ErrMalformPacket
 	}
+	user := string(data[pos : pos+end])
+	pos += len(user) + 1
 
 	//auth length and auth
-	authLen := int(data[pos])
-	pos++
-	auth := data[pos : pos+authLen]
+	var auth []byte
+	if c.capability&(mysql.CLIENT_PLUGIN_AUTH_LENENC_CLIENT_DATA|mysql.CLIENT_SECURE_CONNECTION) > 0 {
+		if pos >= len(data) {
+			return mysql.ErrMalformPacket
+		}
 
-	checkAuth := mysql.CalcPassword(c.salt, []byte(password))
+		authLen, n := uint64(data[pos]), 1
+		if c.capability&mysql.CLIENT_PLUGIN_AUTH_LENENC_CLIENT_DATA > 0 {
+			var ok bool
+			if authLen, n, ok = readLengthEncodedInt(data, pos); !ok {
+				return mysql.ErrMalformPacket
+			}
+		}
+		pos += n
+
+		if authLen > uint64(len(data)-pos) {
+			return mysql.ErrMalformPacket
+		}
+		auth = data[pos : pos+int(authLen)]
+		pos += int(authLen)
+	} else {
+		if end = bytes.IndexByte(data[pos:], 0); end == -1 {
+			return mysql.ErrMalformPacket
+		}
+		auth = data[pos : pos+end]
+		pos += len(auth) + 1
+	}
 
-	if !bytes.Equal(auth, checkAuth) {
-		return mysql.NewDefaultError(mysql.ER_ACCESS_DENIED_ERROR, c.RemoteAddr().String(), c.user, "Yes")
+	var db string
+	if c.capability&mysql.CLIENT_CONNECT_WITH_DB > 0 && len(data[pos:]) > 0 {
+		if end = bytes.IndexByte(data[pos:], 0); end == -1 {
+			return mysql.ErrMalformPacket
+		}
+		db = string(data[pos : pos+end])
+		pos += len(db) + 1
 	}
 
-	pos += authLen
+	//the client without CLIENT_PLUGIN_AUTH uses mysql_native_password
+	plugin := mysql.AUTH_NATIVE_PASSWORD
+	if c.capability&mysql.CLIENT_PLUGIN_AUTH > 0 && len(data[pos:]) > 0 {
+		name := data[pos:]
+		if end := bytes.IndexByte(name, 0); end != -1 {
+			name = name[:end]
+		}
+		plugin = string(name)
+		pos += len(name) + 1
+	}
 
-	if c.capability|mysql.CLIENT_CONNECT_WITH_DB > 0 {
-		if len(data[pos:]) == 0 {
-			return nil
+	//skip the connect attributes
+	if c.capability&mysql.CLIENT_CONNECT_ATTRS > 0 && len(data) > pos {
+		attrsLen, n, ok := readLengthEncodedInt(data, pos)
+		if !ok || attrsLen > uint64(len(data)-pos-n) {
+			return mysql.ErrMalformPacket
 		}
+		pos += n + int(attrsLen)
+	}
 
-		db := string(data[pos : pos+bytes.IndexByte(data[pos:], 0)])
-		pos += len(db) + 1
+	//zstd compression level
+	if c.capability&mysql.CLIENT_ZSTD_COMPRESSION_ALGORITHM > 0 && len(data) > pos {
+		c.zstdLevel = int(data[pos])
+	}
+
+	if err = c.authenticate(user, auth, plugin); err != nil {
+		return err
+	}
 
+	if len(db) > 0 {
 		if err = c.h.UseDB(db); err != nil {
 			return err
 		}
+		c.session.db = db
+	}
+
+	return nil
+}
+
+// readLengthEncodedInt reads the length encoded integer at pos, ok is false if the data is too short.
+func readLengthEncodedInt(data []byte, pos int) (num uint64, n int, ok bool) {
+	if pos >= len(data) {
+		return 0, 0, false
+	}
+
+	switch data[pos] {
+	case 0xfc:
+		n = 3
+	case 0xfd:
+		n = 4
+	case 0xfe:
+		n = 9
+	default:
+		n = 1
+	}
+	if pos+n > len(data) {
+		return 0, 0, false
+	}
+
+	num, _, n = mysql.LengthEncodedInt(data[pos:])
+	return num, n, true
+}
+
+// authenticate checks the auth data of the user with the password hash from the credential provider,
+// the client is asked to switch to our auth plugin if it uses another one. The unknown user is checked
+// with a dummy hash, and denied like a wrong password.
+func (c *Conn) authenticate(user string, auth []byte, plugin string) error {
+	expected := c.cfg.authPlugin()
+
+	stored, found, err := c.credentialHash(user, expected)
+	if err != nil {
+		return err
+	} else if !found {
+		stored = passwordHash(expected, c.salt)
+	}
+
+	if plugin != expected {
+		if c.capability&mysql.CLIENT_PLUGIN_AUTH == 0 {
+			return mysql.NewDefaultError(mysql.ER_NOT_SUPPORTED_AUTH_MODE)
+		}
+
+		if auth, err = c.writeAuthSwitchRequest(expected); err != nil {
+			return err
+		}
+		plugin = expected
+	}
+
+	if plugin != mysql.AUTH_NATIVE_PASSWORD && plugin != mysql.AUTH_CACHING_SHA2_PASSWORD {
+		return mysql.NewDefaultError(mysql.ER_NOT_SUPPORTED_AUTH_MODE)
 	}
 
+	ok := checkAuth(plugin, c.salt, auth, stored) && found
+	if ok && plugin == mysql.AUTH_CACHING_SHA2_PASSWORD && len(stored) > 0 {
+		//we know the password hash, so the fast auth always works
+		if err = c.writeAuthMoreData([]byte{cachingSha2FastAuthSuccess}); err != nil {
+			return err
+		}
+	}
+
+	if !ok {
+		usePassword := "NO"
+		if len(auth) > 0 {
+			usePassword = "YES"
+		}
+		return mysql.NewDefaultError(mysql.ER_ACCESS_DENIED_ERROR, user, c.RemoteAddr().String(), usePassword)
+	}
+
+	// the user is only seen after it's authenticated
+	c.session.user = user
+
 	return nil
 }
+
+// credentialHash returns the password hash of the user for the plugin, from the HashCredentialProvider if implemented.
+func (c *Conn) credentialHash(user string, plugin string) ([]byte, bool, error) {
+	if p, ok := c.cfg.Provider.(HashCredentialProvider); ok {
+		return p.GetCredentialHash(user, plugin)
+	}
+
+	password, found, err := c.cfg.Provider.GetCredential(user)
+	if err != nil || !found {
+		return nil, found, err
+	}
+	return passwordHash(plugin, []byte(password)), true, nil
+}
+
+func newPasswordHash(plugin string) hash.Hash {
+	if plugin == mysql.AUTH_CACHING_SHA2_PASSWORD {
+		return sha256.New()
+	}
+	return sha1.New()
+}
+
+// passwordHash returns SHA1(SHA1(password)) for mysql_native_password, or SHA256(SHA256(password))
+// for caching_sha2_password, the empty password has the empty hash.
+func passwordHash(plugin string, password []byte) []byte {
+	if len(password) == 0 {
+		return nil
+	}
+
+	h := newPasswordHash(plugin)
+	h.Write(password)
+	stage1 := h.Sum(nil)
+
+	h.Reset()
+	h.Write(stage1)
+	return h.Sum(nil)
+}
+
+// checkAuth checks the auth data of the client with the password hash in constant time, the auth data is
+// XOR(SHA1(password), SHA1(salt, hash)) for mysql_native_password, like mysql.CalcPassword, and
+// XOR(SHA256(password), SHA256(hash, salt)) for caching_sha2_password, like mysql.CalcCachingSha2Password.
+func checkAuth(plugin string, salt []byte, auth []byte, stored []byte) bool {
+	if len(stored) == 0 {
+		return len(auth) == 0
+	}
+
+	h := newPasswordHash(plugin)
+	if plugin == mysql.AUTH_CACHING_SHA2_PASSWORD {
+		h.Write(stored)
+		h.Write(salt)
+	} else {
+		h.Write(salt)
+		h.Write(stored)
+	}
+	stage1 := h.Sum(nil)
+	if len(auth) != len(stage1) {
+		return false
+	}
+
+	//the client proves it knows SHA(password), whose hash is the stored one
+	for i := range stage1 {
+		stage1[i] ^= auth[i]
+	}
+
+	h.Reset()
+	h.Write(stage1)
+	return subtle.ConstantTimeCompare(h.Sum(nil), stored) == 1
+}
+
+// writeAuthSwitchRequest asks the client to use plugin with our salt, and returns the new auth data.
+func (c *Conn) writeAuthSwitchRequest(plugin string) ([]byte, error) {
+	data := make([]byte, 4, 4+1+len(plugin)+1+len(c.salt)+1)
+	data = append(data, mysql.EOF_HEADER)
+	data = append(data, plugin...)
+	data = append(data, 0)
+	data = append(data, c.salt...)
+	data = append(data, 0)
+
+	if err := c.WritePacket(data); err != nil {
+		return nil, err
+	}
+
+	return c.ReadPacket()
+}
+
+func (c *Conn) writeAuthMoreData(more []byte) error {
+	data := make([]byte, 4, 4+1+len(more))
+	data = append(data, mysql.MORE_DATA_HEADER)
+	data = append(data, more...)
+	return c.WritePacket(data)
+}
@@ -0,0 +1,261 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+
+	"github.com/gdey/go-mysql/client"
+	"github.com/gdey/go-mysql/mysql"
+	"github.com/gdey/go-mysql/packet"
+	"github.com/juju/errors"
+	. "gopkg.in/check.v1"
+)
+
+type authTestSuite struct {
+	provider *InMemoryProvider
+}
+
+var _ = Suite(&authTestSuite{})
+
+func (s *authTestSuite) SetUpSuite(c *C) {
+	s.provider = NewInMemoryProvider()
+	s.provider.AddUser("root", "")
+	s.provider.AddUser("alice", "alice_pw")
+	s.provider.AddUser("bob", "bob_pw")
+}
+
+// serve accepts one connection with the auth plugin, and returns the connection or the handshake error.
+func (s *authTestSuite) serve(c *C, plugin string) (string, chan *Conn, chan error) {
+	return s.serveProvider(c, s.provider, plugin)
+}
+
+func (s *authTestSuite) serveProvider(c *C, provider CredentialProvider, plugin string) (string, chan *Conn, chan error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+
+	conns := make(chan *Conn, 1)
+	errs := make(chan error, 1)
+
+	go func() {
+		defer l.Close()
+
+		conn, err := l.Accept()
+		if err != nil {
+			errs <- err
+			return
+		}
+
+		co, err := NewConnWithConfig(conn, &ConnConfig{Provider: provider, AuthPlugin: plugin}, &testHandler{})
+		if err != nil {
+			errs <- err
+			return
+		}
+
+		conns <- co
+		for co.HandleCommand() == nil {
+		}
+	}()
+
+	return l.Addr().String(), conns, errs
+}
+
+func (s *authTestSuite) TestAuth(c *C) {
+	for _, plugin := range []string{mysql.AUTH_NATIVE_PASSWORD, mysql.AUTH_CACHING_SHA2_PASSWORD} {
+		for _, user := range []string{"root", "alice", "bob"} {
+			password, _, _ := s.provider.GetCredential(user)
+
+			addr, conns, _ := s.serve(c, plugin)
+
+			conn, err := client.Connect(addr, user, password, "test")
+			c.Assert(err, IsNil)
+
+			co := <-conns
+			c.Assert(co.GetUser(), Equals, user)
+			c.Assert(co.GetDatabase(), Equals, "test")
+
+			c.Assert(conn.UseDB("test2"), IsNil)
+			c.Assert(conn.Ping(), IsNil)
+			c.Assert(co.GetDatabase(), Equals, "test2")
+
+			conn.Close()
+		}
+
+		addr, _, errs := s.serve(c, plugin)
+		_, err := client.Connect(addr, "alice", "bob_pw", "")
+		c.Assert(err, NotNil)
+		c.Assert(errors.Cause(err).(*mysql.MyError).Code, Equals, uint16(mysql.ER_ACCESS_DENIED_ERROR))
+		c.Assert((<-errs).(*mysql.MyError).Code, Equals, uint16(mysql.ER_ACCESS_DENIED_ERROR))
+
+		// the unknown user is denied like a wrong password
+		addr, _, errs = s.serve(c, plugin)
+		_, err = client.Connect(addr, "nobody", "nobody_pw", "")
+		c.Assert(err, NotNil)
+		c.Assert(errors.Cause(err).(*mysql.MyError).Code, Equals, uint16(mysql.ER_ACCESS_DENIED_ERROR))
+		c.Assert((<-errs).(*mysql.MyError).Code, Equals, uint16(mysql.ER_ACCESS_DENIED_ERROR))
+
+		addr, _, errs = s.serve(c, plugin)
+		_, err = client.Connect(addr, "nobody", "", "")
+		c.Assert(err, NotNil)
+		c.Assert((<-errs).(*mysql.MyError).Code, Equals, uint16(mysql.ER_ACCESS_DENIED_ERROR))
+	}
+}
+
+// hashProvider keeps the password hashes of the users for the plugin.
+type hashProvider struct {
+	plugin string
+	hashes map[string][]byte
+}
+
+func (p *hashProvider) GetCredential(user string) (string, bool, error) {
+	return "", false, errors.New("no password")
+}
+
+func (p *hashProvider) GetCredentialHash(user string, plugin string) ([]byte, bool, error) {
+	if plugin != p.plugin {
+		return nil, false, errors.Errorf("no %s hash", plugin)
+	}
+	hash, ok := p.hashes[user]
+	return hash, ok, nil
+}
+
+func (s *authTestSuite) TestHashProvider(c *C) {
+	// SHA1(SHA1("alice_pw")) and SHA256(SHA256("alice_pw"))
+	hashes := map[string]string{
+		mysql.AUTH_NATIVE_PASSWORD:       "d47e3747dbb371d8d9723cbcbbefe68a709b0e99",
+		mysql.AUTH_CACHING_SHA2_PASSWORD: "6db1a84f5710a560800220a5fd75b6b845ee4f391df5e5001e52d82d9e93b097",
+	}
+
+	for plugin, h := range hashes {
+		hash, err := hex.DecodeString(h)
+		c.Assert(err, IsNil)
+		p := &hashProvider{plugin: plugin, hashes: map[string][]byte{"alice": hash, "root": nil}}
+
+		addr, conns, _ := s.serveProvider(c, p, plugin)
+		conn, err := client.Connect(addr, "alice", "alice_pw", "")
+		c.Assert(err, IsNil)
+		c.Assert((<-conns).GetUser(), Equals, "alice")
+		conn.Close()
+
+		addr, _, _ = s.serveProvider(c, p, plugin)
+		conn, err = client.Connect(addr, "root", "", "")
+		c.Assert(err, IsNil)
+		conn.Close()
+
+		addr, _, errs := s.serveProvider(c, p, plugin)
+		_, err = client.Connect(addr, "alice", "bob_pw", "")
+		c.Assert(err, NotNil)
+		c.Assert((<-errs).(*mysql.MyError).Code, Equals, uint16(mysql.ER_ACCESS_DENIED_ERROR))
+	}
+}
+
+func (s *authTestSuite) TestMalformedHandshake(c *C) {
+	capability := mysql.CLIENT_PROTOCOL_41 | mysql.CLIENT_SECURE_CONNECTION |
+		mysql.CLIENT_PLUGIN_AUTH_LENENC_CLIENT_DATA | mysql.CLIENT_CONNECT_ATTRS
+	response := func(body ...byte) []byte {
+		data := make([]byte, 4+4+4+1+23)
+		binary.LittleEndian.PutUint32(data[4:], capability)
+		return append(data, body...)
+	}
+
+	for _, data := range [][]byte{
+		append(make([]byte, 4), 1, 2, 3),
+		// no end of the user name
+		response('a', 'b'),
+		// the length encoded auth length and the auth are short
+		response('a', 0, 0xfc, 1),
+		response('a', 0, 20, 1, 2),
+		// the connect attributes are short
+		response('a', 0, 0, 0xfe),
+	} {
+		addr, _, errs := s.serve(c, mysql.AUTH_NATIVE_PASSWORD)
+		conn, err := net.Dial("tcp", addr)
+		c.Assert(err, IsNil)
+
+		pc := packet.NewConn(conn)
+		_, err = pc.ReadPacket()
+		c.Assert(err, IsNil)
+		c.Assert(pc.WritePacket(data), IsNil)
+		c.Assert(<-errs, Equals, mysql.ErrMalformPacket, Commentf("%v", data[4:]))
+		pc.Close()
+	}
+}
+
+// authSwitch connects the server with mysql_native_password,
+// and returns the plugin the server asks to switch to, and whether the auth succeeds.
+func (s *authTestSuite) authSwitch(c *C, addr string, user string, password string) (string, bool) {
+	conn, err := net.Dial("tcp", addr)
+	c.Assert(err, IsNil)
+
+	pc := packet.NewConn(conn)
+	defer pc.Close()
+
+	data, err := pc.ReadPacket()
+	c.Assert(err, IsNil)
+
+	// salt part 1 is after the version and connection id, part 2 is after the 13 bytes of capability and status
+	pos := 1 + bytes.IndexByte(data[1:], 0) + 1 + 4
+	salt := append([]byte{}, data[pos:pos+8]...)
+	pos += 8 + 1 + 2 + 1 + 2 + 2 + 1 + 10
+	salt = append(salt, data[pos:pos+12]...)
+
+	capability := mysql.CLIENT_PROTOCOL_41 | mysql.CLIENT_SECURE_CONNECTION | mysql.CLIENT_PLUGIN_AUTH
+
+	auth := mysql.CalcPassword(salt, []byte(password))
+
+	data = make([]byte, 4+4+4+1+23)
+	data[4], data[5], data[6], data[7] = byte(capability), byte(capability>>8), byte(capability>>16), byte(capability>>24)
+	data = append(data, user...)
+	data = append(data, 0, byte(len(auth)))
+	data = append(data, auth...)
+	data = append(data, mysql.AUTH_NATIVE_PASSWORD...)
+	data = append(data, 0)
+	c.Assert(pc.WritePacket(data), IsNil)
+
+	data, err = pc.ReadPacket()
+	c.Assert(err, IsNil)
+
+	var plugin string
+	if data[0] == mysql.EOF_HEADER {
+		end := bytes.IndexByte(data[1:], 0)
+		plugin = string(data[1 : 1+end])
+		salt = data[1+end+1 : len(data)-1]
+
+		auth = mysql.CalcCachingSha2Password(salt, []byte(password))
+		c.Assert(pc.WritePacket(append(make([]byte, 4), auth...)), IsNil)
+
+		data, err = pc.ReadPacket()
+		c.Assert(err, IsNil)
+
+		if data[0] == mysql.MORE_DATA_HEADER {
+			// fast auth success
+			c.Assert(data[1], Equals, byte(cachingSha2FastAuthSuccess))
+
+			data, err = pc.ReadPacket()
+			c.Assert(err, IsNil)
+		}
+	}
+
+	return plugin, data[0] == mysql.OK_HEADER
+}
+
+func (s *authTestSuite) TestAuthSwitch(c *C) {
+	addr, conns, _ := s.serve(c, mysql.AUTH_CACHING_SHA2_PASSWORD)
+	plugin, ok := s.authSwitch(c, addr, "alice", "alice_pw")
+	c.Assert(plugin, Equals, mysql.AUTH_CACHING_SHA2_PASSWORD)
+	c.Assert(ok, Equals, true)
+	c.Assert((<-conns).GetUser(), Equals, "alice")
+
+	addr, _, errs := s.serve(c, mysql.AUTH_CACHING_SHA2_PASSWORD)
+	plugin, ok = s.authSwitch(c, addr, "alice", "wrong")
+	c.Assert(plugin, Equals, mysql.AUTH_CACHING_SHA2_PASSWORD)
+	c.Assert(ok, Equals, false)
+	c.Assert(<-errs, NotNil)
+
+	// no switch with the same plugin
+	addr, _, _ = s.serve(c, mysql.AUTH_NATIVE_PASSWORD)
+	plugin, ok = s.authSwitch(c, addr, "bob", "bob_pw")
+	c.Assert(plugin, Equals, "")
+	c.Assert(ok, Equals, true)
+}
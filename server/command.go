@@ -6,8 +6,13 @@ import (
 
 	"github.com/gdey/go-mysql/mysql"
 	"github.com/gdey/go/hack"
+	"github.com/juju/errors"
 )
 
+// ErrCloseConn is returned by the handler to close the client connection without a response,
+// like the session state is lost, so the client sees the bad connection instead of an error.
+var ErrCloseConn = errors.New("close the connection")
+
 type Handler interface {
 	//handle mysql.COM_INIT_DB command, you can check whether the dbName is valid, or other.
 	UseDB(dbName string) error
@@ -25,19 +30,41 @@ type Handler interface {
 }
 
 func (c *Conn) HandleCommand() error {
+	data, err := c.readCommand()
+	if err != nil {
+		return err
+	}
+
+	return c.handleCommand(data)
+}
+
+// readCommand reads the next command, the connection is closed if failed.
+func (c *Conn) readCommand() ([]byte, error) {
 	if c.Conn == nil {
-		return fmt.Errorf("connection closed")
+		return nil, fmt.Errorf("connection closed")
 	}
 
 	data, err := c.ReadPacket()
 	if err != nil {
 		c.Close()
-		return err
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// handleCommand dispatches the command, and writes the response, with the interceptors if any.
+func (c *Conn) handleCommand(data []byte) error {
+	if c.cfg != nil && len(c.cfg.Interceptors) > 0 {
+		return c.interceptCommand(data, c.cfg.Interceptors)
 	}
+	return c.dispatchCommand(data)
+}
 
+func (c *Conn) dispatchCommand(data []byte) error {
 	v := c.dispatch(data)
 
-	err = c.writeValue(v)
+	err := c.writeValue(v)
 
 	if c.Conn != nil {
 		c.ResetSequence()
@@ -53,22 +80,29 @@ func (c *Conn) dispatch(data []byte) interface{} {
 	cmd := data[0]
 	data = data[1:]
 
+	c.questions++
+
 	switch cmd {
 	case mysql.COM_QUIT:
 		c.Close()
 		return noResponse{}
 	case mysql.COM_QUERY:
-		if r, err := c.h.HandleQuery(hack.String(data)); err != nil {
-			return err
-		} else {
-			return r
+		if c.handleReplicationQuery(hack.String(data)) {
+			return nil
+		}
+
+		if v, ok := c.handleLocalInfile(hack.String(data)); ok {
+			return v
 		}
+
+		return c.handleQuery(hack.String(data))
 	case mysql.COM_PING:
 		return nil
 	case mysql.COM_INIT_DB:
 		if err := c.h.UseDB(hack.String(data)); err != nil {
 			return err
 		} else {
+			c.session.SetDatabase(string(data))
 			return nil
 		}
 	case mysql.COM_FIELD_LIST:
@@ -112,6 +146,42 @@ func (c *Conn) dispatch(data []byte) interface{} {
 		} else {
 			return r
 		}
+	case mysql.COM_REGISTER_SLAVE:
+		if err := c.handleRegisterSlave(data); err != nil {
+			return err
+		} else {
+			return nil
+		}
+	case mysql.COM_BINLOG_DUMP:
+		if err := c.handleBinlogDump(data); err != nil {
+			return err
+		} else {
+			return noResponse{}
+		}
+	case mysql.COM_BINLOG_DUMP_GTID:
+		if err := c.handleBinlogDumpGTID(data); err != nil {
+			return err
+		} else {
+			return noResponse{}
+		}
+	case mysql.COM_STMT_FETCH:
+		return c.handleStmtFetch(data)
+	case mysql.COM_STATISTICS:
+		return c.handleStatistics()
+	case mysql.COM_PROCESS_INFO:
+		return c.handleProcessInfo()
+	case mysql.COM_PROCESS_KILL:
+		return c.handleProcessKill(data)
+	case mysql.COM_DEBUG:
+		return c.handleDebug()
+	case mysql.COM_SET_OPTION:
+		return c.handleSetOption(data)
+	case mysql.COM_SHUTDOWN:
+		return c.handleShutdown()
+	case mysql.COM_CHANGE_USER:
+		return c.handleChangeUser(data)
+	case mysql.COM_RESET_CONNECTION:
+		return c.handleResetConnection()
 	default:
 		msg := fmt.Sprintf("command %d is not supported now", cmd)
 		return mysql.NewError(mysql.ER_UNKNOWN_ERROR, msg)
@@ -120,6 +190,26 @@ func (c *Conn) dispatch(data []byte) interface{} {
 	return fmt.Errorf("command %d is not handled correctly", cmd)
 }
 
+// handleQuery passes the query to the handler, the result is streamed if it's a StreamingHandler,
+// or several results are returned if it's a MultiResultHandler.
+func (c *Conn) handleQuery(query string) interface{} {
+	if h, ok := c.h.(StreamingHandler); ok {
+		return c.streamResult(false, func(w *ResultsetWriter) (*mysql.Result, error) {
+			return h.HandleQueryStreaming(query, w)
+		})
+	}
+
+	if h, ok := c.h.(MultiResultHandler); ok {
+		return c.handleMultiQuery(h, query)
+	}
+
+	if r, err := c.h.HandleQuery(query); err != nil {
+		return err
+	} else {
+		return r
+	}
+}
+
 type EmptyHandler struct {
 }
 
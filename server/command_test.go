@@ -0,0 +1,275 @@
+package server
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/gdey/go-mysql/client"
+	"github.com/gdey/go-mysql/mysql"
+	"github.com/juju/errors"
+	. "gopkg.in/check.v1"
+)
+
+type commandTestSuite struct {
+	s    *Server
+	addr string
+}
+
+var _ = Suite(&commandTestSuite{})
+
+// queryHandler fails every query with the query as the message, so we know the query a command is handled as.
+type queryHandler struct {
+	EmptyHandler
+}
+
+func (h *queryHandler) HandleQuery(query string) (*mysql.Result, error) {
+	return nil, mysql.NewError(mysql.ER_UNKNOWN_ERROR, "query "+query)
+}
+
+// eventHandler implements all the optional command interfaces, the query EVENTS returns the commands handled.
+type eventHandler struct {
+	EmptyHandler
+
+	c      *Conn
+	events []string
+}
+
+func (h *eventHandler) event(format string, args ...interface{}) error {
+	h.events = append(h.events, fmt.Sprintf(format, args...))
+	return nil
+}
+
+func (h *eventHandler) HandleQuery(query string) (*mysql.Result, error) {
+	if query != "EVENTS" {
+		return nil, h.event("query %s", query)
+	}
+
+	values := make([][]interface{}, 0, len(h.events))
+	for _, e := range h.events {
+		values = append(values, []interface{}{e})
+	}
+	h.events = nil
+
+	r, err := mysql.BuildSimpleResultset([]string{"event"}, values, false)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	return &mysql.Result{Resultset: r}, nil
+}
+
+func (h *eventHandler) HandleStmtPrepare(query string) (int, int, interface{}, error) {
+	return 0, 0, nil, nil
+}
+
+func (h *eventHandler) HandleStmtExecute(context interface{}, query string, args []interface{}) (*mysql.Result, error) {
+	return nil, nil
+}
+
+func (h *eventHandler) HandleStatistics() (string, error) {
+	return "Uptime: 100", nil
+}
+
+func (h *eventHandler) HandleProcessInfo() (*mysql.Result, error) {
+	return nil, mysql.NewDefaultError(mysql.ER_SPECIFIC_ACCESS_DENIED_ERROR, "PROCESS")
+}
+
+func (h *eventHandler) HandleProcessKill(connectionID uint32) error {
+	return h.event("kill %d", connectionID)
+}
+
+func (h *eventHandler) HandleDebug() error {
+	return h.event("debug")
+}
+
+func (h *eventHandler) HandleSetOption(option uint16) error {
+	if option == mysql.MYSQL_OPTION_MULTI_STATEMENTS_ON {
+		return mysql.NewError(mysql.ER_UNKNOWN_ERROR, "multi statements not supported")
+	}
+	return h.event("option %d", option)
+}
+
+func (h *eventHandler) HandleShutdown() error {
+	return h.event("shutdown")
+}
+
+func (h *eventHandler) HandleChangeUser(user string, db string) error {
+	return h.event("change %s %s %s", user, db, h.c.GetUser())
+}
+
+func (h *eventHandler) HandleResetConnection() error {
+	return h.event("reset")
+}
+
+func (h *eventHandler) UseDB(dbName string) error {
+	return h.event("use %s", dbName)
+}
+
+func (s *commandTestSuite) start(c *C, newHandler HandlerFactory) {
+	p := NewInMemoryProvider()
+	p.AddUser(*testUser, *testPassword)
+	p.AddUser("other", "other_pass")
+
+	s.s = NewServer(&ServerConfig{ConnConfig: ConnConfig{Provider: p}}, newHandler)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	s.addr = l.Addr().String()
+
+	go s.s.Serve(l)
+}
+
+func (s *commandTestSuite) TearDownTest(c *C) {
+	if s.s != nil {
+		s.s.Close()
+		s.s = nil
+	}
+}
+
+func (s *commandTestSuite) connect(c *C) *client.Conn {
+	conn, err := client.Connect(s.addr, *testUser, *testPassword, "")
+	c.Assert(err, IsNil)
+	return conn
+}
+
+// command sends the command, and returns the first packet of the response.
+func (s *commandTestSuite) command(c *C, conn *client.Conn, cmd byte, arg ...byte) []byte {
+	conn.ResetSequence()
+
+	data := make([]byte, 4, 5+len(arg))
+	data = append(data, cmd)
+	data = append(data, arg...)
+	c.Assert(conn.WritePacket(data), IsNil)
+
+	data, err := conn.ReadPacket()
+	c.Assert(err, IsNil)
+	return data
+}
+
+func (s *commandTestSuite) checkError(c *C, data []byte, code uint16, message string) {
+	c.Assert(data[0], Equals, mysql.ERR_HEADER)
+	c.Assert(binary.LittleEndian.Uint16(data[1:]), Equals, code)
+	if len(message) > 0 {
+		c.Assert(string(data[9:]), Equals, message)
+	}
+}
+
+func (s *commandTestSuite) checkEvents(c *C, conn *client.Conn, events ...string) {
+	r, err := conn.Execute("EVENTS")
+	c.Assert(err, IsNil)
+
+	var got []string
+	for i := 0; i < r.RowNumber(); i++ {
+		e, _ := r.GetString(i, 0)
+		got = append(got, e)
+	}
+	c.Assert(got, DeepEquals, events)
+}
+
+func (s *commandTestSuite) TestDefault(c *C) {
+	s.start(c, func(conn *Conn) Handler {
+		return &queryHandler{}
+	})
+
+	conn := s.connect(c)
+	defer conn.Close()
+
+	data := s.command(c, conn, mysql.COM_STATISTICS)
+	c.Assert(strings.HasPrefix(string(data), "Uptime: "), Equals, true)
+
+	s.checkError(c, s.command(c, conn, mysql.COM_PROCESS_INFO), mysql.ER_UNKNOWN_ERROR, "query SHOW PROCESSLIST")
+	s.checkError(c, s.command(c, conn, mysql.COM_PROCESS_KILL, 5, 0, 0, 0), mysql.ER_UNKNOWN_ERROR, "query KILL 5")
+
+	c.Assert(s.command(c, conn, mysql.COM_DEBUG)[0], Equals, mysql.EOF_HEADER)
+
+	c.Assert(s.command(c, conn, mysql.COM_SET_OPTION, 0, 0)[0], Equals, mysql.EOF_HEADER)
+	c.Assert(s.command(c, conn, mysql.COM_SET_OPTION, 1, 0)[0], Equals, mysql.EOF_HEADER)
+	s.checkError(c, s.command(c, conn, mysql.COM_SET_OPTION, 2, 0), mysql.ER_UNKNOWN_COM_ERROR, "")
+
+	s.checkError(c, s.command(c, conn, mysql.COM_SHUTDOWN), mysql.ER_SPECIFIC_ACCESS_DENIED_ERROR, "")
+	s.checkError(c, s.command(c, conn, mysql.COM_STMT_FETCH, 1, 0, 0, 0, 1, 0, 0, 0), mysql.ER_UNKNOWN_STMT_HANDLER, "")
+
+	c.Assert(s.command(c, conn, mysql.COM_RESET_CONNECTION)[0], Equals, mysql.OK_HEADER)
+	c.Assert(conn.Ping(), IsNil)
+}
+
+func (s *commandTestSuite) TestHandler(c *C) {
+	s.start(c, func(conn *Conn) Handler {
+		return &eventHandler{c: conn}
+	})
+
+	conn := s.connect(c)
+	defer conn.Close()
+
+	c.Assert(string(s.command(c, conn, mysql.COM_STATISTICS)), Equals, "Uptime: 100")
+	s.checkError(c, s.command(c, conn, mysql.COM_PROCESS_INFO), mysql.ER_SPECIFIC_ACCESS_DENIED_ERROR, "")
+	c.Assert(s.command(c, conn, mysql.COM_PROCESS_KILL, 5, 0, 0, 0)[0], Equals, mysql.OK_HEADER)
+	c.Assert(s.command(c, conn, mysql.COM_DEBUG)[0], Equals, mysql.EOF_HEADER)
+	s.checkError(c, s.command(c, conn, mysql.COM_SET_OPTION, 0, 0), mysql.ER_UNKNOWN_ERROR, "multi statements not supported")
+	c.Assert(s.command(c, conn, mysql.COM_SET_OPTION, 1, 0)[0], Equals, mysql.EOF_HEADER)
+	c.Assert(s.command(c, conn, mysql.COM_SHUTDOWN)[0], Equals, mysql.EOF_HEADER)
+
+	s.checkEvents(c, conn, "kill 5", "debug", "option 1", "shutdown")
+
+	// the statements are closed after reset
+	stmt, err := conn.Prepare("SELECT 1")
+	c.Assert(err, IsNil)
+	_, err = stmt.Execute()
+	c.Assert(err, IsNil)
+
+	c.Assert(s.command(c, conn, mysql.COM_RESET_CONNECTION)[0], Equals, mysql.OK_HEADER)
+
+	_, err = stmt.Execute()
+	c.Assert(errors.Cause(err).(*mysql.MyError).Code, Equals, uint16(mysql.ER_UNKNOWN_STMT_HANDLER))
+
+	s.checkEvents(c, conn, "reset")
+}
+
+// changeUser sends COM_CHANGE_USER with a plugin the server doesn't use, so the server asks to switch with its salt.
+func (s *commandTestSuite) changeUser(c *C, conn *client.Conn, user, password, db string) []byte {
+	arg := append([]byte(user), 0, 0)
+	arg = append(arg, db...)
+	arg = append(arg, 0, mysql.DEFAULT_COLLATION_ID, 0)
+	arg = append(arg, "unknown_plugin"...)
+	arg = append(arg, 0)
+
+	data := s.command(c, conn, mysql.COM_CHANGE_USER, arg...)
+	if data[0] != mysql.EOF_HEADER {
+		return data
+	}
+
+	// plugin name and salt
+	pos := 1 + strings.IndexByte(string(data[1:]), 0) + 1
+	salt := data[pos : len(data)-1]
+	c.Assert(string(data[1:pos-1]), Equals, mysql.AUTH_NATIVE_PASSWORD)
+
+	auth := append(make([]byte, 4), mysql.CalcPassword(salt, []byte(password))...)
+	c.Assert(conn.WritePacket(auth), IsNil)
+
+	data, err := conn.ReadPacket()
+	c.Assert(err, IsNil)
+	return data
+}
+
+func (s *commandTestSuite) TestChangeUser(c *C) {
+	s.start(c, func(conn *Conn) Handler {
+		return &eventHandler{c: conn}
+	})
+
+	conn := s.connect(c)
+	defer conn.Close()
+
+	stmt, err := conn.Prepare("SELECT 1")
+	c.Assert(err, IsNil)
+
+	c.Assert(s.changeUser(c, conn, "other", "other_pass", "db2")[0], Equals, mysql.OK_HEADER)
+	s.checkEvents(c, conn, "reset", "use db2", "change other db2 other")
+
+	_, err = stmt.Execute()
+	c.Assert(errors.Cause(err).(*mysql.MyError).Code, Equals, uint16(mysql.ER_UNKNOWN_STMT_HANDLER))
+
+	// the connection is closed if the user is not authenticated
+	s.checkError(c, s.changeUser(c, conn, *testUser, "invalid", ""), mysql.ER_ACCESS_DENIED_ERROR, "")
+	c.Assert(conn.Ping(), NotNil)
+}
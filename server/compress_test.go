@@ -0,0 +1,68 @@
+package server
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/gdey/go-mysql/client"
+	"github.com/gdey/go-mysql/mysql"
+	"github.com/gdey/go-mysql/replication"
+	. "gopkg.in/check.v1"
+)
+
+func (s *serverTestSuite) TestCompression(c *C) {
+	for _, algorithm := range []int{mysql.MYSQL_COMPRESS_ZLIB, mysql.MYSQL_COMPRESS_ZSTD} {
+		conn, err := client.Connect(*testAddr, *testUser, *testPassword, "", func(conn *client.Conn) {
+			conn.SetCompression(algorithm)
+		})
+		c.Assert(err, IsNil)
+
+		c.Assert(conn.Compression(), Equals, algorithm)
+		c.Assert(conn.Ping(), IsNil)
+
+		r, err := conn.Execute("SELECT a, b FROM tbl WHERE id=1")
+		c.Assert(err, IsNil)
+		c.Assert(r.RowNumber(), Equals, 1)
+
+		// the compressed packet is split at MaxPayloadLen
+		r, err = conn.Execute("SELECT a, b FROM tbl WHERE b='" + strings.Repeat("a", mysql.MaxPayloadLen+100) + "'")
+		c.Assert(err, IsNil)
+		c.Assert(r.RowNumber(), Equals, 1)
+
+		stmt, err := conn.Prepare("SELECT a, b FROM tbl WHERE id=?")
+		c.Assert(err, IsNil)
+		r, err = stmt.Execute(1)
+		c.Assert(err, IsNil)
+		c.Assert(r.RowNumber(), Equals, 1)
+		c.Assert(stmt.Close(), IsNil)
+
+		c.Assert(conn.Close(), IsNil)
+	}
+
+	// not compressed by default
+	conn, err := client.Connect(*testAddr, *testUser, *testPassword, "")
+	c.Assert(err, IsNil)
+	c.Assert(conn.Compression(), Equals, mysql.MYSQL_COMPRESS_NONE)
+	c.Assert(conn.Close(), IsNil)
+}
+
+func (s *serverTestSuite) TestBinlogSyncerCompression(c *C) {
+	b := replication.NewBinlogSyncer(100, mysql.MySQLFlavor)
+	defer b.Close()
+
+	b.SetCompression(mysql.MYSQL_COMPRESS_ZSTD)
+
+	host, port, err := net.SplitHostPort(*testAddr)
+	c.Assert(err, IsNil)
+
+	n, err := strconv.ParseUint(port, 10, 16)
+	c.Assert(err, IsNil)
+
+	err = b.RegisterSlave(host, uint16(n), *testUser, *testPassword)
+	c.Assert(err, IsNil)
+
+	r, err := b.ExecuteSql("SELECT a, b FROM tbl WHERE id=1")
+	c.Assert(err, IsNil)
+	c.Assert(r.RowNumber(), Equals, 1)
+}
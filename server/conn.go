@@ -1,8 +1,10 @@
 package server
 
 import (
+	"crypto/tls"
 	"net"
 	"sync/atomic"
+	"time"
 
 	"github.com/gdey/go-mysql/mysql"
 	"github.com/gdey/go-mysql/packet"
@@ -19,52 +21,99 @@ type Conn struct {
 
 	connectionID uint32
 
-	status uint16
+	session *Session
 
-	user string
+	cfg *ConnConfig
 
 	salt []byte
 
 	h Handler
 
+	tlsConfig *tls.Config
+
 	stmts  map[uint32]*Stmt
 	stmtID uint32
 
 	closed sync2.AtomicBool
+
+	//for the default COM_STATISTICS
+	startTime time.Time
+	questions uint64
+
+	//requested by the client with CLIENT_ZSTD_COMPRESSION_ALGORITHM
+	zstdLevel int
+
+	//set by the slave for binlog dump
+	heartbeatPeriod time.Duration
+	semiSync        bool
+
+	//the command being intercepted, its results are recorded when the response is written
+	cmd *Command
 }
 
 var baseConnID uint32 = 10000
 
 func NewConn(conn net.Conn, user string, password string, h Handler) (*Conn, error) {
-	c := new(Conn)
+	return NewConnWithTLS(conn, user, password, h, nil)
+}
+
+// NewConnWithTLS is like NewConn, but the client can upgrade the connection to TLS with tlsConfig in the handshake.
+// The client can still use an unencrypted connection.
+func NewConnWithTLS(conn net.Conn, user string, password string, h Handler, tlsConfig *tls.Config) (*Conn, error) {
+	p := NewInMemoryProvider()
+	p.AddUser(user, password)
+
+	return NewConnWithConfig(conn, &ConnConfig{Provider: p, TLSConfig: tlsConfig}, h)
+}
 
+// NewConnWithConfig authenticates the client with the users of cfg.Provider,
+// use GetUser and GetDatabase to know who has connected.
+func NewConnWithConfig(conn net.Conn, cfg *ConnConfig, h Handler) (*Conn, error) {
+	c := newConn(conn, cfg)
 	c.h = h
 
-	c.user = user
+	if err := c.handshake(); err != nil {
+		c.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// newConn creates the connection without the handler, the handshake is not done.
+func newConn(conn net.Conn, cfg *ConnConfig) *Conn {
+	c := new(Conn)
+
+	c.cfg = cfg
+	c.tlsConfig = cfg.TLSConfig
+
 	c.Conn = packet.NewConn(conn)
 
 	c.connectionID = atomic.AddUint32(&baseConnID, 1)
 
 	c.stmts = make(map[uint32]*Stmt)
 
+	c.session = newSession()
+
 	c.salt, _ = mysql.RandomBuf(20)
 
 	c.closed.Set(false)
 
-	if err := c.handshake(password); err != nil {
-		c.Close()
-		return nil, err
-	}
+	c.startTime = time.Now()
 
-	return c, nil
+	return c
 }
 
-func (c *Conn) handshake(password string) error {
+func (c *Conn) handshake() error {
+	if h, ok := c.h.(SessionHandler); ok {
+		h.SetSession(c.session)
+	}
+
 	if err := c.writeInitialHandshake(); err != nil {
 		return err
 	}
 
-	if err := c.readHandshakeResponse(password); err != nil {
+	if err := c.readHandshakeResponse(); err != nil {
 		c.writeError(err)
 
 		return err
@@ -74,6 +123,17 @@ func (c *Conn) handshake(password string) error {
 		return err
 	}
 
+	//the compressed protocol starts after the handshake
+	if c.capability&mysql.CLIENT_ZSTD_COMPRESSION_ALGORITHM > 0 {
+		if err := c.EnableCompression(mysql.MYSQL_COMPRESS_ZSTD, c.zstdLevel); err != nil {
+			return err
+		}
+	} else if c.capability&mysql.CLIENT_COMPRESS > 0 {
+		if err := c.EnableCompression(mysql.MYSQL_COMPRESS_ZLIB, 0); err != nil {
+			return err
+		}
+	}
+
 	c.ResetSequence()
 
 	return nil
@@ -88,8 +148,24 @@ func (c *Conn) Closed() bool {
 	return c.closed.Get()
 }
 
+// IsTLS returns whether the client uses TLS.
+func (c *Conn) IsTLS() bool {
+	_, ok := c.Conn.Conn.(*tls.Conn)
+	return ok
+}
+
 func (c *Conn) GetUser() string {
-	return c.user
+	return c.session.user
+}
+
+// GetDatabase returns the current database, set in the handshake or by COM_INIT_DB.
+func (c *Conn) GetDatabase() string {
+	return c.session.db
+}
+
+// Session returns the session state of the connection.
+func (c *Conn) Session() *Session {
+	return c.session
 }
 
 func (c *Conn) ConnectionID() uint32 {
@@ -97,17 +173,17 @@ func (c *Conn) ConnectionID() uint32 {
 }
 
 func (c *Conn) IsAutoCommit() bool {
-	return c.status&mysql.SERVER_STATUS_AUTOCOMMIT > 0
+	return c.session.IsAutoCommit()
 }
 
 func (c *Conn) IsInTransaction() bool {
-	return c.status&mysql.SERVER_STATUS_IN_TRANS > 0
+	return c.session.IsInTransaction()
 }
 
 func (c *Conn) SetInTransaction() {
-	c.status |= mysql.SERVER_STATUS_IN_TRANS
+	c.session.SetInTransaction()
 }
 
 func (c *Conn) ClearInTransaction() {
-	c.status &= ^mysql.SERVER_STATUS_IN_TRANS
+	c.session.ClearInTransaction()
 }
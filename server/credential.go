@@ -0,0 +1,78 @@
+package server
+
+import (
+	"crypto/tls"
+	"sync"
+
+	"github.com/gdey/go-mysql/mysql"
+)
+
+// CredentialProvider provides the passwords of the users, the connection uses it to authenticate the client.
+type CredentialProvider interface {
+	// GetCredential returns the password of the user, found is false if there is no such user.
+	GetCredential(user string) (password string, found bool, err error)
+}
+
+// HashCredentialProvider is an optional interface for CredentialProvider, implement it to keep the password hashes
+// instead of the passwords, GetCredentialHash is used in place of GetCredential then.
+type HashCredentialProvider interface {
+	// GetCredentialHash returns the password hash of the user for the auth plugin, found is false if there is no such user.
+	// The hash is SHA1(SHA1(password)) for mysql_native_password, like the authentication_string of MySQL without "*" in hex,
+	// and SHA256(SHA256(password)) for caching_sha2_password, it's empty for the empty password.
+	GetCredentialHash(user string, plugin string) (hash []byte, found bool, err error)
+}
+
+// InMemoryProvider keeps the users and passwords in memory, it's safe for concurrent use.
+type InMemoryProvider struct {
+	m     sync.RWMutex
+	users map[string]string
+}
+
+func NewInMemoryProvider() *InMemoryProvider {
+	p := new(InMemoryProvider)
+	p.users = make(map[string]string)
+	return p
+}
+
+// AddUser adds the user or updates its password.
+func (p *InMemoryProvider) AddUser(user string, password string) {
+	p.m.Lock()
+	p.users[user] = password
+	p.m.Unlock()
+}
+
+func (p *InMemoryProvider) RemoveUser(user string) {
+	p.m.Lock()
+	delete(p.users, user)
+	p.m.Unlock()
+}
+
+func (p *InMemoryProvider) GetCredential(user string) (string, bool, error) {
+	p.m.RLock()
+	password, ok := p.users[user]
+	p.m.RUnlock()
+	return password, ok, nil
+}
+
+// ConnConfig is the config for NewConnWithConfig.
+type ConnConfig struct {
+	// authenticates the users
+	Provider CredentialProvider
+
+	// the auth plugin we ask the client to use, mysql_native_password or caching_sha2_password,
+	// mysql_native_password if empty. The client using another plugin is asked to switch to it.
+	AuthPlugin string
+
+	// the client can upgrade the connection to TLS with it in the handshake, nil means no TLS
+	TLSConfig *tls.Config
+
+	// wrap the handling of every command, the first one is the outermost, see Interceptor
+	Interceptors []Interceptor
+}
+
+func (cfg *ConnConfig) authPlugin() string {
+	if len(cfg.AuthPlugin) == 0 {
+		return mysql.AUTH_NATIVE_PASSWORD
+	}
+	return cfg.AuthPlugin
+}
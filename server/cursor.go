@@ -0,0 +1,108 @@
+package server
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+
+	"github.com/gdey/go-mysql/mysql"
+	"github.com/juju/errors"
+)
+
+// stmtCursor keeps the binary rows of the result set for mysql.COM_STMT_FETCH.
+type stmtCursor struct {
+	rows []mysql.RowData
+}
+
+// binaryResultset encodes the Values of the result set in the binary protocol by the field types,
+// the RowDatas in the text protocol are parsed for the Values if there is no Values.
+func binaryResultset(r *mysql.Resultset) (*mysql.Resultset, error) {
+	rows := r.Values
+	if len(rows) == 0 {
+		rows = make([][]interface{}, 0, len(r.RowDatas))
+		for _, row := range r.RowDatas {
+			values, err := row.ParseText(r.Fields)
+			if err != nil {
+				return nil, errors.Trace(err)
+			}
+			rows = append(rows, values)
+		}
+	}
+
+	br := *r
+	br.RowDatas = make([]mysql.RowData, 0, len(rows))
+	for _, values := range rows {
+		row, err := mysql.BuildRowData(r.Fields, values, true)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		br.RowDatas = append(br.RowDatas, row)
+	}
+
+	return &br, nil
+}
+
+// openCursor sends the fields with SERVER_STATUS_CURSOR_EXISTS, the rows are sent by mysql.COM_STMT_FETCH later.
+func (c *Conn) openCursor(s *Stmt, r *mysql.Resultset, status uint16) error {
+	data := make([]byte, 4, 1024)
+	data = append(data, mysql.PutLengthEncodedInt(uint64(len(r.Fields)))...)
+	if err := c.WritePacket(data); err != nil {
+		return err
+	}
+
+	for _, f := range r.Fields {
+		data = data[0:4]
+		data = append(data, f.Dump()...)
+		if err := c.WritePacket(data); err != nil {
+			return err
+		}
+	}
+
+	s.cursor = &stmtCursor{rows: r.RowDatas}
+
+	return c.writeEOFStatus(status | mysql.SERVER_STATUS_CURSOR_EXISTS)
+}
+
+// handleStmtFetch sends the next rows of the cursor, the cursor is closed after the last row is sent.
+func (c *Conn) handleStmtFetch(data []byte) interface{} {
+	if len(data) < 8 {
+		return mysql.ErrMalformPacket
+	}
+
+	id := binary.LittleEndian.Uint32(data[0:4])
+	n := binary.LittleEndian.Uint32(data[4:8])
+
+	s, ok := c.stmts[id]
+	if !ok {
+		return mysql.NewDefaultError(mysql.ER_UNKNOWN_STMT_HANDLER,
+			strconv.FormatUint(uint64(id), 10), "stmt_fetch")
+	} else if s.cursor == nil {
+		return mysql.NewError(mysql.ER_STMT_HAS_NO_OPEN_CURSOR, fmt.Sprintf("The statement (%d) has no open cursor.", id))
+	}
+
+	rows := s.cursor.rows
+	if uint64(n) < uint64(len(rows)) {
+		rows = rows[:n]
+	}
+	s.cursor.rows = s.cursor.rows[len(rows):]
+
+	buf := make([]byte, 4, 1024)
+	for _, row := range rows {
+		buf = append(buf[0:4], row...)
+		if err := c.WritePacket(buf); err != nil {
+			return err
+		}
+		c.recordRows(1)
+	}
+
+	status := mysql.SERVER_STATUS_CURSOR_EXISTS
+	if len(s.cursor.rows) == 0 {
+		status |= mysql.SERVER_STATUS_LAST_ROW_SEND
+		s.cursor = nil
+	}
+
+	if err := c.writeEOFStatus(status); err != nil {
+		return err
+	}
+	return noResponse{}
+}
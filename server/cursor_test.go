@@ -0,0 +1,262 @@
+package server
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/gdey/go-mysql/client"
+	"github.com/gdey/go-mysql/mysql"
+	"github.com/gdey/go-mysql/packet"
+	"github.com/juju/errors"
+	. "gopkg.in/check.v1"
+)
+
+type cursorTestSuite struct {
+	servers []*Server
+
+	typedAddr  string
+	streamAddr string
+}
+
+var _ = Suite(&cursorTestSuite{})
+
+// typedHandler returns 5 rows of the typed values, the name of the row 2 is NULL,
+// only the RowDatas are returned without the Values for the query with "rows".
+type typedHandler struct {
+	EmptyHandler
+}
+
+func (h *typedHandler) result(query string) (*mysql.Result, error) {
+	values := make([][]interface{}, 5)
+	for i := range values {
+		var name interface{}
+		if i != 2 {
+			name = fmt.Sprintf("name %d", i)
+		}
+		values[i] = []interface{}{int64(i), name, float64(i) + 0.5, time.Date(2020, 1, 2, 3, 4, i, 0, time.UTC)}
+	}
+
+	// the rows are in the text protocol, the server encodes them again for the statement
+	r, err := mysql.BuildSimpleTextResultset([]string{"id", "name", "score", "created"}, values)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+	if strings.Contains(query, "rows") {
+		r.Values = nil
+	}
+	return &mysql.Result{Resultset: r}, nil
+}
+
+func (h *typedHandler) HandleQuery(query string) (*mysql.Result, error) {
+	return h.result(query)
+}
+
+func (h *typedHandler) HandleStmtPrepare(query string) (int, int, interface{}, error) {
+	return 0, 4, nil, nil
+}
+
+func (h *typedHandler) HandleStmtExecute(context interface{}, query string, args []interface{}) (*mysql.Result, error) {
+	return h.result(query)
+}
+
+func (s *cursorTestSuite) serve(c *C, newHandler HandlerFactory) string {
+	p := NewInMemoryProvider()
+	p.AddUser(*testUser, *testPassword)
+
+	srv := NewServer(&ServerConfig{ConnConfig: ConnConfig{Provider: p}}, newHandler)
+	s.servers = append(s.servers, srv)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+
+	go srv.Serve(l)
+	return l.Addr().String()
+}
+
+func (s *cursorTestSuite) SetUpSuite(c *C) {
+	s.typedAddr = s.serve(c, func(conn *Conn) Handler {
+		return &typedHandler{}
+	})
+	s.streamAddr = s.serve(c, func(conn *Conn) Handler {
+		return &streamHandler{}
+	})
+}
+
+func (s *cursorTestSuite) TearDownSuite(c *C) {
+	for _, srv := range s.servers {
+		srv.Close()
+	}
+}
+
+func (s *cursorTestSuite) checkTypedRow(c *C, values []interface{}, i int) {
+	c.Assert(values[0], Equals, int64(i))
+	if i == 2 {
+		c.Assert(values[1], IsNil)
+	} else {
+		c.Assert(string(values[1].([]byte)), Equals, fmt.Sprintf("name %d", i))
+	}
+	c.Assert(values[2], Equals, float64(i)+0.5)
+	c.Assert(string(values[3].([]byte)), Equals, fmt.Sprintf("2020-01-02 03:04:%02d", i))
+}
+
+func (s *cursorTestSuite) TestBinaryResult(c *C) {
+	conn, err := client.Connect(s.typedAddr, *testUser, *testPassword, "")
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	stmt, err := conn.Prepare("SELECT * FROM t")
+	c.Assert(err, IsNil)
+	defer stmt.Close()
+
+	r, err := stmt.Execute()
+	c.Assert(err, IsNil)
+	c.Assert(r.Fields[3].Type, Equals, mysql.MYSQL_TYPE_DATETIME)
+	c.Assert(r.RowNumber(), Equals, 5)
+	for i, values := range r.Values {
+		s.checkTypedRow(c, values, i)
+	}
+
+	// the same result is in the text protocol for the query
+	r, err = conn.Execute("SELECT * FROM t")
+	c.Assert(err, IsNil)
+	c.Assert(r.RowNumber(), Equals, 5)
+	c.Assert(r.Values[1][0], Equals, int64(1))
+	c.Assert(string(r.Values[1][3].([]byte)), Equals, "2020-01-02 03:04:01")
+}
+
+func (s *cursorTestSuite) TestBinaryResultRowDatas(c *C) {
+	conn, err := client.Connect(s.typedAddr, *testUser, *testPassword, "")
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	stmt, err := conn.Prepare("SELECT rows FROM t")
+	c.Assert(err, IsNil)
+	defer stmt.Close()
+
+	// the text rows without the Values are encoded in the binary protocol too
+	r, err := stmt.Execute()
+	c.Assert(err, IsNil)
+	c.Assert(r.Fields[3].Type, Equals, mysql.MYSQL_TYPE_DATETIME)
+	c.Assert(r.RowNumber(), Equals, 5)
+	for i, values := range r.Values {
+		s.checkTypedRow(c, values, i)
+	}
+}
+
+// prepare prepares the statement, and returns its id.
+func (s *cursorTestSuite) prepare(c *C, conn *packet.Conn, columns int, params int) uint32 {
+	n := 1
+	if params > 0 {
+		n += params + 1
+	}
+	if columns > 0 {
+		n += columns + 1
+	}
+
+	packets := rawCommand(c, conn, mysql.COM_STMT_PREPARE, []byte("SELECT"), n)
+	c.Assert(packets[0][0], Equals, mysql.OK_HEADER)
+	return binary.LittleEndian.Uint32(packets[0][1:])
+}
+
+// execute opens the cursor, and returns the fields.
+func (s *cursorTestSuite) execute(c *C, conn *packet.Conn, id uint32, params []byte, columns int) []*mysql.Field {
+	arg := append(mysql.Uint32ToBytes(id), mysql.CURSOR_TYPE_READ_ONLY, 1, 0, 0, 0)
+	arg = append(arg, params...)
+
+	packets := rawCommand(c, conn, mysql.COM_STMT_EXECUTE, arg, 1+columns+1)
+	c.Assert(packets[0], DeepEquals, []byte{byte(columns)})
+
+	fields := make([]*mysql.Field, columns)
+	for i := range fields {
+		f, err := mysql.FieldData(packets[1+i]).Parse()
+		c.Assert(err, IsNil)
+		fields[i] = f
+	}
+
+	eof := packets[1+columns]
+	c.Assert(eof[0], Equals, mysql.EOF_HEADER)
+	c.Assert(binary.LittleEndian.Uint16(eof[3:])&mysql.SERVER_STATUS_CURSOR_EXISTS, Not(Equals), uint16(0))
+
+	return fields
+}
+
+// fetch fetches n rows, and returns the rows read, and the status of the EOF packet.
+func (s *cursorTestSuite) fetch(c *C, conn *packet.Conn, id uint32, n uint32, fields []*mysql.Field) ([][]interface{}, uint16) {
+	arg := append(mysql.Uint32ToBytes(id), mysql.Uint32ToBytes(n)...)
+	data := rawCommand(c, conn, mysql.COM_STMT_FETCH, arg, 1)[0]
+
+	var rows [][]interface{}
+	for data[0] != mysql.EOF_HEADER && data[0] != mysql.ERR_HEADER {
+		values, err := mysql.RowData(data).ParseBinary(fields)
+		c.Assert(err, IsNil)
+		rows = append(rows, values)
+
+		data, err = conn.ReadPacket()
+		c.Assert(err, IsNil)
+	}
+
+	if data[0] == mysql.ERR_HEADER {
+		return rows, binary.LittleEndian.Uint16(data[1:])
+	}
+	return rows, binary.LittleEndian.Uint16(data[3:])
+}
+
+func (s *cursorTestSuite) TestCursor(c *C) {
+	conn := rawConnect(c, s.typedAddr, 0)
+	defer conn.Close()
+
+	id := s.prepare(c, conn, 4, 0)
+	fields := s.execute(c, conn, id, nil, 4)
+
+	rows, status := s.fetch(c, conn, id, 2, fields)
+	c.Assert(rows, HasLen, 2)
+	c.Assert(status&mysql.SERVER_STATUS_CURSOR_EXISTS, Not(Equals), uint16(0))
+	c.Assert(status&mysql.SERVER_STATUS_LAST_ROW_SEND, Equals, uint16(0))
+	s.checkTypedRow(c, rows[1], 1)
+
+	rows, status = s.fetch(c, conn, id, 10, fields)
+	c.Assert(rows, HasLen, 3)
+	c.Assert(status&mysql.SERVER_STATUS_LAST_ROW_SEND, Not(Equals), uint16(0))
+	s.checkTypedRow(c, rows[0], 2)
+	s.checkTypedRow(c, rows[2], 4)
+
+	// the cursor is closed after the last row
+	_, code := s.fetch(c, conn, id, 1, fields)
+	c.Assert(code, Equals, uint16(mysql.ER_STMT_HAS_NO_OPEN_CURSOR))
+
+	// and by COM_STMT_RESET
+	s.execute(c, conn, id, nil, 4)
+	c.Assert(rawCommand(c, conn, mysql.COM_STMT_RESET, mysql.Uint32ToBytes(id), 1)[0][0], Equals, mysql.OK_HEADER)
+	_, code = s.fetch(c, conn, id, 1, fields)
+	c.Assert(code, Equals, uint16(mysql.ER_STMT_HAS_NO_OPEN_CURSOR))
+}
+
+func (s *cursorTestSuite) TestStreamingCursor(c *C) {
+	conn := rawConnect(c, s.streamAddr, 0)
+	defer conn.Close()
+
+	id := s.prepare(c, conn, 3, 1)
+
+	// the null bitmap, new params bound, LONGLONG and 1000
+	params := []byte{0, 1, mysql.MYSQL_TYPE_LONGLONG, 0}
+	params = append(params, mysql.Uint64ToBytes(1000)...)
+	fields := s.execute(c, conn, id, params, 3)
+
+	n := 0
+	for {
+		rows, status := s.fetch(c, conn, id, 300, fields)
+		for _, values := range rows {
+			c.Assert(values[0], Equals, int64(n))
+			n++
+		}
+
+		if status&mysql.SERVER_STATUS_LAST_ROW_SEND > 0 {
+			break
+		}
+		c.Assert(rows, HasLen, 300)
+	}
+	c.Assert(n, Equals, 1000)
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+
+	"github.com/gdey/go-mysql/mysql"
+	"github.com/gdey/go-mysql/packet"
+	. "gopkg.in/check.v1"
+)
+
+// the helpers to talk to the server with the raw packets, shared by the tests
+
+// rawConnect does the handshake with the capability, so we can check the packets the server sends.
+func rawConnect(c *C, addr string, capability uint32) *packet.Conn {
+	nc, err := net.Dial("tcp", addr)
+	c.Assert(err, IsNil)
+	conn := packet.NewConn(nc)
+
+	data, err := conn.ReadPacket()
+	c.Assert(err, IsNil)
+
+	// skip the protocol version, server version and connection id
+	pos := 1 + bytes.IndexByte(data[1:], 0) + 1 + 4
+	salt := append([]byte{}, data[pos:pos+8]...)
+	// skip the filter, capability, charset, status, capability, auth data length and reserved
+	pos += 8 + 1 + 2 + 1 + 2 + 2 + 1 + 10
+	salt = append(salt, data[pos:pos+12]...)
+
+	capability |= mysql.CLIENT_PROTOCOL_41 | mysql.CLIENT_SECURE_CONNECTION | mysql.CLIENT_PLUGIN_AUTH
+	auth := mysql.CalcPassword(salt, []byte(*testPassword))
+
+	data = make([]byte, 4+4+4+1+23)
+	binary.LittleEndian.PutUint32(data[4:], capability)
+	data[12] = mysql.DEFAULT_COLLATION_ID
+	data = append(data, *testUser...)
+	data = append(data, 0, byte(len(auth)))
+	data = append(data, auth...)
+	data = append(data, mysql.AUTH_NATIVE_PASSWORD...)
+	data = append(data, 0)
+	c.Assert(conn.WritePacket(data), IsNil)
+
+	data, err = conn.ReadPacket()
+	c.Assert(err, IsNil)
+	c.Assert(data[0], Equals, mysql.OK_HEADER)
+
+	return conn
+}
+
+// rawCommand sends the command, and returns the first n packets of the response.
+func rawCommand(c *C, conn *packet.Conn, cmd byte, arg []byte, n int) [][]byte {
+	conn.ResetSequence()
+
+	data := append(make([]byte, 4), cmd)
+	data = append(data, arg...)
+	c.Assert(conn.WritePacket(data), IsNil)
+
+	packets := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		data, err := conn.ReadPacket()
+		c.Assert(err, IsNil)
+		packets = append(packets, data)
+	}
+	return packets
+}
+
+// okStatus returns the header and status of the OK packet.
+func okStatus(data []byte) (byte, uint16) {
+	pos := 1
+	_, _, n := mysql.LengthEncodedInt(data[pos:])
+	pos += n
+	_, _, n = mysql.LengthEncodedInt(data[pos:])
+	pos += n
+	return data[0], binary.LittleEndian.Uint16(data[pos:])
+}
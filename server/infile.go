@@ -0,0 +1,136 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+
+	"github.com/gdey/go-mysql/mysql"
+	"github.com/juju/errors"
+)
+
+// LocalInfileHandler is an optional interface for Handler, implement it to load the data of the client
+// with LOAD DATA LOCAL INFILE. The query is not passed to HandleQuery.
+type LocalInfileHandler interface {
+	//handle LOAD DATA LOCAL INFILE 'filename', data reads the file content sent by the client,
+	//it's empty if the client can't send the file. The rest data not read is discarded.
+	HandleLocalInfile(query string, filename string, data io.Reader) (*mysql.Result, error)
+}
+
+var localInfileRegexp = regexp.MustCompile(`(?is)^\s*LOAD\s+(?:DATA|XML)\s+(?:LOW_PRIORITY\s+|CONCURRENT\s+)?LOCAL\s+INFILE\s+('(?:[^'\\]|\\.|'')*'|"(?:[^"\\]|\\.|"")*")`)
+
+// handleLocalInfile requests the file of LOAD DATA LOCAL INFILE from the client,
+// it returns false if the query is not a LOAD DATA LOCAL INFILE or the Handler can't handle it.
+func (c *Conn) handleLocalInfile(query string) (interface{}, bool) {
+	h, ok := c.h.(LocalInfileHandler)
+	if !ok {
+		return nil, false
+	}
+
+	m := localInfileRegexp.FindStringSubmatch(query)
+	if m == nil {
+		return nil, false
+	}
+
+	if c.capability&mysql.CLIENT_LOCAL_FILES == 0 {
+		return mysql.NewDefaultError(mysql.ER_NOT_ALLOWED_COMMAND), true
+	}
+
+	filename := unquoteString(m[1])
+
+	data := make([]byte, 4, 5+len(filename))
+	data = append(data, mysql.LocalInFile_HEADER)
+	data = append(data, filename...)
+	if err := c.WritePacket(data); err != nil {
+		return err, true
+	}
+
+	r := &localInfileReader{c: c}
+	result, err := h.HandleLocalInfile(query, filename, r)
+
+	// the client sends all the data anyway
+	if derr := r.drain(); derr != nil {
+		return derr, true
+	}
+
+	if err != nil {
+		return err, true
+	}
+	return result, true
+}
+
+// unquoteString unquotes the quoted string in the query.
+func unquoteString(s string) string {
+	quote := s[0]
+	s = s[1 : len(s)-1]
+
+	var buf bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if ch == '\\' && i+1 < len(s) {
+			i++
+			switch s[i] {
+			case '0':
+				ch = 0
+			case 'b':
+				ch = '\b'
+			case 'n':
+				ch = '\n'
+			case 'r':
+				ch = '\r'
+			case 't':
+				ch = '\t'
+			case 'Z':
+				ch = 26
+			default:
+				ch = s[i]
+			}
+		} else if ch == quote {
+			// the doubled quote
+			i++
+		}
+		buf.WriteByte(ch)
+	}
+
+	return buf.String()
+}
+
+// localInfileReader reads the packets of the local file until the empty packet.
+type localInfileReader struct {
+	c *Conn
+
+	buf bytes.Buffer
+	eof bool
+	err error
+}
+
+func (r *localInfileReader) Read(b []byte) (int, error) {
+	for r.buf.Len() == 0 {
+		if r.err != nil {
+			return 0, r.err
+		} else if r.eof {
+			return 0, io.EOF
+		}
+
+		r.readPacket()
+	}
+
+	return r.buf.Read(b)
+}
+
+func (r *localInfileReader) readPacket() {
+	if err := r.c.ReadPacketTo(&r.buf); err != nil {
+		r.err = errors.Trace(err)
+	} else if r.buf.Len() == 0 {
+		r.eof = true
+	}
+}
+
+func (r *localInfileReader) drain() error {
+	for r.err == nil && !r.eof {
+		r.buf.Reset()
+		r.readPacket()
+	}
+
+	return r.err
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gdey/go-mysql/client"
+	"github.com/gdey/go-mysql/mysql"
+	"github.com/juju/errors"
+	. "gopkg.in/check.v1"
+)
+
+// HandleLocalInfile returns the data size as the affected rows, and the lines as the insert id.
+func (h *testHandler) HandleLocalInfile(query string, filename string, data io.Reader) (*mysql.Result, error) {
+	if strings.Contains(filename, "fail") {
+		return nil, mysql.NewError(mysql.ER_UNKNOWN_ERROR, "load failed")
+	}
+
+	buf, err := ioutil.ReadAll(data)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	return &mysql.Result{Status: mysql.SERVER_STATUS_AUTOCOMMIT, InsertId: uint64(bytes.Count(buf, []byte("\n"))),
+		AffectedRows: uint64(len(buf))}, nil
+}
+
+func (s *serverTestSuite) TestLocalInfile(c *C) {
+	dir, err := ioutil.TempDir("", "infile")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	var csv bytes.Buffer
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(&csv, "%d,name %d\n", i, i)
+	}
+
+	name := filepath.Join(dir, "data.csv")
+	c.Assert(ioutil.WriteFile(name, csv.Bytes(), 0644), IsNil)
+
+	other, err := ioutil.TempFile("", "infile")
+	c.Assert(err, IsNil)
+	other.Close()
+	defer os.Remove(other.Name())
+
+	conn, err := client.Connect(*testAddr, *testUser, *testPassword, "", func(conn *client.Conn) {
+		conn.RegisterLocalInfileReader("csv", func() (io.Reader, error) {
+			return bytes.NewReader(csv.Bytes()), nil
+		})
+		conn.RegisterLocalInfileReader("fail", func() (io.Reader, error) {
+			return bytes.NewReader(csv.Bytes()), nil
+		})
+		conn.AllowLocalInfile(dir)
+	})
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	for _, query := range []string{
+		"LOAD DATA LOCAL INFILE 'Reader::csv' INTO TABLE tbl",
+		fmt.Sprintf("load data local infile '%s' into table tbl fields terminated by ','", name),
+	} {
+		r, err := conn.Execute(query)
+		c.Assert(err, IsNil)
+		c.Assert(r.AffectedRows, Equals, uint64(csv.Len()))
+		c.Assert(r.InsertId, Equals, uint64(10000))
+	}
+
+	rows, err := conn.Query("LOAD DATA LOCAL INFILE 'Reader::csv' INTO TABLE tbl")
+	c.Assert(err, IsNil)
+	c.Assert(rows.Next(), Equals, false)
+	c.Assert(rows.Close(), IsNil)
+	c.Assert(rows.Result().AffectedRows, Equals, uint64(csv.Len()))
+
+	// the file is not sent, but the connection is still usable
+	for _, query := range []string{
+		"LOAD DATA LOCAL INFILE 'Reader::unknown' INTO TABLE tbl",
+		fmt.Sprintf("LOAD DATA LOCAL INFILE '%s' INTO TABLE tbl", other.Name()),
+		fmt.Sprintf("LOAD DATA LOCAL INFILE '%s' INTO TABLE tbl", filepath.Join(dir, "..", filepath.Base(other.Name()))),
+	} {
+		_, err = conn.Execute(query)
+		c.Assert(err, NotNil)
+		c.Assert(conn.Ping(), IsNil)
+	}
+
+	// the handler fails without reading the data
+	_, err = conn.Execute("LOAD DATA LOCAL INFILE 'Reader::fail' INTO TABLE tbl")
+	c.Assert(errors.Cause(err).(*mysql.MyError).Message, Equals, "load failed")
+	c.Assert(conn.Ping(), IsNil)
+}
+
+func (s *serverTestSuite) TestLocalInfileNotAllowed(c *C) {
+	conn, err := client.Connect(*testAddr, *testUser, *testPassword, "")
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	_, err = conn.Execute("LOAD DATA LOCAL INFILE 'Reader::csv' INTO TABLE tbl")
+	c.Assert(errors.Cause(err).(*mysql.MyError).Code, Equals, uint16(mysql.ER_NOT_ALLOWED_COMMAND))
+	c.Assert(conn.Ping(), IsNil)
+}
+
+func (s *serverTestSuite) TestUnquoteString(c *C) {
+	c.Assert(unquoteString(`'a\'b''c\nd'`), Equals, "a'b'c\nd")
+	c.Assert(unquoteString(`"a""b"`), Equals, `a"b`)
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/gdey/go-mysql/mysql"
+	"github.com/juju/errors"
+)
+
+// Command is a command from the client, the interceptors see it before and after it's handled.
+type Command struct {
+	ConnectionID uint32
+	// the user when the command comes, COM_CHANGE_USER changes it after
+	User       string
+	RemoteAddr net.Addr
+
+	// the command type, like mysql.COM_QUERY
+	Type byte
+	// the query of COM_QUERY, the statement query of COM_STMT_PREPARE, COM_STMT_EXECUTE and the other statement commands,
+	// the database of COM_INIT_DB, and the table of COM_FIELD_LIST
+	Query string
+	// the args of COM_STMT_EXECUTE, they are bound when the command is handled, so they are set after next returns,
+	// and the byte slices are only valid until the interceptor returns
+	Args []interface{}
+
+	// the time the command comes
+	Time time.Time
+
+	// the results, set after next returns
+
+	// the time to handle the command and write the response
+	Duration time.Duration
+	// the rows sent to the client, of all the result sets
+	Rows int64
+	// the rows affected in the OK packets
+	AffectedRows uint64
+	// the code of the error sent to the client, 0 if no error
+	ErrorCode uint16
+	// the error sent to the client, or the error of the connection
+	Err error
+}
+
+// Name returns the name of the command type, like Query and Execute, the same as the MySQL general log.
+func (cmd *Command) Name() string {
+	return CommandName(cmd.Type)
+}
+
+var commandNames = map[byte]string{
+	mysql.COM_SLEEP:               "Sleep",
+	mysql.COM_QUIT:                "Quit",
+	mysql.COM_INIT_DB:             "Init DB",
+	mysql.COM_QUERY:               "Query",
+	mysql.COM_FIELD_LIST:          "Field List",
+	mysql.COM_CREATE_DB:           "Create DB",
+	mysql.COM_DROP_DB:             "Drop DB",
+	mysql.COM_REFRESH:             "Refresh",
+	mysql.COM_SHUTDOWN:            "Shutdown",
+	mysql.COM_STATISTICS:          "Statistics",
+	mysql.COM_PROCESS_INFO:        "Processlist",
+	mysql.COM_CONNECT:             "Connect",
+	mysql.COM_PROCESS_KILL:        "Kill",
+	mysql.COM_DEBUG:               "Debug",
+	mysql.COM_PING:                "Ping",
+	mysql.COM_TIME:                "Time",
+	mysql.COM_DELAYED_INSERT:      "Delayed insert",
+	mysql.COM_CHANGE_USER:         "Change user",
+	mysql.COM_BINLOG_DUMP:         "Binlog Dump",
+	mysql.COM_TABLE_DUMP:          "Table Dump",
+	mysql.COM_CONNECT_OUT:         "Connect Out",
+	mysql.COM_REGISTER_SLAVE:      "Register Slave",
+	mysql.COM_STMT_PREPARE:        "Prepare",
+	mysql.COM_STMT_EXECUTE:        "Execute",
+	mysql.COM_STMT_SEND_LONG_DATA: "Long Data",
+	mysql.COM_STMT_CLOSE:          "Close stmt",
+	mysql.COM_STMT_RESET:          "Reset stmt",
+	mysql.COM_SET_OPTION:          "Set option",
+	mysql.COM_STMT_FETCH:          "Fetch",
+	mysql.COM_DAEMON:              "Daemon",
+	mysql.COM_BINLOG_DUMP_GTID:    "Binlog Dump GTID",
+	mysql.COM_RESET_CONNECTION:    "Reset Connection",
+}
+
+// CommandName returns the name of the command type, like Query for mysql.COM_QUERY.
+func CommandName(t byte) string {
+	if name, ok := commandNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("Command %d", t)
+}
+
+// Interceptor wraps the handling of every command after the handshake, like a middleware.
+// It must call next once, which handles the command and writes the response, then the results are set in cmd.
+// The interceptors run in the connection goroutine, so they must not block long.
+type Interceptor func(cmd *Command, next func())
+
+// newCommand returns the command of the data read from the client.
+func (c *Conn) newCommand(data []byte) *Command {
+	cmd := &Command{
+		ConnectionID: c.connectionID,
+		User:         c.session.user,
+		RemoteAddr:   c.RemoteAddr(),
+		Type:         data[0],
+		Time:         time.Now(),
+	}
+
+	switch cmd.Type {
+	case mysql.COM_QUERY, mysql.COM_STMT_PREPARE, mysql.COM_INIT_DB:
+		cmd.Query = string(data[1:])
+	case mysql.COM_FIELD_LIST:
+		cmd.Query = string(data[1:])
+		if n := bytes.IndexByte(data[1:], 0x00); n >= 0 {
+			cmd.Query = cmd.Query[0:n]
+		}
+	case mysql.COM_STMT_EXECUTE, mysql.COM_STMT_CLOSE, mysql.COM_STMT_RESET, mysql.COM_STMT_FETCH,
+		mysql.COM_STMT_SEND_LONG_DATA:
+		if len(data) >= 5 {
+			if s, ok := c.stmts[binary.LittleEndian.Uint32(data[1:])]; ok {
+				cmd.Query = s.Query
+			}
+		}
+	}
+
+	return cmd
+}
+
+// interceptCommand handles the command with the interceptors, the first one is the outermost.
+func (c *Conn) interceptCommand(data []byte, interceptors []Interceptor) error {
+	cmd := c.newCommand(data)
+
+	var err error
+	called := false
+	next := func() {
+		if called {
+			return
+		}
+		called = true
+
+		c.cmd = cmd
+		err = c.dispatchCommand(data)
+		c.cmd = nil
+
+		cmd.Duration = time.Since(cmd.Time)
+		if err != nil && cmd.Err == nil {
+			cmd.Err = err
+		}
+	}
+
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, inner := interceptors[i], next
+		next = func() {
+			interceptor(cmd, inner)
+		}
+	}
+	next()
+
+	if !called {
+		return errors.Errorf("interceptor did not handle command %s", cmd.Name())
+	}
+	return err
+}
+
+// the results of the command being intercepted are recorded by the following functions
+
+func (c *Conn) recordRows(n int) {
+	if c.cmd != nil {
+		c.cmd.Rows += int64(n)
+	}
+}
+
+func (c *Conn) recordOK(r *mysql.Result) {
+	if c.cmd != nil && r != nil {
+		c.cmd.AffectedRows += r.AffectedRows
+	}
+}
+
+func (c *Conn) recordError(m *mysql.MyError, err error) {
+	if c.cmd != nil {
+		c.cmd.ErrorCode = m.Code
+		c.cmd.Err = err
+	}
+}
+
+func (c *Conn) recordArgs(args []interface{}) {
+	if c.cmd != nil {
+		c.cmd.Args = args
+	}
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/gdey/go-mysql/client"
+	"github.com/gdey/go-mysql/mysql"
+	"github.com/juju/errors"
+	. "gopkg.in/check.v1"
+)
+
+type interceptTestSuite struct {
+	s    *Server
+	addr string
+
+	// the commands after all the interceptors
+	cmds chan *Command
+
+	audit bytes.Buffer
+	slow  bytes.Buffer
+}
+
+var _ = Suite(&interceptTestSuite{})
+
+// auditHandler answers SELECT with two rows, UPDATE with 3 affected rows, SLEEP slowly, and fails the others.
+type auditHandler struct {
+	EmptyHandler
+}
+
+func (h *auditHandler) HandleQuery(query string) (*mysql.Result, error) {
+	switch {
+	case strings.HasPrefix(query, "SELECT"):
+		r, err := mysql.BuildSimpleResultset([]string{"a"}, [][]interface{}{{int64(1)}, {int64(2)}}, false)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return &mysql.Result{Resultset: r}, nil
+	case strings.HasPrefix(query, "UPDATE"):
+		return &mysql.Result{AffectedRows: 3}, nil
+	case strings.HasPrefix(query, "SLEEP"):
+		time.Sleep(50 * time.Millisecond)
+		return nil, nil
+	default:
+		return nil, mysql.NewDefaultError(mysql.ER_NO_SUCH_TABLE, "test", "t")
+	}
+}
+
+func (h *auditHandler) HandleStmtPrepare(query string) (int, int, interface{}, error) {
+	return strings.Count(query, "?"), 0, nil, nil
+}
+
+func (h *auditHandler) HandleStmtExecute(context interface{}, query string, args []interface{}) (*mysql.Result, error) {
+	return h.HandleQuery(query)
+}
+
+func (s *interceptTestSuite) SetUpSuite(c *C) {
+	s.cmds = make(chan *Command, 16)
+
+	record := func(cmd *Command, next func()) {
+		next()
+		s.cmds <- cmd
+	}
+
+	p := NewInMemoryProvider()
+	p.AddUser(*testUser, *testPassword)
+
+	cfg := ConnConfig{
+		Provider:     p,
+		Interceptors: []Interceptor{record, NewAuditLog(&s.audit), NewSlowQueryLog(&s.slow, 30*time.Millisecond)},
+	}
+	s.s = NewServer(&ServerConfig{ConnConfig: cfg}, func(conn *Conn) Handler {
+		return &auditHandler{}
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	s.addr = l.Addr().String()
+
+	go s.s.Serve(l)
+}
+
+func (s *interceptTestSuite) TearDownSuite(c *C) {
+	s.s.Close()
+}
+
+func (s *interceptTestSuite) command(c *C) *Command {
+	select {
+	case cmd := <-s.cmds:
+		return cmd
+	case <-time.After(5 * time.Second):
+		c.Fatal("no command is intercepted")
+		return nil
+	}
+}
+
+func (s *interceptTestSuite) TestIntercept(c *C) {
+	conn, err := client.Connect(s.addr, *testUser, *testPassword, "")
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	_, err = conn.Execute("SELECT a FROM t")
+	c.Assert(err, IsNil)
+
+	cmd := s.command(c)
+	c.Assert(cmd.Type, Equals, mysql.COM_QUERY)
+	c.Assert(cmd.Name(), Equals, "Query")
+	c.Assert(cmd.User, Equals, *testUser)
+	c.Assert(cmd.RemoteAddr.String(), Equals, conn.LocalAddr().String())
+	c.Assert(cmd.Query, Equals, "SELECT a FROM t")
+	c.Assert(cmd.Rows, Equals, int64(2))
+	c.Assert(cmd.ErrorCode, Equals, uint16(0))
+	c.Assert(cmd.Duration > 0, Equals, true)
+
+	_, err = conn.Execute("UPDATE t SET a = 1")
+	c.Assert(err, IsNil)
+
+	cmd = s.command(c)
+	c.Assert(cmd.Rows, Equals, int64(0))
+	c.Assert(cmd.AffectedRows, Equals, uint64(3))
+
+	_, err = conn.Execute("DROP TABLE t")
+	c.Assert(err, NotNil)
+
+	cmd = s.command(c)
+	c.Assert(cmd.ErrorCode, Equals, uint16(mysql.ER_NO_SUCH_TABLE))
+	c.Assert(cmd.Err, NotNil)
+
+	_, err = conn.Execute("SLEEP")
+	c.Assert(err, IsNil)
+	c.Assert(s.command(c).Duration >= 50*time.Millisecond, Equals, true)
+
+	// the prepared statement
+	_, err = conn.Execute("SELECT a FROM t WHERE a > ?", "x")
+	c.Assert(err, IsNil)
+
+	cmd = s.command(c)
+	c.Assert(cmd.Type, Equals, mysql.COM_STMT_PREPARE)
+	c.Assert(cmd.Query, Equals, "SELECT a FROM t WHERE a > ?")
+
+	cmd = s.command(c)
+	c.Assert(cmd.Type, Equals, mysql.COM_STMT_EXECUTE)
+	c.Assert(cmd.Query, Equals, "SELECT a FROM t WHERE a > ?")
+	c.Assert(cmd.Args, HasLen, 1)
+	c.Assert(cmd.Rows, Equals, int64(2))
+
+	// the audit log has every command
+	var records []*auditRecord
+	for _, line := range strings.Split(strings.TrimSpace(s.audit.String()), "\n") {
+		r := new(auditRecord)
+		c.Assert(json.Unmarshal([]byte(line), r), IsNil)
+		records = append(records, r)
+	}
+	c.Assert(records, HasLen, 6)
+
+	c.Assert(records[0].Command, Equals, "Query")
+	c.Assert(records[0].User, Equals, *testUser)
+	c.Assert(records[0].Rows, Equals, int64(2))
+	c.Assert(records[1].AffectedRows, Equals, uint64(3))
+	c.Assert(records[2].ErrorCode, Equals, uint16(mysql.ER_NO_SUCH_TABLE))
+	c.Assert(records[2].Error, Matches, ".*doesn't exist")
+	c.Assert(records[5].Command, Equals, "Execute")
+	c.Assert(records[5].Args, DeepEquals, []interface{}{"x"})
+
+	// only SLEEP is slow
+	slow := s.slow.String()
+	c.Assert(strings.Count(slow, "# Time: "), Equals, 1)
+	c.Assert(slow, Matches, `(?s).*# Query_time: \d+\.\d{6}  Rows_sent: 0  Rows_affected: 0  Error_code: 0\n.*`)
+	c.Assert(strings.HasSuffix(slow, "\nSLEEP;\n"), Equals, true)
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"github.com/gdey/go-mysql/mysql"
+)
+
+// MultiResultHandler is an optional interface for Handler, implement it to return several results for one query,
+// like a multi statement query or a stored procedure. HandleQuery is not called if it's implemented.
+type MultiResultHandler interface {
+	//handle mysql.COM_QUERY command, the results are sent in order with SERVER_MORE_RESULTS_EXISTS,
+	//if an error is returned, it's sent after the results, like a failed statement in a multi statement query.
+	HandleMultiQuery(query string) ([]*mysql.Result, error)
+}
+
+var errMultiResultsNotSupported = mysql.NewError(mysql.ER_SP_BADSELECT, "multiple results can't be returned in the given context")
+
+// multiResults is the response of MultiResultHandler.
+type multiResults struct {
+	results []*mysql.Result
+	err     error
+}
+
+// multiResults returns whether the client can read several results, binary is for the statement.
+func (c *Conn) multiResults(binary bool) bool {
+	if binary {
+		return c.capability&mysql.CLIENT_PS_MULTI_RESULTS > 0
+	}
+	return c.capability&mysql.CLIENT_MULTI_RESULTS > 0
+}
+
+func (c *Conn) handleMultiQuery(h MultiResultHandler, query string) interface{} {
+	results, err := h.HandleMultiQuery(query)
+	if len(results) == 0 {
+		if err != nil {
+			return err
+		}
+		return nil
+	}
+
+	if (len(results) > 1 || err != nil) && !c.multiResults(false) {
+		return errMultiResultsNotSupported
+	}
+
+	return &multiResults{results: results, err: err}
+}
+
+func (c *Conn) writeResults(m *multiResults) error {
+	for i, r := range m.results {
+		more := i < len(m.results)-1 || m.err != nil
+		if err := c.writeResult(r, more); err != nil {
+			return err
+		}
+	}
+
+	if m.err != nil {
+		return c.writeError(m.err)
+	}
+	return nil
+}
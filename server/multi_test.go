@@ -0,0 +1,207 @@
+package server
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/gdey/go-mysql/client"
+	"github.com/gdey/go-mysql/mysql"
+	"github.com/juju/errors"
+	. "gopkg.in/check.v1"
+)
+
+type multiTestSuite struct {
+	servers []*Server
+
+	multiAddr string
+	procAddr  string
+}
+
+var _ = Suite(&multiTestSuite{})
+
+// multiHandler returns a result for every statement in the query, "SELECT n" returns n rows,
+// "FAIL" fails, and the others are OK.
+type multiHandler struct {
+	EmptyHandler
+}
+
+func (h *multiHandler) HandleMultiQuery(query string) ([]*mysql.Result, error) {
+	var results []*mysql.Result
+	for _, stmt := range strings.Split(query, ";") {
+		stmt = strings.TrimSpace(stmt)
+
+		var n int
+		if stmt == "FAIL" {
+			return results, mysql.NewError(mysql.ER_UNKNOWN_ERROR, "statement failed")
+		} else if _, err := fmt.Sscanf(stmt, "SELECT %d", &n); err != nil {
+			results = append(results, &mysql.Result{AffectedRows: 1})
+			continue
+		}
+
+		values := make([][]interface{}, n)
+		for i := range values {
+			values[i] = []interface{}{i}
+		}
+
+		r, err := mysql.BuildSimpleResultset([]string{"n"}, values, false)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		results = append(results, &mysql.Result{Resultset: r})
+	}
+
+	return results, nil
+}
+
+// procHandler streams the result sets of 3 and 2 rows, then the OK, like a stored procedure.
+type procHandler struct {
+	streamHandler
+}
+
+func (h *procHandler) call(w *ResultsetWriter) (*mysql.Result, error) {
+	for _, n := range []int{3, 2} {
+		if err := w.WriteRows(&rangeRows{n: n}); err != nil {
+			return nil, errors.Trace(err)
+		}
+
+		if err := w.NextResultset(); err != nil {
+			return nil, errors.Trace(err)
+		}
+	}
+
+	return &mysql.Result{AffectedRows: 5}, nil
+}
+
+func (h *procHandler) HandleStmtPrepare(query string) (int, int, interface{}, error) {
+	return 0, 3, nil, nil
+}
+
+func (h *procHandler) HandleQueryStreaming(query string, w *ResultsetWriter) (*mysql.Result, error) {
+	return h.call(w)
+}
+
+func (h *procHandler) HandleStmtExecuteStreaming(context interface{}, query string, args []interface{}, w *ResultsetWriter) (*mysql.Result, error) {
+	return h.call(w)
+}
+
+func (s *multiTestSuite) serve(c *C, newHandler HandlerFactory) string {
+	p := NewInMemoryProvider()
+	p.AddUser(*testUser, *testPassword)
+
+	srv := NewServer(&ServerConfig{ConnConfig: ConnConfig{Provider: p}}, newHandler)
+	s.servers = append(s.servers, srv)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+
+	go srv.Serve(l)
+	return l.Addr().String()
+}
+
+func (s *multiTestSuite) SetUpSuite(c *C) {
+	s.multiAddr = s.serve(c, func(conn *Conn) Handler {
+		return &multiHandler{}
+	})
+	s.procAddr = s.serve(c, func(conn *Conn) Handler {
+		return &procHandler{}
+	})
+}
+
+func (s *multiTestSuite) TearDownSuite(c *C) {
+	for _, srv := range s.servers {
+		srv.Close()
+	}
+}
+
+func (s *multiTestSuite) TestMultiQuery(c *C) {
+	conn, err := client.Connect(s.multiAddr, *testUser, *testPassword, "", func(conn *client.Conn) {
+		conn.SetMultiStatements(true)
+	})
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	results, err := conn.ExecuteMultiple("SELECT 2; INSERT; SELECT 1")
+	c.Assert(err, IsNil)
+	c.Assert(results, HasLen, 3)
+	c.Assert(results[0].RowNumber(), Equals, 2)
+	c.Assert(results[1].AffectedRows, Equals, uint64(1))
+	c.Assert(results[2].RowNumber(), Equals, 1)
+
+	// the results before the failed statement are sent
+	results, err = conn.ExecuteMultiple("INSERT; FAIL; INSERT")
+	c.Assert(errors.Cause(err).(*mysql.MyError).Message, Equals, "statement failed")
+	c.Assert(results, HasLen, 1)
+
+	rows, err := conn.Query("SELECT 3; SELECT 4")
+	c.Assert(err, IsNil)
+	for _, n := range []int{3, 4} {
+		i := 0
+		for ; rows.Next(); i++ {
+		}
+		c.Assert(i, Equals, n)
+		c.Assert(rows.HasNextResultSet(), Equals, n == 3)
+		rows.NextResultSet()
+	}
+	c.Assert(rows.Close(), IsNil)
+
+	c.Assert(conn.Ping(), IsNil)
+}
+
+func (s *multiTestSuite) TestProcedure(c *C) {
+	conn, err := client.Connect(s.procAddr, *testUser, *testPassword, "")
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	results, err := conn.ExecuteMultiple("CALL p()")
+	c.Assert(err, IsNil)
+	c.Assert(results, HasLen, 3)
+	c.Assert(results[0].RowNumber(), Equals, 3)
+	c.Assert(results[1].RowNumber(), Equals, 2)
+	c.Assert(results[2].AffectedRows, Equals, uint64(5))
+
+	stmt, err := conn.Prepare("CALL p()")
+	c.Assert(err, IsNil)
+	defer stmt.Close()
+
+	results, err = stmt.ExecuteMultiple()
+	c.Assert(err, IsNil)
+	c.Assert(results, HasLen, 3)
+	c.Assert(results[1].Values[1][0], Equals, int64(1))
+	c.Assert(results[2].AffectedRows, Equals, uint64(5))
+}
+
+func (s *multiTestSuite) TestDeprecateEOF(c *C) {
+	conn := rawConnect(c, s.multiAddr, mysql.CLIENT_MULTI_RESULTS|mysql.CLIENT_DEPRECATE_EOF)
+	defer conn.Close()
+
+	// column count, field, 2 rows, no EOF between the fields and rows
+	packets := rawCommand(c, conn, mysql.COM_QUERY, []byte("SELECT 2; INSERT"), 6)
+	c.Assert(packets[0], DeepEquals, []byte{1})
+	c.Assert(packets[2], DeepEquals, []byte{1, '0'})
+	c.Assert(packets[3], DeepEquals, []byte{1, '1'})
+
+	header, status := okStatus(packets[4])
+	c.Assert(header, Equals, mysql.EOF_HEADER)
+	c.Assert(status&mysql.SERVER_MORE_RESULTS_EXISTS, Not(Equals), uint16(0))
+
+	header, status = okStatus(packets[5])
+	c.Assert(header, Equals, mysql.OK_HEADER)
+	c.Assert(status&mysql.SERVER_MORE_RESULTS_EXISTS, Equals, uint16(0))
+}
+
+func (s *multiTestSuite) TestNoMultiResults(c *C) {
+	conn := rawConnect(c, s.multiAddr, 0)
+	defer conn.Close()
+
+	packets := rawCommand(c, conn, mysql.COM_QUERY, []byte("SELECT 1; INSERT"), 1)
+	c.Assert(packets[0][0], Equals, mysql.ERR_HEADER)
+	c.Assert(binary.LittleEndian.Uint16(packets[0][1:]), Equals, uint16(mysql.ER_SP_BADSELECT))
+
+	// one result is fine, with the EOF packets
+	packets = rawCommand(c, conn, mysql.COM_QUERY, []byte("SELECT 1"), 5)
+	c.Assert(packets[2][0], Equals, mysql.EOF_HEADER)
+	c.Assert(packets[3], DeepEquals, []byte{1, '0'})
+	c.Assert(packets[4][0], Equals, mysql.EOF_HEADER)
+}
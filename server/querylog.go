@@ -0,0 +1,143 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gdey/go-mysql/mysql"
+	"github.com/gdey/go/log"
+)
+
+// the time format of the MySQL slow query log
+const slowLogTimeFormat = "2006-01-02T15:04:05.000000Z"
+
+// NewSlowQueryLog returns an Interceptor which writes the queries and the statement executions
+// taking threshold or longer to w, in the format of the MySQL slow query log, and the args in a comment.
+func NewSlowQueryLog(w io.Writer, threshold time.Duration) Interceptor {
+	var m sync.Mutex
+
+	return func(cmd *Command, next func()) {
+		next()
+
+		if (cmd.Type != mysql.COM_QUERY && cmd.Type != mysql.COM_STMT_EXECUTE) || cmd.Duration < threshold {
+			return
+		}
+
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, "# Time: %s\n", cmd.Time.UTC().Format(slowLogTimeFormat))
+		fmt.Fprintf(&buf, "# User@Host: %s[%s] @  [%s]  Id: %d\n", cmd.User, cmd.User, addrHost(cmd.RemoteAddr), cmd.ConnectionID)
+		fmt.Fprintf(&buf, "# Query_time: %.6f  Rows_sent: %d  Rows_affected: %d  Error_code: %d\n",
+			cmd.Duration.Seconds(), cmd.Rows, cmd.AffectedRows, cmd.ErrorCode)
+		if len(cmd.Args) > 0 {
+			if args, err := json.Marshal(logArgs(cmd.Args)); err == nil {
+				fmt.Fprintf(&buf, "# Args: %s\n", args)
+			}
+		}
+		fmt.Fprintf(&buf, "SET timestamp=%d;\n", cmd.Time.Unix())
+		fmt.Fprintf(&buf, "%s;\n", strings.TrimRight(strings.TrimSpace(cmd.Query), ";"))
+
+		m.Lock()
+		_, err := w.Write(buf.Bytes())
+		m.Unlock()
+
+		if err != nil {
+			log.Errorf("write slow query log err: %v", err)
+		}
+	}
+}
+
+// auditRecord is a line of the audit log.
+type auditRecord struct {
+	Time         string        `json:"time"`
+	ConnectionID uint32        `json:"connection_id"`
+	User         string        `json:"user"`
+	RemoteAddr   string        `json:"remote_addr"`
+	Command      string        `json:"command"`
+	Query        string        `json:"query,omitempty"`
+	Args         []interface{} `json:"args,omitempty"`
+	// in seconds
+	Duration     float64 `json:"duration"`
+	Rows         int64   `json:"rows"`
+	AffectedRows uint64  `json:"affected_rows"`
+	ErrorCode    uint16  `json:"error_code,omitempty"`
+	Error        string  `json:"error,omitempty"`
+}
+
+// NewAuditLog returns an Interceptor which writes every command to w after it's handled,
+// as a JSON object in a line, with the connection, the query and args, and the results.
+func NewAuditLog(w io.Writer) Interceptor {
+	var m sync.Mutex
+
+	return func(cmd *Command, next func()) {
+		next()
+
+		r := &auditRecord{
+			Time:         cmd.Time.UTC().Format(time.RFC3339Nano),
+			ConnectionID: cmd.ConnectionID,
+			User:         cmd.User,
+			Command:      cmd.Name(),
+			Query:        cmd.Query,
+			Args:         logArgs(cmd.Args),
+			Duration:     cmd.Duration.Seconds(),
+			Rows:         cmd.Rows,
+			AffectedRows: cmd.AffectedRows,
+			ErrorCode:    cmd.ErrorCode,
+		}
+		if cmd.RemoteAddr != nil {
+			r.RemoteAddr = cmd.RemoteAddr.String()
+		}
+		if cmd.Err != nil {
+			r.Error = cmd.Err.Error()
+		}
+
+		data, err := json.Marshal(r)
+		if err != nil {
+			log.Errorf("encode audit log of connection %d err: %v", cmd.ConnectionID, err)
+			return
+		}
+		data = append(data, '\n')
+
+		m.Lock()
+		_, err = w.Write(data)
+		m.Unlock()
+
+		if err != nil {
+			log.Errorf("write audit log err: %v", err)
+		}
+	}
+}
+
+// logArgs copies the args for the logs, the byte slices are strings.
+func logArgs(args []interface{}) []interface{} {
+	if len(args) == 0 {
+		return nil
+	}
+
+	values := make([]interface{}, len(args))
+	for i, arg := range args {
+		if b, ok := arg.([]byte); ok {
+			values[i] = string(b)
+		} else {
+			values[i] = arg
+		}
+	}
+	return values
+}
+
+func addrHost(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
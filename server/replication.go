@@ -0,0 +1,540 @@
+package server
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gdey/go-mysql/mysql"
+	"github.com/gdey/go-mysql/replication"
+	"github.com/juju/errors"
+)
+
+// The max time we wait for the semi-sync ack of an event, the binlog dump will be stopped if exceeded.
+var SemiSyncAckTimeout = 10 * time.Second
+
+// the time we wait for the events before checking whether the slave is still connected,
+// if the slave doesn't want the heartbeat
+var binlogDumpPollInterval = time.Second
+
+// Slave is the slave information registered with mysql.COM_REGISTER_SLAVE
+type Slave struct {
+	ServerID uint32
+	Host     string
+	User     string
+	Password string
+	Port     uint16
+	Rank     uint32
+	MasterID uint32
+}
+
+// BinlogDumpRequest is the request of mysql.COM_BINLOG_DUMP or mysql.COM_BINLOG_DUMP_GTID
+type BinlogDumpRequest struct {
+	ServerID uint32
+	Flags    uint16
+
+	// the position to start from, the file name may be empty for mysql.COM_BINLOG_DUMP_GTID
+	Position mysql.Position
+
+	// only set for mysql.COM_BINLOG_DUMP_GTID
+	GTIDSet *mysql.MysqlGTIDSet
+
+	// the slave's @master_heartbeat_period, 0 means no heartbeat
+	HeartbeatPeriod time.Duration
+
+	// whether the slave set @rpl_semi_sync_slave
+	SemiSync bool
+}
+
+// NonBlock returns true if the slave wants to stop when there is no more event.
+func (r *BinlogDumpRequest) NonBlock() bool {
+	return r.Flags&replication.BINLOG_DUMP_NON_BLOCK > 0
+}
+
+// BinlogEventSource provides the binlog events for a binlog dump.
+type BinlogEventSource interface {
+	// GetEvent returns the next event data, including the event header and the checksum if has.
+	// If no event comes in timeout, replication.ErrGetEventTimeout must be returned, 0 timeout means waiting forever.
+	// If there is no more event, io.EOF must be returned, and the binlog dump is finished.
+	GetEvent(timeout time.Duration) ([]byte, error)
+
+	// Close is called when the binlog dump is finished.
+	Close() error
+}
+
+// SemiSyncAcker is an optional interface for BinlogEventSource,
+// it will be notified with the position the slave acked in semi-sync replication.
+type SemiSyncAcker interface {
+	SemiSyncAck(pos mysql.Position)
+}
+
+// ReplicationHandler is an optional interface for Handler, implement it to let Conn act as a binlog master.
+// The Handler still needs to handle the queries the slave executes before dumping,
+// like SHOW GLOBAL VARIABLES LIKE 'BINLOG_CHECKSUM' and SET @master_binlog_checksum='NONE'.
+type ReplicationHandler interface {
+	//handle mysql.COM_REGISTER_SLAVE
+	HandleRegisterSlave(s *Slave) error
+	//handle mysql.COM_BINLOG_DUMP and mysql.COM_BINLOG_DUMP_GTID, returns the source of the events sent to the slave,
+	//the events must start with a fake rotate event and a format description event, like MySQL does.
+	HandleBinlogDump(r *BinlogDumpRequest) (BinlogEventSource, error)
+}
+
+// the user variables used by the binlog dump, we handle them ourselves.
+var replicationVarRegexp = regexp.MustCompile(`(?i)^\s*SET\s+@(master_heartbeat_period|rpl_semi_sync_slave)\s*=\s*(\d+)\s*;?\s*$`)
+
+func (c *Conn) handleReplicationQuery(query string) bool {
+	if _, ok := c.h.(ReplicationHandler); !ok {
+		return false
+	}
+
+	m := replicationVarRegexp.FindStringSubmatch(query)
+	if m == nil {
+		return false
+	}
+
+	n, err := strconv.ParseUint(m[2], 10, 64)
+	if err != nil {
+		return false
+	}
+
+	if m[1] == "master_heartbeat_period" {
+		c.heartbeatPeriod = time.Duration(n)
+	} else {
+		c.semiSync = n > 0
+	}
+
+	return true
+}
+
+func (c *Conn) handleRegisterSlave(data []byte) error {
+	h, ok := c.h.(ReplicationHandler)
+	if !ok {
+		return mysql.NewError(mysql.ER_UNKNOWN_ERROR, "register slave is not supported now")
+	}
+
+	s := new(Slave)
+
+	if len(data) < 4 {
+		return mysql.ErrMalformPacket
+	}
+	s.ServerID = binary.LittleEndian.Uint32(data)
+	pos := 4
+
+	var err error
+	for _, v := range []*string{&s.Host, &s.User, &s.Password} {
+		if *v, pos, err = readLengthString(data, pos); err != nil {
+			return err
+		}
+	}
+
+	if len(data) < pos+2+4+4 {
+		return mysql.ErrMalformPacket
+	}
+
+	s.Port = binary.LittleEndian.Uint16(data[pos:])
+	pos += 2
+
+	s.Rank = binary.LittleEndian.Uint32(data[pos:])
+	pos += 4
+
+	s.MasterID = binary.LittleEndian.Uint32(data[pos:])
+
+	return h.HandleRegisterSlave(s)
+}
+
+func readLengthString(data []byte, pos int) (string, int, error) {
+	if len(data) < pos+1 {
+		return "", 0, mysql.ErrMalformPacket
+	}
+
+	n := int(data[pos])
+	pos++
+
+	if len(data) < pos+n {
+		return "", 0, mysql.ErrMalformPacket
+	}
+
+	return string(data[pos : pos+n]), pos + n, nil
+}
+
+func (c *Conn) newBinlogDumpRequest() *BinlogDumpRequest {
+	r := new(BinlogDumpRequest)
+	r.HeartbeatPeriod = c.heartbeatPeriod
+	r.SemiSync = c.semiSync
+	return r
+}
+
+func (c *Conn) handleBinlogDump(data []byte) error {
+	if len(data) < 10 {
+		return mysql.ErrMalformPacket
+	}
+
+	r := c.newBinlogDumpRequest()
+
+	pos := 0
+	r.Position.Pos = binary.LittleEndian.Uint32(data[pos:])
+	pos += 4
+
+	r.Flags = binary.LittleEndian.Uint16(data[pos:])
+	pos += 2
+
+	r.ServerID = binary.LittleEndian.Uint32(data[pos:])
+	pos += 4
+
+	r.Position.Name = string(data[pos:])
+
+	return c.dumpBinlog(r)
+}
+
+func (c *Conn) handleBinlogDumpGTID(data []byte) error {
+	if len(data) < 10 {
+		return mysql.ErrMalformPacket
+	}
+
+	r := c.newBinlogDumpRequest()
+
+	pos := 0
+	r.Flags = binary.LittleEndian.Uint16(data[pos:])
+	pos += 2
+
+	r.ServerID = binary.LittleEndian.Uint32(data[pos:])
+	pos += 4
+
+	n := int(binary.LittleEndian.Uint32(data[pos:]))
+	pos += 4
+
+	if len(data) < pos+n+8 {
+		return mysql.ErrMalformPacket
+	}
+
+	r.Position.Name = string(data[pos : pos+n])
+	pos += n
+
+	r.Position.Pos = uint32(binary.LittleEndian.Uint64(data[pos:]))
+	pos += 8
+
+	if len(data) >= pos+4 {
+		n = int(binary.LittleEndian.Uint32(data[pos:]))
+		pos += 4
+
+		if len(data) < pos+n {
+			return mysql.ErrMalformPacket
+		}
+
+		var err error
+		if r.GTIDSet, err = mysql.DecodeMysqlGTIDSet(data[pos : pos+n]); err != nil {
+			return errors.Trace(err)
+		}
+	} else {
+		r.GTIDSet = &mysql.MysqlGTIDSet{Sets: make(map[string]*mysql.UUIDSet)}
+	}
+
+	return c.dumpBinlog(r)
+}
+
+// binlogDumpState tracks the binlog position we have sent to the slave.
+type binlogDumpState struct {
+	name string
+	pos  uint32
+
+	serverID    uint32
+	checksumAlg byte
+	hasFormat   bool
+}
+
+// eventBody returns the event data without the header and checksum.
+func (s *binlogDumpState) eventBody(data []byte) []byte {
+	body := data[replication.EventHeaderSize:]
+
+	if s.hasFormat {
+		if s.checksumAlg == replication.BINLOG_CHECKSUM_ALG_CRC32 && len(body) >= 4 {
+			body = body[0 : len(body)-4]
+		}
+	} else if len(body) >= 4 {
+		// events before the format description event, like the fake rotate event, may also have a checksum
+		n := len(data) - 4
+		if crc32.ChecksumIEEE(data[0:n]) == binary.LittleEndian.Uint32(data[n:]) {
+			body = body[0 : len(body)-4]
+		}
+	}
+
+	return body
+}
+
+// update updates the state with the event and returns whether the event ends a transaction.
+func (s *binlogDumpState) update(data []byte) (bool, error) {
+	h := new(replication.EventHeader)
+	if err := h.Decode(data); err != nil {
+		return false, errors.Trace(err)
+	}
+
+	if h.LogPos != 0 {
+		s.pos = h.LogPos
+	}
+
+	switch h.EventType {
+	case replication.FORMAT_DESCRIPTION_EVENT:
+		e := new(replication.FormatDescriptionEvent)
+		if err := e.Decode(data[replication.EventHeaderSize:]); err != nil {
+			return false, errors.Trace(err)
+		}
+
+		s.serverID = h.ServerID
+		s.checksumAlg = e.ChecksumAlgorithm
+		s.hasFormat = true
+	case replication.ROTATE_EVENT:
+		e := new(replication.RotateEvent)
+		if err := e.Decode(s.eventBody(data)); err != nil {
+			return false, errors.Trace(err)
+		}
+
+		s.name = string(e.NextLogName)
+		s.pos = uint32(e.Position)
+	case replication.XID_EVENT:
+		return true, nil
+	case replication.QUERY_EVENT:
+		e := new(replication.QueryEvent)
+		if err := e.Decode(s.eventBody(data)); err != nil {
+			return false, errors.Trace(err)
+		}
+
+		return string(e.Query) != "BEGIN", nil
+	}
+
+	return false, nil
+}
+
+// checkSlave checks whether the slave is gone or quits in the binlog dump, it sends nothing but the semi-sync acks,
+// so the connection is closed if it sends COM_QUIT or anything else.
+func (c *Conn) checkSlave() error {
+	ok, err := c.WaitData(time.Millisecond)
+	if err != nil || !ok {
+		return err
+	}
+
+	c.ResetSequence()
+	data, err := c.ReadPacket()
+	c.Close()
+	if err != nil {
+		return err
+	} else if data[0] == mysql.COM_QUIT {
+		return nil
+	}
+	return errors.Errorf("unexpected data %v from the slave in binlog dump", data)
+}
+
+func (c *Conn) dumpBinlog(r *BinlogDumpRequest) error {
+	h, ok := c.h.(ReplicationHandler)
+	if !ok {
+		return mysql.NewError(mysql.ER_UNKNOWN_ERROR, "binlog dump is not supported now")
+	}
+
+	src, err := h.HandleBinlogDump(r)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	s := &binlogDumpState{name: r.Position.Name, pos: r.Position.Pos}
+
+	timeout := r.HeartbeatPeriod
+	if timeout <= 0 {
+		timeout = binlogDumpPollInterval
+	}
+
+	for {
+		data, err := src.GetEvent(timeout)
+		if err == replication.ErrGetEventTimeout {
+			if r.HeartbeatPeriod > 0 {
+				err = c.writeHeartbeat(s)
+			} else {
+				// the slave sends nothing while dumping, so we only see it's gone by reading
+				err = c.checkSlave()
+			}
+			if err != nil || c.Closed() {
+				return errors.Trace(err)
+			}
+			continue
+		} else if err == io.EOF {
+			return c.writeEOF()
+		} else if err != nil {
+			return err
+		}
+
+		trxEnd, err := s.update(data)
+		if err != nil {
+			return err
+		}
+
+		needACK := r.SemiSync && trxEnd
+		if err = c.writeBinlogEvent(data, r.SemiSync, needACK); err != nil {
+			return errors.Trace(err)
+		}
+
+		if needACK {
+			if err = c.readSemiSyncACK(src); err != nil {
+				return errors.Trace(err)
+			}
+		}
+	}
+}
+
+func (c *Conn) writeBinlogEvent(event []byte, semiSync bool, needACK bool) error {
+	data := make([]byte, 4, 4+3+len(event))
+
+	data = append(data, mysql.OK_HEADER)
+
+	if semiSync {
+		data = append(data, replication.SemiSyncIndicator)
+		if needACK {
+			data = append(data, 0x01)
+		} else {
+			data = append(data, 0x00)
+		}
+	}
+
+	data = append(data, event...)
+
+	if err := c.WritePacket(data); err != nil {
+		return err
+	}
+
+	// the slave only reads, so the compressed event is sent at once
+	return c.Flush()
+}
+
+func (c *Conn) writeHeartbeat(s *binlogDumpState) error {
+	h := &replication.EventHeader{
+		EventType: replication.HEARTBEAT_EVENT,
+		ServerID:  s.serverID,
+		LogPos:    s.pos,
+		Flags:     replication.LOG_EVENT_ARTIFICIAL_F,
+	}
+
+	data, err := replication.EncodeEvent(h, &replication.GenericEvent{Data: []byte(s.name)}, s.checksumAlg)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	return c.writeBinlogEvent(data, false, false)
+}
+
+func (c *Conn) readSemiSyncACK(src BinlogEventSource) error {
+	// the ack uses its own sequence, starting from 0
+	seq := c.Sequence
+	c.ResetSequence()
+
+	c.SetReadDeadline(time.Now().Add(SemiSyncAckTimeout))
+	data, err := c.ReadPacket()
+	c.SetReadDeadline(time.Time{})
+
+	c.Sequence = seq
+
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	if len(data) < 9 || data[0] != replication.SemiSyncIndicator {
+		return errors.Errorf("invalid semi-sync ack packet %v", data)
+	}
+
+	if a, ok := src.(SemiSyncAcker); ok {
+		a.SemiSyncAck(mysql.Position{
+			Name: string(data[9:]),
+			Pos:  uint32(binary.LittleEndian.Uint64(data[1:])),
+		})
+	}
+
+	return nil
+}
+
+// MemoryBinlogSource is a BinlogEventSource that serves the events appended to it, it's useful for testing.
+// Every binlog dump should use its own MemoryBinlogSource.
+type MemoryBinlogSource struct {
+	m sync.Mutex
+
+	events [][]byte
+	index  int
+
+	noMore bool
+	closed bool
+
+	notify chan struct{}
+}
+
+func NewMemoryBinlogSource(events ...[]byte) *MemoryBinlogSource {
+	s := new(MemoryBinlogSource)
+
+	s.events = events
+	s.notify = make(chan struct{})
+
+	return s
+}
+
+// Append appends the events and wakes up the waiting binlog dump.
+func (s *MemoryBinlogSource) Append(events ...[]byte) {
+	s.m.Lock()
+	s.events = append(s.events, events...)
+	s.wakeup()
+	s.m.Unlock()
+}
+
+// Finish makes GetEvent return io.EOF after all the events are sent.
+func (s *MemoryBinlogSource) Finish() {
+	s.m.Lock()
+	s.noMore = true
+	s.wakeup()
+	s.m.Unlock()
+}
+
+func (s *MemoryBinlogSource) wakeup() {
+	close(s.notify)
+	s.notify = make(chan struct{})
+}
+
+func (s *MemoryBinlogSource) GetEvent(timeout time.Duration) ([]byte, error) {
+	var timer <-chan time.Time
+	if timeout > 0 {
+		timer = time.After(timeout)
+	}
+
+	for {
+		s.m.Lock()
+		if s.closed {
+			s.m.Unlock()
+			return nil, io.EOF
+		} else if s.index < len(s.events) {
+			data := s.events[s.index]
+			s.index++
+			s.m.Unlock()
+			return data, nil
+		} else if s.noMore {
+			s.m.Unlock()
+			return nil, io.EOF
+		}
+
+		notify := s.notify
+		s.m.Unlock()
+
+		select {
+		case <-notify:
+		case <-timer:
+			return nil, replication.ErrGetEventTimeout
+		}
+	}
+}
+
+func (s *MemoryBinlogSource) Close() error {
+	s.m.Lock()
+	if !s.closed {
+		s.closed = true
+		s.wakeup()
+	}
+	s.m.Unlock()
+
+	return nil
+}
@@ -0,0 +1,296 @@
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gdey/go-mysql/client"
+	"github.com/gdey/go-mysql/mysql"
+	"github.com/gdey/go-mysql/replication"
+	. "gopkg.in/check.v1"
+)
+
+type testBinlog struct {
+	events [][]byte
+
+	requests chan *BinlogDumpRequest
+	acks     chan mysql.Position
+	// the sources closed
+	closes chan struct{}
+}
+
+func newTestBinlog() *testBinlog {
+	b := new(testBinlog)
+
+	b.requests = make(chan *BinlogDumpRequest, 4)
+	b.acks = make(chan mysql.Position, 16)
+	b.closes = make(chan struct{}, 16)
+
+	b.events = testBinlogEvents(2)
+
+	return b
+}
+
+// testBinlogEvents returns a fake rotate event, a format description event and the transactions.
+func testBinlogEvents(trxs int) [][]byte {
+	var events [][]byte
+
+	var buf bytes.Buffer
+	w := replication.NewBinlogWriter(&buf, 1)
+	w.WriteFileHeader()
+
+	// fake rotate event
+	h := &replication.EventHeader{
+		EventType: replication.ROTATE_EVENT,
+		ServerID:  1,
+		Flags:     replication.LOG_EVENT_ARTIFICIAL_F,
+	}
+	data, _ := replication.EncodeEvent(h, &replication.RotateEvent{Position: 4, NextLogName: []byte("mysql-bin.000001")},
+		replication.BINLOG_CHECKSUM_ALG_OFF)
+	events = append(events, data)
+
+	type event struct {
+		t replication.EventType
+		e replication.Event
+	}
+
+	es := []event{
+		{replication.FORMAT_DESCRIPTION_EVENT, replication.NewFormatDescriptionEvent(replication.DefaultBinlogServerVersion, replication.BINLOG_CHECKSUM_ALG_CRC32)},
+	}
+	for i := 0; i < trxs; i++ {
+		es = append(es,
+			event{replication.QUERY_EVENT, &replication.QueryEvent{Schema: []byte("test"), Query: []byte("BEGIN")}},
+			event{replication.XID_EVENT, &replication.XIDEvent{XID: uint64(i + 1)}})
+	}
+
+	for _, e := range es {
+		h := &replication.EventHeader{EventType: e.t, ServerID: 1}
+		if be, err := w.WriteEventWithHeader(h, e.e); err != nil {
+			panic(err)
+		} else {
+			events = append(events, be.RawData)
+		}
+	}
+
+	return events
+}
+
+type testBinlogSource struct {
+	*MemoryBinlogSource
+
+	acks   chan mysql.Position
+	closes chan struct{}
+}
+
+func (s *testBinlogSource) SemiSyncAck(pos mysql.Position) {
+	s.acks <- pos
+}
+
+func (s *testBinlogSource) Close() error {
+	err := s.MemoryBinlogSource.Close()
+	select {
+	case s.closes <- struct{}{}:
+	default:
+	}
+	return err
+}
+
+func (h *testHandler) showVariables(query string, binary bool) (*mysql.Resultset, error) {
+	var value string
+
+	query = strings.ToLower(query)
+	if strings.Contains(query, "binlog_checksum") {
+		value = "CRC32"
+	} else if strings.Contains(query, "rpl_semi_sync_master_enabled") {
+		value = "ON"
+	} else {
+		return nil, fmt.Errorf("invalid query %s", query)
+	}
+
+	return mysql.BuildSimpleResultset([]string{"Variable_name", "Value"}, [][]interface{}{
+		[]interface{}{"", value},
+	}, binary)
+}
+
+func (h *testHandler) HandleRegisterSlave(s *Slave) error {
+	if s.ServerID == 0 {
+		return fmt.Errorf("invalid server id")
+	}
+	return nil
+}
+
+func (h *testHandler) HandleBinlogDump(r *BinlogDumpRequest) (BinlogEventSource, error) {
+	b := h.s.binlog
+	b.requests <- r
+
+	return &testBinlogSource{NewMemoryBinlogSource(b.events...), b.acks, b.closes}, nil
+}
+
+func (s *serverTestSuite) newBinlogSyncer(c *C) *replication.BinlogSyncer {
+	b := replication.NewBinlogSyncer(100, mysql.MySQLFlavor)
+
+	host, port, err := net.SplitHostPort(*testAddr)
+	c.Assert(err, IsNil)
+
+	n, err := strconv.ParseUint(port, 10, 16)
+	c.Assert(err, IsNil)
+
+	err = b.RegisterSlave(host, uint16(n), *testUser, *testPassword)
+	c.Assert(err, IsNil)
+
+	return b
+}
+
+func (s *serverTestSuite) TestBinlogDump(c *C) {
+	b := s.newBinlogSyncer(c)
+	defer b.Close()
+
+	_, err := b.ExecuteSql(fmt.Sprintf("SET @master_heartbeat_period=%d", 100*time.Millisecond))
+	c.Assert(err, IsNil)
+
+	err = b.EnableSemiSync()
+	c.Assert(err, IsNil)
+
+	st, err := b.StartSync(mysql.Position{Name: "mysql-bin.000001", Pos: 4})
+	c.Assert(err, IsNil)
+
+	r := <-s.binlog.requests
+	c.Assert(r.ServerID, Equals, uint32(100))
+	c.Assert(r.Position, DeepEquals, mysql.Position{Name: "mysql-bin.000001", Pos: 4})
+	c.Assert(r.GTIDSet, IsNil)
+	c.Assert(r.HeartbeatPeriod, Equals, 100*time.Millisecond)
+	c.Assert(r.SemiSync, Equals, true)
+
+	types := []replication.EventType{
+		replication.ROTATE_EVENT,
+		replication.FORMAT_DESCRIPTION_EVENT,
+		replication.QUERY_EVENT,
+		replication.XID_EVENT,
+		replication.QUERY_EVENT,
+		replication.XID_EVENT,
+		replication.HEARTBEAT_EVENT,
+	}
+
+	// the slave acks every transaction, and still reads the following events in the right sequence
+	var acked []uint32
+	var e *replication.BinlogEvent
+	for _, t := range types {
+		e, err = st.GetEventTimeout(time.Second)
+		c.Assert(err, IsNil)
+		c.Assert(e.Header.EventType, Equals, t)
+
+		if t == replication.XID_EVENT {
+			acked = append(acked, e.Header.LogPos)
+		}
+	}
+
+	// heartbeat has the current binlog position
+	pos := uint32(4)
+	for _, data := range s.binlog.events[1:] {
+		pos += uint32(len(data))
+	}
+	c.Assert(e.Header.LogPos, Equals, pos)
+	c.Assert(e.Event.(*replication.GenericEvent).Data, DeepEquals, []byte("mysql-bin.000001"))
+
+	for _, p := range acked {
+		select {
+		case ack := <-s.binlog.acks:
+			c.Assert(ack, DeepEquals, mysql.Position{Name: "mysql-bin.000001", Pos: p})
+		case <-time.After(time.Second):
+			c.Fatal("no semi-sync ack")
+		}
+	}
+}
+
+func (s *serverTestSuite) TestBinlogDumpDisconnect(c *C) {
+	interval := binlogDumpPollInterval
+	binlogDumpPollInterval = 50 * time.Millisecond
+	defer func() {
+		binlogDumpPollInterval = interval
+	}()
+
+	// drain the sources closed by the other tests
+	for len(s.binlog.closes) > 0 {
+		<-s.binlog.closes
+	}
+
+	b := s.newBinlogSyncer(c)
+
+	st, err := b.StartSync(mysql.Position{Name: "mysql-bin.000001", Pos: 4})
+	c.Assert(err, IsNil)
+
+	r := <-s.binlog.requests
+	c.Assert(r.HeartbeatPeriod, Equals, time.Duration(0))
+
+	for range s.binlog.events {
+		_, err = st.GetEventTimeout(time.Second)
+		c.Assert(err, IsNil)
+	}
+
+	// no more events and no heartbeat, the dump still finds the slave is gone
+	b.Close()
+
+	select {
+	case <-s.binlog.closes:
+	case <-time.After(5 * time.Second):
+		c.Fatal("binlog dump is not stopped after the slave is gone")
+	}
+}
+
+func (s *serverTestSuite) TestBinlogDumpQuit(c *C) {
+	interval := binlogDumpPollInterval
+	binlogDumpPollInterval = 50 * time.Millisecond
+	defer func() {
+		binlogDumpPollInterval = interval
+	}()
+
+	for len(s.binlog.closes) > 0 {
+		<-s.binlog.closes
+	}
+
+	conn, err := client.Connect(*testAddr, *testUser, *testPassword, "")
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	// COM_BINLOG_DUMP, the position, flags, server id and file name
+	data := append(make([]byte, 4), mysql.COM_BINLOG_DUMP, 4, 0, 0, 0, 0, 0, 100, 0, 0, 0)
+	data = append(data, "mysql-bin.000001"...)
+	conn.ResetSequence()
+	c.Assert(conn.WritePacket(data), IsNil)
+	<-s.binlog.requests
+
+	// the slave quits without reading the events, the connection is kept open
+	conn.ResetSequence()
+	c.Assert(conn.WritePacket(append(make([]byte, 4), mysql.COM_QUIT)), IsNil)
+
+	select {
+	case <-s.binlog.closes:
+	case <-time.After(5 * time.Second):
+		c.Fatal("binlog dump is not stopped after the slave quits")
+	}
+}
+
+func (s *serverTestSuite) TestBinlogDumpGTID(c *C) {
+	b := s.newBinlogSyncer(c)
+	defer b.Close()
+
+	gset, err := mysql.ParseMysqlGTIDSet("de278ad0-2106-11e4-9f8e-6edd0ca20947:1-10")
+	c.Assert(err, IsNil)
+
+	st, err := b.StartSyncGTID(gset)
+	c.Assert(err, IsNil)
+
+	r := <-s.binlog.requests
+	c.Assert(r.GTIDSet, NotNil)
+	c.Assert(r.GTIDSet.Equal(gset), Equals, true)
+	c.Assert(r.SemiSync, Equals, false)
+
+	for i := 0; i < len(s.binlog.events); i++ {
+		_, err = st.GetEventTimeout(time.Second)
+		c.Assert(err, IsNil)
+	}
+}
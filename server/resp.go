@@ -4,36 +4,69 @@ import (
 	"fmt"
 
 	"github.com/gdey/go-mysql/mysql"
+	"github.com/juju/errors"
 )
 
 func (c *Conn) writeOK(r *mysql.Result) error {
+	return c.writeOKStatus(mysql.OK_HEADER, r, 0)
+}
+
+// writeOKStatus writes the OK packet with the status added, like SERVER_MORE_RESULTS_EXISTS,
+// the header is EOF_HEADER if it's in place of the EOF packet for CLIENT_DEPRECATE_EOF.
+func (c *Conn) writeOKStatus(header byte, r *mysql.Result, status uint16) error {
 	if r == nil {
 		r = &mysql.Result{}
 	}
 
-	r.Status |= c.status
+	status |= r.Status | c.session.status
 
 	data := make([]byte, 4, 32)
 
-	data = append(data, mysql.OK_HEADER)
+	data = append(data, header)
 
 	data = append(data, mysql.PutLengthEncodedInt(r.AffectedRows)...)
 	data = append(data, mysql.PutLengthEncodedInt(r.InsertId)...)
 
+	if r.InsertId > 0 {
+		c.session.lastInsertId = r.InsertId
+	}
+	c.recordOK(r)
+
+	var state []byte
+	if c.capability&mysql.CLIENT_SESSION_TRACK > 0 {
+		if state = c.session.trackChanges(); len(state) > 0 {
+			status |= mysql.SERVER_SESSION_STATE_CHANGED
+		}
+	}
+
 	if c.capability&mysql.CLIENT_PROTOCOL_41 > 0 {
-		data = append(data, byte(r.Status), byte(r.Status>>8))
+		data = append(data, byte(status), byte(status>>8))
 		data = append(data, 0, 0)
 	}
 
+	//info and the session state changes
+	if c.capability&mysql.CLIENT_SESSION_TRACK > 0 {
+		data = append(data, 0)
+		if len(state) > 0 {
+			data = append(data, mysql.PutLengthEncodedString(state)...)
+		}
+	}
+
 	return c.WritePacket(data)
 }
 
 func (c *Conn) writeError(e error) error {
+	if errors.Cause(e) == ErrCloseConn {
+		// returned to close the connection
+		return e
+	}
+
 	var m *mysql.MyError
 	var ok bool
-	if m, ok = e.(*mysql.MyError); !ok {
+	if m, ok = errors.Cause(e).(*mysql.MyError); !ok {
 		m = mysql.NewError(mysql.ER_UNKNOWN_ERROR, e.Error())
 	}
+	c.recordError(m, e)
 
 	data := make([]byte, 4, 16+len(m.Message))
 
@@ -51,18 +84,51 @@ func (c *Conn) writeError(e error) error {
 }
 
 func (c *Conn) writeEOF() error {
+	return c.writeEOFStatus(0)
+}
+
+// writeEOFStatus writes the EOF packet with the status added,
+// it's an OK packet with EOF_HEADER if the client has CLIENT_DEPRECATE_EOF.
+func (c *Conn) writeEOFStatus(status uint16) error {
+	if c.capability&mysql.CLIENT_DEPRECATE_EOF > 0 {
+		return c.writeOKStatus(mysql.EOF_HEADER, nil, status)
+	}
+
+	status |= c.session.status
+
 	data := make([]byte, 4, 9)
 
 	data = append(data, mysql.EOF_HEADER)
 	if c.capability&mysql.CLIENT_PROTOCOL_41 > 0 {
 		data = append(data, 0, 0)
-		data = append(data, byte(c.status), byte(c.status>>8))
+		data = append(data, byte(status), byte(status>>8))
 	}
 
 	return c.WritePacket(data)
 }
 
-func (c *Conn) writeResultset(r *mysql.Resultset) error {
+// writeFieldsEOF ends the column definitions, the EOF packet is omitted if the client has CLIENT_DEPRECATE_EOF.
+func (c *Conn) writeFieldsEOF() error {
+	if c.capability&mysql.CLIENT_DEPRECATE_EOF > 0 {
+		return nil
+	}
+	return c.writeEOF()
+}
+
+// writeResult writes the result set or the OK packet, SERVER_MORE_RESULTS_EXISTS is set if more results follow.
+func (c *Conn) writeResult(r *mysql.Result, more bool) error {
+	var status uint16
+	if more {
+		status = mysql.SERVER_MORE_RESULTS_EXISTS
+	}
+
+	if r != nil && r.Resultset != nil {
+		return c.writeResultset(r.Resultset, status|r.Status)
+	}
+	return c.writeOKStatus(mysql.OK_HEADER, r, status)
+}
+
+func (c *Conn) writeResultset(r *mysql.Resultset, status uint16) error {
 	columnLen := mysql.PutLengthEncodedInt(uint64(len(r.Fields)))
 
 	data := make([]byte, 4, 1024)
@@ -80,7 +146,7 @@ func (c *Conn) writeResultset(r *mysql.Resultset) error {
 		}
 	}
 
-	if err := c.writeEOF(); err != nil {
+	if err := c.writeFieldsEOF(); err != nil {
 		return err
 	}
 
@@ -90,9 +156,10 @@ func (c *Conn) writeResultset(r *mysql.Resultset) error {
 		if err := c.WritePacket(data); err != nil {
 			return err
 		}
+		c.recordRows(1)
 	}
 
-	if err := c.writeEOF(); err != nil {
+	if err := c.writeEOFStatus(status); err != nil {
 		return err
 	}
 
@@ -118,20 +185,23 @@ func (c *Conn) writeFieldList(fs []*mysql.Field) error {
 
 type noResponse struct{}
 
+// eofResponse is the response of the commands answered with the EOF packet, like COM_SET_OPTION.
+type eofResponse struct{}
+
 func (c *Conn) writeValue(value interface{}) error {
 	switch v := value.(type) {
 	case noResponse:
 		return nil
+	case eofResponse:
+		return c.writeEOF()
 	case error:
 		return c.writeError(v)
 	case nil:
 		return c.writeOK(nil)
 	case *mysql.Result:
-		if v != nil && v.Resultset != nil {
-			return c.writeResultset(v.Resultset)
-		} else {
-			return c.writeOK(v)
-		}
+		return c.writeResult(v, false)
+	case *multiResults:
+		return c.writeResults(v)
 	case []*mysql.Field:
 		return c.writeFieldList(v)
 	case *Stmt:
@@ -0,0 +1,309 @@
+package server
+
+import (
+	"context"
+	"net"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/gdey/go-mysql/mysql"
+	"github.com/gdey/go-mysql/packet"
+	"github.com/gdey/go/log"
+	"github.com/juju/errors"
+)
+
+// ErrServerClosed is returned by Serve after Shutdown or Close.
+var ErrServerClosed = errors.New("server was closed")
+
+// ServerConfig is the config for NewServer.
+type ServerConfig struct {
+	ConnConfig
+
+	// the max number of the client connections, the new ones are refused with ER_CON_COUNT_ERROR,
+	// 0 means no limit
+	MaxConns int
+
+	// the connection is closed if no command comes in it in IdleTimeout,
+	// it's also the timeout of the handshake, 0 means no timeout
+	IdleTimeout time.Duration
+}
+
+// HandlerFactory creates the Handler for a new connection, so the handler can keep the session state.
+// The connection has not done the handshake, use GetUser and GetDatabase later when handling the commands.
+type HandlerFactory func(c *Conn) Handler
+
+// CloseHandler is an optional interface for Handler, implement it to release the resources of the session,
+// like the backend connection of a proxy. Server calls it after the connection is closed.
+type CloseHandler interface {
+	HandleClose()
+}
+
+// Server accepts the clients from the listener, and handles every connection in its own goroutine.
+type Server struct {
+	cfg        *ServerConfig
+	newHandler HandlerFactory
+
+	m         sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[*serverConn]struct{}
+	// Shutdown or Close is called
+	closed bool
+
+	wg sync.WaitGroup
+}
+
+type serverConn struct {
+	net.Conn
+
+	// a command is being handled
+	busy bool
+}
+
+func NewServer(cfg *ServerConfig, newHandler HandlerFactory) *Server {
+	s := new(Server)
+
+	s.cfg = cfg
+	s.newHandler = newHandler
+	s.listeners = make(map[net.Listener]struct{})
+	s.conns = make(map[*serverConn]struct{})
+
+	return s
+}
+
+// ListenAndServe listens on the TCP address, or the unix socket if addr has "/", then calls Serve.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen(mysql.GetNetProto(addr), addr)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	return s.Serve(l)
+}
+
+// Serve accepts the clients from l until Shutdown or Close, and closes l when it returns.
+// It returns ErrServerClosed after Shutdown or Close, or the accept error.
+func (s *Server) Serve(l net.Listener) error {
+	s.m.Lock()
+	if s.closed {
+		s.m.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.m.Unlock()
+
+	defer func() {
+		s.m.Lock()
+		delete(s.listeners, l)
+		s.m.Unlock()
+
+		l.Close()
+	}()
+
+	var delay time.Duration
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+
+			// retry the temporary error like too many open files
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				if delay == 0 {
+					delay = 5 * time.Millisecond
+				} else if delay *= 2; delay > time.Second {
+					delay = time.Second
+				}
+
+				log.Errorf("server accept err: %v, retry in %v", err, delay)
+				time.Sleep(delay)
+				continue
+			}
+
+			return errors.Trace(err)
+		}
+		delay = 0
+
+		sc := &serverConn{Conn: conn}
+		if err = s.addConn(sc); err != nil {
+			s.refuse(conn, err)
+			continue
+		}
+
+		go s.serveConn(sc)
+	}
+}
+
+func (s *Server) isClosed() bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return s.closed
+}
+
+func (s *Server) addConn(sc *serverConn) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.closed {
+		return mysql.NewDefaultError(mysql.ER_SERVER_SHUTDOWN)
+	} else if s.cfg.MaxConns > 0 && len(s.conns) >= s.cfg.MaxConns {
+		return mysql.NewDefaultError(mysql.ER_CON_COUNT_ERROR)
+	}
+
+	s.conns[sc] = struct{}{}
+	s.wg.Add(1)
+	return nil
+}
+
+func (s *Server) removeConn(sc *serverConn) {
+	s.m.Lock()
+	delete(s.conns, sc)
+	s.m.Unlock()
+
+	s.wg.Done()
+}
+
+// refuse sends the error instead of the initial handshake, like MySQL does.
+func (s *Server) refuse(conn net.Conn, err error) {
+	conn.SetWriteDeadline(time.Now().Add(time.Second))
+
+	c := &Conn{Conn: packet.NewConn(conn)}
+	c.writeError(err)
+	conn.Close()
+}
+
+// ConnCount returns the number of the client connections.
+func (s *Server) ConnCount() int {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	return len(s.conns)
+}
+
+func (s *Server) serveConn(sc *serverConn) {
+	defer s.removeConn(sc)
+	defer sc.Close()
+
+	defer func() {
+		if e := recover(); e != nil {
+			buf := make([]byte, 4096)
+			buf = buf[:runtime.Stack(buf, false)]
+			log.Errorf("server connection %s panic: %v\n%s", sc.RemoteAddr(), e, buf)
+		}
+	}()
+
+	s.setIdleDeadline(sc)
+
+	c := newConn(sc, &s.cfg.ConnConfig)
+	c.h = s.newHandler(c)
+	if h, ok := c.h.(CloseHandler); ok {
+		defer h.HandleClose()
+	}
+
+	if err := c.handshake(); err != nil {
+		log.Warnf("server handshake with %s err: %v", sc.RemoteAddr(), err)
+		c.Close()
+		return
+	}
+
+	for {
+		// Shutdown interrupts the read after it's closed
+		s.m.Lock()
+		if s.closed {
+			s.m.Unlock()
+			c.Close()
+			return
+		}
+		s.setIdleDeadline(sc)
+		s.m.Unlock()
+
+		data, err := c.readCommand()
+		if err != nil {
+			return
+		}
+
+		// the command read is handled, even if we are shutting down
+		s.m.Lock()
+		sc.busy = true
+		s.m.Unlock()
+
+		sc.SetReadDeadline(time.Time{})
+
+		err = c.handleCommand(data)
+
+		s.m.Lock()
+		sc.busy = false
+		closed := s.closed
+		s.m.Unlock()
+
+		if err != nil || closed || c.Closed() {
+			c.Close()
+			return
+		}
+	}
+}
+
+func (s *Server) setIdleDeadline(sc *serverConn) {
+	if s.cfg.IdleTimeout > 0 {
+		sc.SetReadDeadline(time.Now().Add(s.cfg.IdleTimeout))
+	}
+}
+
+// Shutdown stops accepting the clients, closes the idle connections, and waits for the commands being handled,
+// then the connections are closed. If ctx is done before that, all the connections are closed, and ctx.Err() is returned
+// at once, the handlers still running are not waited for.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.m.Lock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+
+	// interrupt the connections waiting for the commands
+	for sc := range s.conns {
+		if !sc.busy {
+			sc.SetReadDeadline(time.Now())
+		}
+	}
+	s.m.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.closeConns()
+		return ctx.Err()
+	}
+}
+
+// Close stops accepting the clients, and closes all the connections at once,
+// the handlers still running are not waited for, use Shutdown to wait for them.
+func (s *Server) Close() error {
+	s.m.Lock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	s.m.Unlock()
+
+	s.closeConns()
+	return nil
+}
+
+func (s *Server) closeConns() {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	for sc := range s.conns {
+		sc.Close()
+	}
+}
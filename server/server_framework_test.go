@@ -0,0 +1,276 @@
+package server
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/gdey/go-mysql/client"
+	"github.com/gdey/go-mysql/mysql"
+	"github.com/juju/errors"
+	. "gopkg.in/check.v1"
+)
+
+type frameworkTestSuite struct {
+	s    *Server
+	addr string
+	done chan error
+
+	// the SLEEP query waits for it
+	release chan struct{}
+	handled chan struct{}
+}
+
+var _ = Suite(&frameworkTestSuite{})
+
+// sessionHandler counts the queries of its connection, and returns the count as the affected rows.
+type sessionHandler struct {
+	s       *frameworkTestSuite
+	c       *Conn
+	queries int
+}
+
+func (h *sessionHandler) UseDB(dbName string) error {
+	return nil
+}
+
+func (h *sessionHandler) HandleQuery(query string) (*mysql.Result, error) {
+	h.queries++
+
+	switch strings.ToUpper(query) {
+	case "SLEEP":
+		h.s.handled <- struct{}{}
+		<-h.s.release
+	case "PANIC":
+		panic("test panic")
+	case "USER":
+		if h.c.GetUser() != *testUser {
+			return nil, errors.Errorf("invalid user %s", h.c.GetUser())
+		}
+	}
+
+	return &mysql.Result{Status: mysql.SERVER_STATUS_AUTOCOMMIT, AffectedRows: uint64(h.queries)}, nil
+}
+
+func (h *sessionHandler) HandleFieldList(table string, fieldWildcard string) ([]*mysql.Field, error) {
+	return nil, nil
+}
+
+func (h *sessionHandler) HandleStmtPrepare(query string) (int, int, interface{}, error) {
+	return 0, 0, nil, errors.New("not supported")
+}
+
+func (h *sessionHandler) HandleStmtExecute(context interface{}, query string, args []interface{}) (*mysql.Result, error) {
+	return nil, errors.New("not supported")
+}
+
+func (s *frameworkTestSuite) start(c *C, cfg *ServerConfig) {
+	p := NewInMemoryProvider()
+	p.AddUser(*testUser, *testPassword)
+	cfg.Provider = p
+
+	s.release = make(chan struct{})
+	s.handled = make(chan struct{}, 1)
+
+	s.s = NewServer(cfg, func(conn *Conn) Handler {
+		return &sessionHandler{s: s, c: conn}
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	s.addr = l.Addr().String()
+
+	srv, done := s.s, make(chan error, 1)
+	s.done = done
+	go func() {
+		done <- srv.Serve(l)
+	}()
+}
+
+func (s *frameworkTestSuite) TearDownTest(c *C) {
+	if s.s != nil {
+		s.s.Close()
+		s.s = nil
+	}
+}
+
+func (s *frameworkTestSuite) connect(c *C) *client.Conn {
+	conn, err := client.Connect(s.addr, *testUser, *testPassword, "")
+	c.Assert(err, IsNil)
+	return conn
+}
+
+func (s *frameworkTestSuite) waitConnCount(c *C, n int) {
+	for i := 0; i < 100 && s.s.ConnCount() != n; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Assert(s.s.ConnCount(), Equals, n)
+}
+
+func (s *frameworkTestSuite) TestSession(c *C) {
+	s.start(c, &ServerConfig{})
+
+	conn1 := s.connect(c)
+	defer conn1.Close()
+	conn2 := s.connect(c)
+	defer conn2.Close()
+
+	s.waitConnCount(c, 2)
+
+	// every connection has its own handler
+	for i := 1; i <= 3; i++ {
+		r, err := conn1.Execute("USER")
+		c.Assert(err, IsNil)
+		c.Assert(r.AffectedRows, Equals, uint64(i))
+	}
+
+	r, err := conn2.Execute("USER")
+	c.Assert(err, IsNil)
+	c.Assert(r.AffectedRows, Equals, uint64(1))
+
+	conn2.Close()
+	s.waitConnCount(c, 1)
+
+	c.Assert(s.s.Close(), IsNil)
+	c.Assert(<-s.done, Equals, ErrServerClosed)
+	c.Assert(conn1.Ping(), NotNil)
+}
+
+func (s *frameworkTestSuite) TestMaxConns(c *C) {
+	s.start(c, &ServerConfig{MaxConns: 1})
+
+	conn := s.connect(c)
+	s.waitConnCount(c, 1)
+
+	_, err := client.Connect(s.addr, *testUser, *testPassword, "")
+	c.Assert(errors.Cause(err).(*mysql.MyError).Code, Equals, uint16(mysql.ER_CON_COUNT_ERROR))
+
+	conn.Close()
+	s.waitConnCount(c, 0)
+
+	conn = s.connect(c)
+	conn.Close()
+}
+
+func (s *frameworkTestSuite) TestIdleTimeout(c *C) {
+	s.start(c, &ServerConfig{IdleTimeout: 100 * time.Millisecond})
+
+	conn := s.connect(c)
+	defer conn.Close()
+
+	c.Assert(conn.Ping(), IsNil)
+	s.waitConnCount(c, 1)
+
+	time.Sleep(300 * time.Millisecond)
+	s.waitConnCount(c, 0)
+	c.Assert(conn.Ping(), NotNil)
+}
+
+func (s *frameworkTestSuite) TestPanic(c *C) {
+	s.start(c, &ServerConfig{})
+
+	conn := s.connect(c)
+	defer conn.Close()
+
+	_, err := conn.Execute("PANIC")
+	c.Assert(err, NotNil)
+	s.waitConnCount(c, 0)
+
+	conn = s.connect(c)
+	defer conn.Close()
+	c.Assert(conn.Ping(), IsNil)
+}
+
+func (s *frameworkTestSuite) TestShutdown(c *C) {
+	s.start(c, &ServerConfig{})
+
+	busy := s.connect(c)
+	defer busy.Close()
+	idle := s.connect(c)
+	defer idle.Close()
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := busy.Execute("SLEEP")
+		result <- err
+	}()
+	<-s.handled
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- s.s.Shutdown(context.Background())
+	}()
+
+	// the idle connection is closed at once, and no more client is accepted
+	s.waitConnCount(c, 1)
+	c.Assert(idle.Ping(), NotNil)
+	c.Assert(<-s.done, Equals, ErrServerClosed)
+
+	_, err := client.Connect(s.addr, *testUser, *testPassword, "")
+	c.Assert(err, NotNil)
+
+	select {
+	case <-shutdown:
+		c.Fatal("shutdown without draining the command")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// the command being handled is finished
+	close(s.release)
+	c.Assert(<-result, IsNil)
+	c.Assert(<-shutdown, IsNil)
+	c.Assert(s.s.ConnCount(), Equals, 0)
+}
+
+func (s *frameworkTestSuite) TestShutdownTimeout(c *C) {
+	s.start(c, &ServerConfig{})
+
+	busy := s.connect(c)
+	defer busy.Close()
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := busy.Execute("SLEEP")
+		result <- err
+	}()
+	<-s.handled
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- s.s.Shutdown(ctx)
+	}()
+
+	// the connection is closed, but the handler is still running, it's not waited for
+	c.Assert(<-result, NotNil)
+	c.Assert(<-shutdown, Equals, context.DeadlineExceeded)
+	close(s.release)
+}
+
+func (s *frameworkTestSuite) TestCloseBusy(c *C) {
+	s.start(c, &ServerConfig{})
+
+	busy := s.connect(c)
+	defer busy.Close()
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := busy.Execute("SLEEP")
+		result <- err
+	}()
+	<-s.handled
+
+	closed := make(chan error, 1)
+	go func() {
+		closed <- s.s.Close()
+	}()
+
+	// the handler is not waited for
+	c.Assert(<-closed, IsNil)
+	c.Assert(<-result, NotNil)
+	close(s.release)
+	s.waitConnCount(c, 0)
+}
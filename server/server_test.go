@@ -1,6 +1,8 @@
 package server
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"database/sql"
 	"flag"
 	"fmt"
@@ -28,6 +30,12 @@ type serverTestSuite struct {
 	db *sql.DB
 
 	l net.Listener
+
+	binlog *testBinlog
+
+	// the CA and config to accept TLS clients
+	caPool    *x509.CertPool
+	tlsConfig *tls.Config
 }
 
 var _ = Suite(&serverTestSuite{})
@@ -70,6 +78,16 @@ func (h *testHandler) handleQuery(query string, binary bool) (*mysql.Result, err
 		return &mysql.Result{0, 0, 1, nil}, nil
 	case "replace":
 		return &mysql.Result{0, 0, 1, nil}, nil
+	case "show":
+		//for handle binlog syncer variables, like SHOW GLOBAL VARIABLES LIKE 'BINLOG_CHECKSUM'
+		r, err := h.showVariables(query, binary)
+		if err != nil {
+			return nil, errors.Trace(err)
+		} else {
+			return &mysql.Result{Resultset: r}, nil
+		}
+	case "set":
+		return &mysql.Result{}, nil
 	default:
 		return nil, fmt.Errorf("invalid query %s", query)
 	}
@@ -115,6 +133,10 @@ func (h *testHandler) HandleStmtExecute(ctx interface{}, query string, args []in
 func (s *serverTestSuite) SetUpSuite(c *C) {
 	var err error
 
+	s.binlog = newTestBinlog()
+
+	s.caPool, s.tlsConfig = newTestTLSConfig(c)
+
 	s.l, err = net.Listen("tcp", *testAddr)
 	c.Assert(err, IsNil)
 
@@ -150,8 +172,11 @@ func (s *serverTestSuite) onAccept(c *C) {
 }
 
 func (s *serverTestSuite) onConn(conn net.Conn, c *C) {
-	co, err := NewConn(conn, *testUser, *testPassword, &testHandler{s})
-	c.Assert(err, IsNil)
+	co, err := NewConnWithTLS(conn, *testUser, *testPassword, &testHandler{s}, s.tlsConfig)
+	if err != nil {
+		// some tests make the handshake fail
+		return
+	}
 
 	for {
 		err = co.HandleCommand()
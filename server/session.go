@@ -0,0 +1,324 @@
+package server
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/gdey/go-mysql/mysql"
+)
+
+// SessionHandler is an optional interface for Handler, implement it to get the session of the connection
+// before the handshake, so the handler can read and change the session state.
+type SessionHandler interface {
+	SetSession(s *Session)
+}
+
+// Session is the state of the session of a connection, like the current database, variables and
+// transaction status. The changes of the schema, system variables and user variables are sent to
+// the client in the next OK packet if it has CLIENT_SESSION_TRACK.
+type Session struct {
+	user      string
+	db        string
+	collation uint8
+
+	status uint16
+
+	// the client allows the multi statements, with CLIENT_MULTI_STATEMENTS or mysql.COM_SET_OPTION
+	multiStatements bool
+
+	lastInsertId uint64
+
+	userVars map[string]interface{}
+	sysVars  map[string]string
+
+	// the changes not sent to the client yet
+	schemaChanged bool
+	changedVars   []string
+	stateChanged  bool
+}
+
+func newSession() *Session {
+	s := new(Session)
+	s.collation = mysql.DEFAULT_COLLATION_ID
+	s.status = mysql.SERVER_STATUS_AUTOCOMMIT
+	s.userVars = make(map[string]interface{})
+	s.sysVars = make(map[string]string)
+	return s
+}
+
+// User returns the authenticated user.
+func (s *Session) User() string {
+	return s.user
+}
+
+// Database returns the current database.
+func (s *Session) Database() string {
+	return s.db
+}
+
+// SetDatabase changes the current database, like USE db in a query.
+func (s *Session) SetDatabase(db string) {
+	if s.db != db {
+		s.db = db
+		s.schemaChanged = true
+	}
+}
+
+// MultiStatements returns whether the client allows the multi statements like "a; b; c" in a query,
+// a handler should take the query as one statement if not, like MySQL.
+func (s *Session) MultiStatements() bool {
+	return s.multiStatements
+}
+
+// Collation returns the collation id the client sent in the handshake.
+func (s *Session) Collation() uint8 {
+	return s.collation
+}
+
+// Charset returns the charset of the collation, or empty if the collation is unknown.
+func (s *Session) Charset() string {
+	return mysql.CollationCharset(s.collation)
+}
+
+// UserVariable returns the user variable, without the @.
+func (s *Session) UserVariable(name string) (interface{}, bool) {
+	v, ok := s.userVars[strings.ToLower(name)]
+	return v, ok
+}
+
+// SetUserVariable sets the user variable, nil removes it.
+func (s *Session) SetUserVariable(name string, value interface{}) {
+	name = strings.ToLower(name)
+	if value == nil {
+		delete(s.userVars, name)
+	} else {
+		s.userVars[name] = value
+	}
+	s.stateChanged = true
+}
+
+// SystemVariable returns the session system variable set with SetSystemVariable.
+func (s *Session) SystemVariable(name string) (string, bool) {
+	v, ok := s.sysVars[strings.ToLower(name)]
+	return v, ok
+}
+
+// SetSystemVariable sets the session system variable, like SET SESSION name = value in a query.
+func (s *Session) SetSystemVariable(name string, value string) {
+	name = strings.ToLower(name)
+	s.sysVars[name] = value
+
+	// the variable changed again is sent once with the last value
+	for _, changed := range s.changedVars {
+		if changed == name {
+			return
+		}
+	}
+	s.changedVars = append(s.changedVars, name)
+}
+
+// LastInsertId returns the last insert id sent to the client, like LAST_INSERT_ID().
+func (s *Session) LastInsertId() uint64 {
+	return s.lastInsertId
+}
+
+// Status returns the server status flags sent to the client.
+func (s *Session) Status() uint16 {
+	return s.status
+}
+
+func (s *Session) IsAutoCommit() bool {
+	return s.status&mysql.SERVER_STATUS_AUTOCOMMIT > 0
+}
+
+func (s *Session) SetAutoCommit(autoCommit bool) {
+	if autoCommit {
+		s.status |= mysql.SERVER_STATUS_AUTOCOMMIT
+	} else {
+		s.status &= ^mysql.SERVER_STATUS_AUTOCOMMIT
+	}
+	s.stateChanged = true
+}
+
+func (s *Session) IsInTransaction() bool {
+	return s.status&mysql.SERVER_STATUS_IN_TRANS > 0
+}
+
+func (s *Session) SetInTransaction() {
+	s.status |= mysql.SERVER_STATUS_IN_TRANS
+}
+
+func (s *Session) ClearInTransaction() {
+	s.status &= ^(mysql.SERVER_STATUS_IN_TRANS | mysql.SERVER_STATUS_IN_TRANS_READONLY)
+}
+
+// reset clears the variables and transaction status, for mysql.COM_RESET_CONNECTION.
+func (s *Session) reset() {
+	s.userVars = make(map[string]interface{})
+	s.sysVars = make(map[string]string)
+	s.changedVars = nil
+	s.status = mysql.SERVER_STATUS_AUTOCOMMIT
+	s.stateChanged = true
+}
+
+// trackChanges returns the session state info of the changes for the OK packet, and clears them.
+func (s *Session) trackChanges() []byte {
+	var data []byte
+
+	for _, name := range s.changedVars {
+		v := mysql.PutLengthEncodedString([]byte(name))
+		v = append(v, mysql.PutLengthEncodedString([]byte(s.sysVars[name]))...)
+		data = appendStateChange(data, mysql.SESSION_TRACK_SYSTEM_VARIABLES, v)
+	}
+
+	if s.schemaChanged {
+		data = appendStateChange(data, mysql.SESSION_TRACK_SCHEMA, mysql.PutLengthEncodedString([]byte(s.db)))
+	}
+
+	if s.stateChanged {
+		data = appendStateChange(data, mysql.SESSION_TRACK_STATE_CHANGE, mysql.PutLengthEncodedString([]byte("1")))
+	}
+
+	s.schemaChanged = false
+	s.changedVars = nil
+	s.stateChanged = false
+
+	return data
+}
+
+func appendStateChange(data []byte, tp byte, v []byte) []byte {
+	data = append(data, tp)
+	data = append(data, mysql.PutLengthEncodedInt(uint64(len(v)))...)
+	return append(data, v...)
+}
+
+// ChangeUserHandler is an optional interface for Handler, implement it to know mysql.COM_CHANGE_USER.
+// The new user is authenticated with the credential provider, and the session is reset like
+// mysql.COM_RESET_CONNECTION before it's called, HandleResetConnection is called if it's a ResetConnectionHandler.
+type ChangeUserHandler interface {
+	//handle mysql.COM_CHANGE_USER, db is the new database, UseDB has been called if it's not empty
+	HandleChangeUser(user string, db string) error
+}
+
+// ResetConnectionHandler is an optional interface for Handler, implement it to reset the session state
+// for mysql.COM_RESET_CONNECTION, like the user variables and temporary tables.
+// The prepared statements and transaction status of the connection are always reset.
+type ResetConnectionHandler interface {
+	HandleResetConnection() error
+}
+
+// resetSession closes the prepared statements and clears the session state.
+func (c *Conn) resetSession() {
+	c.stmts = make(map[uint32]*Stmt)
+	c.session.reset()
+}
+
+func (c *Conn) handleResetConnection() interface{} {
+	if err := c.resetConnection(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// resetConnection resets the session, and the handler if it's a ResetConnectionHandler.
+func (c *Conn) resetConnection() error {
+	c.resetSession()
+
+	if h, ok := c.h.(ResetConnectionHandler); ok {
+		return h.HandleResetConnection()
+	}
+	return nil
+}
+
+// handleChangeUser authenticates the user in mysql.COM_CHANGE_USER with the salt of the handshake.
+// The connection is closed if it fails, because the previous user is not kept like MySQL.
+func (c *Conn) handleChangeUser(data []byte) interface{} {
+	if err := c.changeUser(data); err != nil {
+		c.writeError(err)
+		c.Close()
+		return noResponse{}
+	}
+
+	return nil
+}
+
+func (c *Conn) changeUser(data []byte) error {
+	pos := bytes.IndexByte(data, 0)
+	if pos == -1 {
+		return mysql.ErrMalformPacket
+	}
+
+	//user name
+	user := string(data[:pos])
+	pos++
+
+	//auth length and auth
+	var auth []byte
+	if c.capability&mysql.CLIENT_SECURE_CONNECTION > 0 {
+		if len(data) < pos+1 || len(data) < pos+1+int(data[pos]) {
+			return mysql.ErrMalformPacket
+		}
+		authLen := int(data[pos])
+		pos++
+		auth = data[pos : pos+authLen]
+		pos += authLen
+	} else {
+		end := bytes.IndexByte(data[pos:], 0)
+		if end == -1 {
+			return mysql.ErrMalformPacket
+		}
+		auth = data[pos : pos+end]
+		pos += end + 1
+	}
+
+	//schema name
+	var db string
+	if len(data) > pos {
+		end := bytes.IndexByte(data[pos:], 0)
+		if end == -1 {
+			return mysql.ErrMalformPacket
+		}
+		db = string(data[pos : pos+end])
+		pos += end + 1
+	}
+
+	//charset, 2 bytes, only the lower byte is the collation id
+	var collation uint8
+	if len(data) >= pos+2 {
+		collation = data[pos]
+		pos += 2
+	}
+
+	plugin := mysql.AUTH_NATIVE_PASSWORD
+	if c.capability&mysql.CLIENT_PLUGIN_AUTH > 0 && len(data) > pos {
+		name := data[pos:]
+		if end := bytes.IndexByte(name, 0); end != -1 {
+			name = name[:end]
+		}
+		plugin = string(name)
+	}
+
+	if err := c.authenticate(user, auth, plugin); err != nil {
+		return err
+	}
+
+	if err := c.resetConnection(); err != nil {
+		return err
+	}
+	if collation != 0 {
+		c.session.collation = collation
+	}
+	c.session.SetDatabase("")
+
+	if len(db) > 0 {
+		if err := c.h.UseDB(db); err != nil {
+			return err
+		}
+		c.session.SetDatabase(db)
+	}
+
+	if h, ok := c.h.(ChangeUserHandler); ok {
+		return h.HandleChangeUser(user, db)
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"github.com/gdey/go-mysql/client"
+	"github.com/gdey/go-mysql/mysql"
+	"github.com/juju/errors"
+	. "gopkg.in/check.v1"
+)
+
+type sessionTestSuite struct {
+	s    *Server
+	addr string
+}
+
+var _ = Suite(&sessionTestSuite{})
+
+// stateHandler changes the session for SET queries, and returns the session state for SESSION.
+type stateHandler struct {
+	EmptyHandler
+
+	s *Session
+}
+
+func (h *stateHandler) SetSession(s *Session) {
+	h.s = s
+}
+
+func (h *stateHandler) HandleQuery(query string) (*mysql.Result, error) {
+	switch query {
+	case "SET autocommit = 0":
+		h.s.SetSystemVariable("autocommit", "OFF")
+		h.s.SetAutoCommit(false)
+	case "SET @a = 1":
+		h.s.SetUserVariable("a", int64(1))
+	case "BEGIN":
+		h.s.SetInTransaction()
+	case "INSERT":
+		return &mysql.Result{AffectedRows: 1, InsertId: 10}, nil
+	case "SESSION":
+		a, _ := h.s.UserVariable("A")
+		values := [][]interface{}{{h.s.User(), h.s.Database(), h.s.Charset(), fmt.Sprint(a), h.s.LastInsertId()}}
+
+		r, err := mysql.BuildSimpleResultset([]string{"user", "db", "charset", "a", "last_insert_id"}, values, false)
+		if err != nil {
+			return nil, errors.Trace(err)
+		}
+		return &mysql.Result{Resultset: r}, nil
+	}
+	return nil, nil
+}
+
+func (s *sessionTestSuite) SetUpSuite(c *C) {
+	p := NewInMemoryProvider()
+	p.AddUser(*testUser, *testPassword)
+
+	s.s = NewServer(&ServerConfig{ConnConfig: ConnConfig{Provider: p}}, func(conn *Conn) Handler {
+		return &stateHandler{}
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	s.addr = l.Addr().String()
+
+	go s.s.Serve(l)
+}
+
+func (s *sessionTestSuite) TearDownSuite(c *C) {
+	s.s.Close()
+}
+
+// stateChanges returns the status and the session state changes of the OK packet, by the type.
+func (s *sessionTestSuite) stateChanges(c *C, data []byte) (uint16, map[byte][]byte) {
+	c.Assert(data[0], Equals, mysql.OK_HEADER)
+
+	pos := 1
+	_, _, n := mysql.LengthEncodedInt(data[pos:])
+	pos += n
+	_, _, n = mysql.LengthEncodedInt(data[pos:])
+	pos += n
+	status := binary.LittleEndian.Uint16(data[pos:])
+	pos += 4
+
+	//info
+	_, _, n, err := mysql.LengthEnodedString(data[pos:])
+	c.Assert(err, IsNil)
+	pos += n
+
+	changes := make(map[byte][]byte)
+	if status&mysql.SERVER_SESSION_STATE_CHANGED == 0 {
+		c.Assert(pos, Equals, len(data))
+		return status, changes
+	}
+
+	state, _, _, err := mysql.LengthEnodedString(data[pos:])
+	c.Assert(err, IsNil)
+	for len(state) > 0 {
+		v, _, n, err := mysql.LengthEnodedString(state[1:])
+		c.Assert(err, IsNil)
+		changes[state[0]] = v
+		state = state[1+n:]
+	}
+	return status, changes
+}
+
+func (s *sessionTestSuite) TestSessionTrack(c *C) {
+	conn := rawConnect(c, s.addr, mysql.CLIENT_SESSION_TRACK)
+	defer conn.Close()
+
+	status, changes := s.stateChanges(c, rawCommand(c, conn, mysql.COM_INIT_DB, []byte("db1"), 1)[0])
+	c.Assert(status&mysql.SERVER_STATUS_AUTOCOMMIT, Not(Equals), uint16(0))
+	c.Assert(changes, DeepEquals, map[byte][]byte{mysql.SESSION_TRACK_SCHEMA: []byte("\x03db1")})
+
+	// the changes are sent only once
+	_, changes = s.stateChanges(c, rawCommand(c, conn, mysql.COM_PING, nil, 1)[0])
+	c.Assert(changes, HasLen, 0)
+
+	status, changes = s.stateChanges(c, rawCommand(c, conn, mysql.COM_QUERY, []byte("SET autocommit = 0"), 1)[0])
+	c.Assert(status&mysql.SERVER_STATUS_AUTOCOMMIT, Equals, uint16(0))
+	c.Assert(changes, DeepEquals, map[byte][]byte{
+		mysql.SESSION_TRACK_SYSTEM_VARIABLES: []byte("\x0aautocommit\x03OFF"),
+		mysql.SESSION_TRACK_STATE_CHANGE:     []byte("\x011"),
+	})
+
+	status, _ = s.stateChanges(c, rawCommand(c, conn, mysql.COM_QUERY, []byte("BEGIN"), 1)[0])
+	c.Assert(status&mysql.SERVER_STATUS_IN_TRANS, Not(Equals), uint16(0))
+
+	// reset clears the transaction and restores autocommit
+	status, changes = s.stateChanges(c, rawCommand(c, conn, mysql.COM_RESET_CONNECTION, nil, 1)[0])
+	c.Assert(status&(mysql.SERVER_STATUS_IN_TRANS|mysql.SERVER_STATUS_AUTOCOMMIT), Equals, mysql.SERVER_STATUS_AUTOCOMMIT)
+	c.Assert(changes, DeepEquals, map[byte][]byte{mysql.SESSION_TRACK_STATE_CHANGE: []byte("\x011")})
+}
+
+func (s *sessionTestSuite) TestTrackSystemVariable(c *C) {
+	session := newSession()
+	session.SetSystemVariable("autocommit", "ON")
+	session.SetSystemVariable("AUTOCOMMIT", "OFF")
+
+	data := session.trackChanges()
+	c.Assert(data, DeepEquals, appendStateChange(nil, mysql.SESSION_TRACK_SYSTEM_VARIABLES, []byte("\x0aautocommit\x03OFF")))
+	c.Assert(session.trackChanges(), HasLen, 0)
+}
+
+func (s *sessionTestSuite) TestSession(c *C) {
+	conn, err := client.Connect(s.addr, *testUser, *testPassword, "db1")
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	// the OK packet has no session state for the client without CLIENT_SESSION_TRACK
+	_, err = conn.Execute("SET @a = 1")
+	c.Assert(err, IsNil)
+	r, err := conn.Execute("INSERT")
+	c.Assert(err, IsNil)
+	c.Assert(r.InsertId, Equals, uint64(10))
+	c.Assert(conn.UseDB("db2"), IsNil)
+
+	r, err = conn.Execute("SESSION")
+	c.Assert(err, IsNil)
+	for i, expected := range []string{*testUser, "db2", mysql.CollationCharset(mysql.DEFAULT_COLLATION_ID), "1"} {
+		v, _ := r.GetString(0, i)
+		c.Assert(v, Equals, expected)
+	}
+
+	// the OK packet of COM_INIT_DB has no insert id
+	id, _ := r.GetUint(0, 4)
+	c.Assert(id, Equals, uint64(10))
+}
@@ -7,6 +7,7 @@ import (
 	"strconv"
 
 	"github.com/gdey/go-mysql/mysql"
+	"github.com/gdey/go/log"
 	"github.com/juju/errors"
 )
 
@@ -31,6 +32,9 @@ type Stmt struct {
 	Args []interface{}
 
 	Context interface{}
+
+	//opened by mysql.COM_STMT_EXECUTE with a cursor flag
+	cursor *stmtCursor
 }
 
 func (s *Stmt) Rest(params int, columns int, context interface{}) {
@@ -74,7 +78,7 @@ func (c *Conn) writePrepare(s *Stmt) error {
 			}
 		}
 
-		if err := c.writeEOF(); err != nil {
+		if err := c.writeFieldsEOF(); err != nil {
 			return err
 		}
 	}
@@ -89,7 +93,7 @@ func (c *Conn) writePrepare(s *Stmt) error {
 			}
 		}
 
-		if err := c.writeEOF(); err != nil {
+		if err := c.writeFieldsEOF(); err != nil {
 			return err
 		}
 
@@ -97,7 +101,7 @@ func (c *Conn) writePrepare(s *Stmt) error {
 	return nil
 }
 
-func (c *Conn) handleStmtExecute(data []byte) (*mysql.Result, error) {
+func (c *Conn) handleStmtExecute(data []byte) (interface{}, error) {
 	if len(data) < 9 {
 		return nil, mysql.ErrMalformPacket
 	}
@@ -114,10 +118,14 @@ func (c *Conn) handleStmtExecute(data []byte) (*mysql.Result, error) {
 
 	flag := data[pos]
 	pos++
-	//now we only support CURSOR_TYPE_NO_CURSOR flag
-	if flag != 0 {
+	//all the cursors are read only, the rows are kept in the statement for COM_STMT_FETCH
+	if flag&^(mysql.CURSOR_TYPE_READ_ONLY|mysql.CURSOR_TYPE_FOR_UPDATE|mysql.CURSOR_TYPE_SCROLLABLE) != 0 {
 		return nil, mysql.NewError(mysql.ER_UNKNOWN_ERROR, fmt.Sprintf("unsupported flag %d", flag))
 	}
+	useCursor := flag != mysql.CURSOR_TYPE_NO_CURSOR
+
+	//the cursor opened before is closed
+	s.cursor = nil
 
 	//skip iteration-count, always 1
 	pos += 4
@@ -154,6 +162,27 @@ func (c *Conn) handleStmtExecute(data []byte) (*mysql.Result, error) {
 		}
 	}
 
+	c.recordArgs(s.Args)
+
+	if h, ok := c.h.(StreamingHandler); ok {
+		fn := func(w *ResultsetWriter) (*mysql.Result, error) {
+			return h.HandleStmtExecuteStreaming(s.Context, s.Query, s.Args, w)
+		}
+
+		var v interface{}
+		if useCursor {
+			v = c.bufferResult(s, fn)
+		} else {
+			v = c.streamResult(true, fn)
+		}
+		s.ResetParams()
+
+		if r, ok := v.(*mysql.Result); ok {
+			return c.stmtResult(s, r, useCursor)
+		}
+		return v, nil
+	}
+
 	var r *mysql.Result
 	var err error
 	if r, err = c.h.HandleStmtExecute(s.Context, s.Query, s.Args); err != nil {
@@ -162,7 +191,30 @@ func (c *Conn) handleStmtExecute(data []byte) (*mysql.Result, error) {
 
 	s.ResetParams()
 
-	return r, nil
+	return c.stmtResult(s, r, useCursor)
+}
+
+// stmtResult encodes the rows of the result set in the binary protocol, and opens the cursor for it if useCursor.
+func (c *Conn) stmtResult(s *Stmt, r *mysql.Result, useCursor bool) (interface{}, error) {
+	if r == nil || r.Resultset == nil {
+		return r, nil
+	}
+
+	rs, err := binaryResultset(r.Resultset)
+	if err != nil {
+		return nil, errors.Trace(err)
+	}
+
+	if useCursor {
+		if err = c.openCursor(s, rs, r.Status); err != nil {
+			return err, nil
+		}
+		return noResponse{}, nil
+	}
+
+	br := *r
+	br.Resultset = rs
+	return &br, nil
 }
 
 func (c *Conn) bindStmtArgs(s *Stmt, nullBitmap, paramTypes, paramValues []byte) error {
@@ -336,10 +388,18 @@ func (c *Conn) handleStmtReset(data []byte) (*mysql.Result, error) {
 	}
 
 	s.ResetParams()
+	s.cursor = nil
 
 	return &mysql.Result{}, nil
 }
 
+// StmtCloseHandler is an optional interface for Handler, implement it to release the context of the statement
+// set in prepare, like the statement prepared in the backend of a proxy.
+type StmtCloseHandler interface {
+	//handle mysql.COM_STMT_CLOSE, the client reads no response, so the error is only logged
+	HandleStmtClose(context interface{}) error
+}
+
 // stmt close command has no repsonse
 func (c *Conn) handleStmtClose(data []byte) error {
 	if len(data) < 4 {
@@ -348,7 +408,18 @@ func (c *Conn) handleStmtClose(data []byte) error {
 
 	id := binary.LittleEndian.Uint32(data[0:4])
 
+	s, ok := c.stmts[id]
+	if !ok {
+		return nil
+	}
+
 	delete(c.stmts, id)
 
+	if h, ok := c.h.(StmtCloseHandler); ok {
+		if err := h.HandleStmtClose(s.Context); err != nil {
+			log.Warnf("close statement %d err: %v", id, err)
+		}
+	}
+
 	return nil
 }
@@ -0,0 +1,246 @@
+package server
+
+import (
+	"io"
+
+	"github.com/gdey/go-mysql/mysql"
+	"github.com/juju/errors"
+)
+
+// StreamingHandler is an optional interface for Handler, implement it to stream the result set
+// to the client row by row, instead of building the whole mysql.Resultset in memory.
+// HandleQuery and HandleStmtExecute are not called if it's implemented.
+type StreamingHandler interface {
+	//handle mysql.COM_QUERY command, the result set is written with w, and the returned Result is sent
+	//if no result set is being written. If an error is returned after the fields are written, it's sent in the result set.
+	HandleQueryStreaming(query string, w *ResultsetWriter) (*mysql.Result, error)
+	//handle mysql.COM_STMT_EXECUTE command like HandleQueryStreaming, the rows are in the binary protocol
+	HandleStmtExecuteStreaming(context interface{}, query string, args []interface{}, w *ResultsetWriter) (*mysql.Result, error)
+}
+
+// RowIterator iterates the rows of a result set, for ResultsetWriter.WriteRows.
+// It's closed after the rows are written if it's an io.Closer.
+type RowIterator interface {
+	Fields() []*mysql.Field
+	// Next returns the values of the next row, io.EOF if there is no more row
+	Next() ([]interface{}, error)
+}
+
+// ResultsetWriter writes the fields, then the rows of a result set to the client.
+type ResultsetWriter struct {
+	c      *Conn
+	binary bool
+
+	fields []*mysql.Field
+
+	// the result set is kept in it instead of written for the cursor
+	buffer *mysql.Resultset
+
+	// the error writing to the connection, the connection can't be used then
+	err error
+}
+
+// Binary returns whether the rows are in the binary protocol, for the statement.
+func (w *ResultsetWriter) Binary() bool {
+	return w.binary
+}
+
+// Started returns whether the fields are written.
+func (w *ResultsetWriter) Started() bool {
+	return w.fields != nil
+}
+
+// WriteFields starts the result set with the fields, it must be called only once before the rows.
+func (w *ResultsetWriter) WriteFields(fields []*mysql.Field) error {
+	if w.err != nil {
+		return w.err
+	} else if w.fields != nil {
+		return errors.New("result set fields are already written")
+	} else if len(fields) == 0 {
+		return errors.New("result set must have fields")
+	}
+
+	w.fields = fields
+
+	if w.buffer != nil {
+		w.buffer.Fields = fields
+		return nil
+	}
+
+	data := make([]byte, 4, 1024)
+	data = append(data, mysql.PutLengthEncodedInt(uint64(len(fields)))...)
+	if err := w.writePacket(data); err != nil {
+		return err
+	}
+
+	for _, f := range fields {
+		data = data[0:4]
+		data = append(data, f.Dump()...)
+		if err := w.writePacket(data); err != nil {
+			return err
+		}
+	}
+
+	if err := w.c.writeFieldsEOF(); err != nil {
+		w.err = errors.Trace(err)
+	}
+	return w.err
+}
+
+// NextResultset ends the result set being written, and tells the client that more results follow,
+// then WriteFields starts the next result set, or the returned Result is sent as the last one like a stored procedure.
+func (w *ResultsetWriter) NextResultset() error {
+	if w.err != nil {
+		return w.err
+	} else if w.fields == nil {
+		return errors.New("result set fields are not written")
+	} else if w.buffer != nil || !w.c.multiResults(w.binary) {
+		return errMultiResultsNotSupported
+	}
+
+	w.fields = nil
+
+	if err := w.c.writeEOFStatus(mysql.SERVER_MORE_RESULTS_EXISTS); err != nil {
+		w.err = errors.Trace(err)
+	}
+	return w.err
+}
+
+// WriteResult writes the result without a result set before the other results, like the OK of an INSERT
+// in a multi statement, then WriteFields starts the next result set, or the returned Result is sent as the last one.
+func (w *ResultsetWriter) WriteResult(r *mysql.Result) error {
+	if w.err != nil {
+		return w.err
+	} else if w.fields != nil {
+		return errors.New("result set is being written")
+	} else if w.buffer != nil || !w.c.multiResults(w.binary) {
+		return errMultiResultsNotSupported
+	}
+
+	if err := w.c.writeOKStatus(mysql.OK_HEADER, r, mysql.SERVER_MORE_RESULTS_EXISTS); err != nil {
+		w.err = errors.Trace(err)
+	}
+	return w.err
+}
+
+// WriteRow encodes the values by the fields, and writes the row, nil is NULL.
+func (w *ResultsetWriter) WriteRow(values []interface{}) error {
+	if w.fields == nil {
+		return errors.New("result set fields are not written")
+	}
+
+	row, err := mysql.BuildRowData(w.fields, values, w.binary)
+	if err != nil {
+		return errors.Trace(err)
+	}
+
+	return w.WriteRowData(row)
+}
+
+// WriteRowData writes the encoded row, like the row read from another server in a proxy.
+func (w *ResultsetWriter) WriteRowData(row mysql.RowData) error {
+	if w.err != nil {
+		return w.err
+	} else if w.fields == nil {
+		return errors.New("result set fields are not written")
+	}
+
+	if w.buffer != nil {
+		w.buffer.RowDatas = append(w.buffer.RowDatas, append(mysql.RowData(nil), row...))
+		return nil
+	}
+
+	data := make([]byte, 4, 4+len(row))
+	data = append(data, row...)
+	if err := w.writePacket(data); err != nil {
+		return err
+	}
+
+	w.c.recordRows(1)
+	return nil
+}
+
+// WriteRows writes the fields if not written, then all the rows of it.
+func (w *ResultsetWriter) WriteRows(it RowIterator) error {
+	if closer, ok := it.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	if w.fields == nil {
+		if err := w.WriteFields(it.Fields()); err != nil {
+			return errors.Trace(err)
+		}
+	}
+
+	for {
+		values, err := it.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.Trace(err)
+		}
+
+		if err = w.WriteRow(values); err != nil {
+			return errors.Trace(err)
+		}
+	}
+}
+
+func (w *ResultsetWriter) writePacket(data []byte) error {
+	if err := w.c.WritePacket(data); err != nil {
+		w.err = errors.Trace(err)
+	}
+	return w.err
+}
+
+// streamResult calls the streaming handler, and ends the result set it starts.
+func (c *Conn) streamResult(binary bool, fn func(w *ResultsetWriter) (*mysql.Result, error)) interface{} {
+	w := &ResultsetWriter{c: c, binary: binary}
+
+	r, err := fn(w)
+	if w.err != nil {
+		// the connection is broken
+		return w.err
+	}
+
+	if w.fields == nil {
+		if err != nil {
+			return err
+		}
+		return r
+	}
+
+	// the client reads the error in place of the rows
+	if err != nil {
+		err = c.writeError(err)
+	} else {
+		err = c.writeEOF()
+	}
+
+	if err != nil {
+		return err
+	}
+	return noResponse{}
+}
+
+// bufferResult calls the streaming handler for the cursor, and opens the cursor with the binary rows written,
+// the Result returned by the handler without the rows written is returned.
+func (c *Conn) bufferResult(s *Stmt, fn func(w *ResultsetWriter) (*mysql.Result, error)) interface{} {
+	w := &ResultsetWriter{c: c, binary: true, buffer: new(mysql.Resultset)}
+
+	r, err := fn(w)
+	if err != nil {
+		return err
+	} else if w.fields == nil {
+		return r
+	}
+
+	var status uint16
+	if r != nil {
+		status = r.Status
+	}
+	if err = c.openCursor(s, w.buffer, status); err != nil {
+		return err
+	}
+	return noResponse{}
+}
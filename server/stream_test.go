@@ -0,0 +1,204 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/gdey/go-mysql/client"
+	"github.com/gdey/go-mysql/mysql"
+	"github.com/juju/errors"
+	. "gopkg.in/check.v1"
+)
+
+type streamTestSuite struct {
+	s    *Server
+	addr string
+}
+
+var _ = Suite(&streamTestSuite{})
+
+// rangeRows iterates the rows (i, "name i", i / 2) for i in [0, n), and fails at the row fail if it's not 0.
+type rangeRows struct {
+	n, fail int
+	i       int
+}
+
+func (r *rangeRows) Fields() []*mysql.Field {
+	return []*mysql.Field{
+		{Name: []byte("id"), Type: mysql.MYSQL_TYPE_LONGLONG, Charset: 63},
+		{Name: []byte("name"), Type: mysql.MYSQL_TYPE_VAR_STRING, Charset: 33},
+		{Name: []byte("half"), Type: mysql.MYSQL_TYPE_DOUBLE, Charset: 63},
+	}
+}
+
+func (r *rangeRows) Next() ([]interface{}, error) {
+	if r.fail > 0 && r.i == r.fail {
+		return nil, mysql.NewError(mysql.ER_UNKNOWN_ERROR, "source failed")
+	} else if r.i == r.n {
+		return nil, io.EOF
+	}
+
+	i := r.i
+	r.i++
+
+	var name interface{}
+	if i%10 != 0 {
+		name = fmt.Sprintf("name %d", i)
+	}
+	return []interface{}{int64(i), name, float64(i) / 2}, nil
+}
+
+// streamHandler streams the rows of the queries like "ROWS n" or "ROWS n FAIL m".
+type streamHandler struct {
+	EmptyHandler
+}
+
+func (h *streamHandler) HandleQueryStreaming(query string, w *ResultsetWriter) (*mysql.Result, error) {
+	var n, fail int
+	if strings.Contains(query, "FAIL") {
+		fmt.Sscanf(query, "ROWS %d FAIL %d", &n, &fail)
+	} else if _, err := fmt.Sscanf(query, "ROWS %d", &n); err != nil {
+		return &mysql.Result{AffectedRows: 1}, nil
+	}
+
+	if n < 0 {
+		return nil, mysql.NewError(mysql.ER_UNKNOWN_ERROR, "invalid rows")
+	}
+
+	return nil, w.WriteRows(&rangeRows{n: n, fail: fail})
+}
+
+func (h *streamHandler) HandleStmtPrepare(query string) (int, int, interface{}, error) {
+	return 1, 3, nil, nil
+}
+
+func (h *streamHandler) HandleStmtExecuteStreaming(context interface{}, query string, args []interface{}, w *ResultsetWriter) (*mysql.Result, error) {
+	if !w.Binary() {
+		return nil, errors.New("rows must be binary")
+	}
+
+	n, _ := args[0].(int64)
+	return nil, w.WriteRows(&rangeRows{n: int(n)})
+}
+
+func (s *streamTestSuite) SetUpSuite(c *C) {
+	p := NewInMemoryProvider()
+	p.AddUser(*testUser, *testPassword)
+
+	s.s = NewServer(&ServerConfig{ConnConfig: ConnConfig{Provider: p}}, func(conn *Conn) Handler {
+		return &streamHandler{}
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	s.addr = l.Addr().String()
+
+	go s.s.Serve(l)
+}
+
+func (s *streamTestSuite) TearDownSuite(c *C) {
+	s.s.Close()
+}
+
+func (s *streamTestSuite) checkRows(c *C, rows *client.Rows, n int) {
+	i := 0
+	for ; rows.Next(); i++ {
+		values := rows.Values()
+		c.Assert(values[0], Equals, int64(i))
+		if i%10 == 0 {
+			c.Assert(values[1], IsNil)
+		} else {
+			c.Assert(string(values[1].([]byte)), Equals, fmt.Sprintf("name %d", i))
+		}
+		c.Assert(values[2], Equals, float64(i)/2)
+	}
+	c.Assert(rows.Err(), IsNil)
+	c.Assert(i, Equals, n)
+	c.Assert(rows.Close(), IsNil)
+}
+
+func (s *streamTestSuite) TestQuery(c *C) {
+	conn, err := client.Connect(s.addr, *testUser, *testPassword, "")
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	rows, err := conn.Query("ROWS 100000")
+	c.Assert(err, IsNil)
+	c.Assert(rows.Fields(), HasLen, 3)
+	s.checkRows(c, rows, 100000)
+
+	r, err := conn.Execute("ROWS 0")
+	c.Assert(err, IsNil)
+	c.Assert(r.Resultset.Fields, HasLen, 3)
+	c.Assert(r.RowNumber(), Equals, 0)
+
+	// no result set
+	r, err = conn.Execute("INSERT")
+	c.Assert(err, IsNil)
+	c.Assert(r.AffectedRows, Equals, uint64(1))
+
+	_, err = conn.Execute("ROWS -1")
+	c.Assert(errors.Cause(err).(*mysql.MyError).Message, Equals, "invalid rows")
+	c.Assert(conn.Ping(), IsNil)
+}
+
+func (s *streamTestSuite) TestQueryError(c *C) {
+	conn, err := client.Connect(s.addr, *testUser, *testPassword, "")
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	// the error is sent after the rows
+	rows, err := conn.Query("ROWS 1000 FAIL 500")
+	c.Assert(err, IsNil)
+
+	i := 0
+	for rows.Next() {
+		i++
+	}
+	c.Assert(i, Equals, 500)
+	c.Assert(errors.Cause(rows.Err()).(*mysql.MyError).Message, Equals, "source failed")
+	rows.Close()
+
+	_, err = conn.Execute("ROWS 1000 FAIL 10")
+	c.Assert(errors.Cause(err).(*mysql.MyError).Message, Equals, "source failed")
+
+	c.Assert(conn.Ping(), IsNil)
+}
+
+func (s *streamTestSuite) TestStmt(c *C) {
+	conn, err := client.Connect(s.addr, *testUser, *testPassword, "")
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	stmt, err := conn.Prepare("SELECT ?")
+	c.Assert(err, IsNil)
+	defer stmt.Close()
+
+	rows, err := stmt.Query(1000)
+	c.Assert(err, IsNil)
+	s.checkRows(c, rows, 1000)
+
+	r, err := stmt.Execute(10)
+	c.Assert(err, IsNil)
+	c.Assert(r.RowNumber(), Equals, 10)
+	c.Assert(r.Values[9][0], Equals, int64(9))
+}
+
+func (s *streamTestSuite) TestBuildRowData(c *C) {
+	fields := (&rangeRows{}).Fields()
+	values := []interface{}{int64(-3), nil, 1.5}
+
+	for _, binary := range []bool{false, true} {
+		row, err := mysql.BuildRowData(fields, values, binary)
+		c.Assert(err, IsNil)
+
+		parsed, err := row.Parse(fields, binary)
+		c.Assert(err, IsNil)
+		c.Assert(parsed, DeepEquals, values)
+	}
+
+	_, err := mysql.BuildRowData(fields, values[:2], false)
+	c.Assert(err, NotNil)
+}
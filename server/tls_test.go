@@ -0,0 +1,182 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/gdey/go-mysql/client"
+	"github.com/gdey/go-mysql/mysql"
+	"github.com/gdey/go-mysql/replication"
+	. "gopkg.in/check.v1"
+)
+
+// newTestTLSConfig creates a self-signed CA and a server certificate for 127.0.0.1 and localhost.
+func newTestTLSConfig(c *C) (*x509.CertPool, *tls.Config) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, IsNil)
+
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "go-mysql test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	c.Assert(err, IsNil)
+
+	ca, err = x509.ParseCertificate(caDER)
+	c.Assert(err, IsNil)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, IsNil)
+
+	cert := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "go-mysql test server"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:     []string{"localhost"},
+	}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, cert, ca, &key.PublicKey, caKey)
+	c.Assert(err, IsNil)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{certDER, caDER},
+			PrivateKey:  key,
+		}},
+	}
+
+	return pool, config
+}
+
+func (s *serverTestSuite) connectTLS(c *C, addr string, mode client.TLSMode, config *tls.Config) (*client.Conn, error) {
+	return client.Connect(addr, *testUser, *testPassword, "", func(conn *client.Conn) {
+		conn.SetTLS(mode, config)
+	})
+}
+
+func (s *serverTestSuite) TestTLS(c *C) {
+	for _, mode := range []client.TLSMode{client.TLSPreferred, client.TLSRequired} {
+		conn, err := s.connectTLS(c, *testAddr, mode, nil)
+		c.Assert(err, IsNil)
+		c.Assert(conn.IsTLS(), Equals, true)
+
+		r, err := conn.Execute("SELECT a, b FROM tbl WHERE id=1")
+		c.Assert(err, IsNil)
+		v, _ := r.GetString(0, 1)
+		c.Assert(v, Equals, "hello world")
+
+		conn.Close()
+	}
+
+	// the certificate is not signed by a known CA
+	_, err := s.connectTLS(c, *testAddr, client.TLSVerifyCA, nil)
+	c.Assert(err, NotNil)
+
+	conn, err := s.connectTLS(c, *testAddr, client.TLSVerifyCA, &tls.Config{RootCAs: s.caPool, ServerName: "other"})
+	c.Assert(err, IsNil)
+	c.Assert(conn.IsTLS(), Equals, true)
+	conn.Close()
+
+	conn, err = s.connectTLS(c, *testAddr, client.TLSVerifyIdentity, &tls.Config{RootCAs: s.caPool})
+	c.Assert(err, IsNil)
+	c.Assert(conn.IsTLS(), Equals, true)
+	c.Assert(conn.Ping(), IsNil)
+	conn.Close()
+
+	// the host name is not in the certificate
+	_, err = s.connectTLS(c, *testAddr, client.TLSVerifyIdentity, &tls.Config{RootCAs: s.caPool, ServerName: "other"})
+	c.Assert(err, NotNil)
+}
+
+func (s *serverTestSuite) TestTLSNotSupported(c *C) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer l.Close()
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				co, err := NewConn(conn, *testUser, *testPassword, &testHandler{s})
+				if err != nil {
+					return
+				}
+				for co.HandleCommand() == nil {
+				}
+			}()
+		}
+	}()
+
+	conn, err := s.connectTLS(c, l.Addr().String(), client.TLSPreferred, nil)
+	c.Assert(err, IsNil)
+	c.Assert(conn.IsTLS(), Equals, false)
+	c.Assert(conn.Ping(), IsNil)
+	conn.Close()
+
+	_, err = s.connectTLS(c, l.Addr().String(), client.TLSRequired, nil)
+	c.Assert(err, NotNil)
+}
+
+func (s *serverTestSuite) TestBinlogDumpTLS(c *C) {
+	b := replication.NewBinlogSyncer(100, mysql.MySQLFlavor)
+	defer b.Close()
+
+	b.SetTLSConfig(client.TLSVerifyIdentity, &tls.Config{RootCAs: s.caPool})
+
+	host, port, err := net.SplitHostPort(*testAddr)
+	c.Assert(err, IsNil)
+
+	n, err := strconv.ParseUint(port, 10, 16)
+	c.Assert(err, IsNil)
+
+	err = b.RegisterSlave(host, uint16(n), *testUser, *testPassword)
+	c.Assert(err, IsNil)
+
+	st, err := b.StartSync(mysql.Position{Name: "mysql-bin.000001", Pos: 4})
+	c.Assert(err, IsNil)
+
+	<-s.binlog.requests
+
+	for i := 0; i < len(s.binlog.events); i++ {
+		_, err = st.GetEventTimeout(time.Second)
+		c.Assert(err, IsNil)
+	}
+}
+
+func (s *serverTestSuite) TestParseTLSMode(c *C) {
+	mode, err := client.ParseTLSMode("verify-identity")
+	c.Assert(err, IsNil)
+	c.Assert(mode, Equals, client.TLSVerifyIdentity)
+
+	mode, err = client.ParseTLSMode("PREFERRED")
+	c.Assert(err, IsNil)
+	c.Assert(mode, Equals, client.TLSPreferred)
+
+	_, err = client.ParseTLSMode("unknown")
+	c.Assert(err, NotNil)
+}